@@ -0,0 +1,83 @@
+package main
+
+import (
+	"MediaMTXAuth/internal"
+	"MediaMTXAuth/internal/services"
+	"MediaMTXAuth/internal/views/pages"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"strings"
+)
+
+// dockerSecret is where Docker and Podman mount a secret named admin_password.
+const dockerSecret = "/run/secrets/admin_password"
+
+// adminCredentials returns the initial admin's username and password from the
+// ADMIN_USERNAME and ADMIN_PASSWORD environment variables, the file named by
+// ADMIN_PASSWORD_FILE or the admin_password Docker secret. Empty values mean
+// nothing is configured.
+func adminCredentials() (string, string, error) {
+	username := os.Getenv("ADMIN_USERNAME")
+
+	if password := os.Getenv("ADMIN_PASSWORD"); password != "" {
+		return username, password, nil
+	}
+
+	path := os.Getenv("ADMIN_PASSWORD_FILE")
+	if path == "" {
+		if _, err := os.Stat(dockerSecret); err != nil {
+			return username, "", nil
+		}
+		path = dockerSecret
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return "", "", fmt.Errorf("failed to read admin password: %w", err)
+	}
+
+	password := strings.TrimRight(string(data), "\r\n")
+	if password == "" {
+		return "", "", fmt.Errorf("admin password file %s is empty", path)
+	}
+
+	return username, password, nil
+}
+
+// bootstrapAdmin makes sure a fresh database can be administered. It creates
+// the configured admin, or one with a random password that is logged once, or,
+// with setupToken, logs a one-time token for creating the admin on /login.
+func bootstrapAdmin(userService internal.UserService, loginView *pages.LoginPage, setupToken bool) error {
+	username, password, err := adminCredentials()
+	if err != nil {
+		return err
+	}
+
+	if setupToken && password == "" {
+		hasAdmin, err := userService.HasAdmin()
+		if err != nil || hasAdmin {
+			return err
+		}
+
+		log.Println("no admin exists yet, open /login and enter the setup token:", loginView.NewSetupToken())
+		return nil
+	}
+
+	generated, err := userService.CreateInitialAdmin(username, password)
+	if errors.Is(err, services.ErrAdminExists) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	if generated != "" {
+		log.Println("generated admin password, shown only once:", generated)
+	} else {
+		log.Println("created admin from configured credentials")
+	}
+
+	return nil
+}
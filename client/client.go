@@ -0,0 +1,375 @@
+// Package client is a Go client for the admin API of MediaMTXauth, described
+// in docs/api.md.
+package client
+
+import (
+	"MediaMTXAuth/internal"
+	"MediaMTXAuth/internal/api"
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+type (
+	User                     = api.User
+	Membership               = api.Membership
+	Suspension               = api.Suspension
+	Namespace                = api.Namespace
+	Session                  = api.Session
+	Invitation               = api.Invitation
+	CreateUserRequest        = api.CreateUserRequest
+	CreateUserResponse       = api.CreateUserResponse
+	UpdateUserRequest        = api.UpdateUserRequest
+	CreateInvitationResponse = api.CreateInvitationResponse
+	Permission               = internal.Permission
+	Role                     = internal.Role
+	ListOptions              = internal.ListOptions
+)
+
+// Page is one page of a list. Pass Next as ListOptions.Cursor to get the
+// following page.
+type Page[T any] = internal.Page[T]
+
+const (
+	PermissionPublish = internal.PermissionPublish
+	PermissionRead    = internal.PermissionRead
+	PermissionBoth    = internal.PermissionBoth
+
+	RoleUser    = internal.RoleUser
+	RoleManager = internal.RoleManager
+	RoleAdmin   = internal.RoleAdmin
+
+	SortByName    = internal.SortByName
+	SortByExpires = internal.SortByExpires
+)
+
+// Errors returned by the server. Use errors.Is to check for them.
+var (
+	ErrUserNotFound           = internal.ErrUserNotFound
+	ErrUserAlreadyExists      = internal.ErrUserAlreadyExists
+	ErrNamespaceNotFound      = internal.ErrNamespaceNotFound
+	ErrNamespaceAlreadyExists = internal.ErrNamespaceAlreadyExists
+	ErrSessionNotFound        = internal.ErrSessionNotFound
+	ErrInvitationNotFound     = internal.ErrInvitationNotFound
+	ErrMembershipNotFound     = internal.ErrMembershipNotFound
+	ErrForbidden              = internal.ErrForbidden
+	ErrInvalidPermission      = internal.ErrInvalidPermission
+	ErrInvalidRole            = internal.ErrInvalidRole
+)
+
+// Error is an error response of the server.
+type Error struct {
+	StatusCode int
+	Message    string
+}
+
+func (e *Error) Error() string {
+	return fmt.Sprintf("%d: %s", e.StatusCode, e.Message)
+}
+
+// Is reports whether the server answered with target, comparing messages.
+func (e *Error) Is(target error) bool {
+	return e.Message == target.Error()
+}
+
+type Client struct {
+	// BaseURL is the address of the server, such as http://localhost:8080.
+	BaseURL string
+	// Token is a personal API token.
+	Token      string
+	HTTPClient *http.Client
+}
+
+func New(baseURL, token string) *Client {
+	return &Client{
+		BaseURL:    strings.TrimSuffix(baseURL, "/"),
+		Token:      token,
+		HTTPClient: http.DefaultClient,
+	}
+}
+
+// ListUsers returns a page of the users visible to the caller. The zero
+// ListOptions selects the first page, sorted by name.
+func (c *Client) ListUsers(ctx context.Context, opts ListOptions) (Page[User], error) {
+	return list[User](ctx, c, "/users", opts)
+}
+
+func (c *Client) GetUser(ctx context.Context, name string) (*User, error) {
+	var user User
+	if err := c.do(ctx, http.MethodGet, userPath(name), nil, &user); err != nil {
+		return nil, err
+	}
+	return &user, nil
+}
+
+// CreateUser creates a user and returns them with their temporary password.
+func (c *Client) CreateUser(ctx context.Context, req CreateUserRequest) (*User, string, error) {
+	var resp CreateUserResponse
+	if err := c.do(ctx, http.MethodPost, "/users", req, &resp); err != nil {
+		return nil, "", err
+	}
+	return &resp.User, resp.Password, nil
+}
+
+// UpdateUser changes the fields of req that are set.
+func (c *Client) UpdateUser(ctx context.Context, name string, req UpdateUserRequest) (*User, error) {
+	var user User
+	if err := c.do(ctx, http.MethodPatch, userPath(name), req, &user); err != nil {
+		return nil, err
+	}
+	return &user, nil
+}
+
+func (c *Client) DeleteUser(ctx context.Context, name string) error {
+	return c.do(ctx, http.MethodDelete, userPath(name), nil, nil)
+}
+
+// SetExpiry sets when the account expires. The zero time means never.
+func (c *Client) SetExpiry(ctx context.Context, name string, expiresAt time.Time) error {
+	return c.do(ctx, http.MethodPut, userPath(name)+"/expiry", api.ExpiryRequest{ExpiresAt: expiresAt}, nil)
+}
+
+// SuspendUser suspends a user until until, or indefinitely if it is zero.
+func (c *Client) SuspendUser(ctx context.Context, name, reason string, until time.Time) error {
+	return c.do(ctx, http.MethodPost, userPath(name)+"/suspend", api.SuspendRequest{Reason: reason, Until: until}, nil)
+}
+
+func (c *Client) ReactivateUser(ctx context.Context, name string) error {
+	return c.do(ctx, http.MethodPost, userPath(name)+"/reactivate", nil, nil)
+}
+
+func (c *Client) UnlockUser(ctx context.Context, name string) error {
+	return c.do(ctx, http.MethodPost, userPath(name)+"/unlock", nil, nil)
+}
+
+// LogoutUser ends every login session of a user.
+func (c *Client) LogoutUser(ctx context.Context, name string) error {
+	return c.do(ctx, http.MethodPost, userPath(name)+"/logout", nil, nil)
+}
+
+// ResetStreamKey replaces the stream key of a user and disconnects their
+// streams.
+func (c *Client) ResetStreamKey(ctx context.Context, name string) error {
+	return c.do(ctx, http.MethodPost, userPath(name)+"/reset_key", nil, nil)
+}
+
+// CreateResetLink returns a link the user can set a new password with.
+func (c *Client) CreateResetLink(ctx context.Context, name string) (string, error) {
+	var resp api.LinkResponse
+	err := c.do(ctx, http.MethodPost, userPath(name)+"/reset_link", nil, &resp)
+	return resp.Link, err
+}
+
+func (c *Client) CancelResetLink(ctx context.Context, name string) error {
+	return c.do(ctx, http.MethodDelete, userPath(name)+"/reset_link", nil, nil)
+}
+
+func (c *Client) SetMembership(ctx context.Context, name, namespace string, permission Permission) error {
+	return c.do(ctx, http.MethodPut, membershipPath(name, namespace), api.MembershipRequest{Permission: permission}, nil)
+}
+
+func (c *Client) RemoveMembership(ctx context.Context, name, namespace string) error {
+	return c.do(ctx, http.MethodDelete, membershipPath(name, namespace), nil, nil)
+}
+
+// ListNamespaces is like ListUsers for the namespaces the caller manages.
+func (c *Client) ListNamespaces(ctx context.Context, opts ListOptions) (Page[Namespace], error) {
+	return list[Namespace](ctx, c, "/namespaces", opts)
+}
+
+func (c *Client) GetNamespace(ctx context.Context, name string) (*Namespace, error) {
+	var namespace Namespace
+	if err := c.do(ctx, http.MethodGet, namespacePath(name), nil, &namespace); err != nil {
+		return nil, err
+	}
+	return &namespace, nil
+}
+
+func (c *Client) CreateNamespace(ctx context.Context, name string) (*Namespace, error) {
+	var namespace Namespace
+	if err := c.do(ctx, http.MethodPost, "/namespaces", api.CreateNamespaceRequest{Name: name}, &namespace); err != nil {
+		return nil, err
+	}
+	return &namespace, nil
+}
+
+func (c *Client) DeleteNamespace(ctx context.Context, name string) error {
+	return c.do(ctx, http.MethodDelete, namespacePath(name), nil, nil)
+}
+
+func (c *Client) ListSessions(ctx context.Context, namespace string) ([]Session, error) {
+	var sessions []Session
+	err := c.do(ctx, http.MethodGet, namespacePath(namespace)+"/sessions", nil, &sessions)
+	return sessions, err
+}
+
+func (c *Client) AddSession(ctx context.Context, namespace, name string) (*Session, error) {
+	var session Session
+	if err := c.do(ctx, http.MethodPost, namespacePath(namespace)+"/sessions", api.AddSessionRequest{Name: name}, &session); err != nil {
+		return nil, err
+	}
+	return &session, nil
+}
+
+func (c *Client) RemoveSession(ctx context.Context, namespace, key string) error {
+	return c.do(ctx, http.MethodDelete, namespacePath(namespace)+"/sessions/"+url.PathEscape(key), nil, nil)
+}
+
+func (c *Client) ListInvitations(ctx context.Context, namespace string) ([]Invitation, error) {
+	var invitations []Invitation
+	err := c.do(ctx, http.MethodGet, namespacePath(namespace)+"/invitations", nil, &invitations)
+	return invitations, err
+}
+
+// CreateInvitation creates an invitation link for up to maxUses people that
+// works for ttl.
+func (c *Client) CreateInvitation(ctx context.Context, namespace string, role Role, maxUses int, ttl time.Duration) (*CreateInvitationResponse, error) {
+	req := api.CreateInvitationRequest{Role: role, MaxUses: maxUses, ExpiresIn: ttl.String()}
+
+	var resp CreateInvitationResponse
+	if err := c.do(ctx, http.MethodPost, namespacePath(namespace)+"/invitations", req, &resp); err != nil {
+		return nil, err
+	}
+	return &resp, nil
+}
+
+func (c *Client) RevokeInvitation(ctx context.Context, namespace, id string) error {
+	return c.do(ctx, http.MethodDelete, namespacePath(namespace)+"/invitations/"+url.PathEscape(id), nil, nil)
+}
+
+// do sends body as JSON to path below /api/v1 and decodes the response into
+// out unless it is nil.
+// Backup downloads a consistent snapshot of the database into w. Only admins
+// may do this.
+func (c *Client) Backup(ctx context.Context, w io.Writer) error {
+	resp, err := c.send(ctx, "GET", "/backup", "", nil)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	_, err = io.Copy(w, resp.Body)
+	return err
+}
+
+// Restore replaces the database with the snapshot read from r, as written by
+// Backup. Only admins may do this.
+func (c *Client) Restore(ctx context.Context, r io.Reader) error {
+	resp, err := c.send(ctx, "POST", "/restore", "application/octet-stream", r)
+	if err != nil {
+		return err
+	}
+	return resp.Body.Close()
+}
+
+func (c *Client) do(ctx context.Context, method, path string, body, out any) error {
+	var reader io.Reader
+	var contentType string
+	if body != nil {
+		data, err := json.Marshal(body)
+		if err != nil {
+			return err
+		}
+		reader = bytes.NewReader(data)
+		contentType = "application/json"
+	}
+
+	resp, err := c.send(ctx, method, path, contentType, reader)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if out == nil {
+		return nil
+	}
+
+	if err := json.NewDecoder(resp.Body).Decode(out); err != nil {
+		return fmt.Errorf("invalid response: %w", err)
+	}
+
+	return nil
+}
+
+// list gets the page of the list at path selected by opts.
+func list[T any](ctx context.Context, c *Client, path string, opts ListOptions) (Page[T], error) {
+	query := url.Values{}
+	for name, value := range map[string]string{"q": opts.Search, "sort": opts.Sort, "cursor": opts.Cursor} {
+		if value != "" {
+			query.Set(name, value)
+		}
+	}
+	for name, value := range map[string]bool{"prefix": opts.Prefix, "desc": opts.Desc} {
+		if value {
+			query.Set(name, "true")
+		}
+	}
+	if opts.Limit > 0 {
+		query.Set("limit", strconv.Itoa(opts.Limit))
+	}
+	if len(query) > 0 {
+		path += "?" + query.Encode()
+	}
+
+	resp, err := c.send(ctx, http.MethodGet, path, "", nil)
+	if err != nil {
+		return Page[T]{}, err
+	}
+	defer resp.Body.Close()
+
+	page := Page[T]{Next: resp.Header.Get("X-Next-Cursor")}
+	if err := json.NewDecoder(resp.Body).Decode(&page.Items); err != nil {
+		return Page[T]{}, fmt.Errorf("invalid response: %w", err)
+	}
+	return page, nil
+}
+
+// send makes an authenticated request and turns error responses into an
+// *Error. The caller must close the body of the returned response.
+func (c *Client) send(ctx context.Context, method, path, contentType string, body io.Reader) (*http.Response, error) {
+	req, err := http.NewRequestWithContext(ctx, method, c.BaseURL+"/api/v1"+path, body)
+	if err != nil {
+		return nil, err
+	}
+
+	if contentType != "" {
+		req.Header.Set("Content-Type", contentType)
+	}
+	req.Header.Set("Authorization", "Bearer "+c.Token)
+
+	resp, err := c.HTTPClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+
+	if resp.StatusCode >= 400 {
+		defer resp.Body.Close()
+
+		var errResp api.ErrorResponse
+		if err := json.NewDecoder(resp.Body).Decode(&errResp); err != nil {
+			errResp.Error = resp.Status
+		}
+		return nil, &Error{StatusCode: resp.StatusCode, Message: errResp.Error}
+	}
+
+	return resp, nil
+}
+
+func userPath(name string) string {
+	return "/users/" + url.PathEscape(name)
+}
+
+func membershipPath(name, namespace string) string {
+	return userPath(name) + "/memberships/" + url.PathEscape(namespace)
+}
+
+func namespacePath(name string) string {
+	return "/namespaces/" + url.PathEscape(name)
+}
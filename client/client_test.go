@@ -0,0 +1,209 @@
+package client
+
+import (
+	"MediaMTXAuth/internal"
+	"MediaMTXAuth/internal/server"
+	"MediaMTXAuth/internal/services"
+	"MediaMTXAuth/internal/storage/memory"
+	"bytes"
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestClient(t *testing.T) {
+	storage := &memory.Storage{}
+	_ = storage.Init()
+	userService := services.NewUserService(storage)
+	namespaceService := services.NewNamespaceService(storage)
+
+	srv := httptest.NewServer(server.New(userService, namespaceService))
+	t.Cleanup(srv.Close)
+
+	ctx := context.Background()
+
+	adminClient := func(t *testing.T) *Client {
+		t.Helper()
+		_, _ = userService.Create("admin", "password", true, "")
+		_, token, err := userService.CreateAPIToken("admin", "test", internal.ScopeAdmin, time.Hour)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return New(srv.URL, token)
+	}
+
+	t.Run("users", func(t *testing.T) {
+		t.Cleanup(storage.Clear)
+		c := adminClient(t)
+
+		if _, err := c.CreateNamespace(ctx, "ns"); err != nil {
+			t.Fatalf("Failed to create namespace: %v", err)
+		}
+
+		user, password, err := c.CreateUser(ctx, CreateUserRequest{Name: "user1", Namespace: "ns"})
+		if err != nil {
+			t.Fatalf("Failed to create user: %v", err)
+		}
+
+		if user.Name != "user1" || password == "" {
+			t.Errorf("Unexpected user %+v with password %q", user, password)
+		}
+
+		if _, err := userService.Login("user1", password); err != nil {
+			t.Errorf("Temporary password should work: %v", err)
+		}
+
+		users, err := c.ListUsers(ctx, ListOptions{})
+		if err != nil || len(users.Items) != 2 {
+			t.Errorf("Expected 2 users, got %v, %v", users, err)
+		}
+
+		users, err = c.ListUsers(ctx, ListOptions{Limit: 1})
+		if err != nil || len(users.Items) != 1 || users.Next == "" {
+			t.Fatalf("Expected a page of 1 user, got %v, %v", users, err)
+		}
+
+		users, err = c.ListUsers(ctx, ListOptions{Limit: 1, Cursor: users.Next})
+		if err != nil || len(users.Items) != 1 || users.Items[0].Name != "user1" || users.Next != "" {
+			t.Errorf("Expected user1 on the last page, got %v, %v", users, err)
+		}
+
+		if users, err := c.ListUsers(ctx, ListOptions{Search: "user"}); err != nil || len(users.Items) != 1 {
+			t.Errorf("Expected user1 to be found, got %v, %v", users, err)
+		}
+
+		if err := c.SetMembership(ctx, "user1", "ns", PermissionRead); err != nil {
+			t.Errorf("Failed to set membership: %v", err)
+		}
+
+		newName := "user2"
+		if user, err = c.UpdateUser(ctx, "user1", UpdateUserRequest{Name: &newName}); err != nil || user.Name != newName {
+			t.Errorf("Failed to rename user: %+v, %v", user, err)
+		}
+
+		if user, _ = c.GetUser(ctx, newName); len(user.Memberships) != 1 || user.Memberships[0].Permission != PermissionRead {
+			t.Errorf("Unexpected memberships %+v", user.Memberships)
+		}
+
+		if err := c.SuspendUser(ctx, newName, "spam", time.Time{}); err != nil {
+			t.Errorf("Failed to suspend user: %v", err)
+		}
+
+		if user, _ = c.GetUser(ctx, newName); user.Suspension == nil || user.Suspension.Reason != "spam" {
+			t.Errorf("Expected user to be suspended, got %+v", user.Suspension)
+		}
+
+		if err := c.ReactivateUser(ctx, newName); err != nil {
+			t.Errorf("Failed to reactivate user: %v", err)
+		}
+
+		if err := c.ResetStreamKey(ctx, newName); err != nil {
+			t.Errorf("Failed to reset stream key: %v", err)
+		}
+
+		if link, err := c.CreateResetLink(ctx, newName); err != nil || link == "" {
+			t.Errorf("Failed to create reset link: %q, %v", link, err)
+		}
+
+		if err := c.DeleteUser(ctx, newName); err != nil {
+			t.Errorf("Failed to delete user: %v", err)
+		}
+
+		if _, err := c.GetUser(ctx, newName); !errors.Is(err, ErrUserNotFound) {
+			t.Errorf("Expected ErrUserNotFound, got %v", err)
+		}
+	})
+
+	t.Run("namespaces", func(t *testing.T) {
+		t.Cleanup(storage.Clear)
+		c := adminClient(t)
+
+		if _, err := c.CreateNamespace(ctx, "ns"); err != nil {
+			t.Fatalf("Failed to create namespace: %v", err)
+		}
+
+		if _, err := c.CreateNamespace(ctx, "ns"); !errors.Is(err, ErrNamespaceAlreadyExists) {
+			t.Errorf("Expected ErrNamespaceAlreadyExists, got %v", err)
+		}
+
+		session, err := c.AddSession(ctx, "ns", "event")
+		if err != nil {
+			t.Fatalf("Failed to add session: %v", err)
+		}
+
+		if sessions, _ := c.ListSessions(ctx, "ns"); len(sessions) != 1 || sessions[0].Key != session.Key {
+			t.Errorf("Expected the new session, got %+v", sessions)
+		}
+
+		if err := c.RemoveSession(ctx, "ns", session.Key); err != nil {
+			t.Errorf("Failed to remove session: %v", err)
+		}
+
+		invitation, err := c.CreateInvitation(ctx, "ns", RoleUser, 3, 24*time.Hour)
+		if err != nil {
+			t.Fatalf("Failed to create invitation: %v", err)
+		}
+
+		if invitations, _ := c.ListInvitations(ctx, "ns"); len(invitations) != 1 || invitations[0].MaxUses != 3 {
+			t.Errorf("Expected the new invitation, got %+v", invitations)
+		}
+
+		if err := c.RevokeInvitation(ctx, "ns", invitation.Invitation.ID); err != nil {
+			t.Errorf("Failed to revoke invitation: %v", err)
+		}
+
+		if err := c.DeleteNamespace(ctx, "ns"); err != nil {
+			t.Errorf("Failed to delete namespace: %v", err)
+		}
+
+		if _, err := c.GetNamespace(ctx, "ns"); !errors.Is(err, ErrNamespaceNotFound) {
+			t.Errorf("Expected ErrNamespaceNotFound, got %v", err)
+		}
+	})
+
+	t.Run("errors", func(t *testing.T) {
+		t.Cleanup(storage.Clear)
+
+		var apiErr *Error
+		_, err := New(srv.URL, "invalid").ListUsers(ctx, ListOptions{})
+		if !errors.As(err, &apiErr) || apiErr.StatusCode != http.StatusUnauthorized {
+			t.Errorf("Expected 401, got %v", err)
+		}
+
+		_, _ = userService.Create("user1", "password", false, "")
+		_, token, _ := userService.CreateAPIToken("user1", "test", internal.ScopeRead, time.Hour)
+		if _, err := New(srv.URL, token).ListUsers(ctx, ListOptions{}); !errors.Is(err, ErrForbidden) {
+			t.Errorf("Expected ErrForbidden, got %v", err)
+		}
+	})
+
+	t.Run("backup", func(t *testing.T) {
+		t.Cleanup(storage.Clear)
+		c := adminClient(t)
+
+		var apiErr *Error
+		var snapshot bytes.Buffer
+		if err := c.Backup(ctx, &snapshot); !errors.As(err, &apiErr) || apiErr.StatusCode != http.StatusNotImplemented {
+			t.Errorf("Expected 501 for the memory storage, got %v", err)
+		}
+
+		if snapshot.Len() != 0 {
+			t.Errorf("Error response should not be written as a snapshot")
+		}
+	})
+
+	t.Run("openapi", func(t *testing.T) {
+		resp, err := http.Get(srv.URL + "/api/v1/openapi.json")
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+
+		if resp.StatusCode != http.StatusOK || resp.Header.Get("Content-Type") != "application/json" {
+			t.Errorf("Expected the OpenAPI document, got %d %s", resp.StatusCode, resp.Header.Get("Content-Type"))
+		}
+	})
+}
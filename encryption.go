@@ -0,0 +1,86 @@
+package main
+
+import (
+	"MediaMTXAuth/internal/storage"
+	"MediaMTXAuth/internal/storage/encrypted"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+)
+
+// encryptionKey returns the key from the environment variable name or the file
+// named by name_FILE, or nil if neither is set.
+func encryptionKey(name string) ([]byte, error) {
+	text := os.Getenv(name)
+
+	if text == "" {
+		path := os.Getenv(name + "_FILE")
+		if path == "" {
+			return nil, nil
+		}
+
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read %s: %w", name, err)
+		}
+		text = string(data)
+	}
+
+	key, err := encrypted.ParseKey(text)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", name, err)
+	}
+	return key, nil
+}
+
+// initStorage initializes store and, if ENCRYPTION_KEY or ENCRYPTION_KEY_FILE
+// is set, returns it wrapped to encrypt its secrets. ENCRYPTION_OLD_KEY or
+// ENCRYPTION_OLD_KEY_FILE give the key being rotated out. Records not sealed
+// with the current key are re-encrypted in the background.
+func initStorage(store storage.Storage) (storage.Storage, error) {
+	key, err := encryptionKey("ENCRYPTION_KEY")
+	if err != nil {
+		return nil, err
+	}
+
+	oldKey, err := encryptionKey("ENCRYPTION_OLD_KEY")
+	if err != nil {
+		return nil, err
+	}
+
+	if key == nil {
+		if oldKey != nil {
+			return nil, errors.New("ENCRYPTION_OLD_KEY is set without ENCRYPTION_KEY")
+		}
+
+		if err := store.Init(); err != nil {
+			return nil, err
+		}
+		return store, encrypted.CheckUnencrypted(store)
+	}
+
+	encryptedStore, err := encrypted.New(store, key, oldKey)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := encryptedStore.Init(); err != nil {
+		return nil, err
+	}
+
+	if encryptedStore.Pending() {
+		go func() {
+			log.Println("Re-encrypting database records in the background")
+
+			count, err := encryptedStore.Reencrypt()
+			if err != nil {
+				log.Printf("failed to re-encrypt database: %v", err)
+				return
+			}
+			log.Printf("Re-encrypted %d database records", count)
+		}()
+	}
+
+	return encryptedStore, nil
+}
@@ -0,0 +1,182 @@
+package api
+
+import (
+	"MediaMTXAuth/internal"
+	"MediaMTXAuth/internal/storage"
+	"MediaMTXAuth/internal/views/handlers"
+	_ "embed"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+)
+
+// OpenAPI describes the API. It is served at /api/v1/openapi.json.
+//
+//go:embed openapi.json
+var OpenAPI []byte
+
+// API is the JSON admin API under /api/v1. It offers the actions of the admin
+// pages to scripts and provisioning tools, which authenticate with an API
+// token or a login session.
+type API struct {
+	UserService      internal.UserService
+	NamespaceService internal.NamespaceService
+	// Kicker disconnects live streams of suspended users. It is optional.
+	Kicker internal.SessionKicker
+	// Backuper takes and restores database snapshots. It is optional.
+	Backuper storage.Backuper
+
+	mux *http.ServeMux
+	// routes lists the registered patterns, such as "GET /api/v1/users".
+	routes []string
+}
+
+// handlerFunc handles a request of an authenticated admin or manager. Errors
+// are written as an ErrorResponse.
+type handlerFunc func(w http.ResponseWriter, r *http.Request, actor *internal.User) error
+
+func New(userService internal.UserService, namespaceService internal.NamespaceService) *API {
+	a := &API{
+		UserService:      userService,
+		NamespaceService: namespaceService,
+		mux:              http.NewServeMux(),
+	}
+
+	a.handle("GET /api/v1/users", a.listUsers)
+	a.handle("POST /api/v1/users", a.createUser)
+	a.handle("GET /api/v1/users/{name}", a.getUser)
+	a.handle("PATCH /api/v1/users/{name}", a.updateUser)
+	a.handle("DELETE /api/v1/users/{name}", a.deleteUser)
+	a.handle("PUT /api/v1/users/{name}/expiry", a.setExpiry)
+	a.handle("POST /api/v1/users/{name}/suspend", a.suspendUser)
+	a.handle("POST /api/v1/users/{name}/reactivate", a.reactivateUser)
+	a.handle("POST /api/v1/users/{name}/unlock", a.unlockUser)
+	a.handle("POST /api/v1/users/{name}/logout", a.logoutUser)
+	a.handle("POST /api/v1/users/{name}/reset_key", a.resetStreamKey)
+	a.handle("POST /api/v1/users/{name}/reset_link", a.createResetLink)
+	a.handle("DELETE /api/v1/users/{name}/reset_link", a.cancelResetLink)
+	a.handle("PUT /api/v1/users/{name}/memberships/{namespace}", a.setMembership)
+	a.handle("DELETE /api/v1/users/{name}/memberships/{namespace}", a.removeMembership)
+
+	a.handle("GET /api/v1/namespaces", a.listNamespaces)
+	a.handle("POST /api/v1/namespaces", a.createNamespace)
+	a.handle("GET /api/v1/namespaces/{namespace}", a.getNamespace)
+	a.handle("DELETE /api/v1/namespaces/{namespace}", a.deleteNamespace)
+	a.handle("GET /api/v1/namespaces/{namespace}/sessions", a.listSessions)
+	a.handle("POST /api/v1/namespaces/{namespace}/sessions", a.addSession)
+	a.handle("DELETE /api/v1/namespaces/{namespace}/sessions/{key}", a.removeSession)
+	a.handle("GET /api/v1/namespaces/{namespace}/invitations", a.listInvitations)
+	a.handle("POST /api/v1/namespaces/{namespace}/invitations", a.createInvitation)
+	a.handle("DELETE /api/v1/namespaces/{namespace}/invitations/{id}", a.revokeInvitation)
+
+	a.handle("GET /api/v1/backup", a.backup)
+	a.handle("POST /api/v1/restore", a.restore)
+
+	a.mux.HandleFunc("GET /api/v1/openapi.json", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		_, _ = w.Write(OpenAPI)
+	})
+
+	a.mux.HandleFunc("/api/v1/", func(w http.ResponseWriter, r *http.Request) {
+		writeError(w, ErrNotFound)
+	})
+
+	return a
+}
+
+func (a *API) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	a.mux.ServeHTTP(w, r)
+}
+
+// handle registers handler behind the same authentication as the admin pages.
+func (a *API) handle(pattern string, handler handlerFunc) {
+	a.routes = append(a.routes, pattern)
+	a.mux.HandleFunc(pattern, func(w http.ResponseWriter, r *http.Request) {
+		actor, err := handlers.AuthenticateManager(a.UserService, r)
+		if err != nil {
+			// Suspended users and the like must not learn more than that
+			// their credentials do not work.
+			if !errors.Is(err, internal.ErrForbidden) && !errors.Is(err, handlers.ErrReadOnlyToken) {
+				err = handlers.ErrNotLoggedIn
+			}
+			writeError(w, err)
+			return
+		}
+
+		if err := handler(w, r, actor); err != nil {
+			writeError(w, err)
+		}
+	})
+}
+
+// decode reads the JSON body of r into v.
+func decode(r *http.Request, v any) error {
+	decoder := json.NewDecoder(r.Body)
+	decoder.DisallowUnknownFields()
+	if err := decoder.Decode(v); err != nil {
+		return errors.Join(ErrInvalidBody, err)
+	}
+	return nil
+}
+
+// nextCursorHeader carries the cursor of the following page of a list.
+const nextCursorHeader = "X-Next-Cursor"
+
+// listOptions reads the search, sort and paging parameters of a list request.
+func listOptions(r *http.Request) (internal.ListOptions, error) {
+	query := r.URL.Query()
+	opts := internal.ListOptions{
+		Search: query.Get("q"),
+		Sort:   query.Get("sort"),
+		Cursor: query.Get("cursor"),
+	}
+
+	for name, flag := range map[string]*bool{"prefix": &opts.Prefix, "desc": &opts.Desc} {
+		if value := query.Get(name); value != "" {
+			parsed, err := strconv.ParseBool(value)
+			if err != nil {
+				return opts, fmt.Errorf("%w: %s", ErrInvalidQuery, name)
+			}
+			*flag = parsed
+		}
+	}
+
+	if value := query.Get("limit"); value != "" {
+		limit, err := strconv.Atoi(value)
+		if err != nil || limit < 1 {
+			return opts, fmt.Errorf("%w: limit", ErrInvalidQuery)
+		}
+		opts.Limit = limit
+	}
+
+	return opts, nil
+}
+
+// writePage writes the items of a page as a JSON array, with the cursor of
+// the next page in the X-Next-Cursor header.
+func writePage[T any](w http.ResponseWriter, items []T, next string) error {
+	if next != "" {
+		w.Header().Set(nextCursorHeader, next)
+	}
+	return writeJSON(w, http.StatusOK, items)
+}
+
+func writeJSON(w http.ResponseWriter, status int, v any) error {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	return json.NewEncoder(w).Encode(v)
+}
+
+func writeError(w http.ResponseWriter, err error) {
+	status := StatusCode(err)
+	message := err.Error()
+	if status == http.StatusInternalServerError {
+		log.Printf("API error: %v", err)
+		message = "internal server error"
+	}
+
+	_ = writeJSON(w, status, ErrorResponse{Error: message})
+}
@@ -0,0 +1,556 @@
+package api
+
+import (
+	"MediaMTXAuth/internal"
+	"MediaMTXAuth/internal/services"
+	"MediaMTXAuth/internal/storage"
+	"MediaMTXAuth/internal/storage/bolt"
+	"MediaMTXAuth/internal/storage/memory"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"reflect"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestAPI(t *testing.T) {
+	storage := &memory.Storage{}
+	_ = storage.Init()
+	userService := services.NewUserService(storage)
+	namespaceService := services.NewNamespaceService(storage)
+	api := New(userService, namespaceService)
+
+	// request sends body as JSON with token and decodes the response into out.
+	request := func(t *testing.T, method, path, token string, body, out any) int {
+		t.Helper()
+
+		var buf bytes.Buffer
+		if body != nil {
+			_ = json.NewEncoder(&buf).Encode(body)
+		}
+
+		req := httptest.NewRequest(method, path, &buf)
+		if token != "" {
+			req.Header.Set("Authorization", "Bearer "+token)
+		}
+		rec := httptest.NewRecorder()
+
+		api.ServeHTTP(rec, req)
+
+		if out != nil {
+			if err := json.NewDecoder(rec.Body).Decode(out); err != nil {
+				t.Fatalf("Failed to decode response to %s %s: %v", method, path, err)
+			}
+		}
+
+		return rec.Code
+	}
+
+	adminToken := func(t *testing.T) string {
+		t.Helper()
+		_, _ = userService.Create("admin", "password", true, "")
+		_, token, err := userService.CreateAPIToken("admin", "test", internal.ScopeAdmin, time.Hour)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return token
+	}
+
+	t.Run("unauthenticated", func(t *testing.T) {
+		t.Cleanup(storage.Clear)
+
+		var body ErrorResponse
+		if status := request(t, "GET", "/api/v1/users", "", nil, &body); status != http.StatusUnauthorized {
+			t.Errorf("Expected 401, got %d", status)
+		}
+
+		if body.Error == "" {
+			t.Errorf("Expected an error message")
+		}
+
+		if status := request(t, "GET", "/api/v1/users", "invalid", nil, nil); status != http.StatusUnauthorized {
+			t.Errorf("Expected 401, got %d", status)
+		}
+	})
+
+	t.Run("session cookie", func(t *testing.T) {
+		t.Cleanup(storage.Clear)
+		_, _ = userService.Register("admin", "password", true, "")
+		user, _ := userService.Login("admin", "password")
+
+		req := httptest.NewRequest("GET", "/api/v1/users", nil)
+		req.AddCookie(&http.Cookie{Name: "session_id", Value: fmt.Sprintf("%d", user.Session.ID)})
+		req.AddCookie(&http.Cookie{Name: "username", Value: user.Name})
+		rec := httptest.NewRecorder()
+
+		api.ServeHTTP(rec, req)
+
+		if rec.Code != http.StatusOK {
+			t.Errorf("Expected 200, got %d", rec.Code)
+		}
+	})
+
+	t.Run("read-only token", func(t *testing.T) {
+		t.Cleanup(storage.Clear)
+		_, _ = userService.Create("admin", "password", true, "")
+		_, token, _ := userService.CreateAPIToken("admin", "test", internal.ScopeRead, time.Hour)
+
+		if status := request(t, "GET", "/api/v1/users", token, nil, nil); status != http.StatusOK {
+			t.Errorf("Expected 200, got %d", status)
+		}
+
+		if status := request(t, "POST", "/api/v1/namespaces", token, CreateNamespaceRequest{Name: "ns"}, nil); status != http.StatusForbidden {
+			t.Errorf("Expected 403, got %d", status)
+		}
+	})
+
+	t.Run("create, get and delete user", func(t *testing.T) {
+		t.Cleanup(storage.Clear)
+		token := adminToken(t)
+		_, _ = namespaceService.Create("ns")
+
+		var created CreateUserResponse
+		status := request(t, "POST", "/api/v1/users", token, CreateUserRequest{Name: "user1", Namespace: "ns"}, &created)
+		if status != http.StatusCreated {
+			t.Fatalf("Expected 201, got %d", status)
+		}
+
+		if created.Password == "" || created.User.Name != "user1" || len(created.User.Memberships) != 1 {
+			t.Errorf("Unexpected response %+v", created)
+		}
+
+		var user User
+		if status := request(t, "GET", "/api/v1/users/user1", token, nil, &user); status != http.StatusOK || user.Name != "user1" {
+			t.Errorf("Expected user1, got %d %+v", status, user)
+		}
+
+		var body ErrorResponse
+		if status := request(t, "POST", "/api/v1/users", token, CreateUserRequest{Name: "user1"}, &body); status != http.StatusConflict {
+			t.Errorf("Expected 409, got %d", status)
+		}
+
+		if body.Error != internal.ErrUserAlreadyExists.Error() {
+			t.Errorf("Expected %q, got %q", internal.ErrUserAlreadyExists, body.Error)
+		}
+
+		if status := request(t, "POST", "/api/v1/users", token, CreateUserRequest{Name: "user2", Namespace: "missing", IsManager: true}, nil); status != http.StatusNotFound {
+			t.Errorf("Expected 404, got %d", status)
+		}
+		if status := request(t, "GET", "/api/v1/users/user2", token, nil, nil); status != http.StatusNotFound {
+			t.Errorf("Expected no half configured user, got %d", status)
+		}
+
+		if status := request(t, "DELETE", "/api/v1/users/user1", token, nil, nil); status != http.StatusNoContent {
+			t.Errorf("Expected 204, got %d", status)
+		}
+
+		if status := request(t, "GET", "/api/v1/users/user1", token, nil, &body); status != http.StatusNotFound {
+			t.Errorf("Expected 404, got %d", status)
+		}
+	})
+
+	t.Run("update user", func(t *testing.T) {
+		t.Cleanup(storage.Clear)
+		token := adminToken(t)
+		_, _ = userService.Create("user1", "password", false, "")
+
+		name := "user2"
+		isAdmin := true
+		var user User
+		status := request(t, "PATCH", "/api/v1/users/user1", token, UpdateUserRequest{Name: &name, IsAdmin: &isAdmin}, &user)
+		if status != http.StatusOK {
+			t.Fatalf("Expected 200, got %d", status)
+		}
+
+		if user.Name != "user2" || !user.IsAdmin {
+			t.Errorf("Unexpected user %+v", user)
+		}
+
+		isAdmin = false
+		if status := request(t, "PATCH", "/api/v1/users/admin", token, UpdateUserRequest{IsAdmin: &isAdmin}, nil); status != http.StatusForbidden {
+			t.Errorf("Expected 403 when demoting yourself, got %d", status)
+		}
+	})
+
+	t.Run("user actions", func(t *testing.T) {
+		t.Cleanup(storage.Clear)
+		token := adminToken(t)
+		created, _ := userService.Create("user1", "password", false, "")
+
+		if status := request(t, "POST", "/api/v1/users/user1/reset_key", token, nil, nil); status != http.StatusNoContent {
+			t.Errorf("Expected 204, got %d", status)
+		}
+
+		if user, _ := userService.Get("user1"); user.StreamKey == created.StreamKey {
+			t.Errorf("Stream key should have changed")
+		}
+
+		if status := request(t, "POST", "/api/v1/users/user1/suspend", token, SuspendRequest{Reason: "spam"}, nil); status != http.StatusNoContent {
+			t.Errorf("Expected 204, got %d", status)
+		}
+
+		if user, _ := userService.Get("user1"); user.Suspension.Reason != "spam" || user.Suspension.By != "admin" {
+			t.Errorf("Unexpected suspension %+v", user.Suspension)
+		}
+
+		if status := request(t, "POST", "/api/v1/users/admin/suspend", token, SuspendRequest{}, nil); status != http.StatusForbidden {
+			t.Errorf("Expected 403 when suspending yourself, got %d", status)
+		}
+
+		expiresAt := time.Now().Add(time.Hour).Truncate(time.Second)
+		if status := request(t, "PUT", "/api/v1/users/user1/expiry", token, ExpiryRequest{ExpiresAt: expiresAt}, nil); status != http.StatusNoContent {
+			t.Errorf("Expected 204, got %d", status)
+		}
+
+		if user, _ := userService.Get("user1"); !user.ExpiresAt.Equal(expiresAt) {
+			t.Errorf("Expected expiry %v, got %v", expiresAt, user.ExpiresAt)
+		}
+	})
+
+	t.Run("config file records are read-only", func(t *testing.T) {
+		t.Cleanup(storage.Clear)
+		token := adminToken(t)
+		_, _ = namespaceService.Create("ns")
+		_ = namespaceService.SetFromConfig("ns", true)
+		_, _ = userService.Create("declared", "password", false, "ns")
+		_ = userService.SetFromConfig("declared", true)
+
+		name := "renamed"
+		forbidden := []struct {
+			method, path string
+			body         any
+		}{
+			{"PATCH", "/api/v1/users/declared", UpdateUserRequest{Name: &name}},
+			{"DELETE", "/api/v1/users/declared", nil},
+			{"PUT", "/api/v1/users/declared/expiry", ExpiryRequest{ExpiresAt: time.Now().Add(time.Hour)}},
+			{"POST", "/api/v1/users/declared/reset_key", nil},
+			{"POST", "/api/v1/users/declared/reset_link", nil},
+			{"PUT", "/api/v1/users/declared/memberships/ns", MembershipRequest{Permission: internal.PermissionRead}},
+			{"DELETE", "/api/v1/namespaces/ns", nil},
+		}
+		for _, test := range forbidden {
+			if status := request(t, test.method, test.path, token, test.body, nil); status != http.StatusForbidden {
+				t.Errorf("%s %s: expected 403, got %d", test.method, test.path, status)
+			}
+		}
+
+		if user, _ := userService.Get("declared"); user == nil || !user.ExpiresAt.IsZero() || user.PasswordReset.IsPending() {
+			t.Errorf("Declared user should be unchanged, got %+v", user)
+		}
+		if namespace, _ := namespaceService.Get("ns"); namespace == nil {
+			t.Errorf("Declared namespace should not be deleted")
+		}
+
+		if status := request(t, "POST", "/api/v1/users/declared/suspend", token, SuspendRequest{}, nil); status != http.StatusNoContent {
+			t.Errorf("Expected suspending a declared user to work, got %d", status)
+		}
+	})
+
+	t.Run("memberships", func(t *testing.T) {
+		t.Cleanup(storage.Clear)
+		token := adminToken(t)
+		_, _ = userService.Create("user1", "password", false, "")
+		_, _ = namespaceService.Create("ns")
+
+		if status := request(t, "PUT", "/api/v1/users/user1/memberships/ns", token, MembershipRequest{Permission: internal.PermissionRead}, nil); status != http.StatusNoContent {
+			t.Errorf("Expected 204, got %d", status)
+		}
+
+		var body ErrorResponse
+		if status := request(t, "PUT", "/api/v1/users/user1/memberships/ns", token, MembershipRequest{Permission: "write"}, &body); status != http.StatusBadRequest {
+			t.Errorf("Expected 400, got %d", status)
+		}
+
+		if status := request(t, "DELETE", "/api/v1/users/user1/memberships/ns", token, nil, nil); status != http.StatusNoContent {
+			t.Errorf("Expected 204, got %d", status)
+		}
+
+		if user, _ := userService.Get("user1"); len(user.Memberships) != 0 {
+			t.Errorf("Membership should have been removed")
+		}
+	})
+
+	t.Run("namespaces and sessions", func(t *testing.T) {
+		t.Cleanup(storage.Clear)
+		token := adminToken(t)
+
+		var namespace Namespace
+		if status := request(t, "POST", "/api/v1/namespaces", token, CreateNamespaceRequest{Name: "ns"}, &namespace); status != http.StatusCreated {
+			t.Fatalf("Expected 201, got %d", status)
+		}
+
+		var session Session
+		if status := request(t, "POST", "/api/v1/namespaces/ns/sessions", token, AddSessionRequest{Name: "event"}, &session); status != http.StatusCreated {
+			t.Fatalf("Expected 201, got %d", status)
+		}
+
+		var sessions []Session
+		if status := request(t, "GET", "/api/v1/namespaces/ns/sessions", token, nil, &sessions); status != http.StatusOK || len(sessions) != 1 {
+			t.Errorf("Expected one session, got %d %+v", status, sessions)
+		}
+
+		if status := request(t, "DELETE", "/api/v1/namespaces/ns/sessions/"+session.Key, token, nil, nil); status != http.StatusNoContent {
+			t.Errorf("Expected 204, got %d", status)
+		}
+
+		var invitation CreateInvitationResponse
+		status := request(t, "POST", "/api/v1/namespaces/ns/invitations", token, CreateInvitationRequest{Role: internal.RoleUser, MaxUses: 1, ExpiresIn: "24h"}, &invitation)
+		if status != http.StatusCreated || invitation.Link == "" {
+			t.Errorf("Expected an invitation link, got %d %+v", status, invitation)
+		}
+
+		if status := request(t, "DELETE", "/api/v1/namespaces/ns", token, nil, nil); status != http.StatusNoContent {
+			t.Errorf("Expected 204, got %d", status)
+		}
+
+		if status := request(t, "GET", "/api/v1/namespaces/ns", token, nil, nil); status != http.StatusNotFound {
+			t.Errorf("Expected 404, got %d", status)
+		}
+	})
+
+	t.Run("manager", func(t *testing.T) {
+		t.Cleanup(storage.Clear)
+		_, _ = namespaceService.Create("ns")
+		_, _ = namespaceService.Create("other")
+		_, _ = userService.Create("manager", "password", false, "ns")
+		_ = userService.SetManagedNamespaces("manager", []string{"ns"})
+		_, _ = userService.Create("user1", "password", false, "ns")
+		_, _ = userService.Create("user2", "password", false, "other")
+		_, token, _ := userService.CreateAPIToken("manager", "test", internal.ScopeNamespaceWrite, time.Hour)
+
+		var users []User
+		if status := request(t, "GET", "/api/v1/users", token, nil, &users); status != http.StatusOK || len(users) != 2 {
+			t.Errorf("Expected the manager and user1, got %d %+v", status, users)
+		}
+
+		if status := request(t, "GET", "/api/v1/namespaces/other", token, nil, nil); status != http.StatusForbidden {
+			t.Errorf("Expected 403, got %d", status)
+		}
+
+		if status := request(t, "DELETE", "/api/v1/users/user2", token, nil, nil); status != http.StatusForbidden {
+			t.Errorf("Expected 403, got %d", status)
+		}
+
+		if status := request(t, "POST", "/api/v1/namespaces", token, CreateNamespaceRequest{Name: "new"}, nil); status != http.StatusForbidden {
+			t.Errorf("Expected 403, got %d", status)
+		}
+	})
+
+	t.Run("regular user", func(t *testing.T) {
+		t.Cleanup(storage.Clear)
+		_, _ = userService.Create("user1", "password", false, "")
+		_, token, _ := userService.CreateAPIToken("user1", "test", internal.ScopeRead, time.Hour)
+
+		if status := request(t, "GET", "/api/v1/users", token, nil, nil); status != http.StatusForbidden {
+			t.Errorf("Expected 403, got %d", status)
+		}
+	})
+
+	t.Run("invalid body and unknown path", func(t *testing.T) {
+		t.Cleanup(storage.Clear)
+		token := adminToken(t)
+
+		var body ErrorResponse
+		if status := request(t, "POST", "/api/v1/namespaces", token, map[string]int{"unknown": 1}, &body); status != http.StatusBadRequest {
+			t.Errorf("Expected 400, got %d", status)
+		}
+
+		if status := request(t, "GET", "/api/v1/unknown", token, nil, &body); status != http.StatusNotFound || body.Error != ErrNotFound.Error() {
+			t.Errorf("Expected 404 with a JSON body, got %d %+v", status, body)
+		}
+
+		for _, query := range []string{"limit=0", "desc=maybe", "sort=password", "cursor=invalid"} {
+			if status := request(t, "GET", "/api/v1/users?"+query, token, nil, nil); status != http.StatusBadRequest {
+				t.Errorf("Expected 400 for %s, got %d", query, status)
+			}
+		}
+	})
+}
+
+func TestStatusCode(t *testing.T) {
+	cases := map[error]int{
+		internal.ErrUserNotFound:                         http.StatusNotFound,
+		fmt.Errorf("wrapped: %w", internal.ErrForbidden): http.StatusForbidden,
+		services.ErrShortUsername:                        http.StatusBadRequest,
+		internal.ErrNamespaceAlreadyExists:               http.StatusConflict,
+		errors.New("disk full"):                          http.StatusInternalServerError,
+	}
+
+	for err, expected := range cases {
+		if status := StatusCode(err); status != expected {
+			t.Errorf("Expected %d for %v, got %d", expected, err, status)
+		}
+	}
+}
+
+// TestOpenAPI checks that the OpenAPI document describes exactly the routes
+// of the API.
+func TestOpenAPI(t *testing.T) {
+	var doc struct {
+		OpenAPI string                                `json:"openapi"`
+		Paths   map[string]map[string]json.RawMessage `json:"paths"`
+	}
+	if err := json.Unmarshal(OpenAPI, &doc); err != nil {
+		t.Fatalf("Failed to parse OpenAPI document: %v", err)
+	}
+
+	documented := map[string]bool{}
+	for path, operations := range doc.Paths {
+		for method := range operations {
+			documented[strings.ToUpper(method)+" "+path] = true
+		}
+	}
+
+	api := New(nil, nil)
+	for _, route := range api.routes {
+		if !documented[route] {
+			t.Errorf("Route %s is not documented", route)
+		}
+		delete(documented, route)
+	}
+
+	for route := range documented {
+		t.Errorf("Documented route %s does not exist", route)
+	}
+
+	req := httptest.NewRequest("GET", "/api/v1/openapi.json", nil)
+	rec := httptest.NewRecorder()
+	api.ServeHTTP(rec, req)
+
+	if rec.Code != http.StatusOK || !bytes.Equal(rec.Body.Bytes(), OpenAPI) {
+		t.Errorf("Expected the document to be served without authentication, got %d", rec.Code)
+	}
+}
+
+// TestOpenAPISchemas checks that the schemas of the OpenAPI document have the
+// same properties as the types the API encodes.
+func TestOpenAPISchemas(t *testing.T) {
+	var doc struct {
+		Components struct {
+			Schemas map[string]struct {
+				Properties map[string]json.RawMessage `json:"properties"`
+			} `json:"schemas"`
+		} `json:"components"`
+	}
+	if err := json.Unmarshal(OpenAPI, &doc); err != nil {
+		t.Fatalf("Failed to parse OpenAPI document: %v", err)
+	}
+
+	types := map[string]any{
+		"Error":                    ErrorResponse{},
+		"Membership":               Membership{},
+		"Suspension":               Suspension{},
+		"User":                     User{},
+		"Session":                  Session{},
+		"Invitation":               Invitation{},
+		"Namespace":                Namespace{},
+		"CreateUserRequest":        CreateUserRequest{},
+		"CreateUserResponse":       CreateUserResponse{},
+		"UpdateUserRequest":        UpdateUserRequest{},
+		"ExpiryRequest":            ExpiryRequest{},
+		"SuspendRequest":           SuspendRequest{},
+		"LinkResponse":             LinkResponse{},
+		"MembershipRequest":        MembershipRequest{},
+		"CreateNamespaceRequest":   CreateNamespaceRequest{},
+		"AddSessionRequest":        AddSessionRequest{},
+		"CreateInvitationRequest":  CreateInvitationRequest{},
+		"CreateInvitationResponse": CreateInvitationResponse{},
+	}
+
+	for name, schema := range doc.Components.Schemas {
+		v, ok := types[name]
+		if !ok {
+			t.Errorf("Schema %s has no type", name)
+			continue
+		}
+
+		var fields []string
+		typ := reflect.TypeOf(v)
+		for i := range typ.NumField() {
+			tag, _, _ := strings.Cut(typ.Field(i).Tag.Get("json"), ",")
+			fields = append(fields, tag)
+		}
+
+		var properties []string
+		for property := range schema.Properties {
+			properties = append(properties, property)
+		}
+
+		slices.Sort(fields)
+		slices.Sort(properties)
+		if !slices.Equal(fields, properties) {
+			t.Errorf("Schema %s has properties %v, but the type has %v", name, properties, fields)
+		}
+	}
+}
+
+func TestBackupAPI(t *testing.T) {
+	store, err := bolt.New(filepath.Join(t.TempDir(), "test.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer store.Close()
+	_ = store.Init()
+
+	userService := services.NewUserService(store)
+	api := New(userService, services.NewNamespaceService(store))
+
+	_, _ = userService.Create("admin", "password", true, "")
+	_, token, _ := userService.CreateAPIToken("admin", "test", internal.ScopeAdmin, time.Hour)
+
+	request := func(method, path string, body []byte) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, path, bytes.NewReader(body))
+		req.Header.Set("Authorization", "Bearer "+token)
+		rec := httptest.NewRecorder()
+		api.ServeHTTP(rec, req)
+		return rec
+	}
+
+	t.Run("unsupported", func(t *testing.T) {
+		if rec := request("GET", "/api/v1/backup", nil); rec.Code != http.StatusNotImplemented {
+			t.Errorf("Expected 501 without a Backuper, got %d", rec.Code)
+		}
+	})
+
+	api.Backuper = store.(storage.Backuper)
+
+	rec := request("GET", "/api/v1/backup", nil)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("Expected 200, got %d", rec.Code)
+	}
+	snapshot := rec.Body.Bytes()
+
+	_, _ = userService.Create("later", "password", false, "")
+
+	t.Run("invalid snapshot", func(t *testing.T) {
+		if rec := request("POST", "/api/v1/restore", []byte("garbage")); rec.Code != http.StatusBadRequest {
+			t.Errorf("Expected 400, got %d", rec.Code)
+		}
+	})
+
+	t.Run("restore", func(t *testing.T) {
+		if rec := request("POST", "/api/v1/restore", snapshot); rec.Code != http.StatusNoContent {
+			t.Fatalf("Expected 204, got %d: %s", rec.Code, rec.Body)
+		}
+
+		if user, _ := userService.Get("later"); user != nil {
+			t.Errorf("User created after the backup should be gone")
+		}
+	})
+
+	t.Run("manager", func(t *testing.T) {
+		_ = userService.SetManagedNamespaces("admin", []string{"namespace"})
+		_ = userService.SetAdmin("admin", false)
+
+		if rec := request("GET", "/api/v1/backup", nil); rec.Code != http.StatusForbidden {
+			t.Errorf("Expected 403 for a manager, got %d", rec.Code)
+		}
+	})
+}
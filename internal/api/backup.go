@@ -0,0 +1,48 @@
+package api
+
+import (
+	"MediaMTXAuth/internal"
+	"log"
+	"net/http"
+	"time"
+)
+
+func (a *API) backup(w http.ResponseWriter, r *http.Request, actor *internal.User) error {
+	if !actor.IsAdmin {
+		return internal.ErrForbidden
+	}
+
+	if a.Backuper == nil {
+		return ErrBackupUnsupported
+	}
+
+	filename := "auth-" + time.Now().UTC().Format("20060102-150405") + ".db"
+	w.Header().Set("Content-Type", "application/octet-stream")
+	w.Header().Set("Content-Disposition", `attachment; filename="`+filename+`"`)
+
+	// The status has been sent once the snapshot is being written, so errors
+	// can only be logged. The client sees a truncated body.
+	if _, err := a.Backuper.Backup(w); err != nil {
+		log.Printf("Failed to write backup: %v", err)
+	}
+
+	return nil
+}
+
+func (a *API) restore(w http.ResponseWriter, r *http.Request, actor *internal.User) error {
+	if !actor.IsAdmin {
+		return internal.ErrForbidden
+	}
+
+	if a.Backuper == nil {
+		return ErrBackupUnsupported
+	}
+
+	if err := a.Backuper.Restore(r.Body); err != nil {
+		return err
+	}
+
+	log.Printf("Database restored from a snapshot by %s", actor.Name)
+	w.WriteHeader(http.StatusNoContent)
+	return nil
+}
@@ -0,0 +1,72 @@
+package api
+
+import (
+	"MediaMTXAuth/internal"
+	"MediaMTXAuth/internal/services"
+	"MediaMTXAuth/internal/storage"
+	"MediaMTXAuth/internal/views/handlers"
+	"errors"
+	"net/http"
+)
+
+var (
+	ErrNotFound          = errors.New("not found")
+	ErrInvalidBody       = errors.New("invalid request body")
+	ErrInvalidQuery      = errors.New("invalid query parameter")
+	ErrBackupUnsupported = errors.New("the database does not support online backups")
+)
+
+// statusCodes maps the errors of the services to HTTP status codes. Errors not
+// listed are internal server errors.
+var statusCodes = map[error]int{
+	ErrNotFound:                         http.StatusNotFound,
+	internal.ErrUserNotFound:            http.StatusNotFound,
+	internal.ErrNamespaceNotFound:       http.StatusNotFound,
+	internal.ErrSessionNotFound:         http.StatusNotFound,
+	internal.ErrInvitationNotFound:      http.StatusNotFound,
+	internal.ErrMembershipNotFound:      http.StatusNotFound,
+	internal.ErrTokenNotFound:           http.StatusNotFound,
+	internal.ErrUserAlreadyExists:       http.StatusConflict,
+	internal.ErrNamespaceAlreadyExists:  http.StatusConflict,
+	internal.ErrStreamKeyTaken:          http.StatusConflict,
+	internal.ErrUserSuspended:           http.StatusConflict,
+	internal.ErrUserExpired:             http.StatusConflict,
+	services.ErrAdminExists:             http.StatusConflict,
+	services.ErrTooManyTokens:           http.StatusConflict,
+	internal.ErrAccountLocked:           http.StatusLocked,
+	internal.ErrTooManyAttempts:         http.StatusTooManyRequests,
+	internal.ErrWrongPassword:           http.StatusUnauthorized,
+	internal.ErrInvalidToken:            http.StatusUnauthorized,
+	handlers.ErrNotLoggedIn:             http.StatusUnauthorized,
+	internal.ErrForbidden:               http.StatusForbidden,
+	internal.ErrFromConfig:              http.StatusForbidden,
+	handlers.ErrReadOnlyToken:           http.StatusForbidden,
+	services.ErrDemoteSelf:              http.StatusForbidden,
+	services.ErrSuspendSelf:             http.StatusForbidden,
+	ErrInvalidBody:                      http.StatusBadRequest,
+	ErrInvalidQuery:                     http.StatusBadRequest,
+	internal.ErrInvalidSort:             http.StatusBadRequest,
+	internal.ErrInvalidCursor:           http.StatusBadRequest,
+	internal.ErrInvalidRole:             http.StatusBadRequest,
+	internal.ErrInvalidPermission:       http.StatusBadRequest,
+	internal.ErrInvalidScope:            http.StatusBadRequest,
+	services.ErrShortUsername:           http.StatusBadRequest,
+	services.ErrShortPassword:           http.StatusBadRequest,
+	services.ErrInvalidGrace:            http.StatusBadRequest,
+	services.ErrInvalidMaxUses:          http.StatusBadRequest,
+	services.ErrInvalidExpiration:       http.StatusBadRequest,
+	services.ErrEmptyTokenName:          http.StatusBadRequest,
+	services.ErrManagerWithoutNamespace: http.StatusBadRequest,
+	storage.ErrInvalidSnapshot:          http.StatusBadRequest,
+	ErrBackupUnsupported:                http.StatusNotImplemented,
+}
+
+// StatusCode returns the HTTP status code for err.
+func StatusCode(err error) int {
+	for sentinel, status := range statusCodes {
+		if errors.Is(err, sentinel) {
+			return status
+		}
+	}
+	return http.StatusInternalServerError
+}
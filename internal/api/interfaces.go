@@ -0,0 +1,185 @@
+package api
+
+import (
+	"MediaMTXAuth/internal"
+	"time"
+)
+
+type ErrorResponse struct {
+	Error string `json:"error"`
+}
+
+type Suspension struct {
+	Reason string    `json:"reason,omitempty"`
+	By     string    `json:"by,omitempty"`
+	Since  time.Time `json:"since"`
+	Until  time.Time `json:"until,omitzero"`
+}
+
+type Membership struct {
+	Namespace  string              `json:"namespace"`
+	Permission internal.Permission `json:"permission"`
+}
+
+// User is the public part of internal.User. Secrets such as hashes and the
+// stream key are left out.
+type User struct {
+	Name                 string       `json:"name"`
+	IsAdmin              bool         `json:"isAdmin"`
+	Manages              []string     `json:"manages"`
+	Memberships          []Membership `json:"memberships"`
+	Suspension           *Suspension  `json:"suspension,omitempty"`
+	ExpiresAt            time.Time    `json:"expiresAt,omitzero"`
+	Sessions             int          `json:"sessions"`
+	FailedLogins         int          `json:"failedLogins"`
+	Locked               bool         `json:"locked"`
+	PasswordIsGenerated  bool         `json:"passwordIsGenerated"`
+	PasswordResetPending bool         `json:"passwordResetPending"`
+}
+
+type Session struct {
+	Key     string    `json:"key"`
+	Name    string    `json:"name"`
+	User    string    `json:"user"`
+	Created time.Time `json:"created"`
+}
+
+type Invitation struct {
+	ID         string        `json:"id"`
+	Role       internal.Role `json:"role"`
+	MaxUses    int           `json:"maxUses"`
+	Uses       int           `json:"uses"`
+	CreatedBy  string        `json:"createdBy"`
+	Created    time.Time     `json:"created"`
+	Expiration time.Time     `json:"expiration"`
+}
+
+type Namespace struct {
+	Name        string       `json:"name"`
+	Sessions    []Session    `json:"sessions"`
+	Invitations []Invitation `json:"invitations"`
+}
+
+type CreateUserRequest struct {
+	Name      string    `json:"name"`
+	Namespace string    `json:"namespace,omitempty"`
+	IsAdmin   bool      `json:"isAdmin,omitempty"`
+	IsManager bool      `json:"isManager,omitempty"`
+	ExpiresAt time.Time `json:"expiresAt,omitzero"`
+}
+
+// CreateUserResponse holds the new user and their temporary password, which
+// has to be changed at the first login.
+type CreateUserResponse struct {
+	User     User   `json:"user"`
+	Password string `json:"password"`
+}
+
+// UpdateUserRequest changes the fields that are set.
+type UpdateUserRequest struct {
+	Name    *string `json:"name,omitempty"`
+	IsAdmin *bool   `json:"isAdmin,omitempty"`
+}
+
+// ExpiryRequest sets when an account expires. Zero means never.
+type ExpiryRequest struct {
+	ExpiresAt time.Time `json:"expiresAt,omitzero"`
+}
+
+// SuspendRequest suspends a user until Until, or indefinitely if it is zero.
+type SuspendRequest struct {
+	Reason string    `json:"reason,omitempty"`
+	Until  time.Time `json:"until,omitzero"`
+}
+
+type LinkResponse struct {
+	Link string `json:"link"`
+}
+
+type MembershipRequest struct {
+	Permission internal.Permission `json:"permission"`
+}
+
+type CreateNamespaceRequest struct {
+	Name string `json:"name"`
+}
+
+type AddSessionRequest struct {
+	Name string `json:"name"`
+}
+
+type CreateInvitationRequest struct {
+	Role    internal.Role `json:"role"`
+	MaxUses int           `json:"maxUses"`
+	// ExpiresIn is a duration such as "24h".
+	ExpiresIn string `json:"expiresIn"`
+}
+
+type CreateInvitationResponse struct {
+	Invitation Invitation `json:"invitation"`
+	Link       string     `json:"link"`
+}
+
+func toUser(u internal.User) User {
+	user := User{
+		Name:                 u.Name,
+		IsAdmin:              u.IsAdmin,
+		Manages:              u.Manages,
+		Memberships:          []Membership{},
+		ExpiresAt:            u.ExpiresAt,
+		Sessions:             len(u.Sessions),
+		FailedLogins:         u.LoginAttempts.Failures,
+		Locked:               u.LoginAttempts.IsLocked(),
+		PasswordIsGenerated:  u.Password.IsGenerated,
+		PasswordResetPending: u.PasswordReset.IsPending(),
+	}
+
+	if user.Manages == nil {
+		user.Manages = []string{}
+	}
+
+	for _, m := range u.Memberships {
+		user.Memberships = append(user.Memberships, Membership(m))
+	}
+
+	if u.Suspension.IsActive() {
+		user.Suspension = &Suspension{
+			Reason: u.Suspension.Reason,
+			By:     u.Suspension.By,
+			Since:  u.Suspension.Since,
+			Until:  u.Suspension.Until,
+		}
+	}
+
+	return user
+}
+
+func toNamespace(ns internal.Namespace) Namespace {
+	namespace := Namespace{
+		Name:        ns.Name,
+		Sessions:    []Session{},
+		Invitations: []Invitation{},
+	}
+
+	for _, s := range ns.Sessions {
+		namespace.Sessions = append(namespace.Sessions, Session(s))
+	}
+
+	for _, i := range ns.Invitations {
+		namespace.Invitations = append(namespace.Invitations, toInvitation(i))
+	}
+
+	return namespace
+}
+
+func toInvitation(i internal.NamespaceInvitation) Invitation {
+	return Invitation{
+		ID:         i.ID,
+		Role:       i.Role,
+		MaxUses:    i.MaxUses,
+		Uses:       i.Uses,
+		CreatedBy:  i.CreatedBy,
+		Created:    i.Created,
+		Expiration: i.Expiration,
+	}
+}
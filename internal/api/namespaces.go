@@ -0,0 +1,178 @@
+package api
+
+import (
+	"MediaMTXAuth/internal"
+	"MediaMTXAuth/internal/services"
+	"MediaMTXAuth/internal/views/handlers"
+	"net/http"
+	"time"
+)
+
+func (a *API) listNamespaces(w http.ResponseWriter, r *http.Request, actor *internal.User) error {
+	opts, err := listOptions(r)
+	if err != nil {
+		return err
+	}
+
+	page, err := a.NamespaceService.List(*actor, opts)
+	if err != nil {
+		return err
+	}
+
+	managed := []Namespace{}
+	for _, namespace := range page.Items {
+		managed = append(managed, toNamespace(namespace))
+	}
+
+	return writePage(w, managed, page.Next)
+}
+
+func (a *API) createNamespace(w http.ResponseWriter, r *http.Request, actor *internal.User) error {
+	var req CreateNamespaceRequest
+	if err := decode(r, &req); err != nil {
+		return err
+	}
+
+	if !actor.IsAdmin {
+		return internal.ErrForbidden
+	}
+
+	namespace, err := a.NamespaceService.Create(req.Name)
+	if err != nil {
+		return err
+	}
+
+	return writeJSON(w, http.StatusCreated, toNamespace(*namespace))
+}
+
+func (a *API) getNamespace(w http.ResponseWriter, r *http.Request, actor *internal.User) error {
+	namespace, err := a.managedNamespace(r, actor)
+	if err != nil {
+		return err
+	}
+
+	return writeJSON(w, http.StatusOK, toNamespace(*namespace))
+}
+
+func (a *API) deleteNamespace(w http.ResponseWriter, r *http.Request, actor *internal.User) error {
+	if !actor.IsAdmin {
+		return internal.ErrForbidden
+	}
+
+	if err := services.AuthorizeNamespaceRemoval(a.NamespaceService, r.PathValue("namespace")); err != nil {
+		return err
+	}
+
+	if err := a.NamespaceService.Delete(r.PathValue("namespace")); err != nil {
+		return err
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+	return nil
+}
+
+func (a *API) listSessions(w http.ResponseWriter, r *http.Request, actor *internal.User) error {
+	namespace, err := a.managedNamespace(r, actor)
+	if err != nil {
+		return err
+	}
+
+	return writeJSON(w, http.StatusOK, toNamespace(*namespace).Sessions)
+}
+
+func (a *API) addSession(w http.ResponseWriter, r *http.Request, actor *internal.User) error {
+	var req AddSessionRequest
+	if err := decode(r, &req); err != nil {
+		return err
+	}
+
+	namespace, err := a.managedNamespace(r, actor)
+	if err != nil {
+		return err
+	}
+
+	session, err := a.NamespaceService.AddSession(namespace.Name, req.Name, actor.Name)
+	if err != nil {
+		return err
+	}
+
+	return writeJSON(w, http.StatusCreated, Session(*session))
+}
+
+func (a *API) removeSession(w http.ResponseWriter, r *http.Request, actor *internal.User) error {
+	namespace, err := a.managedNamespace(r, actor)
+	if err != nil {
+		return err
+	}
+
+	if err := a.NamespaceService.RemoveSession(namespace.Name, r.PathValue("key")); err != nil {
+		return err
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+	return nil
+}
+
+func (a *API) listInvitations(w http.ResponseWriter, r *http.Request, actor *internal.User) error {
+	namespace, err := a.managedNamespace(r, actor)
+	if err != nil {
+		return err
+	}
+
+	return writeJSON(w, http.StatusOK, toNamespace(*namespace).Invitations)
+}
+
+func (a *API) createInvitation(w http.ResponseWriter, r *http.Request, actor *internal.User) error {
+	var req CreateInvitationRequest
+	if err := decode(r, &req); err != nil {
+		return err
+	}
+
+	ttl, err := time.ParseDuration(req.ExpiresIn)
+	if err != nil {
+		return ErrInvalidBody
+	}
+
+	namespace, err := a.managedNamespace(r, actor)
+	if err != nil {
+		return err
+	}
+
+	if req.Role != internal.RoleUser && !actor.IsAdmin {
+		return internal.ErrForbidden
+	}
+
+	invitation, token, err := a.NamespaceService.CreateInvitation(namespace.Name, req.Role, req.MaxUses, ttl, actor.Name)
+	if err != nil {
+		return err
+	}
+
+	link := handlers.InviteLink(r, namespace.Name, token)
+	return writeJSON(w, http.StatusCreated, CreateInvitationResponse{Invitation: toInvitation(*invitation), Link: link})
+}
+
+func (a *API) revokeInvitation(w http.ResponseWriter, r *http.Request, actor *internal.User) error {
+	namespace, err := a.managedNamespace(r, actor)
+	if err != nil {
+		return err
+	}
+
+	if err := a.NamespaceService.RevokeInvitation(namespace.Name, r.PathValue("id")); err != nil {
+		return err
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+	return nil
+}
+
+// managedNamespace returns the namespace named in the path if actor manages
+// it. Managers get ErrForbidden for namespaces that do not exist, so they
+// cannot probe for them.
+func (a *API) managedNamespace(r *http.Request, actor *internal.User) (*internal.Namespace, error) {
+	name := r.PathValue("namespace")
+	if !actor.CanManage(name) {
+		return nil, internal.ErrForbidden
+	}
+
+	return a.NamespaceService.Get(name)
+}
@@ -0,0 +1,309 @@
+package api
+
+import (
+	"MediaMTXAuth/internal"
+	"MediaMTXAuth/internal/services"
+	"MediaMTXAuth/internal/views/handlers"
+	"crypto/rand"
+	"errors"
+	"net/http"
+)
+
+func (a *API) listUsers(w http.ResponseWriter, r *http.Request, actor *internal.User) error {
+	opts, err := listOptions(r)
+	if err != nil {
+		return err
+	}
+
+	page, err := a.UserService.List(*actor, opts)
+	if err != nil {
+		return err
+	}
+
+	visible := []User{}
+	for _, user := range page.Items {
+		visible = append(visible, toUser(user))
+	}
+
+	return writePage(w, visible, page.Next)
+}
+
+func (a *API) getUser(w http.ResponseWriter, r *http.Request, actor *internal.User) error {
+	user, err := a.UserService.Get(r.PathValue("name"))
+	if err != nil {
+		return err
+	}
+
+	if user == nil {
+		return internal.ErrUserNotFound
+	}
+
+	if !actor.CanSeeUser(*user) {
+		return internal.ErrForbidden
+	}
+
+	return writeJSON(w, http.StatusOK, toUser(*user))
+}
+
+// createUser creates a user with a temporary password, like the admin page.
+func (a *API) createUser(w http.ResponseWriter, r *http.Request, actor *internal.User) error {
+	var req CreateUserRequest
+	if err := decode(r, &req); err != nil {
+		return err
+	}
+
+	switch {
+	case (req.IsAdmin || req.IsManager) && !actor.IsAdmin:
+		return internal.ErrForbidden
+	case !actor.CanManage(req.Namespace):
+		return internal.ErrForbidden
+	case req.IsManager && req.Namespace == "":
+		return services.ErrManagerWithoutNamespace
+	}
+
+	password := rand.Text()
+	if _, err := a.UserService.Create(req.Name, password, req.IsAdmin, req.Namespace); err != nil {
+		return err
+	}
+
+	var err error
+	if req.IsManager {
+		err = a.UserService.SetManagedNamespaces(req.Name, []string{req.Namespace})
+	}
+	if err == nil && !req.ExpiresAt.IsZero() {
+		err = a.UserService.SetExpiry(req.Name, req.ExpiresAt)
+	}
+	if err != nil {
+		// A user without the requested rights or expiry must not be left
+		// behind.
+		return errors.Join(err, a.UserService.Delete(req.Name))
+	}
+
+	user, err := a.UserService.Get(req.Name)
+	if err != nil {
+		return err
+	}
+
+	return writeJSON(w, http.StatusCreated, CreateUserResponse{User: toUser(*user), Password: password})
+}
+
+// updateUser renames the user and changes their admin rights.
+func (a *API) updateUser(w http.ResponseWriter, r *http.Request, actor *internal.User) error {
+	username := r.PathValue("name")
+
+	var req UpdateUserRequest
+	if err := decode(r, &req); err != nil {
+		return err
+	}
+
+	user, err := services.AuthorizeEdit(a.UserService, actor, username)
+	if err != nil {
+		return err
+	}
+
+	if req.IsAdmin != nil && *req.IsAdmin != user.IsAdmin {
+		switch {
+		case !actor.IsAdmin:
+			return internal.ErrForbidden
+		case username == actor.Name:
+			return services.ErrDemoteSelf
+		}
+
+		if err := a.UserService.SetAdmin(username, *req.IsAdmin); err != nil {
+			return err
+		}
+	}
+
+	if req.Name != nil && *req.Name != username {
+		renamed, err := a.UserService.Rename(username, *req.Name)
+		if err != nil {
+			return err
+		}
+
+		// Streams run under the old path and would no longer match the user.
+		services.KickRenamedStreams(a.Kicker, *renamed, username)
+
+		if session, ok := handlers.CurrentSession(r, renamed); ok && actor.Name == username {
+			renamed.Session = session
+			handlers.SetSessionCookies(w, r, renamed)
+		}
+		username = renamed.Name
+	}
+
+	user, err = a.UserService.Get(username)
+	if err != nil {
+		return err
+	}
+
+	return writeJSON(w, http.StatusOK, toUser(*user))
+}
+
+func (a *API) deleteUser(w http.ResponseWriter, r *http.Request, actor *internal.User) error {
+	username := r.PathValue("name")
+
+	if _, err := services.AuthorizeEdit(a.UserService, actor, username); err != nil {
+		return err
+	}
+
+	if err := a.UserService.Delete(username); err != nil {
+		return err
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+	return nil
+}
+
+func (a *API) setExpiry(w http.ResponseWriter, r *http.Request, actor *internal.User) error {
+	username := r.PathValue("name")
+
+	var req ExpiryRequest
+	if err := decode(r, &req); err != nil {
+		return err
+	}
+
+	if _, err := services.AuthorizeEdit(a.UserService, actor, username); err != nil {
+		return err
+	}
+
+	if err := a.UserService.SetExpiry(username, req.ExpiresAt); err != nil {
+		return err
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+	return nil
+}
+
+func (a *API) suspendUser(w http.ResponseWriter, r *http.Request, actor *internal.User) error {
+	username := r.PathValue("name")
+
+	var req SuspendRequest
+	if err := decode(r, &req); err != nil {
+		return err
+	}
+
+	if _, err := services.AuthorizeUser(a.UserService, actor, username); err != nil {
+		return err
+	}
+
+	if username == actor.Name {
+		return services.ErrSuspendSelf
+	}
+
+	if err := a.UserService.Suspend(username, req.Reason, actor.Name, req.Until); err != nil {
+		return err
+	}
+
+	services.KickStreams(a.UserService, a.Kicker, username)
+
+	w.WriteHeader(http.StatusNoContent)
+	return nil
+}
+
+func (a *API) reactivateUser(w http.ResponseWriter, r *http.Request, actor *internal.User) error {
+	return a.userAction(w, r, actor, a.UserService.Reactivate)
+}
+
+func (a *API) unlockUser(w http.ResponseWriter, r *http.Request, actor *internal.User) error {
+	return a.userAction(w, r, actor, a.UserService.Unlock)
+}
+
+// logoutUser ends every login session of a user.
+func (a *API) logoutUser(w http.ResponseWriter, r *http.Request, actor *internal.User) error {
+	return a.userAction(w, r, actor, func(username string) error {
+		return a.UserService.LogoutAll(username, actor.Name)
+	})
+}
+
+// resetStreamKey replaces the stream key and disconnects running streams. The
+// new key is only shown to the user.
+func (a *API) resetStreamKey(w http.ResponseWriter, r *http.Request, actor *internal.User) error {
+	username := r.PathValue("name")
+
+	if _, err := services.AuthorizeEdit(a.UserService, actor, username); err != nil {
+		return err
+	}
+
+	if _, err := a.UserService.RotateStreamKey(username, actor.Name, 0); err != nil {
+		return err
+	}
+
+	services.KickStreams(a.UserService, a.Kicker, username)
+
+	w.WriteHeader(http.StatusNoContent)
+	return nil
+}
+
+func (a *API) cancelResetLink(w http.ResponseWriter, r *http.Request, actor *internal.User) error {
+	return a.userAction(w, r, actor, a.UserService.CancelPasswordReset)
+}
+
+func (a *API) createResetLink(w http.ResponseWriter, r *http.Request, actor *internal.User) error {
+	username := r.PathValue("name")
+
+	if _, err := services.AuthorizeEdit(a.UserService, actor, username); err != nil {
+		return err
+	}
+
+	token, err := a.UserService.CreatePasswordReset(username, actor.Name)
+	if err != nil {
+		return err
+	}
+
+	link := handlers.ResetLink(r, username, token)
+	return writeJSON(w, http.StatusOK, LinkResponse{Link: link})
+}
+
+func (a *API) setMembership(w http.ResponseWriter, r *http.Request, actor *internal.User) error {
+	username := r.PathValue("name")
+	namespace := r.PathValue("namespace")
+
+	var req MembershipRequest
+	if err := decode(r, &req); err != nil {
+		return err
+	}
+
+	if err := services.AuthorizeMembership(a.UserService, actor, username, namespace); err != nil {
+		return err
+	}
+
+	if err := a.UserService.SetMembership(username, namespace, req.Permission); err != nil {
+		return err
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+	return nil
+}
+
+func (a *API) removeMembership(w http.ResponseWriter, r *http.Request, actor *internal.User) error {
+	username := r.PathValue("name")
+	namespace := r.PathValue("namespace")
+
+	if err := services.AuthorizeMembership(a.UserService, actor, username, namespace); err != nil {
+		return err
+	}
+
+	if err := a.UserService.RemoveMembership(username, namespace); err != nil {
+		return err
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+	return nil
+}
+
+// userAction runs action on the user named in the path if actor may
+// administer them and answers with 204 No Content. It is meant for actions
+// that are allowed on users declared in the config file.
+func (a *API) userAction(w http.ResponseWriter, r *http.Request, actor *internal.User, action func(username string) error) error {
+	username := r.PathValue("name")
+
+	if _, err := services.AuthorizeUser(a.UserService, actor, username); err != nil {
+		return err
+	}
+
+	if err := action(username); err != nil {
+		return err
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+	return nil
+}
@@ -33,11 +33,24 @@ func (a *Auth) Validate(namespace, userName, streamKey, action string) error {
 		return fmt.Errorf("%w: %w", ErrAuthError, internal.ErrUserNotFound)
 	}
 
-	if user.Namespace != "" && user.Namespace != namespace {
-		return fmt.Errorf("%w: %s", ErrAuthError, "user is not allowed in name")
+	if user.Suspension.IsActive() {
+		return fmt.Errorf("%w: %w", ErrAuthError, internal.ErrUserSuspended)
 	}
 
-	if action == "publish" && user.StreamKey != streamKey {
+	if user.IsExpired() {
+		return fmt.Errorf("%w: %w", ErrAuthError, internal.ErrUserExpired)
+	}
+
+	membership, ok := user.Membership(namespace)
+	if !ok {
+		return fmt.Errorf("%w: %s", ErrAuthError, "user is not a member of namespace")
+	}
+
+	if !membership.Permission.Allows(action) {
+		return fmt.Errorf("%w: %s", ErrAuthError, "action not permitted in namespace")
+	}
+
+	if action == "publish" && !user.HasStreamKey(streamKey) {
 		return fmt.Errorf("%w: %s", ErrAuthError, "invalid stream key for user")
 	}
 
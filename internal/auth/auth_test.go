@@ -1,10 +1,12 @@
 package auth
 
 import (
+	"MediaMTXAuth/internal"
 	"MediaMTXAuth/internal/services"
 	"MediaMTXAuth/internal/storage/memory"
 	"errors"
 	"testing"
+	"time"
 )
 
 func TestAuth_Validate(t *testing.T) {
@@ -31,6 +33,16 @@ func TestAuth_Validate(t *testing.T) {
 		t.Fatal(err)
 	}
 
+	other, _ := nsService.Create("other_namespace")
+	viewer, _ := userService.Create("viewer", "testtest", false, "")
+	_ = userService.SetMembership(viewer.Name, ns.Name, internal.PermissionRead)
+	suspended, _ := userService.Create("suspended", "testtest", false, ns.Name)
+	_ = userService.Suspend(suspended.Name, "", "admin", time.Time{})
+	expired, _ := userService.Create("expired", "testtest", false, ns.Name)
+	rotated, _ := userService.Create("rotated", "testtest", false, ns.Name)
+	_, _ = userService.RotateStreamKey(rotated.Name, rotated.Name, time.Hour)
+	_ = userService.SetExpiry(expired.Name, time.Now().Add(-time.Minute))
+
 	tests := []struct {
 		name    string
 		args    args
@@ -56,6 +68,85 @@ func TestAuth_Validate(t *testing.T) {
 			},
 			wantErr: nil,
 		},
+		{
+			name: "publish wrong key",
+			args: args{
+				namespace: ns.Name,
+				userName:  u.Name,
+				streamKey: "wrong",
+				action:    "publish",
+			},
+			wantErr: ErrAuthError,
+		},
+		{
+			name: "not a member",
+			args: args{
+				namespace: other.Name,
+				userName:  u.Name,
+				streamKey: u.StreamKey,
+				action:    "publish",
+			},
+			wantErr: ErrAuthError,
+		},
+		{
+			name: "unknown namespace",
+			args: args{
+				namespace: "missing",
+				userName:  u.Name,
+				streamKey: u.StreamKey,
+				action:    "read",
+			},
+			wantErr: ErrAuthError,
+		},
+		{
+			name: "publish with read-only membership",
+			args: args{
+				namespace: ns.Name,
+				userName:  viewer.Name,
+				streamKey: viewer.StreamKey,
+				action:    "publish",
+			},
+			wantErr: ErrAuthError,
+		},
+		{
+			name: "read with read-only membership",
+			args: args{
+				namespace: ns.Name,
+				userName:  viewer.Name,
+				action:    "read",
+			},
+			wantErr: nil,
+		},
+		{
+			name: "suspended user",
+			args: args{
+				namespace: ns.Name,
+				userName:  suspended.Name,
+				streamKey: suspended.StreamKey,
+				action:    "publish",
+			},
+			wantErr: internal.ErrUserSuspended,
+		},
+		{
+			name: "expired user",
+			args: args{
+				namespace: ns.Name,
+				userName:  expired.Name,
+				streamKey: expired.StreamKey,
+				action:    "publish",
+			},
+			wantErr: internal.ErrUserExpired,
+		},
+		{
+			name: "publish with previous key during grace period",
+			args: args{
+				namespace: ns.Name,
+				userName:  rotated.Name,
+				streamKey: rotated.StreamKey,
+				action:    "publish",
+			},
+			wantErr: nil,
+		},
 		// TODO: cover other cases
 	}
 
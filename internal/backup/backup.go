@@ -0,0 +1,84 @@
+// Package backup saves snapshots of the database to a directory and keeps
+// only the most recent ones.
+package backup
+
+import (
+	"MediaMTXAuth/internal/storage"
+	"errors"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"time"
+)
+
+const prefix = "auth-"
+const suffix = ".db"
+
+// ErrInvalidKeep is returned by Prune when asked to keep a negative number of
+// backups.
+var ErrInvalidKeep = errors.New("number of backups to keep must not be negative")
+
+// Save writes a snapshot to a new file in dir and returns its path. The file
+// is only given its final name once it is complete, so a partial snapshot is
+// never mistaken for a backup.
+func Save(b storage.Backuper, dir string) (string, error) {
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return "", err
+	}
+
+	file, err := os.CreateTemp(dir, ".partial-*")
+	if err != nil {
+		return "", err
+	}
+	defer os.Remove(file.Name())
+
+	_, err = b.Backup(file)
+	if err == nil {
+		err = file.Sync()
+	}
+	if closeErr := file.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return "", err
+	}
+
+	path := filepath.Join(dir, prefix+time.Now().UTC().Format("20060102-150405")+suffix)
+	return path, os.Rename(file.Name(), path)
+}
+
+// Prune deletes all but the newest keep backups in dir and returns the paths
+// of the deleted files. Other files in dir are left alone.
+func Prune(dir string, keep int) ([]string, error) {
+	if keep < 0 {
+		return nil, ErrInvalidKeep
+	}
+
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+
+	var backups []string
+	for _, entry := range entries {
+		name := entry.Name()
+		if entry.Type().IsRegular() && strings.HasPrefix(name, prefix) && strings.HasSuffix(name, suffix) {
+			backups = append(backups, name)
+		}
+	}
+
+	// The timestamps in the names sort chronologically.
+	slices.Sort(backups)
+
+	var deleted []string
+	for _, name := range backups[:max(len(backups)-keep, 0)] {
+		path := filepath.Join(dir, name)
+		if err := os.Remove(path); err != nil {
+			return deleted, err
+		}
+		deleted = append(deleted, path)
+	}
+
+	return deleted, nil
+}
@@ -0,0 +1,78 @@
+package backup
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+type fakeBackuper []byte
+
+func (f fakeBackuper) Backup(w io.Writer) (int64, error) {
+	n, err := w.Write(f)
+	return int64(n), err
+}
+
+func (f fakeBackuper) Restore(r io.Reader) error {
+	return nil
+}
+
+func TestSave(t *testing.T) {
+	dir := filepath.Join(t.TempDir(), "backups")
+
+	path, err := Save(fakeBackuper("snapshot"), dir)
+	if err != nil {
+		t.Fatalf("Failed to save backup: %v", err)
+	}
+
+	data, _ := os.ReadFile(path)
+	if !bytes.Equal(data, []byte("snapshot")) {
+		t.Errorf("Expected snapshot to be written, got %q", data)
+	}
+
+	entries, _ := os.ReadDir(dir)
+	if len(entries) != 1 {
+		t.Errorf("Expected only the backup in the directory, got %v", entries)
+	}
+}
+
+func TestPrune(t *testing.T) {
+	dir := t.TempDir()
+
+	names := []string{
+		"auth-20260101-000000.db",
+		"auth-20260102-000000.db",
+		"auth-20260103-000000.db",
+		"other.db",
+	}
+	for _, name := range names {
+		_ = os.WriteFile(filepath.Join(dir, name), nil, 0600)
+	}
+
+	deleted, err := Prune(dir, 2)
+	if err != nil {
+		t.Fatalf("Failed to prune: %v", err)
+	}
+
+	expected := []string{filepath.Join(dir, "auth-20260101-000000.db")}
+	if !cmp.Equal(deleted, expected) {
+		t.Errorf("Expected %v to be deleted, got %v", expected, deleted)
+	}
+
+	entries, _ := os.ReadDir(dir)
+	if len(entries) != 3 {
+		t.Errorf("Expected 3 files left, got %v", entries)
+	}
+
+	if _, err := Prune(dir, -1); !errors.Is(err, ErrInvalidKeep) {
+		t.Errorf("Expected ErrInvalidKeep, got %v", err)
+	}
+	if entries, _ := os.ReadDir(dir); len(entries) != 3 {
+		t.Errorf("Expected 3 files left, got %v", entries)
+	}
+}
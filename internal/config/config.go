@@ -0,0 +1,206 @@
+// Package config reconciles the database with a declarative config file, so
+// that namespaces, admin accounts and their permissions can be kept in an
+// infrastructure repository.
+package config
+
+import (
+	"MediaMTXAuth/internal"
+	"MediaMTXAuth/internal/transfer"
+	"fmt"
+	"os"
+	"slices"
+
+	"gopkg.in/yaml.v3"
+)
+
+// File is the config file. It uses the export format, so an export is a good
+// starting point, with an additional prune setting.
+type File struct {
+	transfer.Document `yaml:",inline"`
+	// Prune deletes users and namespaces that were declared in the file
+	// before but are no longer. Without it they are kept and become editable
+	// on the admin page again.
+	Prune bool `yaml:"prune,omitempty"`
+}
+
+// Load reads the config file at path. Unknown fields are rejected to catch
+// typos.
+func Load(path string) (*File, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	decoder := yaml.NewDecoder(f)
+	decoder.KnownFields(true)
+
+	var file File
+	if err := decoder.Decode(&file); err != nil {
+		return nil, fmt.Errorf("failed to read %s: %w", path, err)
+	}
+	return &file, nil
+}
+
+// Reconciler makes the database match the config file. Users and namespaces
+// in the file are created or updated and marked FromConfig. Records not in the
+// file are only touched if they were marked before.
+type Reconciler struct {
+	UserService      internal.UserService
+	NamespaceService internal.NamespaceService
+	Path             string
+}
+
+func New(userService internal.UserService, namespaceService internal.NamespaceService, path string) *Reconciler {
+	return &Reconciler{
+		UserService:      userService,
+		NamespaceService: namespaceService,
+		Path:             path,
+	}
+}
+
+// Plan reads the config file and returns the changes Reconcile would make.
+func (r *Reconciler) Plan() ([]transfer.Change, error) {
+	file, err := Load(r.Path)
+	if err != nil {
+		return nil, err
+	}
+
+	imports, marks, err := r.plan(file)
+	return append(imports, marks...), err
+}
+
+// Reconcile reads the config file and applies it. It returns the changes
+// made, which are all changes planned unless an error occurred.
+func (r *Reconciler) Reconcile() ([]transfer.Change, error) {
+	file, err := Load(r.Path)
+	if err != nil {
+		return nil, err
+	}
+
+	_, marks, err := r.plan(file)
+	if err != nil {
+		return nil, err
+	}
+
+	// The records in the file are applied like a merge import, which fails
+	// before changing anything if the file is invalid.
+	changes, err := transfer.New(r.UserService, r.NamespaceService).Import(&file.Document, transfer.Merge)
+	if err != nil {
+		return changes, err
+	}
+
+	for _, namespace := range file.Namespaces {
+		if err := r.NamespaceService.SetFromConfig(namespace.Name, true); err != nil {
+			return changes, err
+		}
+	}
+
+	for _, user := range file.Users {
+		if err := r.UserService.SetFromConfig(user.Name, true); err != nil {
+			return changes, err
+		}
+	}
+
+	for _, change := range marks {
+		if err := r.apply(change); err != nil {
+			return changes, fmt.Errorf("%s: %w", change, err)
+		}
+		changes = append(changes, change)
+	}
+
+	return changes, nil
+}
+
+// plan returns the changes of a merge import of file, and the changes to
+// existing records that are newly listed in it or no longer are.
+func (r *Reconciler) plan(file *File) (imports, marks []transfer.Change, err error) {
+	imports, err = transfer.New(r.UserService, r.NamespaceService).Plan(&file.Document, transfer.Merge)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	namespaces, err := r.NamespaceService.GetAllNamespaces()
+	if err != nil {
+		return nil, nil, err
+	}
+
+	users, err := r.UserService.GetAllUsers()
+	if err != nil {
+		return nil, nil, err
+	}
+
+	var adopted, released []transfer.Change
+
+	add := func(change transfer.Change, ok bool) {
+		if ok && change.Action == "adopt" {
+			adopted = append(adopted, change)
+		} else if ok {
+			released = append(released, change)
+		}
+	}
+
+	for _, user := range users {
+		listed := slices.ContainsFunc(file.Users, func(u transfer.User) bool { return u.Name == user.Name })
+		add(mark("user", user.Name, listed, user.FromConfig, file.Prune))
+	}
+
+	for _, namespace := range namespaces {
+		listed := slices.ContainsFunc(file.Namespaces, func(n transfer.Namespace) bool { return n.Name == namespace.Name })
+		change, ok := mark("namespace", namespace.Name, listed, namespace.FromConfig, file.Prune)
+
+		if ok && change.Action == "delete" {
+			if user, used := usedBy(file.Users, namespace.Name); used {
+				return nil, nil, fmt.Errorf("namespace %q would be pruned but user %q in the config file uses it", namespace.Name, user)
+			}
+		}
+		add(change, ok)
+	}
+
+	return imports, append(adopted, released...), nil
+}
+
+// usedBy returns the first of users with a membership in or managing
+// namespace.
+func usedBy(users []transfer.User, namespace string) (string, bool) {
+	for _, user := range users {
+		if slices.Contains(user.Manages, namespace) || slices.ContainsFunc(user.Memberships, func(m transfer.Membership) bool {
+			return m.Namespace == namespace
+		}) {
+			return user.Name, true
+		}
+	}
+	return "", false
+}
+
+// mark returns the change of the FromConfig mark of an existing record, if
+// any. Records that were created by hand and are now listed are adopted.
+func mark(kind, name string, listed, fromConfig, prune bool) (transfer.Change, bool) {
+	switch {
+	case listed && !fromConfig:
+		return transfer.Change{Action: "adopt", Kind: kind, Name: name}, true
+	case !listed && fromConfig && prune:
+		return transfer.Change{Action: "delete", Kind: kind, Name: name}, true
+	case !listed && fromConfig:
+		return transfer.Change{Action: "release", Kind: kind, Name: name}, true
+	default:
+		return transfer.Change{}, false
+	}
+}
+
+// apply makes a change planned by mark. Reconcile marks all listed records,
+// which covers adopting.
+func (r *Reconciler) apply(change transfer.Change) error {
+	switch {
+	case change.Action == "delete" && change.Kind == "user":
+		return r.UserService.Delete(change.Name)
+	case change.Action == "delete" && change.Kind == "namespace":
+		return r.NamespaceService.Delete(change.Name)
+	case change.Action == "release" && change.Kind == "user":
+		return r.UserService.SetFromConfig(change.Name, false)
+	case change.Action == "release" && change.Kind == "namespace":
+		return r.NamespaceService.SetFromConfig(change.Name, false)
+	default:
+		return nil
+	}
+}
@@ -0,0 +1,206 @@
+package config
+
+import (
+	"MediaMTXAuth/internal/passwords"
+	"MediaMTXAuth/internal/services"
+	"MediaMTXAuth/internal/storage/memory"
+	"MediaMTXAuth/internal/transfer"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestReconciler(t *testing.T) {
+	storage := &memory.Storage{}
+	if err := storage.Init(); err != nil {
+		t.Fatal(err)
+	}
+
+	userService := services.NewUserService(storage)
+	namespaceService := services.NewNamespaceService(storage)
+
+	hash, err := passwords.Hash("password123")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	path := filepath.Join(t.TempDir(), "config.yaml")
+	reconciler := New(userService, namespaceService, path)
+
+	write := func(t *testing.T, config string) {
+		config = strings.ReplaceAll(config, "HASH", hash)
+		if err := os.WriteFile(path, []byte(config), 0600); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	describe := func(changes []transfer.Change) []string {
+		var s []string
+		for _, change := range changes {
+			s = append(s, change.String())
+		}
+		return s
+	}
+
+	config := `
+version: 1
+namespaces:
+  - name: studio
+users:
+  - name: root
+    isAdmin: true
+    passwordHash: HASH
+  - name: bob
+    passwordHash: HASH
+    memberships:
+      - namespace: studio
+        permission: publish
+`
+
+	t.Run("create", func(t *testing.T) {
+		t.Cleanup(storage.Clear)
+		write(t, config)
+
+		planned, err := reconciler.Plan()
+		if err != nil {
+			t.Fatalf("Failed to plan: %v", err)
+		}
+
+		changes, err := reconciler.Reconcile()
+		if err != nil {
+			t.Fatalf("Failed to reconcile: %v", err)
+		}
+
+		want := []string{"create namespace studio", "create user root", "create user bob (memberships)"}
+		if diff := cmp.Diff(want, describe(changes)); diff != "" {
+			t.Errorf("Unexpected changes (-want +got):\n%s", diff)
+		}
+		if diff := cmp.Diff(want, describe(planned)); diff != "" {
+			t.Errorf("Unexpected plan (-want +got):\n%s", diff)
+		}
+
+		root, _ := userService.Get("root")
+		if root == nil || !root.IsAdmin || !root.FromConfig {
+			t.Errorf("Expected admin declared in the config file, got %+v", root)
+		}
+		if namespace, _ := namespaceService.Get("studio"); namespace == nil || !namespace.FromConfig {
+			t.Errorf("Expected namespace declared in the config file, got %+v", namespace)
+		}
+
+		changes, err = reconciler.Reconcile()
+		if err != nil || len(changes) != 0 {
+			t.Errorf("Expected nothing to change the second time, got %v, %v", changes, err)
+		}
+	})
+
+	t.Run("adopt and update", func(t *testing.T) {
+		t.Cleanup(storage.Clear)
+		_, _ = userService.Create("bob", "password123", true, "")
+		write(t, config)
+
+		changes, err := reconciler.Reconcile()
+		if err != nil {
+			t.Fatalf("Failed to reconcile: %v", err)
+		}
+
+		want := []string{"create namespace studio", "create user root", "update user bob (isAdmin, password, memberships)", "adopt user bob"}
+		if diff := cmp.Diff(want, describe(changes)); diff != "" {
+			t.Errorf("Unexpected changes (-want +got):\n%s", diff)
+		}
+
+		if bob, _ := userService.Get("bob"); bob.IsAdmin || !bob.FromConfig {
+			t.Errorf("Expected bob to follow the config file, got %+v", bob)
+		}
+	})
+
+	t.Run("release and prune", func(t *testing.T) {
+		t.Cleanup(storage.Clear)
+		write(t, config)
+		if _, err := reconciler.Reconcile(); err != nil {
+			t.Fatal(err)
+		}
+		_, _ = userService.Create("manual", "password123", false, "")
+
+		withoutBob := `
+version: 1
+namespaces: []
+users:
+  - name: root
+    isAdmin: true
+    passwordHash: HASH
+`
+		write(t, withoutBob)
+		changes, err := reconciler.Reconcile()
+		if err != nil {
+			t.Fatalf("Failed to reconcile: %v", err)
+		}
+
+		want := []string{"release user bob", "release namespace studio"}
+		if diff := cmp.Diff(want, describe(changes)); diff != "" {
+			t.Errorf("Unexpected changes (-want +got):\n%s", diff)
+		}
+		if bob, _ := userService.Get("bob"); bob == nil || bob.FromConfig {
+			t.Errorf("Expected bob to be kept and editable again, got %+v", bob)
+		}
+
+		write(t, config)
+		_, _ = reconciler.Reconcile()
+
+		write(t, "prune: true\n"+withoutBob)
+		changes, err = reconciler.Reconcile()
+		if err != nil {
+			t.Fatalf("Failed to reconcile: %v", err)
+		}
+
+		want = []string{"delete user bob", "delete namespace studio"}
+		if diff := cmp.Diff(want, describe(changes)); diff != "" {
+			t.Errorf("Unexpected changes (-want +got):\n%s", diff)
+		}
+		if manual, _ := userService.Get("manual"); manual == nil {
+			t.Errorf("Prune should keep users not created from the config file")
+		}
+	})
+
+	t.Run("namespace still in use", func(t *testing.T) {
+		t.Cleanup(storage.Clear)
+		write(t, config)
+		if _, err := reconciler.Reconcile(); err != nil {
+			t.Fatal(err)
+		}
+
+		write(t, "prune: true\n"+strings.Replace(config, "  - name: studio\n", "", 1))
+		if _, err := reconciler.Reconcile(); err == nil {
+			t.Errorf("Expected error for pruning a namespace still in use")
+		}
+		if namespace, _ := namespaceService.Get("studio"); namespace == nil {
+			t.Errorf("Namespace should be kept")
+		}
+	})
+
+	t.Run("invalid file", func(t *testing.T) {
+		t.Cleanup(storage.Clear)
+
+		for name, content := range map[string]string{
+			"unknown field": "version: 1\nusers: []\nprnue: true\n",
+			"invalid user":  "version: 1\nusers:\n  - name: bob\n    passwordHash: plain\n",
+			"no version":    "users: []\n",
+		} {
+			write(t, content)
+			if _, err := reconciler.Reconcile(); err == nil {
+				t.Errorf("Expected error for %s", name)
+			}
+		}
+
+		if users, _ := userService.GetAllUsers(); len(users) != 0 {
+			t.Errorf("Invalid files should not change anything, got %d users", len(users))
+		}
+
+		reconciler := New(userService, namespaceService, filepath.Join(t.TempDir(), "missing.yaml"))
+		if _, err := reconciler.Reconcile(); !os.IsNotExist(err) {
+			t.Errorf("Expected missing file error, got %v", err)
+		}
+	})
+}
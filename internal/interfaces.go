@@ -2,6 +2,7 @@ package internal
 
 import (
 	"errors"
+	"slices"
 	"time"
 )
 
@@ -12,7 +13,117 @@ type UserPassword struct {
 
 type UserSession struct {
 	ID         uint64
+	Created    time.Time
 	Expiration time.Time
+	// ImpersonatedBy names the admin using this session to view the account
+	// as the user sees it. Empty for sessions created by logging in.
+	ImpersonatedBy string `json:",omitempty"`
+}
+
+type LoginAttempts struct {
+	Failures    int
+	LastFailure time.Time
+	LockedUntil time.Time
+}
+
+func (a LoginAttempts) IsLocked() bool {
+	return a.LockedUntil.After(time.Now())
+}
+
+type PasswordReset struct {
+	TokenHash  string
+	CreatedBy  string
+	Created    time.Time
+	Expiration time.Time
+}
+
+func (r PasswordReset) IsPending() bool {
+	return r.TokenHash != "" && r.Expiration.After(time.Now())
+}
+
+type UserSuspension struct {
+	Reason string
+	By     string
+	Since  time.Time
+	// Until is when the user is reactivated automatically. Zero means never.
+	Until time.Time
+}
+
+func (s UserSuspension) IsActive() bool {
+	return !s.Since.IsZero() && (s.Until.IsZero() || s.Until.After(time.Now()))
+}
+
+// HistoryEntry records a change to an account, such as a new stream key.
+type HistoryEntry struct {
+	Time   time.Time
+	Action string
+	By     string
+}
+
+type TokenScope string
+
+const (
+	ScopeRead           TokenScope = "read"
+	ScopeNamespaceWrite TokenScope = "namespace:write"
+	ScopeAdmin          TokenScope = "admin"
+)
+
+func (s TokenScope) IsValid() bool {
+	return s == ScopeRead || s == ScopeNamespaceWrite || s == ScopeAdmin
+}
+
+// AllowedFor reports whether u may create tokens with scope s. Tokens never
+// grant more than the user has.
+func (s TokenScope) AllowedFor(u User) bool {
+	switch s {
+	case ScopeAdmin:
+		return u.IsAdmin
+	case ScopeNamespaceWrite:
+		return u.IsAdmin || u.IsManager()
+	default:
+		return s == ScopeRead
+	}
+}
+
+// APIToken is a personal token for scripts, sent as "Authorization: Bearer".
+type APIToken struct {
+	ID         string
+	Name       string
+	TokenHash  string
+	Scope      TokenScope
+	Created    time.Time
+	Expiration time.Time
+	LastUsed   time.Time
+}
+
+func (t APIToken) IsActive() bool {
+	return t.Expiration.After(time.Now())
+}
+
+type Permission string
+
+const (
+	PermissionPublish Permission = "publish"
+	PermissionRead    Permission = "read"
+	PermissionBoth    Permission = "both"
+)
+
+func (p Permission) IsValid() bool {
+	return p == PermissionPublish || p == PermissionRead || p == PermissionBoth
+}
+
+// Allows reports whether p permits a MediaMTX action. Everything other than
+// publishing counts as reading.
+func (p Permission) Allows(action string) bool {
+	if action == "publish" {
+		return p == PermissionPublish || p == PermissionBoth
+	}
+	return p == PermissionRead || p == PermissionBoth
+}
+
+type Membership struct {
+	Namespace  string
+	Permission Permission
 }
 
 type User struct {
@@ -20,14 +131,111 @@ type User struct {
 	StreamKey string
 	IsAdmin   bool
 	Password  UserPassword
-	Session   UserSession
-	Namespace string
+	// Session is the session just created by Login or Register. It is not
+	// stored; Sessions holds all active sessions.
+	Session       UserSession `json:"-"`
+	Sessions      []UserSession
+	Memberships   []Membership
+	LoginAttempts LoginAttempts
+	PasswordReset PasswordReset
+	Manages       []string
+	Suspension    UserSuspension
+	// ExpiresAt is when the account stops working. Zero means never.
+	ExpiresAt time.Time
+	// PreviousStreamKey keeps working until PreviousStreamKeyUntil after the
+	// stream key was rotated, giving the encoder time to be updated.
+	PreviousStreamKey      string
+	PreviousStreamKeyUntil time.Time
+	// History lists the most recent changes to the account, oldest first.
+	History   []HistoryEntry
+	APITokens []APIToken
+	// FromConfig marks users declared in the config file, which are
+	// read-only on the admin page.
+	FromConfig bool `json:",omitempty"`
 }
 
 func (ns User) GetID() string {
 	return ns.Name
 }
 
+func (u User) IsExpired() bool {
+	return !u.ExpiresAt.IsZero() && !u.ExpiresAt.After(time.Now())
+}
+
+// HasStreamKey reports whether key is the current stream key or a previous
+// one that is still within its grace period.
+func (u User) HasStreamKey(key string) bool {
+	return key == u.StreamKey || (u.InStreamKeyGrace() && key == u.PreviousStreamKey)
+}
+
+// InStreamKeyGrace reports whether the previous stream key still works.
+func (u User) InStreamKeyGrace() bool {
+	return u.PreviousStreamKey != "" && u.PreviousStreamKeyUntil.After(time.Now())
+}
+
+func (u User) IsManager() bool {
+	return len(u.Manages) > 0
+}
+
+// CanManage reports whether u may administer users and sessions of namespace.
+func (u User) CanManage(namespace string) bool {
+	return u.IsAdmin || (namespace != "" && slices.Contains(u.Manages, namespace))
+}
+
+func (u User) Membership(namespace string) (Membership, bool) {
+	for _, m := range u.Memberships {
+		if m.Namespace == namespace {
+			return m, true
+		}
+	}
+	return Membership{}, false
+}
+
+// CanSeeUser reports whether u may view other, which is the case when other
+// belongs to at least one namespace u manages.
+func (u User) CanSeeUser(other User) bool {
+	if u.IsAdmin {
+		return true
+	}
+
+	if other.IsAdmin {
+		return false
+	}
+
+	return slices.ContainsFunc(other.Memberships, func(m Membership) bool {
+		return u.CanManage(m.Namespace)
+	})
+}
+
+// CanManageUser reports whether u may administer other as a whole, which
+// requires managing every namespace other belongs to or manages. Otherwise a
+// manager could take over the account of another manager and with it the
+// namespaces only that one manages. Only admins may administer admins and
+// users without a namespace.
+func (u User) CanManageUser(other User) bool {
+	if u.IsAdmin {
+		return true
+	}
+
+	if other.IsAdmin || len(other.Memberships) == 0 {
+		return false
+	}
+
+	for _, m := range other.Memberships {
+		if !u.CanManage(m.Namespace) {
+			return false
+		}
+	}
+
+	for _, namespace := range other.Manages {
+		if !u.CanManage(namespace) {
+			return false
+		}
+	}
+
+	return true
+}
+
 type NamespaceSession struct {
 	Key  string
 	Name string
@@ -40,42 +248,144 @@ func (ns NamespaceSession) GetID() string {
 	return ns.Key
 }
 
+type Role string
+
+const (
+	RoleUser    Role = "user"
+	RoleManager Role = "manager"
+	RoleAdmin   Role = "admin"
+)
+
+type NamespaceInvitation struct {
+	ID         string
+	TokenHash  string
+	Role       Role
+	MaxUses    int
+	Uses       int
+	CreatedBy  string
+	Created    time.Time
+	Expiration time.Time
+}
+
+func (i NamespaceInvitation) IsActive() bool {
+	return i.Uses < i.MaxUses && i.Expiration.After(time.Now())
+}
+
 type Namespace struct {
-	Name     string
-	Sessions []NamespaceSession
+	Name        string
+	Sessions    []NamespaceSession
+	Invitations []NamespaceInvitation
+	// FromConfig marks namespaces declared in the config file, which cannot
+	// be removed on the admin page.
+	FromConfig bool `json:",omitempty"`
 }
 
 func (ns Namespace) GetID() string {
 	return ns.Name
 }
 
+// DefaultPageSize is the page size of lists when ListOptions.Limit is zero.
+const DefaultPageSize = 50
+
+// Columns lists can be sorted by.
+const (
+	SortByName    = "name"
+	SortByExpires = "expires"
+)
+
+// ListOptions selects a page of users or namespaces.
+type ListOptions struct {
+	// Search keeps names containing it, ignoring case, or with Prefix only
+	// names starting with it.
+	Search string
+	Prefix bool
+	// Sort is the column to sort by, SortByName if empty. Users can also be
+	// sorted by SortByExpires, with those that never expire last. Ties are
+	// sorted by name.
+	Sort string
+	Desc bool
+	// Cursor is Page.Next of the previous page, empty for the first page.
+	Cursor string
+	// Limit is the page size, DefaultPageSize if zero.
+	Limit int
+}
+
+// Page is one page of a list.
+type Page[T any] struct {
+	Items []T
+	// Next is the cursor of the following page, empty on the last page.
+	Next string
+}
+
 type WithID interface {
 	GetID() string
 }
 
 type UserService interface {
 	Create(username, password string, isAdmin bool, namespace string) (*User, error)
-	CreateDefaultAdminUser() (string, error)
+	Register(username, password string, isAdmin bool, namespace string) (*User, error)
+	CreateInitialAdmin(username, password string) (string, error)
+	HasAdmin() (bool, error)
 	Get(username string) (*User, error)
 	Delete(name string) error
 	GetAllUsers() ([]User, error)
+	GetVisibleTo(actor User) ([]User, error)
+	List(actor User, opts ListOptions) (Page[User], error)
 
 	ChangePassword(username, password string) error
+	CreateFromHash(username, hash string, isGenerated, isAdmin bool) (*User, error)
+	SetPasswordHash(username, hash string, isGenerated bool) error
+	SetStreamKey(username, key string) error
 	ResetPassword(username string) (string, error)
 	ResetStreamKey(username string) (string, error)
+	RotateStreamKey(username, by string, grace time.Duration) (string, error)
+	SetAdmin(username string, isAdmin bool) error
+	Rename(username, newName string) (*User, error)
 	Login(username, password string) (*User, error)
-	Logout(username string) (*User, error)
+	Logout(username, sessionID string) error
+	LogoutAll(username, by string) error
+	Impersonate(username, by string) (*User, error)
+
+	CreateAPIToken(username, name string, scope TokenScope, ttl time.Duration) (*APIToken, string, error)
+	RevokeAPIToken(username, id string) error
+	AuthenticateToken(token string) (*User, *APIToken, error)
 	VerifySession(username, sessionID string) (bool, error)
+	Unlock(username string) error
+	SetManagedNamespaces(username string, namespaces []string) error
+	SetFromConfig(username string, fromConfig bool) error
+	Suspend(username, reason, by string, until time.Time) error
+	Reactivate(username string) error
+	SetExpiry(username string, expiresAt time.Time) error
+	DeleteExpired(grace time.Duration) ([]string, error)
+	SetMembership(username, namespace string, permission Permission) error
+	RemoveMembership(username, namespace string) error
+
+	CreatePasswordReset(username, createdBy string) (string, error)
+	CancelPasswordReset(username string) error
+	CheckPasswordReset(username, token string) error
+	CompletePasswordReset(username, token, password string) (*User, error)
 }
 
 type NamespaceService interface {
 	Create(name string) (*Namespace, error)
 	Get(name string) (*Namespace, error)
 	GetAllNamespaces() ([]Namespace, error)
+	List(actor User, opts ListOptions) (Page[Namespace], error)
 	Delete(name string) error
+	SetFromConfig(name string, fromConfig bool) error
 
 	AddSession(namespace, sessionName, user string) (*NamespaceSession, error)
 	RemoveSession(namespace, sessionKey string) error
+
+	CreateInvitation(namespace string, role Role, maxUses int, ttl time.Duration, createdBy string) (*NamespaceInvitation, string, error)
+	CheckInvitation(namespace, token string) (*NamespaceInvitation, error)
+	UseInvitation(namespace, token string) (*NamespaceInvitation, error)
+	RevokeInvitation(namespace, id string) error
+}
+
+// SessionKicker disconnects whoever is currently publishing to a MediaMTX path.
+type SessionKicker interface {
+	KickPath(path string) error
 }
 
 var (
@@ -85,4 +395,20 @@ var (
 	ErrNamespaceNotFound      = errors.New("namespace not found")
 	ErrNamespaceAlreadyExists = errors.New("namespace already exists")
 	ErrSessionNotFound        = errors.New("session not found")
+	ErrAccountLocked          = errors.New("account is temporarily locked")
+	ErrTooManyAttempts        = errors.New("too many login attempts, try again later")
+	ErrInvalidToken           = errors.New("invalid or expired token")
+	ErrInvitationNotFound     = errors.New("invitation not found")
+	ErrInvalidRole            = errors.New("invalid role")
+	ErrForbidden              = errors.New("permission denied")
+	ErrInvalidPermission      = errors.New("invalid permission")
+	ErrMembershipNotFound     = errors.New("membership not found")
+	ErrUserSuspended          = errors.New("user is suspended")
+	ErrUserExpired            = errors.New("user account has expired")
+	ErrInvalidScope           = errors.New("invalid token scope")
+	ErrTokenNotFound          = errors.New("API token not found")
+	ErrStreamKeyTaken         = errors.New("stream key is already in use")
+	ErrFromConfig             = errors.New("declared in the config file and read-only here")
+	ErrInvalidSort            = errors.New("invalid sort column")
+	ErrInvalidCursor          = errors.New("invalid page cursor")
 )
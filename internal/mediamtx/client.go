@@ -0,0 +1,94 @@
+package mediamtx
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// kickEndpoints maps MediaMTX source types to the API collection used to kick them.
+var kickEndpoints = map[string]string{
+	"rtmpConn":      "rtmpconns",
+	"rtmpsConn":     "rtmpsconns",
+	"rtspSession":   "rtspsessions",
+	"rtspsSession":  "rtspssessions",
+	"srtConn":       "srtconns",
+	"webRTCSession": "webrtcsessions",
+}
+
+// Client talks to the MediaMTX control API.
+type Client struct {
+	BaseURL string
+	HTTP    *http.Client
+}
+
+func New(baseURL string) *Client {
+	return &Client{
+		BaseURL: strings.TrimSuffix(baseURL, "/"),
+		HTTP:    &http.Client{Timeout: 5 * time.Second},
+	}
+}
+
+type pathSource struct {
+	Type string `json:"type"`
+	ID   string `json:"id"`
+}
+
+type pathInfo struct {
+	Source *pathSource `json:"source"`
+}
+
+// KickPath disconnects the publisher of path. Paths that are not live are
+// ignored.
+func (c *Client) KickPath(path string) error {
+	resp, err := c.HTTP.Get(c.BaseURL + "/v3/paths/get/" + escapePath(path))
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode == http.StatusNotFound {
+		return nil
+	}
+
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("mediamtx: get path %s: %s", path, resp.Status)
+	}
+
+	var info pathInfo
+	if err := json.NewDecoder(resp.Body).Decode(&info); err != nil {
+		return err
+	}
+
+	if info.Source == nil {
+		return nil
+	}
+
+	endpoint, ok := kickEndpoints[info.Source.Type]
+	if !ok {
+		return nil
+	}
+
+	kick, err := c.HTTP.Post(c.BaseURL+"/v3/"+endpoint+"/kick/"+url.PathEscape(info.Source.ID), "application/json", nil)
+	if err != nil {
+		return err
+	}
+	defer kick.Body.Close()
+
+	if kick.StatusCode != http.StatusOK && kick.StatusCode != http.StatusNotFound {
+		return fmt.Errorf("mediamtx: kick %s: %s", path, kick.Status)
+	}
+
+	return nil
+}
+
+func escapePath(path string) string {
+	parts := strings.Split(path, "/")
+	for i, part := range parts {
+		parts[i] = url.PathEscape(part)
+	}
+	return strings.Join(parts, "/")
+}
@@ -0,0 +1,57 @@
+package mediamtx
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestClient_KickPath(t *testing.T) {
+	var kicked []string
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /v3/paths/get/ns/live", func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write([]byte(`{"name":"ns/live","source":{"type":"rtmpConn","id":"abc"},"readers":[]}`))
+	})
+	mux.HandleFunc("GET /v3/paths/get/ns/idle", func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write([]byte(`{"name":"ns/idle","source":null}`))
+	})
+	mux.HandleFunc("POST /v3/rtmpconns/kick/{id}", func(w http.ResponseWriter, r *http.Request) {
+		kicked = append(kicked, r.PathValue("id"))
+	})
+
+	server := httptest.NewServer(mux)
+	defer server.Close()
+
+	client := New(server.URL + "/")
+
+	t.Run("live", func(t *testing.T) {
+		kicked = nil
+
+		if err := client.KickPath("ns/live"); err != nil {
+			t.Fatalf("KickPath failed: %v", err)
+		}
+
+		if len(kicked) != 1 || kicked[0] != "abc" {
+			t.Errorf("Expected connection abc to be kicked, got %v", kicked)
+		}
+	})
+
+	t.Run("idle", func(t *testing.T) {
+		kicked = nil
+
+		if err := client.KickPath("ns/idle"); err != nil {
+			t.Fatalf("KickPath failed: %v", err)
+		}
+
+		if len(kicked) != 0 {
+			t.Errorf("Expected nothing to be kicked, got %v", kicked)
+		}
+	})
+
+	t.Run("missing", func(t *testing.T) {
+		if err := client.KickPath("ns/missing"); err != nil {
+			t.Errorf("Expected missing path to be ignored, got %v", err)
+		}
+	})
+}
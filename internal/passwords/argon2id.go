@@ -2,11 +2,14 @@ package passwords
 
 import (
 	"crypto/rand"
+	"crypto/sha256"
 	"crypto/subtle"
 	"encoding/base64"
+	"encoding/hex"
 	"errors"
 	"fmt"
 	"strconv"
+	"sync"
 
 	"github.com/nothub/hashutils/encoding/b64"
 	"github.com/nothub/hashutils/phc"
@@ -14,6 +17,37 @@ import (
 )
 
 var ErrNotEnoughArguments = errors.New("not enough arguments")
+var ErrInvalidHash = errors.New("not an argon2id password hash")
+
+// DefaultMaxConcurrent is the default number of argon2id computations allowed
+// to run at once. Each one allocates 64MiB.
+const DefaultMaxConcurrent = 4
+
+// slots holds a value for every hash being computed. slotsMu guards
+// replacing it; computations release the slot in the channel they took it
+// from.
+var slots = make(chan struct{}, DefaultMaxConcurrent)
+var slotsMu sync.Mutex
+
+// SetMaxConcurrent changes how many hashes may be computed at once. Hashes
+// already being computed are not counted against the new limit.
+func SetMaxConcurrent(n int) {
+	slotsMu.Lock()
+	defer slotsMu.Unlock()
+
+	slots = make(chan struct{}, max(n, 1))
+}
+
+func idKey(password, salt []byte, time, memory uint32, threads uint8, keyLen uint32) []byte {
+	slotsMu.Lock()
+	taken := slots
+	slotsMu.Unlock()
+
+	taken <- struct{}{}
+	defer func() { <-taken }()
+
+	return argon2.IDKey(password, salt, time, memory, threads, keyLen)
+}
 
 func Hash(password string) (string, error) {
 	// Numbers from RFC 9106
@@ -30,7 +64,7 @@ func Hash(password string) (string, error) {
 		return "", err
 	}
 
-	hash := argon2.IDKey([]byte(password), salt, time, memory, threads, keyLength)
+	hash := idKey([]byte(password), salt, time, memory, threads, keyLength)
 
 	b64Salt := base64.RawStdEncoding.EncodeToString(salt)
 	b64Hash := base64.RawStdEncoding.EncodeToString(hash)
@@ -90,7 +124,7 @@ func Verify(password, stored string) (bool, error) {
 		return false, ErrNotEnoughArguments
 	}
 
-	computedHash := argon2.IDKey(
+	computedHash := idKey(
 		[]byte(password),
 		salt,
 		uint32(iterations),
@@ -102,6 +136,51 @@ func Verify(password, stored string) (bool, error) {
 	return subtle.ConstantTimeCompare(hash, computedHash) == 1, nil
 }
 
+// ValidateHash checks that stored is a password hash in the format produced by
+// Hash, such as one exported from another installation.
+func ValidateHash(stored string) error {
+	p, err := phc.Parse(stored)
+	if err != nil || p.Id() != "argon2id" {
+		return ErrInvalidHash
+	}
+
+	if _, err := b64.Decode(p.Salt()); err != nil {
+		return ErrInvalidHash
+	}
+
+	if hash, err := b64.Decode(p.Hash()); err != nil || len(hash) == 0 {
+		return ErrInvalidHash
+	}
+
+	params := map[string]int{"m": 32, "t": 32, "p": 8}
+	for _, param := range p.Params() {
+		if bits, ok := params[param.K]; ok {
+			if _, err := strconv.ParseUint(param.V, 10, bits); err != nil {
+				return ErrInvalidHash
+			}
+			delete(params, param.K)
+		}
+	}
+
+	if len(params) > 0 {
+		return ErrInvalidHash
+	}
+
+	return nil
+}
+
+// HashToken hashes a random, high-entropy token such as a reset link secret.
+// Unlike passwords these do not need a slow hash.
+func HashToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
+
+// VerifyToken reports whether token matches a hash produced by HashToken.
+func VerifyToken(token, hash string) bool {
+	return hash != "" && subtle.ConstantTimeCompare([]byte(HashToken(token)), []byte(hash)) == 1
+}
+
 func generateSalt(n int) ([]byte, error) {
 	b := make([]byte, n)
 	_, err := rand.Read(b)
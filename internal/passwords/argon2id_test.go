@@ -1,6 +1,7 @@
 package passwords
 
 import (
+	"sync"
 	"testing"
 )
 
@@ -100,3 +101,41 @@ func TestVerifyPasswordErrorHandling(t *testing.T) {
 		})
 	}
 }
+
+func TestValidateHash(t *testing.T) {
+	hashed, err := Hash(TestPassword)
+	if err != nil {
+		t.Fatalf("Hash failed: %v", err)
+	}
+
+	if err := ValidateHash(hashed); err != nil {
+		t.Errorf("Hash should be valid: %v", err)
+	}
+
+	for _, invalid := range []string{
+		"",
+		"plaintext",
+		"$argon2i$v=19$m=65536,t=3,p=4$c2FsdA$aGFzaA",
+		"$argon2id$v=19$m=65536,t=3$c2FsdA$aGFzaA",
+		"$argon2id$v=19$m=65536,t=3,p=4$c2FsdA$",
+	} {
+		if err := ValidateHash(invalid); err != ErrInvalidHash {
+			t.Errorf("Expected ErrInvalidHash for %q, got %v", invalid, err)
+		}
+	}
+}
+
+func TestSetMaxConcurrentWhileHashing(t *testing.T) {
+	defer SetMaxConcurrent(DefaultMaxConcurrent)
+
+	var wg sync.WaitGroup
+	for i := range 4 {
+		wg.Go(func() {
+			if _, err := Hash(TestPassword); err != nil {
+				t.Errorf("Hash failed: %v", err)
+			}
+		})
+		wg.Go(func() { SetMaxConcurrent(i + 1) })
+	}
+	wg.Wait()
+}
@@ -0,0 +1,112 @@
+package server
+
+import (
+	"MediaMTXAuth/internal"
+	"MediaMTXAuth/internal/api"
+	"MediaMTXAuth/internal/auth"
+	"MediaMTXAuth/internal/storage"
+	"MediaMTXAuth/internal/views/pages"
+	"net/http"
+)
+
+// Server routes requests to the pages and APIs.
+type Server struct {
+	Login *pages.LoginPage
+	Admin *pages.AdminPage
+	API   *api.API
+
+	mux *http.ServeMux
+}
+
+func New(userService internal.UserService, namespaceService internal.NamespaceService) *Server {
+	requirePost := func(handler func(http.ResponseWriter, *http.Request)) http.HandlerFunc {
+		return func(w http.ResponseWriter, r *http.Request) {
+			if r.Method != http.MethodPost {
+				http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+				return
+			}
+			handler(w, r)
+		}
+	}
+
+	loginView := pages.NewLogin(userService)
+	adminView := pages.NewAdmin(userService, namespaceService)
+	adminAPI := api.New(userService, namespaceService)
+	userView := pages.NewUser(adminView)
+	panelView := pages.NewPanel(userService)
+	logoutView := pages.NewLogout(userService)
+	resetView := pages.NewReset(userService)
+	inviteView := pages.NewInvite(userService, namespaceService)
+	authAPI := auth.New(userService, namespaceService)
+
+	mux := http.NewServeMux()
+	mux.Handle("/static/", http.StripPrefix("/static/", http.FileServer(http.Dir("internal/views/pages/html/static"))))
+
+	// API
+	mux.Handle("/api/auth", authAPI)
+	mux.Handle("/api/v1/", adminAPI)
+
+	// Views
+	mux.Handle("/login", loginView)
+	mux.HandleFunc("/login/setup", requirePost(loginView.HandleSetup))
+	mux.Handle("/admin", adminView)
+	mux.Handle("/admin/user", userView)
+	mux.Handle("/panel", panelView)
+	mux.Handle("/logout", logoutView)
+	mux.Handle("/reset", resetView)
+	mux.Handle("/invite", inviteView)
+	mux.HandleFunc("/admin/export", adminView.HandleExport)
+
+	// POST
+	mux.HandleFunc("/admin/add", requirePost(adminView.HandleAddUser))
+	mux.HandleFunc("/admin/remove", requirePost(adminView.HandleRemoveUser))
+	mux.HandleFunc("/admin/unlock", requirePost(adminView.HandleUnlockUser))
+	mux.HandleFunc("/admin/suspend", requirePost(adminView.HandleSuspendUser))
+	mux.HandleFunc("/admin/reactivate", requirePost(adminView.HandleReactivateUser))
+	mux.HandleFunc("/admin/logout_user", requirePost(adminView.HandleLogoutUser))
+	mux.HandleFunc("/admin/set_expiry", requirePost(adminView.HandleSetExpiry))
+	mux.HandleFunc("/admin/user/update", requirePost(userView.HandleUpdateUser))
+	mux.HandleFunc("/admin/user/reset_link", requirePost(userView.HandleCreateResetLink))
+	mux.HandleFunc("/admin/user/reset_key", requirePost(userView.HandleResetStreamKey))
+	mux.HandleFunc("/admin/user/impersonate", requirePost(userView.HandleImpersonate))
+	mux.HandleFunc("/admin/reset_key", requirePost(adminView.HandleResetStreamKey))
+	mux.HandleFunc("/admin/set_membership", requirePost(adminView.HandleSetMembership))
+	mux.HandleFunc("/admin/remove_membership", requirePost(adminView.HandleRemoveMembership))
+	mux.HandleFunc("/admin/reset_link", requirePost(adminView.HandleCreateResetLink))
+	mux.HandleFunc("/admin/cancel_reset", requirePost(adminView.HandleCancelResetLink))
+	mux.HandleFunc("/admin/add_namespace", requirePost(adminView.HandleAddNamespace))
+	mux.HandleFunc("/admin/remove_namespace", requirePost(adminView.HandleRemoveNamespace))
+	mux.HandleFunc("/admin/import", requirePost(adminView.HandleImport))
+	mux.HandleFunc("/admin/add_invitation", requirePost(adminView.HandleAddInvitation))
+	mux.HandleFunc("/admin/revoke_invitation", requirePost(adminView.HandleRevokeInvitation))
+	mux.HandleFunc("/admin/add_session", requirePost(adminView.HandleAddSession))
+	mux.HandleFunc("/admin/remove_session", requirePost(adminView.HandleRemoveSession))
+	mux.HandleFunc("/panel/change_password", requirePost(panelView.HandleChangePassword))
+	mux.HandleFunc("/panel/rotate_key", requirePost(panelView.HandleRotateStreamKey))
+	mux.HandleFunc("/panel/create_token", requirePost(panelView.HandleCreateToken))
+	mux.HandleFunc("/panel/revoke_token", requirePost(panelView.HandleRevokeToken))
+	mux.HandleFunc("/logout/all", requirePost(logoutView.HandleLogoutAll))
+	mux.HandleFunc("/impersonate/stop", requirePost(logoutView.HandleStopImpersonation))
+
+	return &Server{
+		Login: loginView,
+		Admin: adminView,
+		API:   adminAPI,
+		mux:   mux,
+	}
+}
+
+// SetKicker sets the SessionKicker used to disconnect live streams.
+func (s *Server) SetKicker(kicker internal.SessionKicker) {
+	s.Admin.Kicker = kicker
+	s.API.Kicker = kicker
+}
+
+// SetBackuper sets the Backuper used by the backup and restore endpoints.
+func (s *Server) SetBackuper(backuper storage.Backuper) {
+	s.API.Backuper = backuper
+}
+
+func (s *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	s.mux.ServeHTTP(w, r)
+}
@@ -0,0 +1,120 @@
+package services
+
+import (
+	"MediaMTXAuth/internal"
+	"errors"
+	"log"
+)
+
+// The rules in this file are shared by the admin pages and the JSON API, so
+// that both refuse the same changes.
+
+var (
+	ErrManagerWithoutNamespace = errors.New("managers need a namespace")
+	ErrSuspendSelf             = errors.New("you cannot suspend yourself")
+	ErrDemoteSelf              = errors.New("you cannot remove your own admin rights")
+)
+
+// AuthorizeUser returns the user called username if actor may administer
+// them.
+func AuthorizeUser(users internal.UserService, actor *internal.User, username string) (*internal.User, error) {
+	user, err := users.Get(username)
+	if err != nil {
+		return nil, err
+	}
+
+	if user == nil {
+		return nil, internal.ErrUserNotFound
+	}
+
+	if !actor.CanManageUser(*user) {
+		return nil, internal.ErrForbidden
+	}
+
+	return user, nil
+}
+
+// AuthorizeEdit is like AuthorizeUser but also refuses changes to users
+// declared in the config file, which would be overwritten by the next
+// reconciliation.
+func AuthorizeEdit(users internal.UserService, actor *internal.User, username string) (*internal.User, error) {
+	user, err := AuthorizeUser(users, actor, username)
+	if err != nil {
+		return nil, err
+	}
+
+	if user.FromConfig {
+		return nil, internal.ErrFromConfig
+	}
+
+	return user, nil
+}
+
+// AuthorizeMembership checks that actor may change the membership of the user
+// called username in namespace. Managers may only change users they can see,
+// so that they cannot pull users of other namespaces into their own.
+func AuthorizeMembership(users internal.UserService, actor *internal.User, username, namespace string) error {
+	user, err := users.Get(username)
+	if err != nil {
+		return err
+	}
+
+	if user == nil {
+		return internal.ErrUserNotFound
+	}
+
+	if !actor.CanManage(namespace) || !actor.CanSeeUser(*user) {
+		return internal.ErrForbidden
+	}
+
+	if user.FromConfig {
+		return internal.ErrFromConfig
+	}
+
+	return nil
+}
+
+// AuthorizeNamespaceRemoval refuses to remove namespaces declared in the
+// config file.
+func AuthorizeNamespaceRemoval(namespaces internal.NamespaceService, name string) error {
+	namespace, err := namespaces.Get(name)
+	if err != nil {
+		return err
+	}
+
+	if namespace != nil && namespace.FromConfig {
+		return internal.ErrFromConfig
+	}
+
+	return nil
+}
+
+// KickStreams disconnects everything the user called username is publishing
+// right now. kicker may be nil, in which case nothing happens.
+func KickStreams(users internal.UserService, kicker internal.SessionKicker, username string) {
+	if kicker == nil {
+		return
+	}
+
+	user, _ := users.Get(username)
+	if user == nil {
+		return
+	}
+
+	KickRenamedStreams(kicker, *user, user.Name)
+}
+
+// KickRenamedStreams disconnects everything user is publishing under name,
+// the name it had before it was renamed. kicker may be nil, in which case
+// nothing happens.
+func KickRenamedStreams(kicker internal.SessionKicker, user internal.User, name string) {
+	if kicker == nil {
+		return
+	}
+
+	for _, m := range user.Memberships {
+		if err := kicker.KickPath(m.Namespace + "/" + name); err != nil {
+			log.Printf("Failed to kick %s/%s: %v", m.Namespace, name, err)
+		}
+	}
+}
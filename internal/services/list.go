@@ -0,0 +1,181 @@
+package services
+
+import (
+	"MediaMTXAuth/internal"
+	"MediaMTXAuth/internal/storage"
+	"cmp"
+	"encoding/base64"
+	"slices"
+	"strings"
+)
+
+func (s *userService) List(actor internal.User, opts internal.ListOptions) (internal.Page[internal.User], error) {
+	var key func(internal.User) string
+
+	switch opts.Sort {
+	case "", internal.SortByName:
+		// Admins page through the storage by name without loading the
+		// other users.
+		if actor.IsAdmin {
+			return listRange(s.storage.ListUsers, opts)
+		}
+		key = internal.User.GetID
+	case internal.SortByExpires:
+		key = expiresKey
+	default:
+		return internal.Page[internal.User]{}, internal.ErrInvalidSort
+	}
+
+	var users []internal.User
+	var err error
+
+	if actor.IsAdmin {
+		users, err = s.storage.ListUsers(searchRange(opts))
+	} else {
+		users, err = s.GetVisibleTo(actor)
+		users = slices.DeleteFunc(users, func(user internal.User) bool {
+			return !searchRange(opts).Match(user.Name)
+		})
+	}
+	if err != nil {
+		return internal.Page[internal.User]{}, err
+	}
+
+	return paginate(users, key, opts)
+}
+
+func (s *namespaceService) List(actor internal.User, opts internal.ListOptions) (internal.Page[internal.Namespace], error) {
+	if opts.Sort != "" && opts.Sort != internal.SortByName {
+		return internal.Page[internal.Namespace]{}, internal.ErrInvalidSort
+	}
+
+	if actor.IsAdmin {
+		return listRange(s.storage.ListNamespaces, opts)
+	}
+
+	var namespaces []internal.Namespace
+	for _, name := range actor.Manages {
+		namespace, err := s.storage.GetNamespace(name)
+		if err != nil {
+			return internal.Page[internal.Namespace]{}, err
+		}
+		if namespace != nil && searchRange(opts).Match(name) {
+			namespaces = append(namespaces, *namespace)
+		}
+	}
+
+	return paginate(namespaces, internal.Namespace.GetID, opts)
+}
+
+// expiresKey sorts users by expiry, with users that never expire last.
+func expiresKey(user internal.User) string {
+	if user.ExpiresAt.IsZero() {
+		return "~"
+	}
+	return user.ExpiresAt.UTC().Format("2006-01-02T15:04:05.000000000")
+}
+
+// searchRange returns the storage range matching the search of opts.
+func searchRange(opts internal.ListOptions) storage.Range {
+	if opts.Prefix {
+		return storage.Range{Prefix: opts.Search}
+	}
+	return storage.Range{Contains: opts.Search}
+}
+
+// listRange returns a page sorted by name from list, which only reads the
+// records of the page.
+func listRange[T internal.WithID](list func(storage.Range) ([]T, error), opts internal.ListOptions) (internal.Page[T], error) {
+	_, after, err := decodeCursor(opts.Cursor)
+	if err != nil {
+		return internal.Page[T]{}, err
+	}
+
+	r := searchRange(opts)
+	r.After = after
+	r.Desc = opts.Desc
+	r.Limit = pageSize(opts) + 1
+
+	items, err := list(r)
+	if err != nil {
+		return internal.Page[T]{}, err
+	}
+
+	return page(items, T.GetID, opts), nil
+}
+
+// paginate sorts items by key and name and returns the page after the cursor
+// of opts.
+func paginate[T internal.WithID](items []T, key func(T) string, opts internal.ListOptions) (internal.Page[T], error) {
+	afterKey, afterName, err := decodeCursor(opts.Cursor)
+	if err != nil {
+		return internal.Page[T]{}, err
+	}
+
+	compare := func(a, b T) int {
+		c := cmp.Or(strings.Compare(key(a), key(b)), strings.Compare(a.GetID(), b.GetID()))
+		if opts.Desc {
+			return -c
+		}
+		return c
+	}
+	slices.SortFunc(items, compare)
+
+	if opts.Cursor != "" {
+		start := slices.IndexFunc(items, func(item T) bool {
+			c := cmp.Or(strings.Compare(key(item), afterKey), strings.Compare(item.GetID(), afterName))
+			return (c > 0) != opts.Desc && c != 0
+		})
+		if start < 0 {
+			start = len(items)
+		}
+		items = items[start:]
+	}
+
+	return page(items[:min(len(items), pageSize(opts)+1)], key, opts), nil
+}
+
+// page cuts items, which may hold one more item than the page size, to a
+// page and sets the cursor of the next page if there is one.
+func page[T internal.WithID](items []T, key func(T) string, opts internal.ListOptions) internal.Page[T] {
+	limit := pageSize(opts)
+	if len(items) <= limit {
+		return internal.Page[T]{Items: items}
+	}
+
+	last := items[limit-1]
+	return internal.Page[T]{
+		Items: items[:limit],
+		Next:  encodeCursor(key(last), last.GetID()),
+	}
+}
+
+func pageSize(opts internal.ListOptions) int {
+	if opts.Limit > 0 {
+		return opts.Limit
+	}
+	return internal.DefaultPageSize
+}
+
+// encodeCursor returns a cursor pointing after the item with the sort key and
+// name. Cursors are opaque to clients, so their layout can change.
+func encodeCursor(key, name string) string {
+	return base64.RawURLEncoding.EncodeToString([]byte(key + "\x00" + name))
+}
+
+func decodeCursor(cursor string) (key, name string, err error) {
+	if cursor == "" {
+		return "", "", nil
+	}
+
+	data, err := base64.RawURLEncoding.DecodeString(cursor)
+	if err != nil {
+		return "", "", internal.ErrInvalidCursor
+	}
+
+	key, name, ok := strings.Cut(string(data), "\x00")
+	if !ok {
+		return "", "", internal.ErrInvalidCursor
+	}
+	return key, name, nil
+}
@@ -2,11 +2,17 @@ package services
 
 import (
 	"MediaMTXAuth/internal"
+	"MediaMTXAuth/internal/passwords"
 	"MediaMTXAuth/internal/storage"
 	"crypto/rand"
+	"errors"
+	"slices"
 	"time"
 )
 
+var ErrInvalidMaxUses = errors.New("max uses must be at least 1")
+var ErrInvalidExpiration = errors.New("expiration must be in the future")
+
 type namespaceService struct {
 	storage storage.Storage
 }
@@ -46,28 +52,64 @@ func (s *namespaceService) GetAllNamespaces() ([]internal.Namespace, error) {
 	return s.storage.GetAllNamespaces()
 }
 
+// Delete removes the namespace along with all memberships in it, so that a
+// namespace created later with the same name starts out empty.
 func (s *namespaceService) Delete(namespaceName string) error {
-	return s.storage.DeleteNamespace(namespaceName)
-}
+	users, err := s.storage.GetUsersByNamespace(namespaceName)
+	if err != nil {
+		return err
+	}
 
-func (s *namespaceService) AddSession(namespaceName, sessionName, user string) (*internal.NamespaceSession, error) {
-	namespace, _ := s.storage.GetNamespace(namespaceName)
-	if namespace == nil {
-		return nil, internal.ErrNamespaceNotFound
+	for _, user := range users {
+		memberships := slices.DeleteFunc(slices.Clone(user.Memberships), func(m internal.Membership) bool {
+			return m.Namespace == namespaceName
+		})
+		manages := slices.DeleteFunc(slices.Clone(user.Manages), func(name string) bool {
+			return name == namespaceName
+		})
+
+		if len(memberships) == len(user.Memberships) && len(manages) == len(user.Manages) {
+			continue
+		}
+
+		err := s.storage.UpdateUser(user.Name, func(user *internal.User) error {
+			user.Memberships = slices.DeleteFunc(user.Memberships, func(m internal.Membership) bool {
+				return m.Namespace == namespaceName
+			})
+			user.Manages = slices.DeleteFunc(user.Manages, func(name string) bool {
+				return name == namespaceName
+			})
+			return nil
+		})
+		if err != nil && !errors.Is(err, internal.ErrUserNotFound) {
+			return err
+		}
 	}
 
-	sessionKey := rand.Text()
+	return s.storage.DeleteNamespace(namespaceName)
+}
 
+// SetFromConfig marks whether the namespace is declared in the config file.
+func (s *namespaceService) SetFromConfig(namespaceName string, fromConfig bool) error {
+	return s.storage.UpdateNamespace(namespaceName, func(namespace *internal.Namespace) error {
+		namespace.FromConfig = fromConfig
+		return nil
+	})
+}
+
+func (s *namespaceService) AddSession(namespaceName, sessionName, user string) (*internal.NamespaceSession, error) {
 	newSession := internal.NamespaceSession{
-		Key:     sessionKey,
+		Key:     rand.Text(),
 		Name:    sessionName,
 		User:    user,
 		Created: time.Now(),
 	}
 
-	namespace.Sessions = append(namespace.Sessions, newSession)
-
-	if err := s.storage.SetNamespace(*namespace); err != nil {
+	err := s.storage.UpdateNamespace(namespaceName, func(namespace *internal.Namespace) error {
+		namespace.Sessions = append(namespace.Sessions, newSession)
+		return nil
+	})
+	if err != nil {
 		return nil, err
 	}
 
@@ -75,23 +117,107 @@ func (s *namespaceService) AddSession(namespaceName, sessionName, user string) (
 }
 
 func (s *namespaceService) RemoveSession(namespaceName, sessionKey string) error {
+	return s.storage.UpdateNamespace(namespaceName, func(namespace *internal.Namespace) error {
+		namespace.Sessions = slices.DeleteFunc(namespace.Sessions, func(session internal.NamespaceSession) bool {
+			return session.Key == sessionKey
+		})
+		return nil
+	})
+}
+
+func (s *namespaceService) CreateInvitation(namespaceName string, role internal.Role, maxUses int, ttl time.Duration, createdBy string) (*internal.NamespaceInvitation, string, error) {
+	if role != internal.RoleUser && role != internal.RoleManager && role != internal.RoleAdmin {
+		return nil, "", internal.ErrInvalidRole
+	}
+
+	if maxUses < 1 {
+		return nil, "", ErrInvalidMaxUses
+	}
+
+	if ttl <= 0 {
+		return nil, "", ErrInvalidExpiration
+	}
+
+	token := rand.Text()
+	now := time.Now()
+
+	invitation := internal.NamespaceInvitation{
+		ID:         rand.Text()[:10],
+		TokenHash:  passwords.HashToken(token),
+		Role:       role,
+		MaxUses:    maxUses,
+		CreatedBy:  createdBy,
+		Created:    now,
+		Expiration: now.Add(ttl),
+	}
+
+	err := s.storage.UpdateNamespace(namespaceName, func(namespace *internal.Namespace) error {
+		namespace.Invitations = append(namespace.Invitations, invitation)
+		return nil
+	})
+	if err != nil {
+		return nil, "", err
+	}
+
+	return &invitation, token, nil
+}
+
+func (s *namespaceService) CheckInvitation(namespaceName, token string) (*internal.NamespaceInvitation, error) {
 	namespace, _ := s.storage.GetNamespace(namespaceName)
 	if namespace == nil {
-		return internal.ErrNamespaceNotFound
+		return nil, internal.ErrInvalidToken
 	}
 
-	newSessions := make([]internal.NamespaceSession, 0, len(namespace.Sessions))
-	for _, sess := range namespace.Sessions {
-		if sess.Key == sessionKey {
-			continue
-		}
-		newSessions = append(newSessions, sess)
+	i := findInvitation(namespace, token)
+	if i < 0 {
+		return nil, internal.ErrInvalidToken
 	}
 
-	namespace.Sessions = newSessions
-	if err := s.storage.SetNamespace(*namespace); err != nil {
-		return err
+	return &namespace.Invitations[i], nil
+}
+
+func (s *namespaceService) UseInvitation(namespaceName, token string) (*internal.NamespaceInvitation, error) {
+	var invitation internal.NamespaceInvitation
+
+	err := s.storage.UpdateNamespace(namespaceName, func(namespace *internal.Namespace) error {
+		i := findInvitation(namespace, token)
+		if i < 0 {
+			return internal.ErrInvalidToken
+		}
+
+		namespace.Invitations[i].Uses++
+		invitation = namespace.Invitations[i]
+		return nil
+	})
+	if errors.Is(err, internal.ErrNamespaceNotFound) {
+		return nil, internal.ErrInvalidToken
+	} else if err != nil {
+		return nil, err
 	}
 
-	return nil
+	return &invitation, nil
+}
+
+func (s *namespaceService) RevokeInvitation(namespaceName, id string) error {
+	return s.storage.UpdateNamespace(namespaceName, func(namespace *internal.Namespace) error {
+		i := slices.IndexFunc(namespace.Invitations, func(invitation internal.NamespaceInvitation) bool {
+			return invitation.ID == id
+		})
+		if i < 0 {
+			return internal.ErrInvitationNotFound
+		}
+
+		namespace.Invitations = slices.Delete(namespace.Invitations, i, i+1)
+		return nil
+	})
+}
+
+// findInvitation returns the index of the active invitation matching token, or -1.
+func findInvitation(namespace *internal.Namespace, token string) int {
+	for i, invitation := range namespace.Invitations {
+		if invitation.IsActive() && passwords.VerifyToken(token, invitation.TokenHash) {
+			return i
+		}
+	}
+	return -1
 }
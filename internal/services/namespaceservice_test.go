@@ -3,7 +3,9 @@ package services
 import (
 	"MediaMTXAuth/internal"
 	"MediaMTXAuth/internal/storage/memory"
+	"sync"
 	"testing"
+	"time"
 
 	"github.com/google/go-cmp/cmp"
 )
@@ -91,6 +93,58 @@ func TestNamespaceService(t *testing.T) {
 			t.Errorf("namespace shouldnt exist, got %v", deletedUser)
 		}
 	})
+	t.Run("delete namespace removes memberships", func(t *testing.T) {
+		t.Cleanup(storage.Clear)
+		_, _ = namespaceService.Create(namespace)
+		_ = storage.SetUser(internal.User{
+			Name:        username,
+			Memberships: []internal.Membership{{Namespace: namespace, Permission: internal.PermissionBoth}},
+			Manages:     []string{namespace},
+		})
+
+		if err := namespaceService.Delete(namespace); err != nil {
+			t.Fatalf("Failed to delete namespace: %v", err)
+		}
+
+		user, _ := storage.GetUser(username)
+		if len(user.Memberships) != 0 || len(user.Manages) != 0 {
+			t.Errorf("Expected memberships to be removed, got %v and %v", user.Memberships, user.Manages)
+		}
+	})
+
+	t.Run("list", func(t *testing.T) {
+		t.Cleanup(storage.Clear)
+		for _, name := range []string{"delta", "alpha", "charlie", "bravo"} {
+			_, _ = namespaceService.Create(name)
+		}
+
+		admin := internal.User{Name: "admin", IsAdmin: true}
+		page, err := namespaceService.List(admin, internal.ListOptions{Limit: 3})
+		if err != nil || len(page.Items) != 3 || page.Items[0].Name != "alpha" || page.Next == "" {
+			t.Fatalf("Expected first page of 3, got %v, %v", page, err)
+		}
+
+		page, _ = namespaceService.List(admin, internal.ListOptions{Limit: 3, Cursor: page.Next})
+		if len(page.Items) != 1 || page.Items[0].Name != "delta" || page.Next != "" {
+			t.Errorf("Expected last page with delta, got %v", page)
+		}
+
+		manager := internal.User{Name: "manager", Manages: []string{"delta", "bravo", "missing"}}
+		page, _ = namespaceService.List(manager, internal.ListOptions{})
+		if len(page.Items) != 2 || page.Items[0].Name != "bravo" || page.Items[1].Name != "delta" {
+			t.Errorf("Expected managed namespaces, got %v", page)
+		}
+
+		page, _ = namespaceService.List(manager, internal.ListOptions{Search: "del"})
+		if len(page.Items) != 1 || page.Items[0].Name != "delta" {
+			t.Errorf("Expected search result delta, got %v", page)
+		}
+
+		if _, err := namespaceService.List(admin, internal.ListOptions{Sort: internal.SortByExpires}); err != internal.ErrInvalidSort {
+			t.Errorf("Expected ErrInvalidSort, got %v", err)
+		}
+	})
+
 	t.Run("add session", func(t *testing.T) {
 		t.Cleanup(storage.Clear)
 		_, err := namespaceService.Create(namespace)
@@ -159,4 +213,102 @@ func TestNamespaceService(t *testing.T) {
 			t.Errorf("Expected ErrNamespaceNotFound, got %v", err)
 		}
 	})
+	t.Run("invitations", func(t *testing.T) {
+		t.Cleanup(storage.Clear)
+		_, _ = namespaceService.Create(namespace)
+
+		invitation, token, err := namespaceService.CreateInvitation(namespace, internal.RoleUser, 2, time.Hour, username)
+		if err != nil {
+			t.Fatalf("Failed to create invitation: %v", err)
+		}
+
+		if invitation.TokenHash == token {
+			t.Errorf("Token should be stored hashed")
+		}
+
+		t.Run("check", func(t *testing.T) {
+			checked, err := namespaceService.CheckInvitation(namespace, token)
+			if err != nil {
+				t.Fatalf("Failed to check invitation: %v", err)
+			}
+
+			if checked.ID != invitation.ID || checked.Uses != 0 {
+				t.Errorf("Unexpected invitation: %+v", checked)
+			}
+
+			if _, err := namespaceService.CheckInvitation(namespace, "wrong"); err != internal.ErrInvalidToken {
+				t.Errorf("Expected ErrInvalidToken, got %v", err)
+			}
+		})
+
+		t.Run("use up", func(t *testing.T) {
+			for range 2 {
+				if _, err := namespaceService.UseInvitation(namespace, token); err != nil {
+					t.Fatalf("Failed to use invitation: %v", err)
+				}
+			}
+
+			if _, err := namespaceService.UseInvitation(namespace, token); err != internal.ErrInvalidToken {
+				t.Errorf("Expected ErrInvalidToken, got %v", err)
+			}
+		})
+
+		t.Run("revoke", func(t *testing.T) {
+			if err := namespaceService.RevokeInvitation(namespace, invitation.ID); err != nil {
+				t.Fatalf("Failed to revoke invitation: %v", err)
+			}
+
+			if err := namespaceService.RevokeInvitation(namespace, invitation.ID); err != internal.ErrInvitationNotFound {
+				t.Errorf("Expected ErrInvitationNotFound, got %v", err)
+			}
+		})
+	})
+
+	t.Run("invalid invitations", func(t *testing.T) {
+		t.Cleanup(storage.Clear)
+		_, _ = namespaceService.Create(namespace)
+
+		if _, _, err := namespaceService.CreateInvitation(namespace, "owner", 1, time.Hour, username); err != internal.ErrInvalidRole {
+			t.Errorf("Expected ErrInvalidRole, got %v", err)
+		}
+
+		if _, _, err := namespaceService.CreateInvitation(namespace, internal.RoleUser, 0, time.Hour, username); err != ErrInvalidMaxUses {
+			t.Errorf("Expected ErrInvalidMaxUses, got %v", err)
+		}
+
+		if _, _, err := namespaceService.CreateInvitation("nonexistent", internal.RoleUser, 1, time.Hour, username); err != internal.ErrNamespaceNotFound {
+			t.Errorf("Expected ErrNamespaceNotFound, got %v", err)
+		}
+
+		_, token, _ := namespaceService.CreateInvitation(namespace, internal.RoleUser, 1, time.Hour, username)
+		ns, _ := namespaceService.Get(namespace)
+		ns.Invitations[0].Expiration = time.Now().Add(-time.Minute)
+		_ = storage.SetNamespace(*ns)
+
+		if _, err := namespaceService.CheckInvitation(namespace, token); err != internal.ErrInvalidToken {
+			t.Errorf("Expected ErrInvalidToken for expired invitation, got %v", err)
+		}
+	})
+
+	t.Run("concurrent sessions", func(t *testing.T) {
+		t.Cleanup(storage.Clear)
+		_, _ = namespaceService.Create(namespace)
+
+		var wg sync.WaitGroup
+		for range 20 {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				if _, err := namespaceService.AddSession(namespace, session, username); err != nil {
+					t.Errorf("Failed to add session: %v", err)
+				}
+			}()
+		}
+		wg.Wait()
+
+		ns, _ := namespaceService.Get(namespace)
+		if len(ns.Sessions) != 20 {
+			t.Errorf("Expected 20 sessions, got %d", len(ns.Sessions))
+		}
+	})
 }
@@ -0,0 +1,143 @@
+package services
+
+import (
+	"MediaMTXAuth/internal"
+	"MediaMTXAuth/internal/passwords"
+	"crypto/rand"
+	"encoding/base64"
+	"errors"
+	"slices"
+	"strings"
+	"time"
+)
+
+const MaxTokenDuration = 365 * 24 * time.Hour
+const MaxAPITokens = 20
+
+var (
+	ErrTooManyTokens  = errors.New("too many API tokens, revoke one first")
+	ErrEmptyTokenName = errors.New("token name cannot be empty")
+)
+
+// CreateAPIToken creates a personal API token and returns it together with the
+// secret, which is only stored hashed. The secret starts with the encoded
+// username so that AuthenticateToken does not have to search every user.
+func (s *userService) CreateAPIToken(username, name string, scope internal.TokenScope, ttl time.Duration) (*internal.APIToken, string, error) {
+	if strings.TrimSpace(name) == "" {
+		return nil, "", ErrEmptyTokenName
+	}
+
+	if !scope.IsValid() {
+		return nil, "", internal.ErrInvalidScope
+	}
+
+	if ttl <= 0 || ttl > MaxTokenDuration {
+		return nil, "", ErrInvalidExpiration
+	}
+
+	secret := base64.RawURLEncoding.EncodeToString([]byte(username)) + "." + rand.Text()
+	now := time.Now()
+
+	token := internal.APIToken{
+		ID:         rand.Text()[:10],
+		Name:       name,
+		TokenHash:  passwords.HashToken(secret),
+		Scope:      scope,
+		Created:    now,
+		Expiration: now.Add(ttl),
+	}
+
+	err := s.storage.UpdateUser(username, func(user *internal.User) error {
+		if !scope.AllowedFor(*user) {
+			return internal.ErrForbidden
+		}
+
+		user.APITokens = slices.DeleteFunc(user.APITokens, func(token internal.APIToken) bool {
+			return !token.IsActive()
+		})
+
+		if len(user.APITokens) >= MaxAPITokens {
+			return ErrTooManyTokens
+		}
+
+		user.APITokens = append(user.APITokens, token)
+		addHistory(user, "API token \""+name+"\" created", username)
+		return nil
+	})
+	if err != nil {
+		return nil, "", err
+	}
+
+	return &token, secret, nil
+}
+
+func (s *userService) RevokeAPIToken(username, id string) error {
+	return s.storage.UpdateUser(username, func(user *internal.User) error {
+		i := slices.IndexFunc(user.APITokens, func(token internal.APIToken) bool {
+			return token.ID == id
+		})
+		if i < 0 {
+			return internal.ErrTokenNotFound
+		}
+
+		addHistory(user, "API token \""+user.APITokens[i].Name+"\" revoked", username)
+		user.APITokens = slices.Delete(user.APITokens, i, i+1)
+		return nil
+	})
+}
+
+// AuthenticateToken returns the owner of an API token and the token itself.
+// Tokens of suspended or expired users do not work.
+func (s *userService) AuthenticateToken(secret string) (*internal.User, *internal.APIToken, error) {
+	encoded, _, ok := strings.Cut(secret, ".")
+	if !ok {
+		return nil, nil, internal.ErrInvalidToken
+	}
+
+	username, err := base64.RawURLEncoding.DecodeString(encoded)
+	if err != nil {
+		return nil, nil, internal.ErrInvalidToken
+	}
+
+	user, _ := s.storage.GetUser(string(username))
+	if user == nil {
+		return nil, nil, internal.ErrInvalidToken
+	}
+
+	i := slices.IndexFunc(user.APITokens, func(token internal.APIToken) bool {
+		return token.IsActive() && passwords.VerifyToken(secret, token.TokenHash)
+	})
+	if i < 0 {
+		return nil, nil, internal.ErrInvalidToken
+	}
+
+	if user.Suspension.IsActive() {
+		return nil, nil, internal.ErrUserSuspended
+	}
+
+	if user.IsExpired() {
+		return nil, nil, internal.ErrUserExpired
+	}
+
+	// Only record use once a minute to avoid a write on every request.
+	now := time.Now()
+	if now.Sub(user.APITokens[i].LastUsed) > time.Minute {
+		id := user.APITokens[i].ID
+		user.APITokens[i].LastUsed = now
+
+		err := s.storage.UpdateUser(user.Name, func(user *internal.User) error {
+			for i := range user.APITokens {
+				if user.APITokens[i].ID == id {
+					user.APITokens[i].LastUsed = now
+				}
+			}
+			return nil
+		})
+		if err != nil {
+			return nil, nil, err
+		}
+	}
+
+	token := user.APITokens[i]
+	return user, &token, nil
+}
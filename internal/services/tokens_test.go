@@ -0,0 +1,113 @@
+package services
+
+import (
+	"MediaMTXAuth/internal"
+	"MediaMTXAuth/internal/storage/memory"
+	"testing"
+	"time"
+)
+
+func TestAPITokens(t *testing.T) {
+	storage := &memory.Storage{}
+	if err := storage.Init(); err != nil {
+		t.Fatal(err)
+	}
+
+	userService := NewUserService(storage)
+
+	t.Run("create and authenticate", func(t *testing.T) {
+		t.Cleanup(storage.Clear)
+		_, _ = userService.Create(username, password, false, "")
+
+		token, secret, err := userService.CreateAPIToken(username, "ci", internal.ScopeRead, time.Hour)
+		if err != nil {
+			t.Fatalf("Failed to create token: %v", err)
+		}
+
+		user, _ := userService.Get(username)
+		if len(user.APITokens) != 1 || user.APITokens[0].TokenHash == secret {
+			t.Errorf("Expected one hashed token, got %+v", user.APITokens)
+		}
+
+		owner, used, err := userService.AuthenticateToken(secret)
+		if err != nil {
+			t.Fatalf("Failed to authenticate: %v", err)
+		}
+
+		if owner.Name != username || used.ID != token.ID || used.LastUsed.IsZero() {
+			t.Errorf("Unexpected token owner %s or token %+v", owner.Name, used)
+		}
+
+		if _, _, err := userService.AuthenticateToken(secret + "x"); err != internal.ErrInvalidToken {
+			t.Errorf("Expected ErrInvalidToken, got %v", err)
+		}
+
+		if _, _, err := userService.AuthenticateToken("garbage"); err != internal.ErrInvalidToken {
+			t.Errorf("Expected ErrInvalidToken, got %v", err)
+		}
+	})
+
+	t.Run("scope and expiry validation", func(t *testing.T) {
+		t.Cleanup(storage.Clear)
+		_, _ = userService.Create(username, password, false, "")
+
+		if _, _, err := userService.CreateAPIToken(username, "ci", "write", time.Hour); err != internal.ErrInvalidScope {
+			t.Errorf("Expected ErrInvalidScope, got %v", err)
+		}
+
+		if _, _, err := userService.CreateAPIToken(username, "ci", internal.ScopeAdmin, time.Hour); err != internal.ErrForbidden {
+			t.Errorf("Expected ErrForbidden, got %v", err)
+		}
+
+		if _, _, err := userService.CreateAPIToken(username, "ci", internal.ScopeRead, 0); err != ErrInvalidExpiration {
+			t.Errorf("Expected ErrInvalidExpiration, got %v", err)
+		}
+
+		if _, _, err := userService.CreateAPIToken(username, "ci", internal.ScopeRead, 2*MaxTokenDuration); err != ErrInvalidExpiration {
+			t.Errorf("Expected ErrInvalidExpiration, got %v", err)
+		}
+	})
+
+	t.Run("expired token", func(t *testing.T) {
+		t.Cleanup(storage.Clear)
+		_, _ = userService.Create(username, password, false, "")
+		_, secret, _ := userService.CreateAPIToken(username, "ci", internal.ScopeRead, time.Hour)
+
+		user, _ := storage.GetUser(username)
+		user.APITokens[0].Expiration = time.Now().Add(-time.Minute)
+		_ = storage.SetUser(*user)
+
+		if _, _, err := userService.AuthenticateToken(secret); err != internal.ErrInvalidToken {
+			t.Errorf("Expected ErrInvalidToken, got %v", err)
+		}
+	})
+
+	t.Run("suspended user", func(t *testing.T) {
+		t.Cleanup(storage.Clear)
+		_, _ = userService.Create(username, password, false, "")
+		_, secret, _ := userService.CreateAPIToken(username, "ci", internal.ScopeRead, time.Hour)
+		_ = userService.Suspend(username, "", "admin", time.Time{})
+
+		if _, _, err := userService.AuthenticateToken(secret); err != internal.ErrUserSuspended {
+			t.Errorf("Expected ErrUserSuspended, got %v", err)
+		}
+	})
+
+	t.Run("revoke", func(t *testing.T) {
+		t.Cleanup(storage.Clear)
+		_, _ = userService.Create(username, password, false, "")
+		token, secret, _ := userService.CreateAPIToken(username, "ci", internal.ScopeRead, time.Hour)
+
+		if err := userService.RevokeAPIToken(username, token.ID); err != nil {
+			t.Fatalf("Failed to revoke token: %v", err)
+		}
+
+		if _, _, err := userService.AuthenticateToken(secret); err != internal.ErrInvalidToken {
+			t.Errorf("Expected ErrInvalidToken, got %v", err)
+		}
+
+		if err := userService.RevokeAPIToken(username, token.ID); err != internal.ErrTokenNotFound {
+			t.Errorf("Expected ErrTokenNotFound, got %v", err)
+		}
+	})
+}
@@ -0,0 +1,75 @@
+package services
+
+import (
+	"MediaMTXAuth/internal"
+	"MediaMTXAuth/internal/passwords"
+	"crypto/rand"
+	"errors"
+	"time"
+)
+
+var ErrShortStreamKey = errors.New("stream key must be at least 16 characters long")
+
+// CreateFromHash creates a user with an existing password hash, such as one
+// exported from another installation. The hash must be in the format produced
+// by passwords.Hash.
+func (s *userService) CreateFromHash(username, hash string, isGenerated, isAdmin bool) (*internal.User, error) {
+	if err := ValidateUsername(username); err != nil {
+		return nil, err
+	}
+
+	if err := passwords.ValidateHash(hash); err != nil {
+		return nil, err
+	}
+
+	existingUser, err := s.storage.GetUser(username)
+	if err != nil {
+		return nil, err
+	}
+	if existingUser != nil {
+		return nil, internal.ErrUserAlreadyExists
+	}
+
+	user := internal.User{
+		Name:      username,
+		StreamKey: rand.Text(),
+		IsAdmin:   isAdmin,
+		Password:  internal.UserPassword{Hash: hash, IsGenerated: isGenerated},
+	}
+
+	if err := s.storage.SetUser(user); err != nil {
+		return nil, err
+	}
+	return &user, nil
+}
+
+// SetPasswordHash replaces the password with an existing hash. Like a password
+// reset, it ends all sessions.
+func (s *userService) SetPasswordHash(username, hash string, isGenerated bool) error {
+	if err := passwords.ValidateHash(hash); err != nil {
+		return err
+	}
+
+	return s.storage.UpdateUser(username, func(user *internal.User) error {
+		user.Password = internal.UserPassword{Hash: hash, IsGenerated: isGenerated}
+		user.Sessions = nil
+		addHistory(user, "password replaced by import", "")
+		return nil
+	})
+}
+
+// SetStreamKey replaces the stream key with key, without a grace period for
+// the old one.
+func (s *userService) SetStreamKey(username, key string) error {
+	if len(key) < 16 {
+		return ErrShortStreamKey
+	}
+
+	return s.storage.UpdateUser(username, func(user *internal.User) error {
+		user.StreamKey = key
+		user.PreviousStreamKey = ""
+		user.PreviousStreamKeyUntil = time.Time{}
+		addHistory(user, "stream key replaced by import", "")
+		return nil
+	})
+}
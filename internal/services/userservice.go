@@ -4,39 +4,52 @@ import (
 	"MediaMTXAuth/internal"
 	"MediaMTXAuth/internal/passwords"
 	"MediaMTXAuth/internal/storage"
+	"MediaMTXAuth/internal/throttle"
 	"crypto/rand"
 	"crypto/subtle"
 	"errors"
 	"fmt"
 	"math"
 	"math/big"
+	"slices"
+	"strings"
 	"time"
 )
 
 const DefaultAdminUsername = "admin"
-const DefaultAdminPassword = "admin"
+const SessionDuration = 15 * time.Minute
+const ImpersonationDuration = 10 * time.Minute
+const PasswordResetDuration = 24 * time.Hour
+const MaxHistory = 50
+const MaxSessions = 10
+const MaxStreamKeyGrace = 24 * time.Hour
 
 var (
 	ErrShortUsername = errors.New("username must be at least 3 characters long")
 	ErrShortPassword = errors.New("password must be at least 8 characters long")
+	ErrInvalidGrace  = errors.New("grace period must be between 0 and 24 hours")
+	ErrAdminExists   = errors.New("an admin account already exists")
 )
 
 type userService struct {
-	storage storage.Storage
+	storage     storage.Storage
+	loginPolicy throttle.Policy
 }
 
 func NewUserService(storage storage.Storage) internal.UserService {
-	return &userService{storage}
+	return &userService{storage, throttle.DefaultAccountPolicy}
 }
 
-func validateUsername(username string) error {
+// ValidateUsername checks that username has an allowed length.
+func ValidateUsername(username string) error {
 	if len(username) < 3 || len(username) > 32 {
 		return ErrShortUsername
 	}
 	return nil
 }
 
-func validatePassword(password string) error {
+// ValidatePassword checks that password is long enough.
+func ValidatePassword(password string) error {
 	if len(password) < 8 {
 		return ErrShortPassword
 	}
@@ -44,18 +57,32 @@ func validatePassword(password string) error {
 }
 
 func (s *userService) Create(username, password string, isAdmin bool, namespace string) (*internal.User, error) {
-	if err := validateUsername(username); err != nil {
+	if err := ValidateUsername(username); err != nil {
 		return nil, err
 	}
 
-	if err := validatePassword(password); err != nil {
+	if err := ValidatePassword(password); err != nil {
 		return nil, err
 	}
 
-	return s.create(username, password, isAdmin, namespace)
+	return s.create(username, password, isAdmin, namespace, true)
 }
 
-func (s *userService) create(username, password string, isAdmin bool, namespace string) (*internal.User, error) {
+// Register creates a user with a password they picked themselves and logs
+// them in.
+func (s *userService) Register(username, password string, isAdmin bool, namespace string) (*internal.User, error) {
+	if err := ValidateUsername(username); err != nil {
+		return nil, err
+	}
+
+	if err := ValidatePassword(password); err != nil {
+		return nil, err
+	}
+
+	return s.create(username, password, isAdmin, namespace, false)
+}
+
+func (s *userService) create(username, password string, isAdmin bool, namespace string, isGenerated bool) (*internal.User, error) {
 	existingUser, err := s.storage.GetUser(username)
 
 	if err != nil {
@@ -74,7 +101,7 @@ func (s *userService) create(username, password string, isAdmin bool, namespace
 
 	userPassword := internal.UserPassword{
 		Hash:        hash,
-		IsGenerated: true,
+		IsGenerated: isGenerated,
 	}
 
 	user := internal.User{
@@ -82,28 +109,56 @@ func (s *userService) create(username, password string, isAdmin bool, namespace
 		StreamKey: rand.Text(),
 		IsAdmin:   isAdmin,
 		Password:  userPassword,
-		Namespace: namespace,
+	}
+
+	if namespace != "" {
+		user.Memberships = []internal.Membership{{Namespace: namespace, Permission: internal.PermissionBoth}}
+	}
+
+	var session internal.UserSession
+	if !isGenerated {
+		session = startSession(&user)
 	}
 
 	err = s.storage.SetUser(user)
 	if err != nil {
 		return nil, err
 	}
+	user.Session = session
 	return &user, nil
 }
 
-func (s *userService) CreateDefaultAdminUser() (string, error) {
-	_, err := s.create(DefaultAdminUsername, DefaultAdminPassword, true, "")
+// CreateInitialAdmin creates the first admin account. An empty password is
+// replaced by a generated one, which is returned and has to be changed at the
+// first login. It fails with ErrAdminExists once any admin exists.
+func (s *userService) CreateInitialAdmin(username, password string) (string, error) {
+	hasAdmin, err := s.HasAdmin()
+	if err != nil {
+		return "", err
+	}
+	if hasAdmin {
+		return "", ErrAdminExists
+	}
 
-	if err == nil {
-		return DefaultAdminPassword, nil
+	if username == "" {
+		username = DefaultAdminUsername
 	}
 
-	if errors.Is(err, internal.ErrUserAlreadyExists) {
-		return "", nil
+	if password != "" {
+		_, err := s.Register(username, password, true, "")
+		return "", err
 	}
 
-	return "", err
+	generated := rand.Text()
+	if _, err := s.Create(username, generated, true, ""); err != nil {
+		return "", err
+	}
+
+	return generated, nil
+}
+
+func (s *userService) HasAdmin() (bool, error) {
+	return s.storage.HasAdmin()
 }
 
 func (s *userService) Get(username string) (*internal.User, error) {
@@ -115,32 +170,21 @@ func (s *userService) Delete(username string) error {
 }
 
 func (s *userService) ChangePassword(username, password string) error {
-	user, _ := s.storage.GetUser(username)
-
-	if user == nil {
-		return internal.ErrUserNotFound
-	}
-
 	hash, err := passwords.Hash(password)
 	if err != nil {
 		return err
 	}
 
-	user.Password = internal.UserPassword{
-		Hash:        hash,
-		IsGenerated: false,
-	}
-
-	return s.storage.SetUser(*user)
+	return s.storage.UpdateUser(username, func(user *internal.User) error {
+		user.Password = internal.UserPassword{
+			Hash:        hash,
+			IsGenerated: false,
+		}
+		return nil
+	})
 }
 
 func (s *userService) ResetPassword(username string) (string, error) {
-	user, _ := s.storage.GetUser(username)
-
-	if user == nil {
-		return "", internal.ErrUserNotFound
-	}
-
 	generated := rand.Text()
 
 	hash, err := passwords.Hash(generated)
@@ -148,12 +192,13 @@ func (s *userService) ResetPassword(username string) (string, error) {
 		return "", err
 	}
 
-	user.Password = internal.UserPassword{
-		Hash:        hash,
-		IsGenerated: true,
-	}
-
-	err = s.storage.SetUser(*user)
+	err = s.storage.UpdateUser(username, func(user *internal.User) error {
+		user.Password = internal.UserPassword{
+			Hash:        hash,
+			IsGenerated: true,
+		}
+		return nil
+	})
 	if err != nil {
 		return "", err
 	}
@@ -161,22 +206,108 @@ func (s *userService) ResetPassword(username string) (string, error) {
 }
 
 func (s *userService) ResetStreamKey(username string) (string, error) {
-	user, _ := s.storage.GetUser(username)
+	return s.RotateStreamKey(username, "", 0)
+}
 
-	if user == nil {
-		return "", internal.ErrUserNotFound
+// RotateStreamKey replaces the stream key. The old key keeps working for
+// grace, which may be zero. The change is recorded in the user's history.
+func (s *userService) RotateStreamKey(username, by string, grace time.Duration) (string, error) {
+	if grace < 0 || grace > MaxStreamKeyGrace {
+		return "", ErrInvalidGrace
 	}
 
 	generated := rand.Text()
-	user.StreamKey = generated
 
-	err := s.storage.SetUser(*user)
+	err := s.storage.UpdateUser(username, func(user *internal.User) error {
+		user.PreviousStreamKey = ""
+		user.PreviousStreamKeyUntil = time.Time{}
+		if grace > 0 {
+			user.PreviousStreamKey = user.StreamKey
+			user.PreviousStreamKeyUntil = time.Now().Add(grace)
+		}
+		user.StreamKey = generated
+
+		action := "stream key rotated"
+		if grace > 0 {
+			action += ", old key valid until " + user.PreviousStreamKeyUntil.Format("2006-01-02 15:04")
+		}
+		addHistory(user, action, by)
+		return nil
+	})
 	if err != nil {
 		return "", err
 	}
 	return generated, nil
 }
 
+// addHistory appends an entry to the user's history, dropping the oldest
+// entries beyond MaxHistory.
+func addHistory(user *internal.User, action, by string) {
+	user.History = append(user.History, internal.HistoryEntry{
+		Time:   time.Now(),
+		Action: action,
+		By:     by,
+	})
+
+	if len(user.History) > MaxHistory {
+		user.History = user.History[len(user.History)-MaxHistory:]
+	}
+}
+
+func (s *userService) SetAdmin(username string, isAdmin bool) error {
+	return s.storage.UpdateUser(username, func(user *internal.User) error {
+		user.IsAdmin = isAdmin
+		return nil
+	})
+}
+
+// Rename changes the username. The stream key, password and login session
+// are kept and guest sessions created by the user are moved to the new name.
+// Pending password reset links name the old account and are cancelled, as are
+// API tokens.
+func (s *userService) Rename(username, newName string) (*internal.User, error) {
+	if err := ValidateUsername(newName); err != nil {
+		return nil, err
+	}
+
+	var renamed internal.User
+	err := s.storage.UpdateUser(username, func(user *internal.User) error {
+		user.Name = newName
+		user.PasswordReset = internal.PasswordReset{}
+		user.APITokens = nil
+		renamed = *user
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	namespaces, err := s.storage.GetAllNamespaces()
+	if err != nil {
+		return nil, err
+	}
+
+	for _, ns := range namespaces {
+		if !slices.ContainsFunc(ns.Sessions, func(session internal.NamespaceSession) bool { return session.User == username }) {
+			continue
+		}
+
+		err := s.storage.UpdateNamespace(ns.Name, func(ns *internal.Namespace) error {
+			for i := range ns.Sessions {
+				if ns.Sessions[i].User == username {
+					ns.Sessions[i].User = newName
+				}
+			}
+			return nil
+		})
+		if err != nil && !errors.Is(err, internal.ErrNamespaceNotFound) {
+			return nil, err
+		}
+	}
+
+	return &renamed, nil
+}
+
 func (s *userService) Login(username, password string) (*internal.User, error) {
 	user, _ := s.storage.GetUser(username)
 
@@ -184,6 +315,31 @@ func (s *userService) Login(username, password string) (*internal.User, error) {
 		return nil, internal.ErrUserNotFound
 	}
 
+	if user.Suspension.IsActive() {
+		return nil, internal.ErrUserSuspended
+	}
+
+	if user.IsExpired() {
+		return nil, internal.ErrUserExpired
+	}
+
+	// The attempt is recorded as failed before the password is verified,
+	// so that concurrent attempts cannot all get past the policy. A
+	// successful login clears it below.
+	now := time.Now()
+	err := s.storage.UpdateUser(username, func(user *internal.User) error {
+		attempts, err := s.loginPolicy.Attempt(user.LoginAttempts, now)
+		if err != nil {
+			return err
+		}
+		user.LoginAttempts = attempts
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	// Verifying is slow, so it happens outside of the update.
 	storedHash := user.Password.Hash
 	p, err := passwords.Verify(password, storedHash)
 	if err != nil {
@@ -194,56 +350,406 @@ func (s *userService) Login(username, password string) (*internal.User, error) {
 		return nil, internal.ErrWrongPassword
 	}
 
+	var session internal.UserSession
+	err = s.storage.UpdateUser(username, func(u *internal.User) error {
+		// The password may have been changed or the user suspended since
+		// it was loaded.
+		if u.Password.Hash != storedHash {
+			return internal.ErrWrongPassword
+		}
+
+		if u.Suspension.IsActive() {
+			return internal.ErrUserSuspended
+		}
+
+		u.LoginAttempts = internal.LoginAttempts{}
+		session = startSession(u)
+		user = u
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	user.Session = session
+
+	return user, nil
+}
+
+func newSession() internal.UserSession {
 	randomID, _ := rand.Int(rand.Reader, big.NewInt(math.MaxInt64))
-	user.Session = internal.UserSession{
+	now := time.Now()
+	return internal.UserSession{
 		ID:         uint64(randomID.Int64()),
-		Expiration: time.Now().Add(time.Minute * 15),
+		Created:    now,
+		Expiration: now.Add(SessionDuration),
+	}
+}
+
+// startSession adds a new session to the user, dropping expired ones and the
+// oldest beyond MaxSessions.
+func startSession(user *internal.User) internal.UserSession {
+	session := newSession()
+	addSession(user, session)
+	return session
+}
+
+func addSession(user *internal.User, session internal.UserSession) {
+	now := time.Now()
+	user.Sessions = slices.DeleteFunc(user.Sessions, func(session internal.UserSession) bool {
+		return session.Expiration.Before(now)
+	})
+
+	user.Sessions = append(user.Sessions, session)
+
+	if len(user.Sessions) > MaxSessions {
+		user.Sessions = user.Sessions[len(user.Sessions)-MaxSessions:]
 	}
+}
 
-	err = s.storage.SetUser(*user)
+// Impersonate starts a short session that lets the admin called by see the
+// account as the user does. It is recorded in the user's history.
+func (s *userService) Impersonate(username, by string) (*internal.User, error) {
+	session := newSession()
+	session.ImpersonatedBy = by
+	session.Expiration = session.Created.Add(ImpersonationDuration)
+
+	var impersonated *internal.User
+	err := s.storage.UpdateUser(username, func(user *internal.User) error {
+		if user.Suspension.IsActive() {
+			return internal.ErrUserSuspended
+		}
+
+		if user.IsExpired() {
+			return internal.ErrUserExpired
+		}
+
+		addSession(user, session)
+		addHistory(user, "viewed as user", by)
+		impersonated = user
+		return nil
+	})
 	if err != nil {
 		return nil, err
 	}
+	impersonated.Session = session
 
-	return user, nil
+	return impersonated, nil
 }
 
-func (s *userService) Logout(username string) (*internal.User, error) {
+// Logout ends a single session of the user.
+func (s *userService) Logout(username, sessionID string) error {
+	return s.storage.UpdateUser(username, func(user *internal.User) error {
+		i := slices.IndexFunc(user.Sessions, func(session internal.UserSession) bool {
+			return matchesSession(session, sessionID)
+		})
+		if i < 0 {
+			return internal.ErrSessionNotFound
+		}
+
+		user.Sessions = slices.Delete(user.Sessions, i, i+1)
+		return nil
+	})
+}
+
+// LogoutAll ends every session of the user.
+func (s *userService) LogoutAll(username, by string) error {
+	return s.storage.UpdateUser(username, func(user *internal.User) error {
+		user.Sessions = nil
+		addHistory(user, "logged out of all sessions", by)
+		return nil
+	})
+}
+
+func matchesSession(session internal.UserSession, sessionID string) bool {
+	idStr := fmt.Sprintf("%d", session.ID)
+	return subtle.ConstantTimeCompare([]byte(idStr), []byte(sessionID)) == 1
+}
+
+func (s *userService) VerifySession(username, sessionkey string) (bool, error) {
 	user, _ := s.storage.GetUser(username)
 
 	if user == nil {
-		return nil, internal.ErrUserNotFound
+		return false, internal.ErrUserNotFound
+	}
+
+	if user.Suspension.IsActive() || user.IsExpired() {
+		return false, nil
+	}
+
+	now := time.Now()
+	for _, session := range user.Sessions {
+		if session.ID != 0 && session.Expiration.After(now) && matchesSession(session, sessionkey) {
+			return true, nil
+		}
 	}
+	return false, nil
+}
 
-	user.Session = internal.UserSession{}
+func (s *userService) Unlock(username string) error {
+	return s.storage.UpdateUser(username, func(user *internal.User) error {
+		user.LoginAttempts = internal.LoginAttempts{}
+		return nil
+	})
+}
 
-	err := s.storage.SetUser(*user)
+func (s *userService) SetManagedNamespaces(username string, namespaces []string) error {
+	for _, namespace := range namespaces {
+		ns, err := s.storage.GetNamespace(namespace)
+		if err != nil {
+			return err
+		}
+		if ns == nil {
+			return internal.ErrNamespaceNotFound
+		}
+	}
+
+	return s.storage.UpdateUser(username, func(user *internal.User) error {
+		user.Manages = namespaces
+		return nil
+	})
+}
+
+// SetFromConfig marks whether the user is declared in the config file.
+func (s *userService) SetFromConfig(username string, fromConfig bool) error {
+	return s.storage.UpdateUser(username, func(user *internal.User) error {
+		user.FromConfig = fromConfig
+		return nil
+	})
+}
 
+// Suspend disables the user and ends their login session. A zero until keeps
+// the user suspended until Reactivate is called.
+func (s *userService) Suspend(username, reason, by string, until time.Time) error {
+	return s.storage.UpdateUser(username, func(user *internal.User) error {
+		user.Suspension = internal.UserSuspension{
+			Reason: reason,
+			By:     by,
+			Since:  time.Now(),
+			Until:  until,
+		}
+		user.Sessions = nil
+		return nil
+	})
+}
+
+func (s *userService) Reactivate(username string) error {
+	return s.storage.UpdateUser(username, func(user *internal.User) error {
+		user.Suspension = internal.UserSuspension{}
+		return nil
+	})
+}
+
+// SetExpiry sets when the account stops working. A zero time removes the expiry.
+func (s *userService) SetExpiry(username string, expiresAt time.Time) error {
+	return s.storage.UpdateUser(username, func(user *internal.User) error {
+		user.ExpiresAt = expiresAt
+		return nil
+	})
+}
+
+// DeleteExpired removes accounts that expired more than grace ago and returns
+// their names.
+func (s *userService) DeleteExpired(grace time.Duration) ([]string, error) {
+	users, err := s.storage.GetAllUsers()
 	if err != nil {
 		return nil, err
 	}
 
-	return user, nil
+	cutoff := time.Now().Add(-grace)
+	var deleted []string
+
+	expired := func(user *internal.User) bool {
+		return user != nil && !user.ExpiresAt.IsZero() && !user.ExpiresAt.After(cutoff)
+	}
+
+	for _, user := range users {
+		if !expired(&user) {
+			continue
+		}
+
+		// The account may have been extended since the list was read.
+		current, err := s.storage.GetUser(user.Name)
+		if err != nil {
+			return deleted, err
+		}
+		if !expired(current) {
+			continue
+		}
+
+		if err := s.storage.DeleteUser(user.Name); err != nil {
+			return deleted, err
+		}
+
+		deleted = append(deleted, user.Name)
+	}
+
+	return deleted, nil
 }
 
-func (s *userService) VerifySession(username, sessionkey string) (bool, error) {
+func (s *userService) SetMembership(username, namespace string, permission internal.Permission) error {
+	if !permission.IsValid() {
+		return internal.ErrInvalidPermission
+	}
+
+	ns, err := s.storage.GetNamespace(namespace)
+	if err != nil {
+		return err
+	}
+	if ns == nil {
+		if user, _ := s.storage.GetUser(username); user == nil {
+			return internal.ErrUserNotFound
+		}
+		return internal.ErrNamespaceNotFound
+	}
+
+	return s.storage.UpdateUser(username, func(user *internal.User) error {
+		membership := internal.Membership{Namespace: namespace, Permission: permission}
+		i := slices.IndexFunc(user.Memberships, func(m internal.Membership) bool { return m.Namespace == namespace })
+
+		if i < 0 {
+			user.Memberships = append(user.Memberships, membership)
+		} else {
+			user.Memberships[i] = membership
+		}
+		return nil
+	})
+}
+
+func (s *userService) RemoveMembership(username, namespace string) error {
+	return s.storage.UpdateUser(username, func(user *internal.User) error {
+		i := slices.IndexFunc(user.Memberships, func(m internal.Membership) bool { return m.Namespace == namespace })
+		if i < 0 {
+			return internal.ErrMembershipNotFound
+		}
+
+		user.Memberships = slices.Delete(user.Memberships, i, i+1)
+		return nil
+	})
+}
+
+func (s *userService) CreatePasswordReset(username, createdBy string) (string, error) {
+	token := rand.Text()
+	now := time.Now()
+
+	err := s.storage.UpdateUser(username, func(user *internal.User) error {
+		user.PasswordReset = internal.PasswordReset{
+			TokenHash:  passwords.HashToken(token),
+			CreatedBy:  createdBy,
+			Created:    now,
+			Expiration: now.Add(PasswordResetDuration),
+		}
+		return nil
+	})
+	if err != nil {
+		return "", err
+	}
+
+	return token, nil
+}
+
+func (s *userService) CancelPasswordReset(username string) error {
+	return s.storage.UpdateUser(username, func(user *internal.User) error {
+		user.PasswordReset = internal.PasswordReset{}
+		return nil
+	})
+}
+
+func (s *userService) CheckPasswordReset(username, token string) error {
+	_, err := s.getByResetToken(username, token)
+	return err
+}
+
+func (s *userService) CompletePasswordReset(username, token, password string) (*internal.User, error) {
+	if _, err := s.getByResetToken(username, token); err != nil {
+		return nil, err
+	}
+
+	if err := ValidatePassword(password); err != nil {
+		return nil, err
+	}
+
+	hash, err := passwords.Hash(password)
+	if err != nil {
+		return nil, err
+	}
+
+	var session internal.UserSession
+	var reset *internal.User
+	err = s.storage.UpdateUser(username, func(user *internal.User) error {
+		// The token may have been used or cancelled in the meantime.
+		if !user.PasswordReset.IsPending() || !passwords.VerifyToken(token, user.PasswordReset.TokenHash) {
+			return internal.ErrInvalidToken
+		}
+
+		user.Password = internal.UserPassword{
+			Hash:        hash,
+			IsGenerated: false,
+		}
+		user.PasswordReset = internal.PasswordReset{}
+		user.LoginAttempts = internal.LoginAttempts{}
+		// Whoever knew the old password may still be logged in elsewhere.
+		user.Sessions = nil
+		session = startSession(user)
+		reset = user
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	reset.Session = session
+
+	return reset, nil
+}
+
+func (s *userService) getByResetToken(username, token string) (*internal.User, error) {
 	user, _ := s.storage.GetUser(username)
 
-	if user == nil {
-		return false, internal.ErrUserNotFound
+	if user == nil || !user.PasswordReset.IsPending() || !passwords.VerifyToken(token, user.PasswordReset.TokenHash) {
+		return nil, internal.ErrInvalidToken
 	}
 
-	if user.Session.ID == 0 || user.Session.Expiration.Before(time.Now()) {
-		return false, nil
+	if user.Suspension.IsActive() {
+		return nil, internal.ErrUserSuspended
 	}
 
-	idStr := fmt.Sprintf("%d", user.Session.ID)
-	if subtle.ConstantTimeCompare([]byte(idStr), []byte(sessionkey)) == 1 {
-		return true, nil
+	if user.IsExpired() {
+		return nil, internal.ErrUserExpired
 	}
-	return false, nil
+
+	return user, nil
 }
 
 func (s *userService) GetAllUsers() ([]internal.User, error) {
 	return s.storage.GetAllUsers()
 }
+
+// GetVisibleTo returns the users actor can see, sorted by name. Only the
+// namespaces a manager manages are looked at, so the cost does not grow with
+// the users elsewhere.
+func (s *userService) GetVisibleTo(actor internal.User) ([]internal.User, error) {
+	if actor.IsAdmin {
+		return s.storage.GetAllUsers()
+	}
+
+	seen := make(map[string]bool)
+	var users []internal.User
+
+	for _, namespace := range actor.Manages {
+		members, err := s.storage.GetUsersByNamespace(namespace)
+		if err != nil {
+			return nil, err
+		}
+
+		for _, user := range members {
+			if !seen[user.Name] && actor.CanSeeUser(user) {
+				seen[user.Name] = true
+				users = append(users, user)
+			}
+		}
+	}
+
+	slices.SortFunc(users, func(a, b internal.User) int {
+		return strings.Compare(a.Name, b.Name)
+	})
+	return users, nil
+}
@@ -4,6 +4,7 @@ import (
 	"MediaMTXAuth/internal"
 	"MediaMTXAuth/internal/storage/memory"
 	"fmt"
+	"sync"
 	"testing"
 	"time"
 
@@ -62,6 +63,22 @@ func TestUserService(t *testing.T) {
 		})
 	})
 
+	t.Run("register user", func(t *testing.T) {
+		t.Cleanup(storage.Clear)
+		user, err := userService.Register(username, password, false, "")
+		if err != nil {
+			t.Fatalf("Failed to register user: %v", err)
+		}
+
+		if user.Password.IsGenerated {
+			t.Errorf("Registered password should not be marked as generated")
+		}
+
+		if user.Session.ID == 0 {
+			t.Errorf("Registered user should be logged in")
+		}
+	})
+
 	t.Run("get all users", func(t *testing.T) {
 		t.Cleanup(storage.Clear)
 		user, err := userService.Create(username, password, true, "")
@@ -107,6 +124,109 @@ func TestUserService(t *testing.T) {
 		}
 	})
 
+	t.Run("visible users", func(t *testing.T) {
+		t.Cleanup(storage.Clear)
+		for _, name := range []string{"ns", "other"} {
+			_ = storage.SetNamespace(internal.Namespace{Name: name})
+		}
+
+		admin, _ := userService.Create("admin", password, true, "")
+		_, _ = userService.Create("member2", password, false, "ns")
+		_, _ = userService.Create("member1", password, false, "ns")
+		_, _ = userService.Create("outsider", password, false, "other")
+		manager, _ := userService.Create("manager", password, false, "")
+		_ = userService.SetManagedNamespaces("manager", []string{"ns"})
+		manager, _ = userService.Get(manager.Name)
+
+		names := func(users []internal.User) []string {
+			var names []string
+			for _, user := range users {
+				names = append(names, user.Name)
+			}
+			return names
+		}
+
+		users, err := userService.GetVisibleTo(*manager)
+		if err != nil {
+			t.Fatalf("Failed to get visible users: %v", err)
+		}
+		if diff := cmp.Diff([]string{"member1", "member2"}, names(users)); diff != "" {
+			t.Errorf("Unexpected users for manager (-want +got):\n%s", diff)
+		}
+
+		users, _ = userService.GetVisibleTo(*admin)
+		if len(users) != 5 {
+			t.Errorf("Expected admin to see all 5 users, got %v", names(users))
+		}
+	})
+
+	t.Run("list", func(t *testing.T) {
+		t.Cleanup(storage.Clear)
+		_ = storage.SetNamespace(internal.Namespace{Name: "ns"})
+
+		admin, _ := userService.Create("admin", password, true, "")
+		for i := range 5 {
+			name := fmt.Sprintf("user%d", i)
+			_, _ = userService.Create(name, password, false, "ns")
+			_ = userService.SetExpiry(name, time.Now().Add(time.Duration(5-i)*time.Hour))
+		}
+		_, _ = userService.Create("viewer", password, false, "ns")
+		manager, _ := userService.Create("manager", password, false, "")
+		_ = userService.SetManagedNamespaces("manager", []string{"ns"})
+		manager, _ = userService.Get(manager.Name)
+
+		// collect follows the cursors through all pages.
+		collect := func(t *testing.T, actor internal.User, opts internal.ListOptions) [][]string {
+			var pages [][]string
+			for {
+				page, err := userService.List(actor, opts)
+				if err != nil {
+					t.Fatalf("Failed to list users: %v", err)
+				}
+
+				var names []string
+				for _, user := range page.Items {
+					names = append(names, user.Name)
+				}
+				pages = append(pages, names)
+
+				if page.Next == "" {
+					return pages
+				}
+				opts.Cursor = page.Next
+			}
+		}
+
+		tests := map[string]struct {
+			actor internal.User
+			opts  internal.ListOptions
+			want  [][]string
+		}{
+			"admin by name": {*admin, internal.ListOptions{Limit: 3}, [][]string{{"admin", "manager", "user0"}, {"user1", "user2", "user3"}, {"user4", "viewer"}}},
+			"admin desc":    {*admin, internal.ListOptions{Limit: 4, Desc: true}, [][]string{{"viewer", "user4", "user3", "user2"}, {"user1", "user0", "manager", "admin"}}},
+			"search":        {*admin, internal.ListOptions{Search: "ER"}, [][]string{{"manager", "user0", "user1", "user2", "user3", "user4", "viewer"}}},
+			"prefix":        {*admin, internal.ListOptions{Search: "user", Prefix: true, Limit: 4}, [][]string{{"user0", "user1", "user2", "user3"}, {"user4"}}},
+			"by expiry":     {*admin, internal.ListOptions{Sort: internal.SortByExpires, Limit: 4}, [][]string{{"user4", "user3", "user2", "user1"}, {"user0", "admin", "manager", "viewer"}}},
+			"manager":       {*manager, internal.ListOptions{Limit: 4}, [][]string{{"user0", "user1", "user2", "user3"}, {"user4", "viewer"}}},
+			"manager desc":  {*manager, internal.ListOptions{Search: "user", Desc: true, Limit: 3}, [][]string{{"user4", "user3", "user2"}, {"user1", "user0"}}},
+		}
+
+		for name, test := range tests {
+			t.Run(name, func(t *testing.T) {
+				if diff := cmp.Diff(test.want, collect(t, test.actor, test.opts)); diff != "" {
+					t.Errorf("Unexpected pages (-want +got):\n%s", diff)
+				}
+			})
+		}
+
+		if _, err := userService.List(*admin, internal.ListOptions{Sort: "password"}); err != internal.ErrInvalidSort {
+			t.Errorf("Expected ErrInvalidSort, got %v", err)
+		}
+		if _, err := userService.List(*admin, internal.ListOptions{Cursor: "!"}); err != internal.ErrInvalidCursor {
+			t.Errorf("Expected ErrInvalidCursor, got %v", err)
+		}
+	})
+
 	t.Run("get user", func(t *testing.T) {
 		t.Cleanup(storage.Clear)
 		createdUser, err := userService.Create(username, password, true, "")
@@ -270,33 +390,546 @@ func TestUserService(t *testing.T) {
 		})
 
 		t.Run("logout", func(t *testing.T) {
-
-			_, err := userService.Login(username, password)
-
+			first, err := userService.Login(username, password)
 			if err != nil {
 				t.Errorf("Failed to login: %v", err)
 				return
 			}
 
-			_, err = userService.Logout(username)
+			second, err := userService.Login(username, password)
+			if err != nil {
+				t.Errorf("Failed to login: %v", err)
+				return
+			}
 
+			err = userService.Logout(username, fmt.Sprintf("%d", first.Session.ID))
 			if err != nil {
 				t.Errorf("Failed to logout: %v", err)
 				return
 			}
 
-			user, err := userService.Get(username)
+			if valid, _ := userService.VerifySession(username, fmt.Sprintf("%d", first.Session.ID)); valid {
+				t.Errorf("Logged out session should not be valid")
+			}
+
+			if valid, _ := userService.VerifySession(username, fmt.Sprintf("%d", second.Session.ID)); !valid {
+				t.Errorf("Other sessions should stay valid")
+			}
+
+			if err := userService.Logout(username, fmt.Sprintf("%d", first.Session.ID)); err != internal.ErrSessionNotFound {
+				t.Errorf("Expected ErrSessionNotFound, got %v", err)
+			}
+		})
+
+		t.Run("logout all", func(t *testing.T) {
+			_, _ = userService.Login(username, password)
+			_, _ = userService.Login(username, password)
+
+			if err := userService.LogoutAll(username, "admin"); err != nil {
+				t.Fatalf("Failed to logout: %v", err)
+			}
+
+			user, _ := userService.Get(username)
+			if len(user.Sessions) != 0 {
+				t.Errorf("Expected all sessions to be removed, got %d", len(user.Sessions))
+			}
+
+			if len(user.History) == 0 || user.History[len(user.History)-1].By != "admin" {
+				t.Errorf("Expected logout to be recorded, got %+v", user.History)
+			}
+		})
+	})
+
+	t.Run("impersonate", func(t *testing.T) {
+		t.Cleanup(storage.Clear)
+		_, _ = userService.Create(username, password, false, "")
+
+		user, err := userService.Impersonate(username, "admin")
+		if err != nil {
+			t.Fatalf("Failed to impersonate: %v", err)
+		}
+
+		if user.Session.ImpersonatedBy != "admin" || user.Session.Expiration.After(time.Now().Add(ImpersonationDuration)) {
+			t.Errorf("Unexpected impersonation session: %+v", user.Session)
+		}
+
+		if valid, _ := userService.VerifySession(username, fmt.Sprintf("%d", user.Session.ID)); !valid {
+			t.Errorf("Impersonation session should be valid")
+		}
+
+		_ = userService.Suspend(username, "", "admin", time.Time{})
+		if _, err := userService.Impersonate(username, "admin"); err != internal.ErrUserSuspended {
+			t.Errorf("Expected ErrUserSuspended, got %v", err)
+		}
+	})
+
+	t.Run("session limit", func(t *testing.T) {
+		t.Cleanup(storage.Clear)
+		_, _ = userService.Create(username, password, false, "")
+
+		first, _ := userService.Login(username, password)
+		for range MaxSessions {
+			_, _ = userService.Login(username, password)
+		}
+
+		user, _ := userService.Get(username)
+		if len(user.Sessions) != MaxSessions {
+			t.Errorf("Expected %d sessions, got %d", MaxSessions, len(user.Sessions))
+		}
+
+		if valid, _ := userService.VerifySession(username, fmt.Sprintf("%d", first.Session.ID)); valid {
+			t.Errorf("Oldest session should be dropped")
+		}
+	})
+
+	t.Run("login lockout", func(t *testing.T) {
+		t.Cleanup(storage.Clear)
+		_, _ = userService.Create(username, password, false, "")
+
+		for range 3 {
+			_, err := userService.Login(username, "wrongpassword")
+			if err != internal.ErrWrongPassword {
+				t.Fatalf("Expected ErrWrongPassword, got %v", err)
+			}
+		}
+
+		t.Run("throttled", func(t *testing.T) {
+			_, err := userService.Login(username, password)
+			if err != internal.ErrTooManyAttempts {
+				t.Errorf("Expected ErrTooManyAttempts, got %v", err)
+			}
+		})
+
+		t.Run("locked", func(t *testing.T) {
+			user, _ := userService.Get(username)
+			user.LoginAttempts.LockedUntil = time.Now().Add(time.Hour)
+			_ = storage.SetUser(*user)
+
+			_, err := userService.Login(username, password)
+			if err != internal.ErrAccountLocked {
+				t.Errorf("Expected ErrAccountLocked, got %v", err)
+			}
+		})
+
+		t.Run("concurrent", func(t *testing.T) {
+			if err := userService.Unlock(username); err != nil {
+				t.Fatalf("Failed to unlock: %v", err)
+			}
+
+			var wg sync.WaitGroup
+			var mu sync.Mutex
+			verified := 0
+			for range 10 {
+				wg.Go(func() {
+					if _, err := userService.Login(username, "wrongpassword"); err == internal.ErrWrongPassword {
+						mu.Lock()
+						verified++
+						mu.Unlock()
+					}
+				})
+			}
+			wg.Wait()
+
+			if verified != 3 {
+				t.Errorf("Expected only the 3 free attempts to be verified, got %d", verified)
+			}
+		})
+
+		t.Run("unlock", func(t *testing.T) {
+			if err := userService.Unlock(username); err != nil {
+				t.Fatalf("Failed to unlock: %v", err)
+			}
 
-			if user.Session.ID != 0 {
-				t.Errorf("Session ID should be cleared, got %d", user.Session.ID)
+			user, err := userService.Login(username, password)
+			if err != nil {
+				t.Fatalf("Failed to login after unlock: %v", err)
 			}
 
-			if !user.Session.Expiration.IsZero() {
-				t.Errorf("Session expiration should be zero")
+			if user.LoginAttempts.Failures != 0 {
+				t.Errorf("Expected failures to be reset, got %d", user.LoginAttempts.Failures)
 			}
 		})
 	})
 
+	t.Run("password reset link", func(t *testing.T) {
+		t.Cleanup(storage.Clear)
+		_, _ = userService.Create(username, password, false, "")
+
+		token, err := userService.CreatePasswordReset(username, "admin")
+		if err != nil {
+			t.Fatalf("Failed to create password reset: %v", err)
+		}
+
+		stored, _ := userService.Get(username)
+		if stored.PasswordReset.TokenHash == token {
+			t.Errorf("Token should be stored hashed")
+		}
+
+		if !stored.PasswordReset.IsPending() {
+			t.Errorf("Password reset should be pending")
+		}
+
+		t.Run("wrong token", func(t *testing.T) {
+			if err := userService.CheckPasswordReset(username, "wrong"); err != internal.ErrInvalidToken {
+				t.Errorf("Expected ErrInvalidToken, got %v", err)
+			}
+		})
+
+		t.Run("short password", func(t *testing.T) {
+			if _, err := userService.CompletePasswordReset(username, token, "short"); err != ErrShortPassword {
+				t.Errorf("Expected ErrShortPassword, got %v", err)
+			}
+		})
+
+		t.Run("complete", func(t *testing.T) {
+			user, err := userService.CompletePasswordReset(username, token, "newpassword")
+			if err != nil {
+				t.Fatalf("Failed to complete password reset: %v", err)
+			}
+
+			if user.Session.ID == 0 {
+				t.Errorf("User should be logged in after reset")
+			}
+
+			if _, err := userService.Login(username, "newpassword"); err != nil {
+				t.Errorf("Failed to login with new password: %v", err)
+			}
+		})
+
+		t.Run("single use", func(t *testing.T) {
+			if _, err := userService.CompletePasswordReset(username, token, "otherpassword"); err != internal.ErrInvalidToken {
+				t.Errorf("Expected ErrInvalidToken, got %v", err)
+			}
+		})
+	})
+
+	t.Run("expired password reset link", func(t *testing.T) {
+		t.Cleanup(storage.Clear)
+		_, _ = userService.Create(username, password, false, "")
+
+		token, _ := userService.CreatePasswordReset(username, "admin")
+		user, _ := userService.Get(username)
+		user.PasswordReset.Expiration = time.Now().Add(-time.Minute)
+		_ = storage.SetUser(*user)
+
+		if err := userService.CheckPasswordReset(username, token); err != internal.ErrInvalidToken {
+			t.Errorf("Expected ErrInvalidToken, got %v", err)
+		}
+	})
+
+	t.Run("cancel password reset link", func(t *testing.T) {
+		t.Cleanup(storage.Clear)
+		_, _ = userService.Create(username, password, false, "")
+
+		token, _ := userService.CreatePasswordReset(username, "admin")
+
+		if err := userService.CancelPasswordReset(username); err != nil {
+			t.Fatalf("Failed to cancel password reset: %v", err)
+		}
+
+		if err := userService.CheckPasswordReset(username, token); err != internal.ErrInvalidToken {
+			t.Errorf("Expected ErrInvalidToken, got %v", err)
+		}
+	})
+
+	t.Run("set managed namespaces", func(t *testing.T) {
+		t.Cleanup(storage.Clear)
+		_, _ = userService.Create(username, password, false, "")
+		_ = storage.SetNamespace(internal.Namespace{Name: "ns"})
+
+		if err := userService.SetManagedNamespaces(username, []string{"missing"}); err != internal.ErrNamespaceNotFound {
+			t.Errorf("Expected ErrNamespaceNotFound, got %v", err)
+		}
+
+		if err := userService.SetManagedNamespaces(username, []string{"ns"}); err != nil {
+			t.Fatalf("Failed to set managed namespaces: %v", err)
+		}
+
+		user, _ := userService.Get(username)
+		if !user.IsManager() || !user.CanManage("ns") || user.CanManage("other") {
+			t.Errorf("Unexpected manager permissions: %v", user.Manages)
+		}
+	})
+
+	t.Run("suspend and reactivate", func(t *testing.T) {
+		t.Cleanup(storage.Clear)
+		_, _ = userService.Create(username, password, false, "")
+		user, _ := userService.Login(username, password)
+		sessionKey := fmt.Sprintf("%d", user.Session.ID)
+
+		if err := userService.Suspend(username, "spam", "admin", time.Time{}); err != nil {
+			t.Fatalf("Failed to suspend: %v", err)
+		}
+
+		if valid, _ := userService.VerifySession(username, sessionKey); valid {
+			t.Errorf("Session should be ended by suspension")
+		}
+
+		if _, err := userService.Login(username, password); err != internal.ErrUserSuspended {
+			t.Errorf("Expected ErrUserSuspended, got %v", err)
+		}
+
+		suspended, _ := userService.Get(username)
+		if suspended.Suspension.Reason != "spam" || suspended.Suspension.By != "admin" {
+			t.Errorf("Unexpected suspension: %+v", suspended.Suspension)
+		}
+
+		if err := userService.Reactivate(username); err != nil {
+			t.Fatalf("Failed to reactivate: %v", err)
+		}
+
+		if _, err := userService.Login(username, password); err != nil {
+			t.Errorf("Failed to login after reactivation: %v", err)
+		}
+	})
+
+	t.Run("automatic reactivation", func(t *testing.T) {
+		t.Cleanup(storage.Clear)
+		_, _ = userService.Create(username, password, false, "")
+
+		_ = userService.Suspend(username, "", "admin", time.Now().Add(time.Hour))
+		if _, err := userService.Login(username, password); err != internal.ErrUserSuspended {
+			t.Errorf("Expected ErrUserSuspended, got %v", err)
+		}
+
+		user, _ := userService.Get(username)
+		user.Suspension.Until = time.Now().Add(-time.Minute)
+		_ = storage.SetUser(*user)
+
+		if _, err := userService.Login(username, password); err != nil {
+			t.Errorf("Expected suspension to have ended, got %v", err)
+		}
+	})
+
+	t.Run("expiry", func(t *testing.T) {
+		t.Cleanup(storage.Clear)
+		_, _ = userService.Create(username, password, false, "")
+		user, _ := userService.Login(username, password)
+		sessionKey := fmt.Sprintf("%d", user.Session.ID)
+
+		if err := userService.SetExpiry(username, time.Now().Add(-time.Minute)); err != nil {
+			t.Fatalf("Failed to set expiry: %v", err)
+		}
+
+		if valid, _ := userService.VerifySession(username, sessionKey); valid {
+			t.Errorf("Session of expired user should not be valid")
+		}
+
+		if _, err := userService.Login(username, password); err != internal.ErrUserExpired {
+			t.Errorf("Expected ErrUserExpired, got %v", err)
+		}
+
+		if err := userService.SetExpiry(username, time.Now().Add(time.Hour)); err != nil {
+			t.Fatalf("Failed to extend expiry: %v", err)
+		}
+
+		if _, err := userService.Login(username, password); err != nil {
+			t.Errorf("Failed to login after extending expiry: %v", err)
+		}
+
+		if err := userService.SetExpiry("nonexistent", time.Time{}); err != internal.ErrUserNotFound {
+			t.Errorf("Expected ErrUserNotFound, got %v", err)
+		}
+	})
+
+	t.Run("rename", func(t *testing.T) {
+		t.Cleanup(storage.Clear)
+		_ = storage.SetNamespace(internal.Namespace{Name: namespace})
+		created, _ := userService.Create(username, password, false, namespace)
+		_, _ = userService.Create("taken", password, false, "")
+		user, _ := userService.Login(username, password)
+		_ = storage.SetNamespace(internal.Namespace{
+			Name:     namespace,
+			Sessions: []internal.NamespaceSession{{Key: "key", Name: "guest", User: username}},
+		})
+
+		if _, err := userService.Rename(username, "taken"); err != internal.ErrUserAlreadyExists {
+			t.Errorf("Expected ErrUserAlreadyExists, got %v", err)
+		}
+
+		if _, err := userService.Rename(username, "x"); err != ErrShortUsername {
+			t.Errorf("Expected ErrShortUsername, got %v", err)
+		}
+
+		renamed, err := userService.Rename(username, "renamed")
+		if err != nil {
+			t.Fatalf("Failed to rename: %v", err)
+		}
+
+		if renamed.StreamKey != created.StreamKey || len(renamed.Sessions) != 1 || renamed.Sessions[0].ID != user.Session.ID {
+			t.Errorf("Expected stream key and session to be kept, got %+v", renamed)
+		}
+
+		if old, _ := userService.Get(username); old != nil {
+			t.Errorf("Old user should be removed")
+		}
+
+		ns, _ := storage.GetNamespace(namespace)
+		if ns.Sessions[0].User != "renamed" {
+			t.Errorf("Expected guest session to be moved, got %s", ns.Sessions[0].User)
+		}
+	})
+
+	t.Run("rotate stream key", func(t *testing.T) {
+		t.Cleanup(storage.Clear)
+		created, _ := userService.Create(username, password, false, "")
+
+		if _, err := userService.RotateStreamKey(username, username, 48*time.Hour); err != ErrInvalidGrace {
+			t.Errorf("Expected ErrInvalidGrace, got %v", err)
+		}
+
+		key, err := userService.RotateStreamKey(username, username, time.Hour)
+		if err != nil {
+			t.Fatalf("Failed to rotate stream key: %v", err)
+		}
+
+		user, _ := userService.Get(username)
+		if !user.HasStreamKey(key) || !user.HasStreamKey(created.StreamKey) {
+			t.Errorf("Expected old and new key to work during grace period")
+		}
+
+		user.PreviousStreamKeyUntil = time.Now().Add(-time.Minute)
+		_ = storage.SetUser(*user)
+		user, _ = userService.Get(username)
+		if user.HasStreamKey(created.StreamKey) {
+			t.Errorf("Old key should stop working after grace period")
+		}
+
+		if _, err := userService.RotateStreamKey(username, "admin", 0); err != nil {
+			t.Fatalf("Failed to rotate stream key: %v", err)
+		}
+
+		user, _ = userService.Get(username)
+		if user.HasStreamKey(key) {
+			t.Errorf("Rotation without grace should revoke the old key immediately")
+		}
+
+		if len(user.History) != 2 || user.History[1].By != "admin" {
+			t.Errorf("Expected rotations to be recorded, got %+v", user.History)
+		}
+	})
+
+	t.Run("history is capped", func(t *testing.T) {
+		t.Cleanup(storage.Clear)
+		_, _ = userService.Create(username, password, false, "")
+
+		for range MaxHistory + 5 {
+			_, _ = userService.ResetStreamKey(username)
+		}
+
+		if user, _ := userService.Get(username); len(user.History) != MaxHistory {
+			t.Errorf("Expected %d history entries, got %d", MaxHistory, len(user.History))
+		}
+	})
+
+	t.Run("set admin", func(t *testing.T) {
+		t.Cleanup(storage.Clear)
+		_, _ = userService.Create(username, password, false, "")
+
+		if err := userService.SetAdmin(username, true); err != nil {
+			t.Fatalf("Failed to set admin: %v", err)
+		}
+
+		if user, _ := userService.Get(username); !user.IsAdmin {
+			t.Errorf("Expected user to be admin")
+		}
+
+		if err := userService.SetAdmin("nonexistent", true); err != internal.ErrUserNotFound {
+			t.Errorf("Expected ErrUserNotFound, got %v", err)
+		}
+	})
+
+	t.Run("delete expired", func(t *testing.T) {
+		t.Cleanup(storage.Clear)
+		_, _ = userService.Create("permanent", password, false, "")
+		_, _ = userService.Create("recent", password, false, "")
+		_, _ = userService.Create("old", password, false, "")
+		_ = userService.SetExpiry("recent", time.Now().Add(-time.Hour))
+		_ = userService.SetExpiry("old", time.Now().Add(-48*time.Hour))
+
+		deleted, err := userService.DeleteExpired(24 * time.Hour)
+		if err != nil {
+			t.Fatalf("Failed to delete expired users: %v", err)
+		}
+
+		if !cmp.Equal(deleted, []string{"old"}) {
+			t.Errorf("Expected only old to be deleted, got %v", deleted)
+		}
+
+		if user, _ := userService.Get("recent"); user == nil {
+			t.Errorf("User within grace period should be kept")
+		}
+	})
+
+	t.Run("delete expired after extension", func(t *testing.T) {
+		t.Cleanup(storage.Clear)
+		_, _ = userService.Create("extended", password, false, "")
+		_ = userService.SetExpiry("extended", time.Now().Add(-48*time.Hour))
+
+		// The list still shows the old expiry when the account is extended
+		// before it is deleted.
+		stale := &staleStorage{Storage: storage}
+		stale.users, _ = storage.GetAllUsers()
+		_ = userService.SetExpiry("extended", time.Now().Add(time.Hour))
+
+		deleted, err := NewUserService(stale).DeleteExpired(24 * time.Hour)
+		if err != nil || len(deleted) != 0 {
+			t.Errorf("Expected nothing deleted, got %v, %v", deleted, err)
+		}
+
+		if user, _ := userService.Get("extended"); user == nil {
+			t.Errorf("Extended user should be kept")
+		}
+	})
+
+	t.Run("memberships", func(t *testing.T) {
+		t.Cleanup(storage.Clear)
+		_ = storage.SetNamespace(internal.Namespace{Name: "first"})
+		_ = storage.SetNamespace(internal.Namespace{Name: "second"})
+		_, _ = userService.Create(username, password, false, "first")
+
+		if err := userService.SetMembership(username, "second", "owner"); err != internal.ErrInvalidPermission {
+			t.Errorf("Expected ErrInvalidPermission, got %v", err)
+		}
+
+		if err := userService.SetMembership(username, "missing", internal.PermissionRead); err != internal.ErrNamespaceNotFound {
+			t.Errorf("Expected ErrNamespaceNotFound, got %v", err)
+		}
+
+		if err := userService.SetMembership(username, "second", internal.PermissionRead); err != nil {
+			t.Fatalf("Failed to add membership: %v", err)
+		}
+
+		if err := userService.SetMembership(username, "first", internal.PermissionPublish); err != nil {
+			t.Fatalf("Failed to update membership: %v", err)
+		}
+
+		user, _ := userService.Get(username)
+		expected := []internal.Membership{
+			{Namespace: "first", Permission: internal.PermissionPublish},
+			{Namespace: "second", Permission: internal.PermissionRead},
+		}
+		if !cmp.Equal(user.Memberships, expected) {
+			t.Errorf("Expected memberships %v, got %v", expected, user.Memberships)
+		}
+
+		if err := userService.RemoveMembership(username, "first"); err != nil {
+			t.Fatalf("Failed to remove membership: %v", err)
+		}
+
+		if err := userService.RemoveMembership(username, "first"); err != internal.ErrMembershipNotFound {
+			t.Errorf("Expected ErrMembershipNotFound, got %v", err)
+		}
+	})
+
+	t.Run("unlock non-existent user", func(t *testing.T) {
+		t.Cleanup(storage.Clear)
+		err := userService.Unlock("nouser")
+		if err != internal.ErrUserNotFound {
+			t.Errorf("Expected ErrUserNotFound, got %v", err)
+		}
+	})
+
 	t.Run("login in to non-existent user", func(t *testing.T) {
 		t.Cleanup(storage.Clear)
 		_, err := userService.Login("nouser", "password")
@@ -305,28 +938,51 @@ func TestUserService(t *testing.T) {
 		}
 	})
 
-	t.Run("create default admin user", func(t *testing.T) {
+	t.Run("create initial admin", func(t *testing.T) {
 		t.Cleanup(storage.Clear)
-		result, err := userService.CreateDefaultAdminUser()
+		generated, err := userService.CreateInitialAdmin("", "")
 		if err != nil {
 			t.Errorf("Failed to create: %v", err)
 			return
 		}
 
-		expectedResult := "admin"
-		if result != expectedResult {
-			t.Errorf("Expected result %s, got %s", expectedResult, result)
+		if generated == "" || generated == "admin" {
+			t.Errorf("Expected a random password, got %q", generated)
 		}
 
-		adminUser, err := userService.Get("admin")
+		adminUser, err := userService.Get(DefaultAdminUsername)
 
 		if adminUser == nil {
 			t.Errorf("Admin should exist")
 			return
 		}
 
-		if adminUser.IsAdmin != true {
-			t.Errorf("Expected admin, got %v", adminUser.IsAdmin)
+		if adminUser.IsAdmin != true || !adminUser.Password.IsGenerated {
+			t.Errorf("Expected admin with generated password, got %+v", adminUser)
+		}
+
+		if _, err := userService.CreateInitialAdmin("other", "password"); err != ErrAdminExists {
+			t.Errorf("Expected ErrAdminExists, got %v", err)
+		}
+	})
+
+	t.Run("create initial admin with password", func(t *testing.T) {
+		t.Cleanup(storage.Clear)
+		generated, err := userService.CreateInitialAdmin("root", "supersecret")
+		if err != nil {
+			t.Fatalf("Failed to create: %v", err)
+		}
+
+		if generated != "" {
+			t.Errorf("Expected no generated password, got %q", generated)
+		}
+
+		if _, err := userService.Login("root", "supersecret"); err != nil {
+			t.Errorf("Failed to login with configured password: %v", err)
+		}
+
+		if _, err := userService.CreateInitialAdmin("short", "short"); err != ErrAdminExists {
+			t.Errorf("Expected ErrAdminExists, got %v", err)
 		}
 	})
 
@@ -367,7 +1023,7 @@ func TestUserService(t *testing.T) {
 		})
 
 		t.Run("expired session", func(t *testing.T) {
-			user.Session.Expiration = time.Now().Add(-time.Hour)
+			user.Sessions[0].Expiration = time.Now().Add(-time.Hour)
 			_ = storage.SetUser(*user)
 			valid, err := userService.VerifySession(username, sessionKey)
 			if err != nil {
@@ -388,4 +1044,49 @@ func TestUserService(t *testing.T) {
 			}
 		})
 	})
+
+	t.Run("concurrent updates", func(t *testing.T) {
+		t.Cleanup(storage.Clear)
+		_, _ = userService.Create(username, password, false, "")
+
+		var wg sync.WaitGroup
+		for i := range 10 {
+			name := fmt.Sprintf("namespace%d", i)
+			_ = storage.SetNamespace(internal.Namespace{Name: name})
+
+			wg.Add(2)
+			go func() {
+				defer wg.Done()
+				if err := userService.SetMembership(username, name, internal.PermissionRead); err != nil {
+					t.Errorf("Failed to add membership: %v", err)
+				}
+			}()
+			go func() {
+				defer wg.Done()
+				if _, err := userService.RotateStreamKey(username, username, 0); err != nil {
+					t.Errorf("Failed to rotate stream key: %v", err)
+				}
+			}()
+		}
+		wg.Wait()
+
+		user, _ := userService.Get(username)
+		if len(user.Memberships) != 10 {
+			t.Errorf("Expected 10 memberships, got %v", user.Memberships)
+		}
+
+		if len(user.History) != 10 {
+			t.Errorf("Expected 10 history entries, got %d", len(user.History))
+		}
+	})
+}
+
+// staleStorage returns users from GetAllUsers as they were read earlier.
+type staleStorage struct {
+	*memory.Storage
+	users []internal.User
+}
+
+func (s *staleStorage) GetAllUsers() ([]internal.User, error) {
+	return s.users, nil
 }
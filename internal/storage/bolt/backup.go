@@ -0,0 +1,150 @@
+package bolt
+
+import (
+	"MediaMTXAuth/internal/storage"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"time"
+
+	bolt "go.etcd.io/bbolt"
+)
+
+// Backup writes a consistent snapshot of the database to w. It only holds a
+// read transaction, so the database stays usable meanwhile.
+func (s *boltStorage) Backup(w io.Writer) (n int64, err error) {
+	err = s.view(func(tx *bolt.Tx) error {
+		n, err = tx.WriteTo(w)
+		return err
+	})
+	return
+}
+
+// Restore replaces the database with the snapshot read from r. The snapshot
+// is checked and migrated to the current schema version before it is swapped
+// in, and the replaced database is kept next to it.
+func (s *boltStorage) Restore(r io.Reader) error {
+	return s.RestoreChecked(r, nil)
+}
+
+// RestoreChecked is like Restore, but also runs check on the migrated
+// snapshot before it is swapped in.
+func (s *boltStorage) RestoreChecked(r io.Reader, check func(storage.Storage) error) error {
+	s.mu.RLock()
+	path := s.DB.Path()
+	s.mu.RUnlock()
+
+	file, err := os.CreateTemp(filepath.Dir(path), filepath.Base(path)+".restore-*")
+	if err != nil {
+		return err
+	}
+	snapshot := file.Name()
+	defer os.Remove(snapshot)
+
+	_, err = io.Copy(file, r)
+	if closeErr := file.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return err
+	}
+
+	if err := prepareSnapshot(snapshot, check); err != nil {
+		return err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	previous := fmt.Sprintf("%s.pre-restore.%s.bak", path, time.Now().Format("20060102-150405"))
+
+	if err := s.DB.Close(); err != nil {
+		return err
+	}
+
+	if err := os.Rename(path, previous); err != nil {
+		return errors.Join(err, s.reopen(path))
+	}
+
+	if err := os.Rename(snapshot, path); err != nil {
+		return errors.Join(err, os.Rename(previous, path), s.reopen(path))
+	}
+
+	if err := s.reopen(path); err != nil {
+		return errors.Join(err, os.Rename(previous, path), s.reopen(path))
+	}
+
+	return nil
+}
+
+// reopen opens the database at path again after Restore closed it. The
+// caller must hold mu.
+func (s *boltStorage) reopen(path string) error {
+	db, err := bolt.Open(path, 0600, &bolt.Options{Timeout: 1 * time.Second})
+	if err != nil {
+		return err
+	}
+
+	s.DB = db
+	return nil
+}
+
+// prepareSnapshot checks the database at path for consistency and migrates it
+// to the current schema version. check, if not nil, runs on it last.
+func prepareSnapshot(path string, check func(storage.Storage) error) error {
+	db, err := bolt.Open(path, 0600, &bolt.Options{Timeout: 1 * time.Second})
+	if err != nil {
+		return fmt.Errorf("%w: %w", storage.ErrInvalidSnapshot, err)
+	}
+	defer db.Close()
+
+	snapshot := &boltStorage{DB: db}
+
+	version, err := snapshot.storedVersion()
+	if err != nil {
+		return fmt.Errorf("%w: %w", storage.ErrInvalidSnapshot, err)
+	}
+
+	err = db.Update(func(tx *bolt.Tx) error {
+		// An empty file opens as a new database, which must not replace
+		// the existing data.
+		if tx.Bucket(usersBucket) == nil {
+			return fmt.Errorf("%w: no users", storage.ErrInvalidSnapshot)
+		}
+
+		var errs []error
+		for err := range tx.Check() {
+			errs = append(errs, err)
+		}
+		if len(errs) > 0 {
+			return fmt.Errorf("%w: %w", storage.ErrInvalidSnapshot, errors.Join(errs...))
+		}
+
+		if err := migrate(tx, version); err != nil {
+			return fmt.Errorf("%w: %w", storage.ErrInvalidSnapshot, err)
+		}
+
+		for _, bucket := range [][]byte{usersBucket, namespacesBucket} {
+			err := tx.Bucket(bucket).ForEach(func(k, v []byte) error {
+				var r record
+				if err := json.Unmarshal(v, &r); err != nil {
+					return fmt.Errorf("%w: record %q: %w", storage.ErrInvalidSnapshot, k, err)
+				}
+				return nil
+			})
+			if err != nil {
+				return err
+			}
+		}
+
+		return nil
+	})
+	if err != nil || check == nil {
+		return err
+	}
+
+	return check(snapshot)
+}
@@ -0,0 +1,69 @@
+package bolt
+
+import (
+	"MediaMTXAuth/internal"
+	"MediaMTXAuth/internal/storage"
+	"bytes"
+	"errors"
+	"path"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestBackup(t *testing.T) {
+	s, err := New(path.Join(t.TempDir(), "test.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+
+	if err := s.Init(); err != nil {
+		t.Fatal(err)
+	}
+
+	db := s.(*boltStorage)
+	_ = s.SetUser(internal.User{Name: "before"})
+
+	var snapshot bytes.Buffer
+	if _, err := db.Backup(&snapshot); err != nil {
+		t.Fatalf("Failed to back up: %v", err)
+	}
+
+	_ = s.SetUser(internal.User{Name: "after"})
+
+	t.Run("invalid snapshot", func(t *testing.T) {
+		for name, data := range map[string]string{"garbage": "not a database", "empty": ""} {
+			if err := db.Restore(strings.NewReader(data)); !errors.Is(err, storage.ErrInvalidSnapshot) {
+				t.Errorf("Expected ErrInvalidSnapshot for %s snapshot, got %v", name, err)
+			}
+		}
+
+		if user, _ := s.GetUser("after"); user == nil {
+			t.Errorf("Invalid snapshot should not replace the data")
+		}
+	})
+
+	t.Run("restore", func(t *testing.T) {
+		if err := db.Restore(bytes.NewReader(snapshot.Bytes())); err != nil {
+			t.Fatalf("Failed to restore: %v", err)
+		}
+
+		if user, _ := s.GetUser("before"); user == nil {
+			t.Errorf("Expected user from the snapshot")
+		}
+
+		if user, _ := s.GetUser("after"); user != nil {
+			t.Errorf("User created after the snapshot should be gone")
+		}
+
+		if err := s.SetUser(internal.User{Name: "restored"}); err != nil {
+			t.Errorf("Restored database should be writable: %v", err)
+		}
+
+		matches, _ := filepath.Glob(db.DB.Path() + ".pre-restore.*.bak")
+		if len(matches) != 1 {
+			t.Errorf("Expected the replaced database to be kept, got %v", matches)
+		}
+	})
+}
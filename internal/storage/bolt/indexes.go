@@ -0,0 +1,209 @@
+package bolt
+
+import (
+	"MediaMTXAuth/internal"
+	"MediaMTXAuth/internal/storage"
+	"encoding/json"
+	"fmt"
+
+	bolt "go.etcd.io/bbolt"
+)
+
+// usersByNamespaceBucket holds a bucket per namespace with the names of the
+// users indexed under it as keys. usersByStreamKeyBucket maps stream keys to
+// user names, and adminsBucket has the names of the admins as keys. All are
+// written in the same transaction as the users.
+var usersByNamespaceBucket = []byte("users_by_namespace")
+var usersByStreamKeyBucket = []byte("users_by_stream_key")
+var adminsBucket = []byte("admins")
+
+func (s *boltStorage) GetUsersByNamespace(namespace string) ([]internal.User, error) {
+	users := []internal.User{}
+
+	err := s.view(func(tx *bolt.Tx) error {
+		names := tx.Bucket(usersByNamespaceBucket).Bucket([]byte(namespace))
+		if names == nil {
+			return nil
+		}
+
+		b := tx.Bucket(usersBucket)
+		return names.ForEach(func(k, v []byte) error {
+			var user internal.User
+			if err := json.Unmarshal(b.Get(k), &user); err != nil {
+				return fmt.Errorf("user %q: %w", k, err)
+			}
+			users = append(users, user)
+			return nil
+		})
+	})
+
+	return users, err
+}
+
+func (s *boltStorage) GetUserByStreamKey(key string) (u *internal.User, err error) {
+	err = s.view(func(tx *bolt.Tx) error {
+		name := tx.Bucket(usersByStreamKeyBucket).Get([]byte(key))
+		if name == nil {
+			return nil
+		}
+
+		var user internal.User
+		if err := json.Unmarshal(tx.Bucket(usersBucket).Get(name), &user); err != nil {
+			return err
+		}
+		u = &user
+		return nil
+	})
+
+	return
+}
+
+func (s *boltStorage) HasAdmin() (found bool, err error) {
+	err = s.view(func(tx *bolt.Tx) error {
+		k, _ := tx.Bucket(adminsBucket).Cursor().First()
+		found = k != nil
+		return nil
+	})
+	return
+}
+
+// storedUser returns the user called name in tx, or nil if there is none.
+func storedUser(tx *bolt.Tx, name string) (*internal.User, error) {
+	data := tx.Bucket(usersBucket).Get([]byte(name))
+	if data == nil {
+		return nil, nil
+	}
+
+	var user internal.User
+	if err := json.Unmarshal(data, &user); err != nil {
+		return nil, err
+	}
+	return &user, nil
+}
+
+// reindexUser moves a user from the index entries of old to those of new,
+// either of which is nil when the user is created or deleted. It fails if new
+// has a stream key of another user, which rolls back the transaction.
+func reindexUser(tx *bolt.Tx, old, new *internal.User) error {
+	byNamespace := tx.Bucket(usersByNamespaceBucket)
+	byStreamKey := tx.Bucket(usersByStreamKeyBucket)
+	admins := tx.Bucket(adminsBucket)
+
+	if new != nil {
+		for _, key := range storage.UserStreamKeys(*new) {
+			owner := string(byStreamKey.Get([]byte(key)))
+			if owner != "" && owner != new.Name && (old == nil || owner != old.Name) {
+				return internal.ErrStreamKeyTaken
+			}
+		}
+	}
+
+	if old != nil {
+		for _, namespace := range storage.UserNamespaces(*old) {
+			names := byNamespace.Bucket([]byte(namespace))
+			if names == nil {
+				continue
+			}
+			if err := names.Delete([]byte(old.Name)); err != nil {
+				return err
+			}
+			if k, _ := names.Cursor().First(); k == nil {
+				if err := byNamespace.DeleteBucket([]byte(namespace)); err != nil {
+					return err
+				}
+			}
+		}
+		for _, key := range storage.UserStreamKeys(*old) {
+			if err := byStreamKey.Delete([]byte(key)); err != nil {
+				return err
+			}
+		}
+		if err := admins.Delete([]byte(old.Name)); err != nil {
+			return err
+		}
+	}
+
+	if new != nil {
+		for _, namespace := range storage.UserNamespaces(*new) {
+			names, err := byNamespace.CreateBucketIfNotExists([]byte(namespace))
+			if err != nil {
+				return err
+			}
+			if err := names.Put([]byte(new.Name), []byte{}); err != nil {
+				return err
+			}
+		}
+		for _, key := range storage.UserStreamKeys(*new) {
+			if err := byStreamKey.Put([]byte(key), []byte(new.Name)); err != nil {
+				return err
+			}
+		}
+		if new.IsAdmin {
+			if err := admins.Put([]byte(new.Name), []byte{}); err != nil {
+				return err
+			}
+		}
+	}
+
+	return nil
+}
+
+// migrateIndexUsers fills the user indexes for databases written before they
+// existed. Two users sharing a stream key cannot be indexed and fail the
+// migration, so the conflict has to be resolved with an older build first.
+func migrateIndexUsers(tx *bolt.Tx) error {
+	type membership struct {
+		Namespace string
+	}
+
+	type user struct {
+		Name              string
+		StreamKey         string
+		PreviousStreamKey string
+		Memberships       []membership
+		Manages           []string
+	}
+
+	return tx.Bucket(usersBucket).ForEach(func(k, v []byte) error {
+		var u user
+		if err := json.Unmarshal(v, &u); err != nil {
+			return fmt.Errorf("record %q: %w", k, err)
+		}
+
+		indexed := internal.User{
+			Name:              string(k),
+			StreamKey:         u.StreamKey,
+			PreviousStreamKey: u.PreviousStreamKey,
+			Manages:           u.Manages,
+		}
+		for _, m := range u.Memberships {
+			indexed.Memberships = append(indexed.Memberships, internal.Membership{Namespace: m.Namespace})
+		}
+
+		if err := reindexUser(tx, nil, &indexed); err != nil {
+			return fmt.Errorf("record %q: %w", k, err)
+		}
+		return nil
+	})
+}
+
+// migrateIndexAdmins fills the admin index for databases written before it
+// existed.
+func migrateIndexAdmins(tx *bolt.Tx) error {
+	type user struct {
+		IsAdmin bool
+	}
+
+	admins := tx.Bucket(adminsBucket)
+	return tx.Bucket(usersBucket).ForEach(func(k, v []byte) error {
+		var u user
+		if err := json.Unmarshal(v, &u); err != nil {
+			return fmt.Errorf("record %q: %w", k, err)
+		}
+
+		if !u.IsAdmin {
+			return nil
+		}
+		return admins.Put(k, []byte{})
+	})
+}
@@ -0,0 +1,221 @@
+package bolt
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"slices"
+	"strconv"
+	"time"
+
+	bolt "go.etcd.io/bbolt"
+)
+
+var metaBucket = []byte("meta")
+var versionKey = []byte("version")
+
+var errDryRun = errors.New("dry run")
+
+// migration changes the stored records from one schema version to the next.
+// Migrations work on the raw JSON, so that they keep working when the types in
+// package internal change later.
+type migration struct {
+	description string
+	apply       func(tx *bolt.Tx) error
+}
+
+// migrations are applied in order. The schema version of a database is the
+// number of migrations applied to it, so new migrations must only be appended.
+var migrations = []migration{
+	{"convert legacy user namespaces to memberships", migrateLegacyNamespaces},
+	{"index users by namespace and stream key", migrateIndexUsers},
+	{"index admins", migrateIndexAdmins},
+}
+
+// schemaVersion is the version of databases written by this build.
+var schemaVersion = len(migrations)
+
+// ErrNewerSchema is returned when the database was written by a newer build.
+var ErrNewerSchema = errors.New("database schema is newer than this build supports")
+
+// PlanMigrations returns the schema version of the database and the
+// migrations Init would apply. The migrations are run against the stored data
+// to check that they succeed, but nothing is changed.
+func (s *boltStorage) PlanMigrations() (version int, pending []string, err error) {
+	version, err = s.storedVersion()
+	if err != nil {
+		return version, nil, err
+	}
+
+	for _, m := range migrations[version:] {
+		pending = append(pending, m.description)
+	}
+
+	err = s.update(func(tx *bolt.Tx) error {
+		if err := migrate(tx, version); err != nil {
+			return err
+		}
+		return errDryRun
+	})
+	if errors.Is(err, errDryRun) {
+		err = nil
+	}
+
+	return version, pending, err
+}
+
+// storedVersion returns the version stored in the meta bucket. Databases
+// without one are either new, which are created at schemaVersion, or were
+// written before versioning existed, which are at version 0.
+func (s *boltStorage) storedVersion() (version int, err error) {
+	err = s.view(func(tx *bolt.Tx) error {
+		meta := tx.Bucket(metaBucket)
+		if meta == nil {
+			if tx.Bucket(usersBucket) == nil && tx.Bucket(namespacesBucket) == nil {
+				version = schemaVersion
+			}
+			return nil
+		}
+
+		version, err = strconv.Atoi(string(meta.Get(versionKey)))
+		if err != nil {
+			return fmt.Errorf("invalid schema version: %w", err)
+		}
+		return nil
+	})
+	if err == nil && version > schemaVersion {
+		err = fmt.Errorf("%w: version %d, supported %d", ErrNewerSchema, version, schemaVersion)
+	}
+	return
+}
+
+// backup copies the database next to the original file and returns its path.
+func (s *boltStorage) backup(version int) (path string, err error) {
+	path = fmt.Sprintf("%s.v%d.%s.bak", s.DB.Path(), version, time.Now().Format("20060102-150405"))
+	err = s.view(func(tx *bolt.Tx) error {
+		return tx.CopyFile(path, 0600)
+	})
+	return
+}
+
+// migrate creates the buckets and applies the migrations after version.
+func migrate(tx *bolt.Tx, version int) error {
+	for _, bucket := range buckets {
+		if _, err := tx.CreateBucketIfNotExists(bucket); err != nil {
+			return err
+		}
+	}
+
+	for i, m := range migrations[version:] {
+		if err := m.apply(tx); err != nil {
+			return fmt.Errorf("migration %d (%s): %w", version+i+1, m.description, err)
+		}
+	}
+
+	return tx.Bucket(metaBucket).Put(versionKey, []byte(strconv.Itoa(schemaVersion)))
+}
+
+// record is a stored value with its fields kept as raw JSON.
+type record map[string]json.RawMessage
+
+// updateRecords calls fn for every record in bucket and stores it again if fn
+// reports a change.
+func updateRecords(tx *bolt.Tx, bucket []byte, fn func(record) (bool, error)) error {
+	b := tx.Bucket(bucket)
+
+	var keys [][]byte
+	err := b.ForEach(func(k, v []byte) error {
+		keys = append(keys, slices.Clone(k))
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	for _, k := range keys {
+		var r record
+		if err := json.Unmarshal(b.Get(k), &r); err != nil {
+			return fmt.Errorf("record %q: %w", k, err)
+		}
+
+		changed, err := fn(r)
+		if err != nil {
+			return fmt.Errorf("record %q: %w", k, err)
+		}
+		if !changed {
+			continue
+		}
+
+		data, err := json.Marshal(r)
+		if err != nil {
+			return err
+		}
+
+		if err := b.Put(k, data); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// migrateLegacyNamespaces converts users stored with a single namespace into
+// memberships. An empty legacy namespace used to grant every namespace, so
+// those users become members of all namespaces that exist.
+func migrateLegacyNamespaces(tx *bolt.Tx) error {
+	var namespaces []string
+	err := tx.Bucket(namespacesBucket).ForEach(func(k, v []byte) error {
+		namespaces = append(namespaces, string(k))
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	type membership struct {
+		Namespace  string
+		Permission string
+	}
+
+	return updateRecords(tx, usersBucket, func(user record) (bool, error) {
+		raw, ok := user["Namespace"]
+		if !ok {
+			return false, nil
+		}
+		delete(user, "Namespace")
+
+		var legacy *string
+		if err := json.Unmarshal(raw, &legacy); err != nil {
+			return false, err
+		}
+		if legacy == nil {
+			return true, nil
+		}
+
+		granted := []string{*legacy}
+		if *legacy == "" {
+			granted = namespaces
+		}
+
+		var memberships []membership
+		if raw, ok := user["Memberships"]; ok {
+			if err := json.Unmarshal(raw, &memberships); err != nil {
+				return false, err
+			}
+		}
+
+		for _, namespace := range granted {
+			if !slices.ContainsFunc(memberships, func(m membership) bool { return m.Namespace == namespace }) {
+				memberships = append(memberships, membership{Namespace: namespace, Permission: "both"})
+			}
+		}
+
+		data, err := json.Marshal(memberships)
+		if err != nil {
+			return false, err
+		}
+
+		user["Memberships"] = data
+		return true, nil
+	})
+}
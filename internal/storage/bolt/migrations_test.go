@@ -0,0 +1,204 @@
+package bolt
+
+import (
+	"MediaMTXAuth/internal"
+	"errors"
+	"path"
+	"path/filepath"
+	"strconv"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+	bolt "go.etcd.io/bbolt"
+)
+
+// openLegacy returns a database laid out the way it was before records were
+// versioned.
+func openLegacy(t *testing.T) *boltStorage {
+	s, err := New(path.Join(t.TempDir(), "test.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { s.Close() })
+
+	db := s.(*boltStorage)
+	err = db.DB.Update(func(tx *bolt.Tx) error {
+		users, _ := tx.CreateBucket(usersBucket)
+		namespaces, _ := tx.CreateBucket(namespacesBucket)
+
+		_ = namespaces.Put([]byte("first"), []byte(`{"Name":"first"}`))
+		_ = namespaces.Put([]byte("second"), []byte(`{"Name":"second"}`))
+		_ = users.Put([]byte("single"), []byte(`{"Name":"single","Namespace":"first"}`))
+		_ = users.Put([]byte("all"), []byte(`{"Name":"all","Namespace":""}`))
+		return users.Put([]byte("current"), []byte(`{"Name":"current","Memberships":null}`))
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return db
+}
+
+func TestMigrations(t *testing.T) {
+	t.Run("new database", func(t *testing.T) {
+		s, _ := New(path.Join(t.TempDir(), "test.db"))
+		defer s.Close()
+
+		version, pending, err := s.(*boltStorage).PlanMigrations()
+		if err != nil || version != schemaVersion || len(pending) != 0 {
+			t.Errorf("Expected nothing to migrate, got version %d, %v, %v", version, pending, err)
+		}
+
+		if err := s.Init(); err != nil {
+			t.Fatal(err)
+		}
+
+		matches, _ := filepath.Glob(path.Join(filepath.Dir(s.(*boltStorage).DB.Path()), "*.bak"))
+		if len(matches) != 0 {
+			t.Errorf("New database should not be backed up, got %v", matches)
+		}
+	})
+
+	t.Run("dry run", func(t *testing.T) {
+		s := openLegacy(t)
+
+		version, pending, err := s.PlanMigrations()
+		if err != nil {
+			t.Fatalf("Failed to plan migrations: %v", err)
+		}
+
+		if version != 0 || len(pending) != len(migrations) {
+			t.Errorf("Expected all migrations from version 0, got version %d, %v", version, pending)
+		}
+
+		if version, _ := s.storedVersion(); version != 0 {
+			t.Errorf("Dry run should not change the version, got %d", version)
+		}
+
+		user, _ := s.GetUser("single")
+		if len(user.Memberships) != 0 {
+			t.Errorf("Dry run should not change records, got %v", user.Memberships)
+		}
+	})
+
+	t.Run("legacy namespaces", func(t *testing.T) {
+		s := openLegacy(t)
+
+		if err := s.Init(); err != nil {
+			t.Fatalf("Failed to migrate: %v", err)
+		}
+
+		tests := map[string][]internal.Membership{
+			"single": {
+				{Namespace: "first", Permission: internal.PermissionBoth},
+			},
+			"all": {
+				{Namespace: "first", Permission: internal.PermissionBoth},
+				{Namespace: "second", Permission: internal.PermissionBoth},
+			},
+			"current": nil,
+		}
+
+		for name, expected := range tests {
+			user, _ := s.GetUser(name)
+			if !cmp.Equal(user.Memberships, expected) {
+				t.Errorf("Expected memberships %v for %s, got %v", expected, name, user.Memberships)
+			}
+		}
+
+		_ = s.DB.View(func(tx *bolt.Tx) error {
+			if data := tx.Bucket(usersBucket).Get([]byte("single")); string(data) != `{"Memberships":[{"Namespace":"first","Permission":"both"}],"Name":"single"}` {
+				t.Errorf("Legacy namespace should be removed, got %s", data)
+			}
+			return nil
+		})
+
+		if version, _ := s.storedVersion(); version != schemaVersion {
+			t.Errorf("Expected version %d, got %d", schemaVersion, version)
+		}
+
+		matches, _ := filepath.Glob(s.DB.Path() + ".v0.*.bak")
+		if len(matches) != 1 {
+			t.Errorf("Expected one backup, got %v", matches)
+		}
+	})
+
+	t.Run("user indexes", func(t *testing.T) {
+		s := openLegacy(t)
+
+		_ = s.DB.Update(func(tx *bolt.Tx) error {
+			return tx.Bucket(usersBucket).Put([]byte("keyed"), []byte(`{"Name":"keyed","StreamKey":"key","Manages":["second"]}`))
+		})
+
+		if err := s.Init(); err != nil {
+			t.Fatalf("Failed to migrate: %v", err)
+		}
+
+		users, _ := s.GetUsersByNamespace("second")
+		var names []string
+		for _, user := range users {
+			names = append(names, user.Name)
+		}
+		if diff := cmp.Diff([]string{"all", "keyed"}, names); diff != "" {
+			t.Errorf("Unexpected users (-want +got):\n%s", diff)
+		}
+
+		if user, _ := s.GetUserByStreamKey("key"); user == nil || user.Name != "keyed" {
+			t.Errorf("Expected keyed, got %v", user)
+		}
+	})
+
+	t.Run("admin index", func(t *testing.T) {
+		s := openLegacy(t)
+
+		if err := s.Init(); err != nil {
+			t.Fatalf("Failed to migrate: %v", err)
+		}
+		if found, _ := s.HasAdmin(); found {
+			t.Errorf("Expected no admin")
+		}
+
+		// A database at the version before the admin index.
+		_ = s.DB.Update(func(tx *bolt.Tx) error {
+			_ = tx.Bucket(usersBucket).Put([]byte("admin"), []byte(`{"Name":"admin","IsAdmin":true}`))
+			return tx.Bucket(metaBucket).Put(versionKey, []byte(strconv.Itoa(schemaVersion-1)))
+		})
+
+		if err := s.Init(); err != nil {
+			t.Fatalf("Failed to migrate: %v", err)
+		}
+		if found, err := s.HasAdmin(); err != nil || !found {
+			t.Errorf("Expected an admin, got %v, %v", found, err)
+		}
+	})
+
+	t.Run("duplicate stream keys", func(t *testing.T) {
+		s := openLegacy(t)
+
+		_ = s.DB.Update(func(tx *bolt.Tx) error {
+			users := tx.Bucket(usersBucket)
+			_ = users.Put([]byte("one"), []byte(`{"Name":"one","StreamKey":"key"}`))
+			return users.Put([]byte("two"), []byte(`{"Name":"two","PreviousStreamKey":"key"}`))
+		})
+
+		if err := s.Init(); !errors.Is(err, internal.ErrStreamKeyTaken) {
+			t.Errorf("Expected ErrStreamKeyTaken, got %v", err)
+		}
+		if version, _ := s.storedVersion(); version != 0 {
+			t.Errorf("Failed migration should not change the version, got %d", version)
+		}
+	})
+
+	t.Run("newer schema", func(t *testing.T) {
+		s := openLegacy(t)
+
+		_ = s.DB.Update(func(tx *bolt.Tx) error {
+			meta, _ := tx.CreateBucket(metaBucket)
+			return meta.Put(versionKey, []byte(strconv.Itoa(schemaVersion+1)))
+		})
+
+		if err := s.Init(); !errors.Is(err, ErrNewerSchema) {
+			t.Errorf("Expected ErrNewerSchema, got %v", err)
+		}
+	})
+}
@@ -0,0 +1,96 @@
+package bolt
+
+import (
+	"MediaMTXAuth/internal"
+	"MediaMTXAuth/internal/storage"
+	"bytes"
+	"encoding/json"
+
+	bolt "go.etcd.io/bbolt"
+)
+
+func (s *boltStorage) ListUsers(r storage.Range) ([]internal.User, error) {
+	return list[internal.User](s, usersBucket, r)
+}
+
+func (s *boltStorage) ListNamespaces(r storage.Range) ([]internal.Namespace, error) {
+	return list[internal.Namespace](s, namespacesBucket, r)
+}
+
+// list returns the values in bucket whose keys are in r. The cursor seeks to
+// the start of the range, and only values with matching keys are decoded.
+func list[T any](s *boltStorage, bucket []byte, r storage.Range) ([]T, error) {
+	values := []T{}
+
+	err := s.view(func(tx *bolt.Tx) error {
+		c := tx.Bucket(bucket).Cursor()
+		prefix := []byte(r.Prefix)
+
+		next := c.Next
+		k, v := seekFirst(c, r)
+		if r.Desc {
+			next = c.Prev
+			k, v = seekLast(c, r)
+		}
+
+		for ; k != nil && bytes.HasPrefix(k, prefix) && !r.Full(len(values)); k, v = next() {
+			if !r.Match(string(k)) {
+				continue
+			}
+
+			var value T
+			if err := json.Unmarshal(v, &value); err != nil {
+				return err
+			}
+			values = append(values, value)
+		}
+
+		return nil
+	})
+
+	return values, err
+}
+
+// seekFirst moves c to the first key that can be in r.
+func seekFirst(c *bolt.Cursor, r storage.Range) ([]byte, []byte) {
+	if r.After == "" || r.After < r.Prefix {
+		return c.Seek([]byte(r.Prefix))
+	}
+
+	k, v := c.Seek([]byte(r.After))
+	if string(k) == r.After {
+		return c.Next()
+	}
+	return k, v
+}
+
+// seekLast moves c to the last key that can be in r, which is the one before
+// the first key past both the prefix and After.
+func seekLast(c *bolt.Cursor, r storage.Range) ([]byte, []byte) {
+	bound := prefixEnd(r.Prefix)
+	if r.After != "" && (bound == "" || r.After < bound) {
+		bound = r.After
+	}
+
+	if bound == "" {
+		return c.Last()
+	}
+
+	if k, _ := c.Seek([]byte(bound)); k == nil {
+		return c.Last()
+	}
+	return c.Prev()
+}
+
+// prefixEnd returns the smallest string greater than all strings starting
+// with prefix, or "" if there is none.
+func prefixEnd(prefix string) string {
+	end := []byte(prefix)
+	for i := len(end) - 1; i >= 0; i-- {
+		if end[i] < 0xff {
+			end[i]++
+			return string(end[:i+1])
+		}
+	}
+	return ""
+}
@@ -4,6 +4,10 @@ import (
 	"MediaMTXAuth/internal"
 	"MediaMTXAuth/internal/storage"
 	"encoding/json"
+	"fmt"
+	"log"
+	"slices"
+	"sync"
 	"time"
 
 	bolt "go.etcd.io/bbolt"
@@ -11,10 +15,13 @@ import (
 
 var usersBucket = []byte("users")
 var namespacesBucket = []byte("namespaces")
-var buckets = [][]byte{usersBucket, namespacesBucket}
+var buckets = [][]byte{metaBucket, usersBucket, namespacesBucket, usersByNamespaceBucket, usersByStreamKeyBucket, adminsBucket}
 
 type boltStorage struct {
 	DB *bolt.DB
+
+	// mu is held for writing while Restore swaps DB.
+	mu sync.RWMutex
 }
 
 func New(path string) (storage.Storage, error) {
@@ -28,48 +35,116 @@ func New(path string) (storage.Storage, error) {
 }
 
 func (s *boltStorage) Close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
 	return s.DB.Close()
 }
 
+// Init creates the buckets and brings the stored records up to the current
+// schema version. A copy of the database is saved before any migration runs.
 func (s *boltStorage) Init() error {
-	return s.DB.Update(func(tx *bolt.Tx) error {
-		for _, bucket := range buckets {
-			if _, err := tx.CreateBucketIfNotExists(bucket); err != nil {
-				return err
-			}
+	version, err := s.storedVersion()
+	if err != nil {
+		return err
+	}
+
+	if version < schemaVersion {
+		backup, err := s.backup(version)
+		if err != nil {
+			return fmt.Errorf("failed to back up database: %w", err)
 		}
 
-		return nil
+		log.Printf("migrating database from schema version %d to %d, backup saved to %s", version, schemaVersion, backup)
+		for _, m := range migrations[version:] {
+			log.Printf("  %s", m.description)
+		}
+	}
+
+	return s.update(func(tx *bolt.Tx) error {
+		return migrate(tx, version)
 	})
 }
 
 func (s *boltStorage) SetUser(u internal.User) error {
-	return set(s.DB, usersBucket, u)
+	data, err := json.Marshal(&u)
+	if err != nil {
+		return err
+	}
+
+	return s.update(func(tx *bolt.Tx) error {
+		old, err := storedUser(tx, u.Name)
+		if err != nil {
+			return err
+		}
+
+		if err := reindexUser(tx, old, &u); err != nil {
+			return err
+		}
+
+		return tx.Bucket(usersBucket).Put([]byte(u.Name), data)
+	})
 }
 
 func (s *boltStorage) GetUser(name string) (*internal.User, error) {
-	return get[internal.User](s.DB, usersBucket, name)
+	return get[internal.User](s, usersBucket, name)
 }
 func (s *boltStorage) DeleteUser(name string) error {
-	return remove[internal.User](s.DB, usersBucket, name)
+	return s.update(func(tx *bolt.Tx) error {
+		old, err := storedUser(tx, name)
+		if err != nil || old == nil {
+			return err
+		}
+
+		if err := reindexUser(tx, old, nil); err != nil {
+			return err
+		}
+
+		return tx.Bucket(usersBucket).Delete([]byte(name))
+	})
+}
+
+func (s *boltStorage) UpdateUser(name string, fn func(*internal.User) error) error {
+	return updateValue(s, usersBucket, name, fn, reindexUser, internal.ErrUserNotFound, internal.ErrUserAlreadyExists)
+}
+
+func (s *boltStorage) GetMeta(name string) (value []byte, err error) {
+	err = s.view(func(tx *bolt.Tx) error {
+		value = slices.Clone(tx.Bucket(metaBucket).Get([]byte(name)))
+		return nil
+	})
+	return
+}
+
+func (s *boltStorage) SetMeta(name string, value []byte) error {
+	return s.update(func(tx *bolt.Tx) error {
+		if value == nil {
+			return tx.Bucket(metaBucket).Delete([]byte(name))
+		}
+		return tx.Bucket(metaBucket).Put([]byte(name), value)
+	})
 }
 
 func (s *boltStorage) SetNamespace(u internal.Namespace) error {
-	return set(s.DB, namespacesBucket, u)
+	return set(s, namespacesBucket, u)
 }
 
 func (s *boltStorage) GetNamespace(name string) (*internal.Namespace, error) {
-	return get[internal.Namespace](s.DB, namespacesBucket, name)
+	return get[internal.Namespace](s, namespacesBucket, name)
 }
 
 func (s *boltStorage) DeleteNamespace(name string) error {
-	return remove[internal.Namespace](s.DB, namespacesBucket, name)
+	return remove(s, namespacesBucket, name)
+}
+
+func (s *boltStorage) UpdateNamespace(name string, fn func(*internal.Namespace) error) error {
+	return updateValue(s, namespacesBucket, name, fn, nil, internal.ErrNamespaceNotFound, internal.ErrNamespaceAlreadyExists)
 }
 
 func (s *boltStorage) GetAllUsers() ([]internal.User, error) {
-	var users []internal.User
+	users := []internal.User{}
 
-	err := s.DB.View(func(tx *bolt.Tx) error {
+	err := s.view(func(tx *bolt.Tx) error {
 		bucket := tx.Bucket(usersBucket)
 		if bucket == nil {
 			return nil
@@ -89,9 +164,9 @@ func (s *boltStorage) GetAllUsers() ([]internal.User, error) {
 }
 
 func (s *boltStorage) GetAllNamespaces() ([]internal.Namespace, error) {
-	var namespaces []internal.Namespace
+	namespaces := []internal.Namespace{}
 
-	err := s.DB.View(func(tx *bolt.Tx) error {
+	err := s.view(func(tx *bolt.Tx) error {
 		bucket := tx.Bucket(namespacesBucket)
 		if bucket == nil {
 			return nil
@@ -110,20 +185,20 @@ func (s *boltStorage) GetAllNamespaces() ([]internal.Namespace, error) {
 	return namespaces, err
 }
 
-func set[T internal.WithID](db *bolt.DB, bucket []byte, v T) error {
+func set[T internal.WithID](s *boltStorage, bucket []byte, v T) error {
 	data, err := json.Marshal(&v)
 
 	if err != nil {
 		return err
 	}
 
-	return db.Update(func(tx *bolt.Tx) error {
+	return s.update(func(tx *bolt.Tx) error {
 		return tx.Bucket(bucket).Put([]byte(v.GetID()), data)
 	})
 }
 
-func get[T any](db *bolt.DB, bucket []byte, name string) (u *T, err error) {
-	err = db.View(func(tx *bolt.Tx) error {
+func get[T any](s *boltStorage, bucket []byte, name string) (u *T, err error) {
+	err = s.view(func(tx *bolt.Tx) error {
 		data := tx.Bucket(bucket).Get([]byte(name))
 
 		if data == nil {
@@ -144,8 +219,74 @@ func get[T any](db *bolt.DB, bucket []byte, name string) (u *T, err error) {
 	return
 }
 
-func remove[T any](db *bolt.DB, bucket []byte, name string) (err error) {
-	err = db.Update(func(tx *bolt.Tx) error {
+// view runs fn in a read transaction.
+func (s *boltStorage) view(fn func(*bolt.Tx) error) error {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	return s.DB.View(fn)
+}
+
+// update runs fn in a write transaction.
+func (s *boltStorage) update(fn func(*bolt.Tx) error) error {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	return s.DB.Update(fn)
+}
+
+// updateValue applies fn to the value called name in a single write
+// transaction. reindex, if not nil, updates the indexes for the change in the
+// same transaction. notFound and exists are returned when the value is missing
+// or fn renames it to an ID that is already taken.
+func updateValue[T internal.WithID](s *boltStorage, bucket []byte, name string, fn func(*T) error, reindex func(tx *bolt.Tx, old, new *T) error, notFound, exists error) error {
+	return s.update(func(tx *bolt.Tx) error {
+		b := tx.Bucket(bucket)
+
+		data := b.Get([]byte(name))
+		if data == nil {
+			return notFound
+		}
+
+		var v, old T
+		if err := json.Unmarshal(data, &v); err != nil {
+			return err
+		}
+		if err := json.Unmarshal(data, &old); err != nil {
+			return err
+		}
+
+		if err := fn(&v); err != nil {
+			return err
+		}
+
+		if id := v.GetID(); id != name {
+			if b.Get([]byte(id)) != nil {
+				return exists
+			}
+
+			if err := b.Delete([]byte(name)); err != nil {
+				return err
+			}
+		}
+
+		if reindex != nil {
+			if err := reindex(tx, &old, &v); err != nil {
+				return err
+			}
+		}
+
+		data, err := json.Marshal(&v)
+		if err != nil {
+			return err
+		}
+
+		return b.Put([]byte(v.GetID()), data)
+	})
+}
+
+func remove(s *boltStorage, bucket []byte, name string) (err error) {
+	err = s.update(func(tx *bolt.Tx) error {
 		return tx.Bucket(bucket).Delete([]byte(name))
 	})
 	return
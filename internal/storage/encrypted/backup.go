@@ -0,0 +1,98 @@
+package encrypted
+
+import (
+	"MediaMTXAuth/internal/storage"
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+)
+
+// Backup writes a snapshot of the wrapped storage to w. The records stay
+// sealed in it, so it can only be restored with the key encryption key.
+func (s *Storage) Backup(w io.Writer) (int64, error) {
+	b, ok := s.Storage.(storage.Backuper)
+	if !ok {
+		return 0, ErrUnsupported
+	}
+	return b.Backup(w)
+}
+
+// Restore replaces the wrapped database with the snapshot read from r and
+// loads its keyring. A snapshot encrypted with a key that is not configured is
+// refused with storage.ErrInvalidSnapshot, and the current database is put
+// back. Records of the snapshot that are not sealed with the current data key,
+// because it was taken before encryption was enabled or the key rotated, are
+// re-encrypted before Restore returns.
+func (s *Storage) Restore(r io.Reader) error {
+	b, ok := s.Storage.(storage.Backuper)
+	if !ok {
+		return ErrUnsupported
+	}
+
+	if err := s.restore(b, r); err != nil {
+		return err
+	}
+
+	if !s.Pending() {
+		return nil
+	}
+
+	_, err := s.Reencrypt()
+	return err
+}
+
+// Unencrypted returns b, the Backuper of a database used without encryption,
+// with a Restore that refuses snapshots of an encrypted database with
+// storage.ErrInvalidSnapshot and ErrKeyRequired, as they could not be opened.
+// b is returned as is if it cannot check a snapshot before swapping it in.
+func Unencrypted(b storage.Backuper) storage.Backuper {
+	if _, ok := b.(storage.CheckedRestorer); !ok {
+		return b
+	}
+	return unencrypted{b}
+}
+
+type unencrypted struct {
+	storage.Backuper
+}
+
+func (u unencrypted) Restore(r io.Reader) error {
+	return u.Backuper.(storage.CheckedRestorer).RestoreChecked(r, func(s storage.Storage) error {
+		if err := CheckUnencrypted(s); err != nil {
+			return fmt.Errorf("%w: %w", storage.ErrInvalidSnapshot, err)
+		}
+		return nil
+	})
+}
+
+// restore swaps the snapshot in while holding the locks, so that no record is
+// written meanwhile or opened with the keys of the replaced database. The
+// replaced database is kept in memory until the keyring of the snapshot is
+// loaded.
+func (s *Storage) restore(b storage.Backuper, r io.Reader) error {
+	s.records.Lock()
+	defer s.records.Unlock()
+
+	var previous bytes.Buffer
+	if _, err := b.Backup(&previous); err != nil {
+		return err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if err := b.Restore(r); err != nil {
+		return err
+	}
+
+	err := s.loadKeys()
+	if err == nil {
+		return nil
+	}
+
+	if restoreErr := b.Restore(&previous); restoreErr != nil {
+		return errors.Join(err, restoreErr)
+	}
+	return fmt.Errorf("%w: %w", storage.ErrInvalidSnapshot, err)
+}
@@ -0,0 +1,149 @@
+package encrypted
+
+import (
+	"MediaMTXAuth/internal"
+	"MediaMTXAuth/internal/storage"
+	"MediaMTXAuth/internal/storage/bolt"
+	"bytes"
+	"errors"
+	"path"
+	"testing"
+)
+
+func newBolt(t *testing.T) storage.Storage {
+	s, err := bolt.New(path.Join(t.TempDir(), "test.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { s.Close() })
+	return s
+}
+
+func TestRestore(t *testing.T) {
+	inner := newBolt(t)
+	oldKey, key := newKey(t), newKey(t)
+
+	s := newStorage(t, inner, oldKey, nil)
+	if err := s.SetUser(internal.User{Name: "alice", StreamKey: "alice-key"}); err != nil {
+		t.Fatal(err)
+	}
+
+	var snapshot bytes.Buffer
+	if _, err := s.Backup(&snapshot); err != nil {
+		t.Fatalf("Failed to back up: %v", err)
+	}
+
+	s = newStorage(t, inner, key, oldKey)
+	if _, err := s.Reencrypt(); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.SetUser(internal.User{Name: "bob", StreamKey: "bob-key"}); err != nil {
+		t.Fatal(err)
+	}
+
+	t.Run("unknown key", func(t *testing.T) {
+		s := newStorage(t, inner, key, nil)
+		if err := s.Restore(bytes.NewReader(snapshot.Bytes())); !errors.Is(err, storage.ErrInvalidSnapshot) || !errors.Is(err, ErrUnknownKey) {
+			t.Errorf("Expected ErrInvalidSnapshot, got %v", err)
+		}
+
+		if user, err := s.GetUserByStreamKey("bob-key"); err != nil || user == nil {
+			t.Errorf("Refused snapshot should not replace the data, got %v, %v", user, err)
+		}
+	})
+
+	t.Run("rotated", func(t *testing.T) {
+		if err := s.Restore(bytes.NewReader(snapshot.Bytes())); err != nil {
+			t.Fatalf("Failed to restore: %v", err)
+		}
+		if s.Pending() {
+			t.Errorf("Restored records should be re-encrypted")
+		}
+
+		if user, err := s.GetUserByStreamKey("alice-key"); err != nil || user == nil || user.Name != "alice" {
+			t.Errorf("Expected alice by stream key, got %v, %v", user, err)
+		}
+
+		if err := s.SetUser(internal.User{Name: "carol", StreamKey: "carol-key"}); err != nil {
+			t.Fatal(err)
+		}
+
+		reopened := newStorage(t, inner, key, nil)
+		for _, name := range []string{"alice", "carol"} {
+			user, err := reopened.GetUserByStreamKey(name + "-key")
+			if err != nil || user == nil || user.Name != name {
+				t.Errorf("Expected %s without the old key, got %v, %v", name, user, err)
+			}
+		}
+	})
+
+	t.Run("unencrypted", func(t *testing.T) {
+		plain := newBolt(t)
+		if err := plain.Init(); err != nil {
+			t.Fatal(err)
+		}
+		if err := plain.SetUser(internal.User{Name: "dave", StreamKey: "dave-key"}); err != nil {
+			t.Fatal(err)
+		}
+
+		var snapshot bytes.Buffer
+		if _, err := plain.(storage.Backuper).Backup(&snapshot); err != nil {
+			t.Fatal(err)
+		}
+
+		if err := s.Restore(&snapshot); err != nil {
+			t.Fatalf("Failed to restore: %v", err)
+		}
+		if s.Pending() {
+			t.Errorf("Restored records should be encrypted")
+		}
+
+		if err := CheckUnencrypted(inner); !errors.Is(err, ErrKeyRequired) {
+			t.Errorf("Expected ErrKeyRequired, got %v", err)
+		}
+		if user, err := newStorage(t, inner, key, nil).GetUserByStreamKey("dave-key"); err != nil || user == nil {
+			t.Errorf("Expected dave by stream key, got %v, %v", user, err)
+		}
+	})
+}
+
+func TestRestoreUnencrypted(t *testing.T) {
+	sealed := newBolt(t)
+	s := newStorage(t, sealed, newKey(t), nil)
+	if err := s.SetUser(internal.User{Name: "alice", StreamKey: "alice-key"}); err != nil {
+		t.Fatal(err)
+	}
+
+	var snapshot bytes.Buffer
+	if _, err := s.Backup(&snapshot); err != nil {
+		t.Fatal(err)
+	}
+
+	plain := newBolt(t)
+	if err := plain.Init(); err != nil {
+		t.Fatal(err)
+	}
+	if err := plain.SetUser(internal.User{Name: "bob", StreamKey: "bob-key"}); err != nil {
+		t.Fatal(err)
+	}
+
+	b := Unencrypted(plain.(storage.Backuper))
+	if err := b.Restore(&snapshot); !errors.Is(err, storage.ErrInvalidSnapshot) || !errors.Is(err, ErrKeyRequired) {
+		t.Errorf("Expected ErrInvalidSnapshot, got %v", err)
+	}
+
+	if err := CheckUnencrypted(plain); err != nil {
+		t.Errorf("Refused snapshot should not replace the data, got %v", err)
+	}
+	if user, err := plain.GetUserByStreamKey("bob-key"); err != nil || user == nil {
+		t.Errorf("Expected bob by stream key, got %v, %v", user, err)
+	}
+
+	snapshot.Reset()
+	if _, err := b.Backup(&snapshot); err != nil {
+		t.Fatal(err)
+	}
+	if err := b.Restore(&snapshot); err != nil {
+		t.Errorf("Failed to restore unencrypted snapshot: %v", err)
+	}
+}
@@ -0,0 +1,204 @@
+package encrypted
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// KeySize is the size of encryption keys in bytes, for AES-256.
+const KeySize = 32
+
+var (
+	ErrInvalidKey  = errors.New("encryption key must be 32 bytes encoded as base64")
+	ErrUnknownKey  = errors.New("database is encrypted with a key that is not configured")
+	ErrKeyRequired = errors.New("database is encrypted but no encryption key is configured")
+	ErrUnsupported = errors.New("database does not support encryption")
+)
+
+// keyringName is the meta value holding the keyring.
+const keyringName = "keyring"
+
+// ParseKey decodes a base64 encoded key, such as one generated with
+// openssl rand -base64 32.
+func ParseKey(text string) ([]byte, error) {
+	key, err := base64.StdEncoding.DecodeString(strings.TrimSpace(text))
+	if err != nil || len(key) != KeySize {
+		return nil, ErrInvalidKey
+	}
+	return key, nil
+}
+
+// keyring lists the data keys of a database, each wrapped by a key
+// encryption key that is configured outside the database. Records are sealed
+// with the current data key. Older data keys are kept until every record
+// sealed with them has been re-encrypted.
+type keyring struct {
+	Current string
+	Keys    []wrappedKey
+}
+
+type wrappedKey struct {
+	ID string
+	// WrappedBy is the ID of the key encryption key that sealed Key.
+	WrappedBy string
+	Key       []byte
+}
+
+// dataKey is an unwrapped data key.
+type dataKey struct {
+	id   string
+	raw  []byte
+	aead cipher.AEAD
+	// index is the HMAC key for blind indexes of sealed values.
+	index []byte
+}
+
+func newDataKey(id string, raw []byte) (*dataKey, error) {
+	aead, err := newAEAD(derive(raw, "seal"))
+	if err != nil {
+		return nil, err
+	}
+	return &dataKey{id: id, raw: raw, aead: aead, index: derive(raw, "index")}, nil
+}
+
+// generateDataKey returns a new random data key.
+func generateDataKey() (*dataKey, error) {
+	raw := make([]byte, KeySize)
+	if _, err := rand.Read(raw); err != nil {
+		return nil, err
+	}
+
+	id := make([]byte, 8)
+	if _, err := rand.Read(id); err != nil {
+		return nil, err
+	}
+	return newDataKey(hex.EncodeToString(id), raw)
+}
+
+// seal encrypts the JSON encoding of v. aad binds the result to its record,
+// so that it cannot be moved to another one.
+func (k *dataKey) seal(v any, aad string) (string, error) {
+	plaintext, err := json.Marshal(v)
+	if err != nil {
+		return "", err
+	}
+
+	sealed, err := sealBytes(k.aead, plaintext, []byte(aad))
+	if err != nil {
+		return "", err
+	}
+	return k.id + ":" + base64.RawStdEncoding.EncodeToString(sealed), nil
+}
+
+// open decrypts data sealed with seal into v.
+func (k *dataKey) open(data, aad string, v any) error {
+	sealed, err := base64.RawStdEncoding.DecodeString(data)
+	if err != nil {
+		return fmt.Errorf("invalid sealed value: %w", err)
+	}
+
+	plaintext, err := openBytes(k.aead, sealed, []byte(aad))
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(plaintext, v)
+}
+
+// blind returns a value to store in place of secret that can be looked up,
+// but not reversed. Empty values stay empty.
+func (k *dataKey) blind(secret string) string {
+	if secret == "" {
+		return ""
+	}
+
+	mac := hmac.New(sha256.New, k.index)
+	mac.Write([]byte(secret))
+	return "blind:" + k.id + ":" + base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+}
+
+// blindID is like blind for session IDs, which are stored as numbers. Zero
+// stays zero, and no other ID is blinded to it.
+func (k *dataKey) blindID(id uint64) uint64 {
+	if id == 0 {
+		return 0
+	}
+
+	mac := hmac.New(sha256.New, k.index)
+	fmt.Fprintf(mac, "session:%d", id)
+	return max(binary.BigEndian.Uint64(mac.Sum(nil)), 1)
+}
+
+// keyID identifies a key encryption key without revealing it.
+func keyID(key []byte) string {
+	return hex.EncodeToString(derive(key, "key id")[:8])
+}
+
+// wrap seals the data key with the key encryption key kek.
+func wrap(kek []byte, k *dataKey) (wrappedKey, error) {
+	aead, err := newAEAD(kek)
+	if err != nil {
+		return wrappedKey{}, err
+	}
+
+	sealed, err := sealBytes(aead, k.raw, []byte(k.id))
+	if err != nil {
+		return wrappedKey{}, err
+	}
+	return wrappedKey{ID: k.id, WrappedBy: keyID(kek), Key: sealed}, nil
+}
+
+// unwrap opens a data key wrapped by kek.
+func unwrap(kek []byte, w wrappedKey) (*dataKey, error) {
+	aead, err := newAEAD(kek)
+	if err != nil {
+		return nil, err
+	}
+
+	raw, err := openBytes(aead, w.Key, []byte(w.ID))
+	if err != nil {
+		return nil, fmt.Errorf("%w: data key %s cannot be unwrapped", ErrUnknownKey, w.ID)
+	}
+	return newDataKey(w.ID, raw)
+}
+
+func newAEAD(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+// sealBytes encrypts plaintext with a random nonce, which is prepended.
+func sealBytes(aead cipher.AEAD, plaintext, aad []byte) ([]byte, error) {
+	nonce := make([]byte, aead.NonceSize(), aead.NonceSize()+len(plaintext)+aead.Overhead())
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, err
+	}
+	return aead.Seal(nonce, nonce, plaintext, aad), nil
+}
+
+func openBytes(aead cipher.AEAD, sealed, aad []byte) ([]byte, error) {
+	if len(sealed) < aead.NonceSize() {
+		return nil, errors.New("sealed value is too short")
+	}
+	nonce, ciphertext := sealed[:aead.NonceSize()], sealed[aead.NonceSize():]
+	return aead.Open(nil, nonce, ciphertext, aad)
+}
+
+// derive returns a subkey of key for the purpose named by label.
+func derive(key []byte, label string) []byte {
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(label))
+	return mac.Sum(nil)
+}
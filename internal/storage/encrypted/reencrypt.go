@@ -0,0 +1,89 @@
+package encrypted
+
+import (
+	"MediaMTXAuth/internal"
+	"MediaMTXAuth/internal/storage"
+	"errors"
+)
+
+// reencryptBatch is the number of records read at a time by Reencrypt.
+const reencryptBatch = 100
+
+// Reencrypt seals every record that is not sealed with the current data key
+// yet, then drops the other data keys from the keyring. It returns the number
+// of records changed. Records are updated one at a time, so it can run while
+// the storage is in use.
+func (s *Storage) Reencrypt() (int, error) {
+	k := s.currentKey()
+	count := 0
+
+	err := batches(s.Storage.ListUsers, func(u internal.User) string { return u.Name }, func(u internal.User) error {
+		if sealed, err := s.sealedWith(k, userKind, u.Name); err != nil || sealed {
+			return err
+		}
+
+		err := s.UpdateUser(u.Name, func(*internal.User) error { return nil })
+		if errors.Is(err, internal.ErrUserNotFound) {
+			return nil
+		}
+		if err == nil {
+			count++
+		}
+		return err
+	})
+	if err != nil {
+		return count, err
+	}
+
+	err = batches(s.Storage.ListNamespaces, func(n internal.Namespace) string { return n.Name }, func(n internal.Namespace) error {
+		if sealed, err := s.sealedWith(k, namespaceKind, n.Name); err != nil || sealed {
+			return err
+		}
+
+		err := s.UpdateNamespace(n.Name, func(*internal.Namespace) error { return nil })
+		if errors.Is(err, internal.ErrNamespaceNotFound) {
+			return nil
+		}
+		if err == nil {
+			count++
+		}
+		return err
+	})
+	if err != nil {
+		return count, err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	keys := map[string]*dataKey{s.current.id: s.current}
+	if err := s.saveKeys(s.current, keys); err != nil {
+		return count, err
+	}
+	s.keys = keys
+	s.pending = false
+	return count, nil
+}
+
+// batches passes every value returned by list to fn, reading them
+// reencryptBatch at a time.
+func batches[T any](list func(storage.Range) ([]T, error), name func(T) string, fn func(T) error) error {
+	r := storage.Range{Limit: reencryptBatch}
+	for {
+		values, err := list(r)
+		if err != nil {
+			return err
+		}
+
+		for _, v := range values {
+			if err := fn(v); err != nil {
+				return err
+			}
+		}
+
+		if len(values) < r.Limit {
+			return nil
+		}
+		r.After = name(values[len(values)-1])
+	}
+}
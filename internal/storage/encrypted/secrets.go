@@ -0,0 +1,174 @@
+package encrypted
+
+import (
+	"bytes"
+	"errors"
+	"maps"
+)
+
+// errMismatch is returned when a stored record holds a blind index value its
+// secrets do not know.
+var errMismatch = errors.New("sealed secrets do not match the record")
+
+// The kinds of records with secrets, which name their meta values and bind
+// the sealed secrets to them.
+const (
+	userKind      = "user"
+	namespaceKind = "namespace"
+)
+
+// secrets maps the blind index values stored in a record back to the secrets
+// they replace. It is sealed into a meta value named after the record. New
+// secrets, such as TOTP seeds, are blinded into it the same way.
+type secrets struct {
+	Values     map[string]string `json:",omitempty"`
+	SessionIDs map[uint64]uint64 `json:",omitempty"`
+}
+
+// secretsName returns the name of the meta value holding the secrets of the
+// record called name.
+func secretsName(kind, name string) string {
+	return "secrets/" + kind + "/" + name
+}
+
+// blind returns the blind index value of value under k, remembering value.
+func (sec *secrets) blind(k *dataKey, value string) string {
+	blinded := k.blind(value)
+	if blinded != "" {
+		if sec.Values == nil {
+			sec.Values = make(map[string]string)
+		}
+		sec.Values[blinded] = value
+	}
+	return blinded
+}
+
+// blindID is like blind for session IDs.
+func (sec *secrets) blindID(k *dataKey, id uint64) uint64 {
+	blinded := k.blindID(id)
+	if blinded != 0 {
+		if sec.SessionIDs == nil {
+			sec.SessionIDs = make(map[uint64]uint64)
+		}
+		sec.SessionIDs[blinded] = id
+	}
+	return blinded
+}
+
+// value returns the secret replaced by blinded. Empty values stay empty.
+func (sec *secrets) value(blinded string) (string, error) {
+	if blinded == "" {
+		return "", nil
+	}
+
+	value, ok := sec.Values[blinded]
+	if !ok {
+		return "", errMismatch
+	}
+	return value, nil
+}
+
+// id is like value for session IDs.
+func (sec *secrets) id(blinded uint64) (uint64, error) {
+	if blinded == 0 {
+		return 0, nil
+	}
+
+	id, ok := sec.SessionIDs[blinded]
+	if !ok {
+		return 0, errMismatch
+	}
+	return id, nil
+}
+
+// merge adds the secrets of other to sec.
+func (sec *secrets) merge(other secrets) {
+	if len(other.Values) > 0 {
+		if sec.Values == nil {
+			sec.Values = make(map[string]string)
+		}
+		maps.Copy(sec.Values, other.Values)
+	}
+
+	if len(other.SessionIDs) > 0 {
+		if sec.SessionIDs == nil {
+			sec.SessionIDs = make(map[uint64]uint64)
+		}
+		maps.Copy(sec.SessionIDs, other.SessionIDs)
+	}
+}
+
+// secrets returns the secrets of the record called name. ok is false if it has
+// none, because it was stored before encryption was enabled.
+func (s *Storage) secrets(kind, name string) (sec secrets, ok bool, err error) {
+	data, err := s.meta.GetMeta(secretsName(kind, name))
+	if err != nil || data == nil {
+		return sec, false, err
+	}
+
+	err = s.open(string(data), kind+":"+name, &sec)
+	return sec, true, err
+}
+
+// sealedWith reports whether the secrets of the record called name are sealed
+// with k.
+func (s *Storage) sealedWith(k *dataKey, kind, name string) (bool, error) {
+	data, err := s.meta.GetMeta(secretsName(kind, name))
+	return bytes.HasPrefix(data, []byte(k.id+":")), err
+}
+
+// store writes a record with write and sealed its secrets sec with k. from is
+// the name the record had, and name the one it is stored under.
+//
+// The secrets are stored first, merged with those already stored under name,
+// so that the record finds its secrets whether or not write happened, even if
+// the process stops in between. The secrets the record no longer uses are
+// dropped once it is written. The caller must hold the records lock for
+// writing.
+func (s *Storage) store(k *dataKey, kind, from, name string, sec secrets, write func() error) error {
+	metaName, aad := secretsName(kind, name), kind+":"+name
+
+	previous, err := s.meta.GetMeta(metaName)
+	if err != nil {
+		return err
+	}
+
+	merged := secrets{}
+	merged.merge(sec)
+	if previous != nil {
+		// Secrets that cannot be opened belong to no readable record, so
+		// they need not be kept.
+		var stored secrets
+		if s.open(string(previous), aad, &stored) == nil {
+			merged.merge(stored)
+		}
+	}
+
+	if err := s.setSecrets(k, metaName, aad, merged); err != nil {
+		return err
+	}
+
+	if err := write(); err != nil {
+		if restoreErr := s.meta.SetMeta(metaName, previous); restoreErr != nil {
+			return errors.Join(err, restoreErr)
+		}
+		return err
+	}
+
+	if err := s.setSecrets(k, metaName, aad, sec); err != nil {
+		return err
+	}
+
+	if from != name {
+		return s.meta.SetMeta(secretsName(kind, from), nil)
+	}
+	return nil
+}
+
+func (s *Storage) setSecrets(k *dataKey, metaName, aad string, sec secrets) error {
+	sealed, err := k.seal(sec, aad)
+	if err != nil {
+		return err
+	}
+	return s.meta.SetMeta(metaName, []byte(sealed))
+}
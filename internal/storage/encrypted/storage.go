@@ -0,0 +1,589 @@
+// Package encrypted adds encryption at rest to a storage. The secrets of each
+// record, such as stream keys and session IDs, are replaced by blind index
+// values before it is stored, so that the wrapped storage can still look users
+// up by stream key, and sealed with AES-GCM into a meta value next to it.
+//
+// Data keys are kept in the database, wrapped by a key encryption key given
+// to New. Rotating the key encryption key creates a new data key, and
+// Reencrypt moves the records over to it.
+package encrypted
+
+import (
+	"MediaMTXAuth/internal"
+	"MediaMTXAuth/internal/storage"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"maps"
+	"slices"
+	"strings"
+	"sync"
+)
+
+// Storage seals the secrets of users and namespaces on their way into the
+// wrapped storage and opens them on the way out.
+type Storage struct {
+	storage.Storage
+	meta storage.MetaStore
+
+	// records is held for writing while a record and its secrets are
+	// stored, and for reading while they are read, so that they match.
+	records sync.RWMutex
+
+	// key wraps the data keys. oldKey is the previous key while it is
+	// rotated.
+	key, oldKey []byte
+
+	mu      sync.RWMutex
+	current *dataKey
+	keys    map[string]*dataKey
+	// pending is set when records may not be sealed with the current data
+	// key yet.
+	pending bool
+}
+
+// New wraps s, which must implement storage.MetaStore to keep the keyring.
+// key is the key encryption key, and oldKey, if not nil, the one it replaces.
+func New(s storage.Storage, key, oldKey []byte) (*Storage, error) {
+	meta, ok := s.(storage.MetaStore)
+	if !ok {
+		return nil, ErrUnsupported
+	}
+
+	if len(key) != KeySize || (oldKey != nil && len(oldKey) != KeySize) {
+		return nil, ErrInvalidKey
+	}
+
+	return &Storage{Storage: s, meta: meta, key: key, oldKey: oldKey}, nil
+}
+
+// CheckUnencrypted returns ErrKeyRequired if s holds encrypted records, so
+// that it is not used without its key.
+func CheckUnencrypted(s storage.Storage) error {
+	meta, ok := s.(storage.MetaStore)
+	if !ok {
+		return nil
+	}
+
+	data, err := meta.GetMeta(keyringName)
+	if err != nil {
+		return err
+	}
+	if data != nil {
+		return ErrKeyRequired
+	}
+	return nil
+}
+
+// Init initializes the wrapped storage and unwraps the data keys. It fails
+// with ErrUnknownKey if they were wrapped by a key that is not configured. If
+// the key encryption key changed, a new data key is created and the old ones
+// are wrapped with the new key, so the old key is no longer needed.
+func (s *Storage) Init() error {
+	if err := s.Storage.Init(); err != nil {
+		return err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.loadKeys()
+}
+
+// Pending reports whether some records may not be sealed with the current
+// data key, because encryption was just enabled or the key rotated.
+func (s *Storage) Pending() bool {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	return s.pending
+}
+
+// readKeys reads the keyring and unwraps its data keys without changing it.
+// ring is nil if there is no keyring yet.
+func (s *Storage) readKeys() (ring *keyring, keys map[string]*dataKey, err error) {
+	data, err := s.meta.GetMeta(keyringName)
+	if err != nil {
+		return nil, nil, err
+	}
+	if data == nil {
+		return nil, map[string]*dataKey{}, nil
+	}
+
+	ring = &keyring{}
+	if err := json.Unmarshal(data, ring); err != nil {
+		return nil, nil, fmt.Errorf("invalid keyring: %w", err)
+	}
+
+	kek := map[string][]byte{keyID(s.key): s.key}
+	if s.oldKey != nil {
+		kek[keyID(s.oldKey)] = s.oldKey
+	}
+
+	keys = make(map[string]*dataKey)
+	for _, wrapped := range ring.Keys {
+		key, ok := kek[wrapped.WrappedBy]
+		if !ok {
+			return nil, nil, fmt.Errorf("%w: data key %s is wrapped by key %s", ErrUnknownKey, wrapped.ID, wrapped.WrappedBy)
+		}
+
+		k, err := unwrap(key, wrapped)
+		if err != nil {
+			return nil, nil, err
+		}
+		keys[k.id] = k
+	}
+	return ring, keys, nil
+}
+
+// loadKeys reads the keyring, creating it if needed and rotating it if the
+// key encryption key changed. The caller must hold the lock for writing.
+func (s *Storage) loadKeys() error {
+	ring, keys, err := s.readKeys()
+	if err != nil {
+		return err
+	}
+
+	var current *dataKey
+	rotated := false
+	if ring != nil {
+		current = keys[ring.Current]
+		rotated = current == nil || !slices.ContainsFunc(ring.Keys, func(w wrappedKey) bool {
+			return w.ID == ring.Current && w.WrappedBy == keyID(s.key)
+		})
+	}
+
+	if ring == nil || rotated {
+		if current, err = generateDataKey(); err != nil {
+			return err
+		}
+		keys[current.id] = current
+		if err := s.saveKeys(current, keys); err != nil {
+			return err
+		}
+	}
+
+	s.current = current
+	s.keys = keys
+	s.pending = ring == nil || len(keys) > 1
+	return nil
+}
+
+// saveKeys stores the keyring with all keys wrapped by the current key
+// encryption key.
+func (s *Storage) saveKeys(current *dataKey, keys map[string]*dataKey) error {
+	ring := keyring{Current: current.id}
+	for _, id := range slices.Sorted(maps.Keys(keys)) {
+		wrapped, err := wrap(s.key, keys[id])
+		if err != nil {
+			return err
+		}
+		ring.Keys = append(ring.Keys, wrapped)
+	}
+
+	data, err := json.Marshal(ring)
+	if err != nil {
+		return err
+	}
+	return s.meta.SetMeta(keyringName, data)
+}
+
+// dataKey returns the data key called id. The keyring is read again if the
+// key is unknown, but never changed, so a lookup cannot rotate or replace
+// keys.
+func (s *Storage) dataKey(id string) (*dataKey, error) {
+	s.mu.RLock()
+	k := s.keys[id]
+	s.mu.RUnlock()
+
+	if k != nil {
+		return k, nil
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	_, keys, err := s.readKeys()
+	if err != nil {
+		return nil, err
+	}
+
+	k = keys[id]
+	if k == nil {
+		return nil, fmt.Errorf("%w: data key %s is missing", ErrUnknownKey, id)
+	}
+	s.keys[id] = k
+	return k, nil
+}
+
+// dataKeys returns the current data key followed by the others.
+func (s *Storage) dataKeys() []*dataKey {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	keys := []*dataKey{s.current}
+	for _, k := range s.keys {
+		if k != s.current {
+			keys = append(keys, k)
+		}
+	}
+	return keys
+}
+
+func (s *Storage) currentKey() *dataKey {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	return s.current
+}
+
+// open decrypts sealed, which is prefixed with the ID of its data key.
+func (s *Storage) open(sealed, aad string, v any) error {
+	id, data, ok := strings.Cut(sealed, ":")
+	if !ok {
+		return errors.New("invalid sealed value")
+	}
+
+	k, err := s.dataKey(id)
+	if err != nil {
+		return err
+	}
+	return k.open(data, aad, v)
+}
+
+// sealUser returns a copy of u with its secrets replaced by their blind
+// index values under k, and the secrets.
+func sealUser(k *dataKey, u internal.User) (internal.User, secrets) {
+	var sec secrets
+	u.StreamKey = sec.blind(k, u.StreamKey)
+	u.PreviousStreamKey = sec.blind(k, u.PreviousStreamKey)
+	u.Sessions = slices.Clone(u.Sessions)
+	for i := range u.Sessions {
+		u.Sessions[i].ID = sec.blindID(k, u.Sessions[i].ID)
+	}
+	return u, sec
+}
+
+// openUser returns a copy of u with its secrets restored. Users stored before
+// encryption was enabled are returned as they are. The caller must hold the
+// records lock.
+func (s *Storage) openUser(u internal.User) (internal.User, error) {
+	sec, ok, err := s.secrets(userKind, u.Name)
+	if err != nil || !ok {
+		return u, err
+	}
+
+	if u.StreamKey, err = sec.value(u.StreamKey); err != nil {
+		return u, fmt.Errorf("user %s: %w", u.Name, err)
+	}
+	if u.PreviousStreamKey, err = sec.value(u.PreviousStreamKey); err != nil {
+		return u, fmt.Errorf("user %s: %w", u.Name, err)
+	}
+	u.Sessions = slices.Clone(u.Sessions)
+	for i := range u.Sessions {
+		if u.Sessions[i].ID, err = sec.id(u.Sessions[i].ID); err != nil {
+			return u, fmt.Errorf("user %s: %w", u.Name, err)
+		}
+	}
+	return u, nil
+}
+
+// sealNamespace is like sealUser for namespaces.
+func sealNamespace(k *dataKey, n internal.Namespace) (internal.Namespace, secrets) {
+	var sec secrets
+	n.Sessions = slices.Clone(n.Sessions)
+	for i := range n.Sessions {
+		n.Sessions[i].Key = sec.blind(k, n.Sessions[i].Key)
+	}
+	return n, sec
+}
+
+// openNamespace is like openUser for namespaces.
+func (s *Storage) openNamespace(n internal.Namespace) (internal.Namespace, error) {
+	sec, ok, err := s.secrets(namespaceKind, n.Name)
+	if err != nil || !ok {
+		return n, err
+	}
+
+	n.Sessions = slices.Clone(n.Sessions)
+	for i := range n.Sessions {
+		if n.Sessions[i].Key, err = sec.value(n.Sessions[i].Key); err != nil {
+			return n, fmt.Errorf("namespace %s: %w", n.Name, err)
+		}
+	}
+	return n, nil
+}
+
+func (s *Storage) SetUser(u internal.User) error {
+	s.records.Lock()
+	defer s.records.Unlock()
+
+	if err := s.checkStreamKeys(u.Name, u); err != nil {
+		return err
+	}
+
+	k := s.currentKey()
+	sealed, sec := sealUser(k, u)
+	return s.store(k, userKind, u.Name, u.Name, sec, func() error {
+		return s.Storage.SetUser(sealed)
+	})
+}
+
+func (s *Storage) GetUser(name string) (*internal.User, error) {
+	s.records.RLock()
+	defer s.records.RUnlock()
+
+	return s.openResult(s.Storage.GetUser(name))
+}
+
+func (s *Storage) GetAllUsers() ([]internal.User, error) {
+	s.records.RLock()
+	defer s.records.RUnlock()
+
+	return s.openResults(s.Storage.GetAllUsers())
+}
+
+func (s *Storage) GetUsersByNamespace(namespace string) ([]internal.User, error) {
+	s.records.RLock()
+	defer s.records.RUnlock()
+
+	return s.openResults(s.Storage.GetUsersByNamespace(namespace))
+}
+
+func (s *Storage) ListUsers(r storage.Range) ([]internal.User, error) {
+	s.records.RLock()
+	defer s.records.RUnlock()
+
+	return s.openResults(s.Storage.ListUsers(r))
+}
+
+func (s *Storage) DeleteUser(name string) error {
+	s.records.Lock()
+	defer s.records.Unlock()
+
+	if err := s.Storage.DeleteUser(name); err != nil {
+		return err
+	}
+	return s.meta.SetMeta(secretsName(userKind, name), nil)
+}
+
+// UpdateUser passes update a decrypted copy of the user, so that callers
+// keeping it see no blind index values, and stores it sealed. The user is
+// read before the wrapped storage is updated, which is safe because every
+// write goes through s and holds the records lock.
+func (s *Storage) UpdateUser(name string, update func(*internal.User) error) error {
+	s.records.Lock()
+	defer s.records.Unlock()
+
+	stored, err := s.Storage.GetUser(name)
+	if err != nil {
+		return err
+	}
+	if stored == nil {
+		return internal.ErrUserNotFound
+	}
+
+	user, err := s.openUser(*stored)
+	if err != nil {
+		return err
+	}
+
+	previous := user
+	if err := update(&user); err != nil {
+		return err
+	}
+
+	if err := s.checkStreamKeys(name, user, previous.StreamKey, previous.PreviousStreamKey); err != nil {
+		return err
+	}
+
+	k := s.currentKey()
+	sealed, sec := sealUser(k, user)
+	return s.store(k, userKind, name, user.Name, sec, func() error {
+		return s.Storage.UpdateUser(name, func(stored *internal.User) error {
+			*stored = sealed
+			return nil
+		})
+	})
+}
+
+// GetUserByStreamKey looks key up under the blind index of every data key.
+// Users stored before encryption was enabled keep their plain key until they
+// are re-encrypted.
+func (s *Storage) GetUserByStreamKey(key string) (*internal.User, error) {
+	s.records.RLock()
+	defer s.records.RUnlock()
+
+	return s.userByStreamKey(key)
+}
+
+// checkStreamKeys returns internal.ErrStreamKeyTaken if another user than the
+// one called name has a stream key of u. The index of the wrapped storage
+// only compares values blinded with the same data key, so it misses users
+// sealed with an older key or stored before encryption was enabled. Keys in
+// kept are not checked. The caller must hold the records lock.
+func (s *Storage) checkStreamKeys(name string, u internal.User, kept ...string) error {
+	for _, key := range []string{u.StreamKey, u.PreviousStreamKey} {
+		if key == "" || slices.Contains(kept, key) {
+			continue
+		}
+
+		owner, err := s.userByStreamKey(key)
+		if err != nil {
+			return err
+		}
+		if owner != nil && owner.Name != name {
+			return internal.ErrStreamKeyTaken
+		}
+	}
+	return nil
+}
+
+// userByStreamKey is GetUserByStreamKey without taking the records lock.
+func (s *Storage) userByStreamKey(key string) (*internal.User, error) {
+	for _, k := range s.dataKeys() {
+		user, err := s.Storage.GetUserByStreamKey(k.blind(key))
+		if err != nil || user != nil {
+			return s.openResult(user, err)
+		}
+	}
+
+	user, err := s.Storage.GetUserByStreamKey(key)
+	if err != nil || user == nil {
+		return nil, err
+	}
+
+	// A sealed user only matches through the blind index.
+	if sealed, err := s.meta.GetMeta(secretsName(userKind, user.Name)); err != nil || sealed != nil {
+		return nil, err
+	}
+	return user, nil
+}
+
+func (s *Storage) SetNamespace(n internal.Namespace) error {
+	s.records.Lock()
+	defer s.records.Unlock()
+
+	k := s.currentKey()
+	sealed, sec := sealNamespace(k, n)
+	return s.store(k, namespaceKind, n.Name, n.Name, sec, func() error {
+		return s.Storage.SetNamespace(sealed)
+	})
+}
+
+func (s *Storage) GetNamespace(name string) (*internal.Namespace, error) {
+	s.records.RLock()
+	defer s.records.RUnlock()
+
+	return s.openNamespaceResult(s.Storage.GetNamespace(name))
+}
+
+func (s *Storage) GetAllNamespaces() ([]internal.Namespace, error) {
+	s.records.RLock()
+	defer s.records.RUnlock()
+
+	return s.openNamespaceResults(s.Storage.GetAllNamespaces())
+}
+
+func (s *Storage) ListNamespaces(r storage.Range) ([]internal.Namespace, error) {
+	s.records.RLock()
+	defer s.records.RUnlock()
+
+	return s.openNamespaceResults(s.Storage.ListNamespaces(r))
+}
+
+func (s *Storage) DeleteNamespace(name string) error {
+	s.records.Lock()
+	defer s.records.Unlock()
+
+	if err := s.Storage.DeleteNamespace(name); err != nil {
+		return err
+	}
+	return s.meta.SetMeta(secretsName(namespaceKind, name), nil)
+}
+
+// UpdateNamespace is like UpdateUser for namespaces.
+func (s *Storage) UpdateNamespace(name string, update func(*internal.Namespace) error) error {
+	s.records.Lock()
+	defer s.records.Unlock()
+
+	stored, err := s.Storage.GetNamespace(name)
+	if err != nil {
+		return err
+	}
+	if stored == nil {
+		return internal.ErrNamespaceNotFound
+	}
+
+	namespace, err := s.openNamespace(*stored)
+	if err != nil {
+		return err
+	}
+
+	if err := update(&namespace); err != nil {
+		return err
+	}
+
+	k := s.currentKey()
+	sealed, sec := sealNamespace(k, namespace)
+	return s.store(k, namespaceKind, name, namespace.Name, sec, func() error {
+		return s.Storage.UpdateNamespace(name, func(stored *internal.Namespace) error {
+			*stored = sealed
+			return nil
+		})
+	})
+}
+
+// openResult opens the user returned by a storage call, which may be nil.
+func (s *Storage) openResult(user *internal.User, err error) (*internal.User, error) {
+	if err != nil || user == nil {
+		return nil, err
+	}
+
+	opened, err := s.openUser(*user)
+	if err != nil {
+		return nil, err
+	}
+	return &opened, nil
+}
+
+// openResults opens the users returned by a storage call.
+func (s *Storage) openResults(users []internal.User, err error) ([]internal.User, error) {
+	if err != nil {
+		return nil, err
+	}
+
+	for i := range users {
+		if users[i], err = s.openUser(users[i]); err != nil {
+			return nil, err
+		}
+	}
+	return users, nil
+}
+
+// openNamespaceResult is like openResult for namespaces.
+func (s *Storage) openNamespaceResult(namespace *internal.Namespace, err error) (*internal.Namespace, error) {
+	if err != nil || namespace == nil {
+		return nil, err
+	}
+
+	opened, err := s.openNamespace(*namespace)
+	if err != nil {
+		return nil, err
+	}
+	return &opened, nil
+}
+
+// openNamespaceResults is like openResults for namespaces.
+func (s *Storage) openNamespaceResults(namespaces []internal.Namespace, err error) ([]internal.Namespace, error) {
+	if err != nil {
+		return nil, err
+	}
+
+	for i := range namespaces {
+		if namespaces[i], err = s.openNamespace(namespaces[i]); err != nil {
+			return nil, err
+		}
+	}
+	return namespaces, nil
+}
@@ -0,0 +1,444 @@
+package encrypted
+
+import (
+	"MediaMTXAuth/internal"
+	"MediaMTXAuth/internal/storage"
+	"MediaMTXAuth/internal/storage/memory"
+	"bytes"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+func newKey(t *testing.T) []byte {
+	key := make([]byte, KeySize)
+	if _, err := rand.Read(key); err != nil {
+		t.Fatal(err)
+	}
+	return key
+}
+
+func newUninitialized(t *testing.T, inner storage.Storage, key, oldKey []byte) *Storage {
+	s, err := New(inner, key, oldKey)
+	if err != nil {
+		t.Fatalf("Failed to create storage: %v", err)
+	}
+	return s
+}
+
+func newStorage(t *testing.T, inner storage.Storage, key, oldKey []byte) *Storage {
+	s := newUninitialized(t, inner, key, oldKey)
+	if err := s.Init(); err != nil {
+		t.Fatalf("Failed to init storage: %v", err)
+	}
+	return s
+}
+
+func TestStorage(t *testing.T) {
+	s, err := New(&memory.Storage{}, newKey(t), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer s.Close()
+
+	storage.XTestStorage(t, s)
+}
+
+func TestParseKey(t *testing.T) {
+	key := newKey(t)
+
+	parsed, err := ParseKey(base64.StdEncoding.EncodeToString(key) + "\n")
+	if err != nil || !bytes.Equal(parsed, key) {
+		t.Errorf("Expected key, got %v, %v", parsed, err)
+	}
+
+	for _, text := range []string{"", "not base64!", base64.StdEncoding.EncodeToString(key[:16])} {
+		if _, err := ParseKey(text); !errors.Is(err, ErrInvalidKey) {
+			t.Errorf("%q: expected ErrInvalidKey, got %v", text, err)
+		}
+	}
+}
+
+func TestEncryption(t *testing.T) {
+	inner := &memory.Storage{}
+	key := newKey(t)
+	s := newStorage(t, inner, key, nil)
+
+	user := internal.User{
+		Name:              "alice",
+		StreamKey:         "alice-key",
+		PreviousStreamKey: "alice-old-key",
+		Sessions:          []internal.UserSession{{ID: 1234567, Expiration: time.Unix(1234567890, 0)}},
+	}
+	if err := s.SetUser(user); err != nil {
+		t.Fatalf("Failed to set user: %v", err)
+	}
+
+	namespace := internal.Namespace{
+		Name:     "team",
+		Sessions: []internal.NamespaceSession{{Key: "session-key", Name: "live", User: "alice"}},
+	}
+	if err := s.SetNamespace(namespace); err != nil {
+		t.Fatalf("Failed to set namespace: %v", err)
+	}
+
+	t.Run("stored sealed", func(t *testing.T) {
+		data, err := json.Marshal(inner.Users)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, secret := range []string{"alice-key", "alice-old-key", "1234567"} {
+			if strings.Contains(string(data), secret) {
+				t.Errorf("Stored user contains %s: %s", secret, data)
+			}
+		}
+
+		data, err = json.Marshal(inner.Namespaces)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if strings.Contains(string(data), "session-key") {
+			t.Errorf("Stored namespace contains its session key: %s", data)
+		}
+
+		for _, name := range []string{secretsName(userKind, "alice"), secretsName(namespaceKind, "team")} {
+			if sealed, err := inner.GetMeta(name); err != nil || sealed == nil {
+				t.Errorf("Expected %s to be stored, got %v", name, err)
+			}
+		}
+	})
+
+	t.Run("opened", func(t *testing.T) {
+		stored, err := s.GetUser("alice")
+		if err != nil || stored == nil {
+			t.Fatalf("Failed to get user: %v", err)
+		}
+		if diff := cmp.Diff(user, *stored); diff != "" {
+			t.Errorf("Unexpected user (-want +got):\n%s", diff)
+		}
+
+		storedNamespace, err := s.GetNamespace("team")
+		if err != nil || storedNamespace == nil {
+			t.Fatalf("Failed to get namespace: %v", err)
+		}
+		if diff := cmp.Diff(namespace, *storedNamespace); diff != "" {
+			t.Errorf("Unexpected namespace (-want +got):\n%s", diff)
+		}
+	})
+
+	t.Run("by stream key", func(t *testing.T) {
+		for _, key := range []string{"alice-key", "alice-old-key"} {
+			stored, err := s.GetUserByStreamKey(key)
+			if err != nil || stored == nil || stored.Name != "alice" {
+				t.Errorf("%s: expected alice, got %v, %v", key, stored, err)
+			}
+		}
+
+		if err := s.SetUser(internal.User{Name: "bob", StreamKey: "alice-key"}); !errors.Is(err, internal.ErrStreamKeyTaken) {
+			t.Errorf("Expected ErrStreamKeyTaken, got %v", err)
+		}
+	})
+
+	t.Run("update", func(t *testing.T) {
+		var kept *internal.User
+		err := s.UpdateUser("alice", func(u *internal.User) error {
+			u.StreamKey = "alice-new-key"
+			kept = u
+			return nil
+		})
+		if err != nil {
+			t.Fatalf("Failed to update user: %v", err)
+		}
+
+		if kept.StreamKey != "alice-new-key" {
+			t.Errorf("Updated user should stay open, got %v", kept)
+		}
+		if stored, _ := s.GetUserByStreamKey("alice-new-key"); stored == nil {
+			t.Errorf("Expected alice by the new key")
+		}
+	})
+
+	t.Run("interrupted", func(t *testing.T) {
+		stored := inner.Users["alice"]
+
+		err := s.UpdateUser("alice", func(u *internal.User) error {
+			u.StreamKey = "alice-newer-key"
+			return nil
+		})
+		if err != nil {
+			t.Fatalf("Failed to update user: %v", err)
+		}
+
+		if _, err := s.openUser(stored); err == nil {
+			t.Errorf("Expected the replaced secrets to be dropped")
+		}
+
+		// Both the stored record and the one being written find their
+		// secrets while it is written.
+		k := s.currentKey()
+		sealed, sec := sealUser(k, internal.User{Name: "alice", StreamKey: "alice-newest-key"})
+		err = s.store(k, userKind, "alice", "alice", sec, func() error {
+			for _, u := range []internal.User{inner.Users["alice"], sealed} {
+				if _, err := s.openUser(u); err != nil {
+					t.Errorf("Expected the secrets of %s, got %v", u.StreamKey, err)
+				}
+			}
+			return internal.ErrStreamKeyTaken
+		})
+		if !errors.Is(err, internal.ErrStreamKeyTaken) {
+			t.Errorf("Expected ErrStreamKeyTaken, got %v", err)
+		}
+
+		if stored, err := s.GetUser("alice"); err != nil || stored == nil || stored.StreamKey != "alice-newer-key" {
+			t.Errorf("Failed write should keep the secrets, got %v, %v", stored, err)
+		}
+		if _, err := s.openUser(sealed); err == nil {
+			t.Errorf("Failed write should not keep its secrets")
+		}
+	})
+
+	t.Run("renamed", func(t *testing.T) {
+		err := s.UpdateUser("alice", func(u *internal.User) error {
+			u.Name = "alicia"
+			return nil
+		})
+		if err != nil {
+			t.Fatalf("Failed to rename user: %v", err)
+		}
+
+		if stored, err := s.GetUser("alicia"); err != nil || stored == nil || stored.StreamKey != "alice-newer-key" {
+			t.Errorf("Expected the secrets to move, got %v, %v", stored, err)
+		}
+		if sealed, _ := inner.GetMeta(secretsName(userKind, "alice")); sealed != nil {
+			t.Errorf("Secrets of the old name should be removed")
+		}
+
+		if err := s.DeleteUser("alicia"); err != nil {
+			t.Fatal(err)
+		}
+		if sealed, _ := inner.GetMeta(secretsName(userKind, "alicia")); sealed != nil {
+			t.Errorf("Secrets of a deleted user should be removed")
+		}
+	})
+
+	t.Run("wrong key", func(t *testing.T) {
+		wrong, err := New(inner, newKey(t), nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := wrong.Init(); !errors.Is(err, ErrUnknownKey) {
+			t.Errorf("Expected ErrUnknownKey, got %v", err)
+		}
+	})
+
+	t.Run("no key", func(t *testing.T) {
+		if err := CheckUnencrypted(inner); !errors.Is(err, ErrKeyRequired) {
+			t.Errorf("Expected ErrKeyRequired, got %v", err)
+		}
+		if err := CheckUnencrypted(&memory.Storage{}); err != nil {
+			t.Errorf("Expected no error, got %v", err)
+		}
+	})
+}
+
+func TestDataKeyLookup(t *testing.T) {
+	inner := &memory.Storage{}
+	oldKey, key := newKey(t), newKey(t)
+	s := newStorage(t, inner, key, oldKey)
+
+	// sealElsewhere stores alice as another database would, whose keyring is
+	// wrapped by kek, and returns that keyring.
+	sealElsewhere := func(kek []byte) []byte {
+		other := &memory.Storage{}
+		if err := newStorage(t, other, kek, nil).SetUser(internal.User{Name: "alice", StreamKey: "alice-key"}); err != nil {
+			t.Fatal(err)
+		}
+		if err := inner.SetUser(other.Users["alice"]); err != nil {
+			t.Fatal(err)
+		}
+		sealed, _ := other.GetMeta(secretsName(userKind, "alice"))
+		if err := inner.SetMeta(secretsName(userKind, "alice"), sealed); err != nil {
+			t.Fatal(err)
+		}
+		ring, _ := other.GetMeta(keyringName)
+		return ring
+	}
+
+	t.Run("unknown", func(t *testing.T) {
+		sealElsewhere(key)
+		ring, _ := inner.GetMeta(keyringName)
+
+		if _, err := s.GetUser("alice"); !errors.Is(err, ErrUnknownKey) {
+			t.Errorf("Expected ErrUnknownKey, got %v", err)
+		}
+		if stored, _ := inner.GetMeta(keyringName); !bytes.Equal(stored, ring) {
+			t.Errorf("Reading a record should not change the keyring")
+		}
+	})
+
+	t.Run("reloaded", func(t *testing.T) {
+		ring := sealElsewhere(oldKey)
+		if err := inner.SetMeta(keyringName, ring); err != nil {
+			t.Fatal(err)
+		}
+
+		stored, err := s.GetUser("alice")
+		if err != nil || stored == nil || stored.StreamKey != "alice-key" {
+			t.Errorf("Expected alice, got %v, %v", stored, err)
+		}
+		if stored, _ := inner.GetMeta(keyringName); !bytes.Equal(stored, ring) {
+			t.Errorf("Reading a record should not rotate the keyring")
+		}
+	})
+}
+
+func TestReencrypt(t *testing.T) {
+	t.Run("plain records", func(t *testing.T) {
+		inner := &memory.Storage{}
+		if err := inner.SetUser(internal.User{Name: "alice", StreamKey: "alice-key"}); err != nil {
+			t.Fatal(err)
+		}
+		if err := inner.SetNamespace(internal.Namespace{Name: "team"}); err != nil {
+			t.Fatal(err)
+		}
+
+		s := newStorage(t, inner, newKey(t), nil)
+		if !s.Pending() {
+			t.Errorf("Records should be pending after encryption is enabled")
+		}
+
+		if stored, err := s.GetUserByStreamKey("alice-key"); err != nil || stored == nil {
+			t.Errorf("Plain user should be found before re-encryption, got %v, %v", stored, err)
+		}
+
+		count, err := s.Reencrypt()
+		if err != nil || count != 2 {
+			t.Errorf("Expected 2 records re-encrypted, got %d, %v", count, err)
+		}
+		if s.Pending() {
+			t.Errorf("Nothing should be pending after re-encryption")
+		}
+
+		if sealed, _ := inner.GetMeta(secretsName(userKind, "alice")); sealed == nil || inner.Users["alice"].StreamKey == "alice-key" {
+			t.Errorf("Stored user should be sealed, got %v", inner.Users["alice"])
+		}
+		if stored, err := s.GetUserByStreamKey("alice-key"); err != nil || stored == nil {
+			t.Errorf("Sealed user should be found, got %v, %v", stored, err)
+		}
+
+		if count, err := s.Reencrypt(); err != nil || count != 0 {
+			t.Errorf("Expected nothing re-encrypted, got %d, %v", count, err)
+		}
+	})
+
+	t.Run("rotation", func(t *testing.T) {
+		inner := &memory.Storage{}
+		oldKey, key := newKey(t), newKey(t)
+
+		s := newStorage(t, inner, oldKey, nil)
+		for _, name := range []string{"alice", "bob"} {
+			if err := s.SetUser(internal.User{Name: name, StreamKey: name + "-key"}); err != nil {
+				t.Fatal(err)
+			}
+		}
+		if _, err := s.Reencrypt(); err != nil {
+			t.Fatal(err)
+		}
+
+		if err := newUninitialized(t, inner, key, nil).Init(); !errors.Is(err, ErrUnknownKey) {
+			t.Errorf("Expected ErrUnknownKey without the old key, got %v", err)
+		}
+
+		s = newStorage(t, inner, key, oldKey)
+		if !s.Pending() {
+			t.Errorf("Records should be pending after rotation")
+		}
+		if stored, err := s.GetUserByStreamKey("alice-key"); err != nil || stored == nil {
+			t.Errorf("User sealed with the old data key should be found, got %v, %v", stored, err)
+		}
+
+		count, err := s.Reencrypt()
+		if err != nil || count != 2 {
+			t.Errorf("Expected 2 records re-encrypted, got %d, %v", count, err)
+		}
+
+		s = newStorage(t, inner, key, nil)
+		if s.Pending() {
+			t.Errorf("Nothing should be pending after re-encryption")
+		}
+		for _, name := range []string{"alice", "bob"} {
+			stored, err := s.GetUserByStreamKey(name + "-key")
+			if err != nil || stored == nil || stored.Name != name {
+				t.Errorf("Expected %s without the old key, got %v, %v", name, stored, err)
+			}
+		}
+
+		if err := newUninitialized(t, inner, oldKey, nil).Init(); !errors.Is(err, ErrUnknownKey) {
+			t.Errorf("Expected ErrUnknownKey with the old key, got %v", err)
+		}
+	})
+}
+
+func TestStreamKeyTaken(t *testing.T) {
+	inner := &memory.Storage{}
+	oldKey, key := newKey(t), newKey(t)
+
+	if err := inner.SetUser(internal.User{Name: "carol", StreamKey: "carol-key"}); err != nil {
+		t.Fatal(err)
+	}
+
+	s := newStorage(t, inner, oldKey, nil)
+	if err := s.SetUser(internal.User{Name: "alice", StreamKey: "alice-key", PreviousStreamKey: "alice-old-key"}); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.SetUser(internal.User{Name: "bob", StreamKey: "bob-key"}); err != nil {
+		t.Fatal(err)
+	}
+
+	// Neither alice nor carol is sealed with the rotated data key, so the
+	// index of the wrapped storage does not see their keys.
+	s = newStorage(t, inner, key, oldKey)
+	if !s.Pending() {
+		t.Fatal("Records should be pending after rotation")
+	}
+
+	for _, taken := range []string{"alice-key", "alice-old-key", "carol-key"} {
+		t.Run(taken, func(t *testing.T) {
+			if err := s.SetUser(internal.User{Name: "dave", StreamKey: taken}); !errors.Is(err, internal.ErrStreamKeyTaken) {
+				t.Errorf("Expected ErrStreamKeyTaken setting a user, got %v", err)
+			}
+
+			err := s.UpdateUser("bob", func(u *internal.User) error {
+				u.StreamKey, u.PreviousStreamKey = taken, u.StreamKey
+				return nil
+			})
+			if !errors.Is(err, internal.ErrStreamKeyTaken) {
+				t.Errorf("Expected ErrStreamKeyTaken updating a user, got %v", err)
+			}
+		})
+	}
+
+	t.Run("own keys", func(t *testing.T) {
+		err := s.UpdateUser("alice", func(u *internal.User) error {
+			u.Name, u.StreamKey, u.PreviousStreamKey = "alicia", u.PreviousStreamKey, u.StreamKey
+			return nil
+		})
+		if err != nil {
+			t.Fatalf("Failed to update user: %v", err)
+		}
+
+		if err := s.SetUser(internal.User{Name: "carol", StreamKey: "carol-key", PreviousStreamKey: "carol-old-key"}); err != nil {
+			t.Fatalf("Failed to set user: %v", err)
+		}
+		if stored, err := s.GetUserByStreamKey("alice-key"); err != nil || stored == nil || stored.Name != "alicia" {
+			t.Errorf("Expected alicia by stream key, got %v, %v", stored, err)
+		}
+	})
+}
@@ -0,0 +1,25 @@
+package storage
+
+import "MediaMTXAuth/internal"
+
+// UserNamespaces returns the namespaces GetUsersByNamespace finds u under:
+// those u is a member of or manages.
+func UserNamespaces(u internal.User) []string {
+	namespaces := make([]string, 0, len(u.Memberships)+len(u.Manages))
+	for _, m := range u.Memberships {
+		namespaces = append(namespaces, m.Namespace)
+	}
+	return append(namespaces, u.Manages...)
+}
+
+// UserStreamKeys returns the stream keys GetUserByStreamKey finds u by. The
+// previous key is included until it is replaced, even after its grace period.
+func UserStreamKeys(u internal.User) []string {
+	var keys []string
+	for _, key := range []string{u.StreamKey, u.PreviousStreamKey} {
+		if key != "" {
+			keys = append(keys, key)
+		}
+	}
+	return keys
+}
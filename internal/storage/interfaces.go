@@ -2,9 +2,14 @@ package storage
 
 import (
 	"MediaMTXAuth/internal"
+	"errors"
 	"io"
 )
 
+// ErrInvalidSnapshot is returned by Backuper.Restore when the snapshot is not
+// a usable database.
+var ErrInvalidSnapshot = errors.New("invalid snapshot")
+
 type Storage interface {
 	io.Closer
 	Init() error
@@ -13,9 +18,66 @@ type Storage interface {
 	GetUser(string) (*internal.User, error)
 	GetAllUsers() ([]internal.User, error)
 	DeleteUser(string) error
+	// UpdateUser loads the user called name, passes it to update and stores
+	// the result, all in one transaction. Nothing is stored if update fails.
+	// It returns internal.ErrUserNotFound if there is no such user. If update
+	// changes the name, the user is moved, unless the new name is taken, in
+	// which case it returns internal.ErrUserAlreadyExists.
+	UpdateUser(name string, update func(*internal.User) error) error
+	// GetUsersByNamespace returns the users that are members of namespace or
+	// manage it, sorted by name.
+	GetUsersByNamespace(namespace string) ([]internal.User, error)
+	// GetUserByStreamKey returns the user whose current or previous stream
+	// key is key, or nil if there is none. Stream keys are unique: SetUser
+	// and UpdateUser return internal.ErrStreamKeyTaken for a key another
+	// user has.
+	GetUserByStreamKey(key string) (*internal.User, error)
+	// HasAdmin reports whether any user is an admin.
+	HasAdmin() (bool, error)
+	// ListUsers returns the users in r, sorted by name.
+	ListUsers(r Range) ([]internal.User, error)
 
 	SetNamespace(internal.Namespace) error
 	GetNamespace(string) (*internal.Namespace, error)
 	GetAllNamespaces() ([]internal.Namespace, error)
 	DeleteNamespace(string) error
+	// UpdateNamespace is like UpdateUser for namespaces.
+	UpdateNamespace(name string, update func(*internal.Namespace) error) error
+	// ListNamespaces returns the namespaces in r, sorted by name.
+	ListNamespaces(r Range) ([]internal.Namespace, error)
+}
+
+// Migrator is implemented by storages that version their records and migrate
+// them in Init.
+type Migrator interface {
+	// PlanMigrations returns the schema version of the stored records and
+	// the migrations Init would apply. The migrations are checked against
+	// the stored data, but nothing is changed.
+	PlanMigrations() (version int, pending []string, err error)
+}
+
+// MetaStore is implemented by storages that keep small named values next to
+// the records, such as the keys of encrypted fields.
+type MetaStore interface {
+	// GetMeta returns the value called name, or nil if it is not set.
+	GetMeta(name string) ([]byte, error)
+	// SetMeta sets the value called name. A nil value removes it.
+	SetMeta(name string, value []byte) error
+}
+
+// Backuper is implemented by storages that can be backed up while in use.
+type Backuper interface {
+	// Backup writes a consistent snapshot of the database to w.
+	Backup(w io.Writer) (int64, error)
+	// Restore checks the snapshot read from r and replaces the database
+	// with it. The current data is kept if the snapshot is invalid.
+	Restore(r io.Reader) error
+}
+
+// CheckedRestorer is implemented by Backupers that can check a snapshot with a
+// check of the caller before Restore swaps it in.
+type CheckedRestorer interface {
+	// RestoreChecked is like Restore, but also refuses the snapshot if
+	// check returns an error for it.
+	RestoreChecked(r io.Reader, check func(Storage) error) error
 }
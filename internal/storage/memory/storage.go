@@ -2,11 +2,31 @@ package memory
 
 import (
 	"MediaMTXAuth/internal"
+	"MediaMTXAuth/internal/storage"
+	"encoding/json"
+	"maps"
+	"slices"
+	"sync"
 )
 
+// Storage keeps everything in memory, which makes it suitable for tests and
+// ephemeral deployments (--db :memory:). It is safe for concurrent use. Values
+// are copied on the way in and out, so callers never share slices with the
+// stored records.
 type Storage struct {
 	Users      map[string]internal.User
 	Namespaces map[string]internal.Namespace
+
+	// usersByNamespace, usersByStreamKey and admins index Users by name.
+	// Every method changing Users keeps them in step, and Init builds them
+	// for Users filled in directly.
+	usersByNamespace map[string]map[string]bool
+	usersByStreamKey map[string]string
+	admins           map[string]bool
+
+	meta map[string][]byte
+
+	mu sync.RWMutex
 }
 
 func (s *Storage) Close() error {
@@ -18,6 +38,9 @@ func (s *Storage) Init() error {
 		return nil
 	}
 
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
 	if s.Users == nil {
 		s.Users = make(map[string]internal.User)
 	}
@@ -26,31 +49,81 @@ func (s *Storage) Init() error {
 		s.Namespaces = make(map[string]internal.Namespace)
 	}
 
+	// Users may have been filled in directly, so the indexes are rebuilt.
+	clear(s.usersByNamespace)
+	clear(s.usersByStreamKey)
+	clear(s.admins)
+	for _, user := range s.Users {
+		if err := s.reindexUser(nil, &user); err != nil {
+			return err
+		}
+	}
+
 	return nil
 }
 
 func (s *Storage) SetUser(u internal.User) error {
 	if s != nil {
+		u, err := clone(u)
+		if err != nil {
+			return err
+		}
+
+		s.mu.Lock()
+		defer s.mu.Unlock()
+
 		if s.Users == nil {
 			s.Users = make(map[string]internal.User)
 		}
 
+		var old *internal.User
+		if stored, ok := s.Users[u.Name]; ok {
+			old = &stored
+		}
+
+		if err := s.reindexUser(old, &u); err != nil {
+			return err
+		}
+
 		s.Users[u.Name] = u
 	}
 	return nil
 }
 
 func (s *Storage) GetUser(name string) (*internal.User, error) {
-	if s != nil && s.Users != nil {
+	if s != nil {
+		s.mu.RLock()
+		defer s.mu.RUnlock()
+
 		if u, ok := s.Users[name]; ok {
-			return &u, nil
+			u, err := clone(u)
+			return &u, err
 		}
 	}
 	return nil, nil
 }
 
+func (s *Storage) UpdateUser(name string, update func(*internal.User) error) error {
+	if s == nil {
+		return internal.ErrUserNotFound
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return updateValue(s.Users, name, update, s.reindexUser, internal.ErrUserNotFound, internal.ErrUserAlreadyExists)
+}
+
 func (s *Storage) SetNamespace(n internal.Namespace) error {
 	if s != nil {
+		n, err := clone(n)
+		if err != nil {
+			return err
+		}
+
+		s.mu.Lock()
+		defer s.mu.Unlock()
+
 		if s.Namespaces == nil {
 			s.Namespaces = make(map[string]internal.Namespace)
 		}
@@ -61,55 +134,329 @@ func (s *Storage) SetNamespace(n internal.Namespace) error {
 }
 
 func (s *Storage) GetNamespace(name string) (*internal.Namespace, error) {
-	if s != nil && s.Namespaces != nil {
+	if s != nil {
+		s.mu.RLock()
+		defer s.mu.RUnlock()
+
 		if n, ok := s.Namespaces[name]; ok {
-			return &n, nil
+			n, err := clone(n)
+			return &n, err
 		}
 	}
 	return nil, nil
 }
 
+func (s *Storage) UpdateNamespace(name string, update func(*internal.Namespace) error) error {
+	if s == nil {
+		return internal.ErrNamespaceNotFound
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return updateValue(s.Namespaces, name, update, nil, internal.ErrNamespaceNotFound, internal.ErrNamespaceAlreadyExists)
+}
+
 func (s *Storage) DeleteUser(name string) error {
-	if s != nil && s.Users != nil {
+	if s != nil {
+		s.mu.Lock()
+		defer s.mu.Unlock()
+
+		if stored, ok := s.Users[name]; ok {
+			_ = s.reindexUser(&stored, nil)
+		}
+
 		delete(s.Users, name)
 	}
 	return nil
 }
 
 func (s *Storage) DeleteNamespace(name string) error {
-	if s != nil && s.Namespaces != nil {
+	if s != nil {
+		s.mu.Lock()
+		defer s.mu.Unlock()
+
 		delete(s.Namespaces, name)
 	}
 	return nil
 }
 
+func (s *Storage) GetMeta(name string) ([]byte, error) {
+	if s == nil {
+		return nil, nil
+	}
+
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	return slices.Clone(s.meta[name]), nil
+}
+
+func (s *Storage) SetMeta(name string, value []byte) error {
+	if s != nil {
+		s.mu.Lock()
+		defer s.mu.Unlock()
+
+		if value == nil {
+			delete(s.meta, name)
+			return nil
+		}
+
+		if s.meta == nil {
+			s.meta = make(map[string][]byte)
+		}
+		s.meta[name] = slices.Clone(value)
+	}
+	return nil
+}
+
 func (s *Storage) Clear() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
 	clear(s.Users)
 	clear(s.Namespaces)
+	clear(s.usersByNamespace)
+	clear(s.usersByStreamKey)
+	clear(s.admins)
+	clear(s.meta)
 }
 
 func (s *Storage) GetAllUsers() ([]internal.User, error) {
-	if s == nil || s.Users == nil {
+	if s == nil {
 		return []internal.User{}, nil
 	}
 
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
 	users := make([]internal.User, 0, len(s.Users))
-	for _, user := range s.Users {
-		users = append(users, user)
+	for _, name := range slices.Sorted(maps.Keys(s.Users)) {
+		v, err := clone(s.Users[name])
+		if err != nil {
+			return nil, err
+		}
+		users = append(users, v)
+	}
+
+	return users, nil
+}
+
+func (s *Storage) GetUsersByNamespace(namespace string) ([]internal.User, error) {
+	if s == nil {
+		return []internal.User{}, nil
+	}
+
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	names := slices.Sorted(maps.Keys(s.usersByNamespace[namespace]))
+	users := make([]internal.User, 0, len(names))
+	for _, name := range names {
+		v, err := clone(s.Users[name])
+		if err != nil {
+			return nil, err
+		}
+		users = append(users, v)
 	}
 
 	return users, nil
 }
 
+func (s *Storage) GetUserByStreamKey(key string) (*internal.User, error) {
+	if s != nil {
+		s.mu.RLock()
+		defer s.mu.RUnlock()
+
+		if name, ok := s.usersByStreamKey[key]; ok {
+			u, err := clone(s.Users[name])
+			return &u, err
+		}
+	}
+	return nil, nil
+}
+
+func (s *Storage) HasAdmin() (bool, error) {
+	if s == nil {
+		return false, nil
+	}
+
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	return len(s.admins) > 0, nil
+}
+
 func (s *Storage) GetAllNamespaces() ([]internal.Namespace, error) {
-	if s == nil || s.Namespaces == nil {
+	if s == nil {
 		return []internal.Namespace{}, nil
 	}
 
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
 	namespaces := make([]internal.Namespace, 0, len(s.Namespaces))
-	for _, namespace := range s.Namespaces {
-		namespaces = append(namespaces, namespace)
+	for _, name := range slices.Sorted(maps.Keys(s.Namespaces)) {
+		v, err := clone(s.Namespaces[name])
+		if err != nil {
+			return nil, err
+		}
+		namespaces = append(namespaces, v)
 	}
 
 	return namespaces, nil
 }
+
+// reindexUser moves a user from the index entries of old to those of new,
+// either of which is nil when the user is created or deleted. It fails without
+// changing anything if new has a stream key of another user. The caller must
+// hold the lock.
+func (s *Storage) reindexUser(old, new *internal.User) error {
+	if new != nil {
+		for _, key := range storage.UserStreamKeys(*new) {
+			owner, ok := s.usersByStreamKey[key]
+			if ok && owner != new.Name && (old == nil || owner != old.Name) {
+				return internal.ErrStreamKeyTaken
+			}
+		}
+	}
+
+	if old != nil {
+		for _, namespace := range storage.UserNamespaces(*old) {
+			delete(s.usersByNamespace[namespace], old.Name)
+			if len(s.usersByNamespace[namespace]) == 0 {
+				delete(s.usersByNamespace, namespace)
+			}
+		}
+		for _, key := range storage.UserStreamKeys(*old) {
+			delete(s.usersByStreamKey, key)
+		}
+		delete(s.admins, old.Name)
+	}
+
+	if new != nil {
+		if s.usersByNamespace == nil {
+			s.usersByNamespace = make(map[string]map[string]bool)
+		}
+		if s.usersByStreamKey == nil {
+			s.usersByStreamKey = make(map[string]string)
+		}
+		if s.admins == nil {
+			s.admins = make(map[string]bool)
+		}
+
+		for _, namespace := range storage.UserNamespaces(*new) {
+			if s.usersByNamespace[namespace] == nil {
+				s.usersByNamespace[namespace] = make(map[string]bool)
+			}
+			s.usersByNamespace[namespace][new.Name] = true
+		}
+		for _, key := range storage.UserStreamKeys(*new) {
+			s.usersByStreamKey[key] = new.Name
+		}
+		if new.IsAdmin {
+			s.admins[new.Name] = true
+		}
+	}
+
+	return nil
+}
+
+// updateValue applies update to a copy of values[name] and stores it if
+// update succeeds. reindex, if not nil, updates the indexes for the change and
+// can refuse it. The caller must hold the lock.
+func updateValue[T internal.WithID](values map[string]T, name string, update func(*T) error, reindex func(old, new *T) error, notFound, exists error) error {
+	stored, ok := values[name]
+	if !ok {
+		return notFound
+	}
+
+	// Copy the slices too, so that a failed update leaves no trace.
+	v, err := clone(stored)
+	if err != nil {
+		return err
+	}
+
+	if err := update(&v); err != nil {
+		return err
+	}
+
+	if id := v.GetID(); id != name {
+		if _, ok := values[id]; ok {
+			return exists
+		}
+	}
+
+	if reindex != nil {
+		if err := reindex(&stored, &v); err != nil {
+			return err
+		}
+	}
+
+	delete(values, name)
+	values[v.GetID()] = v
+	return nil
+}
+
+func (s *Storage) ListUsers(r storage.Range) ([]internal.User, error) {
+	if s == nil {
+		return []internal.User{}, nil
+	}
+
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	return list(s.Users, r)
+}
+
+func (s *Storage) ListNamespaces(r storage.Range) ([]internal.Namespace, error) {
+	if s == nil {
+		return []internal.Namespace{}, nil
+	}
+
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	return list(s.Namespaces, r)
+}
+
+// list returns copies of the values whose names are in r. The caller must
+// hold the lock.
+func list[T any](values map[string]T, r storage.Range) ([]T, error) {
+	names := slices.Sorted(maps.Keys(values))
+	if r.Desc {
+		slices.Reverse(names)
+	}
+
+	selected := []T{}
+	for _, name := range names {
+		if r.Full(len(selected)) {
+			break
+		}
+		if !r.Match(name) {
+			continue
+		}
+
+		v, err := clone(values[name])
+		if err != nil {
+			return nil, err
+		}
+		selected = append(selected, v)
+	}
+
+	return selected, nil
+}
+
+// clone returns a deep copy of v, made the same way the bolt backend stores
+// values. This also drops fields that are not stored, like User.Session.
+func clone[T any](v T) (T, error) {
+	var copied T
+
+	data, err := json.Marshal(&v)
+	if err != nil {
+		return copied, err
+	}
+
+	err = json.Unmarshal(data, &copied)
+	return copied, err
+}
@@ -1,6 +1,7 @@
 package memory
 
 import (
+	"MediaMTXAuth/internal"
 	"MediaMTXAuth/internal/storage"
 	"testing"
 )
@@ -12,3 +13,28 @@ func TestStorage(t *testing.T) {
 
 	storage.XTestStorage(t, s)
 }
+
+func TestClear(t *testing.T) {
+	s := &Storage{}
+	if err := s.Init(); err != nil {
+		t.Fatal(err)
+	}
+
+	_ = s.SetUser(internal.User{Name: "admin", StreamKey: "key", IsAdmin: true})
+	_ = s.SetMeta("name", []byte("value"))
+
+	s.Clear()
+
+	if users, _ := s.GetAllUsers(); len(users) != 0 {
+		t.Errorf("Expected no users, got %v", users)
+	}
+	if user, _ := s.GetUserByStreamKey("key"); user != nil {
+		t.Errorf("Expected no user by stream key, got %v", user)
+	}
+	if found, _ := s.HasAdmin(); found {
+		t.Errorf("Expected no admin")
+	}
+	if value, _ := s.GetMeta("name"); value != nil {
+		t.Errorf("Expected no meta value, got %q", value)
+	}
+}
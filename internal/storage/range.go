@@ -0,0 +1,39 @@
+package storage
+
+import "strings"
+
+// Range selects records by name for ListUsers and ListNamespaces. Names are
+// compared byte by byte, so a page can continue where the last one ended.
+type Range struct {
+	// Prefix keeps names starting with it.
+	Prefix string
+	// Contains keeps names containing it, ignoring case.
+	Contains string
+	// After skips names up to and including it, or from it on if Desc is
+	// set. It is ignored if empty.
+	After string
+	Desc  bool
+	// Limit is the most records returned, all if zero.
+	Limit int
+}
+
+// Match reports whether name is selected by r, leaving out the limit.
+func (r Range) Match(name string) bool {
+	if !strings.HasPrefix(name, r.Prefix) || !strings.Contains(strings.ToLower(name), strings.ToLower(r.Contains)) {
+		return false
+	}
+
+	switch {
+	case r.After == "":
+		return true
+	case r.Desc:
+		return name < r.After
+	default:
+		return name > r.After
+	}
+}
+
+// Full reports whether n records fill the limit.
+func (r Range) Full(n int) bool {
+	return r.Limit > 0 && n >= r.Limit
+}
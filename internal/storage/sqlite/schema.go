@@ -0,0 +1,126 @@
+package sqlite
+
+// schema creates the tables if they do not exist yet. Lists that belong to a
+// user or namespace are stored in their own tables, with a position column
+// keeping their order, and are removed along with their owner. Flags added
+// later, such as FromConfig, get a table listing the marked records, so that
+// existing databases need no new columns.
+const schema = `
+CREATE TABLE IF NOT EXISTS users (
+	name                      TEXT PRIMARY KEY,
+	stream_key                TEXT NOT NULL,
+	is_admin                  INTEGER NOT NULL,
+	password_hash             TEXT NOT NULL,
+	password_is_generated     INTEGER NOT NULL,
+	login_failures            INTEGER NOT NULL,
+	last_login_failure        TEXT NOT NULL,
+	locked_until              TEXT NOT NULL,
+	reset_token_hash          TEXT NOT NULL,
+	reset_created_by          TEXT NOT NULL,
+	reset_created             TEXT NOT NULL,
+	reset_expiration          TEXT NOT NULL,
+	suspension_reason         TEXT NOT NULL,
+	suspended_by              TEXT NOT NULL,
+	suspended_since           TEXT NOT NULL,
+	suspended_until           TEXT NOT NULL,
+	expires_at                TEXT NOT NULL,
+	previous_stream_key       TEXT NOT NULL,
+	previous_stream_key_until TEXT NOT NULL
+);
+
+CREATE INDEX IF NOT EXISTS users_stream_key ON users (stream_key);
+CREATE INDEX IF NOT EXISTS users_previous_stream_key ON users (previous_stream_key);
+CREATE INDEX IF NOT EXISTS users_admins ON users (name) WHERE is_admin;
+
+CREATE TABLE IF NOT EXISTS user_sessions (
+	user            TEXT NOT NULL REFERENCES users(name) ON DELETE CASCADE,
+	position        INTEGER NOT NULL,
+	id              INTEGER NOT NULL,
+	created         TEXT NOT NULL,
+	expiration      TEXT NOT NULL,
+	impersonated_by TEXT NOT NULL,
+	PRIMARY KEY (user, position)
+);
+
+CREATE TABLE IF NOT EXISTS memberships (
+	user       TEXT NOT NULL REFERENCES users(name) ON DELETE CASCADE,
+	position   INTEGER NOT NULL,
+	namespace  TEXT NOT NULL,
+	permission TEXT NOT NULL,
+	PRIMARY KEY (user, position)
+);
+
+CREATE INDEX IF NOT EXISTS memberships_namespace ON memberships (namespace);
+
+CREATE TABLE IF NOT EXISTS managed_namespaces (
+	user      TEXT NOT NULL REFERENCES users(name) ON DELETE CASCADE,
+	position  INTEGER NOT NULL,
+	namespace TEXT NOT NULL,
+	PRIMARY KEY (user, position)
+);
+
+CREATE INDEX IF NOT EXISTS managed_namespaces_namespace ON managed_namespaces (namespace);
+
+CREATE TABLE IF NOT EXISTS user_history (
+	user     TEXT NOT NULL REFERENCES users(name) ON DELETE CASCADE,
+	position INTEGER NOT NULL,
+	time     TEXT NOT NULL,
+	action   TEXT NOT NULL,
+	by_user  TEXT NOT NULL,
+	PRIMARY KEY (user, position)
+);
+
+CREATE TABLE IF NOT EXISTS api_tokens (
+	user       TEXT NOT NULL REFERENCES users(name) ON DELETE CASCADE,
+	position   INTEGER NOT NULL,
+	id         TEXT NOT NULL,
+	name       TEXT NOT NULL,
+	token_hash TEXT NOT NULL,
+	scope      TEXT NOT NULL,
+	created    TEXT NOT NULL,
+	expiration TEXT NOT NULL,
+	last_used  TEXT NOT NULL,
+	PRIMARY KEY (user, position)
+);
+
+CREATE TABLE IF NOT EXISTS config_users (
+	user TEXT PRIMARY KEY REFERENCES users(name) ON DELETE CASCADE
+);
+
+CREATE TABLE IF NOT EXISTS namespaces (
+	name TEXT PRIMARY KEY
+);
+
+CREATE TABLE IF NOT EXISTS namespace_sessions (
+	namespace   TEXT NOT NULL REFERENCES namespaces(name) ON DELETE CASCADE,
+	position    INTEGER NOT NULL,
+	session_key TEXT NOT NULL,
+	name        TEXT NOT NULL,
+	user        TEXT NOT NULL,
+	created     TEXT NOT NULL,
+	PRIMARY KEY (namespace, position)
+);
+
+CREATE TABLE IF NOT EXISTS namespace_invitations (
+	namespace  TEXT NOT NULL REFERENCES namespaces(name) ON DELETE CASCADE,
+	position   INTEGER NOT NULL,
+	id         TEXT NOT NULL,
+	token_hash TEXT NOT NULL,
+	role       TEXT NOT NULL,
+	max_uses   INTEGER NOT NULL,
+	uses       INTEGER NOT NULL,
+	created_by TEXT NOT NULL,
+	created    TEXT NOT NULL,
+	expiration TEXT NOT NULL,
+	PRIMARY KEY (namespace, position)
+);
+
+CREATE TABLE IF NOT EXISTS config_namespaces (
+	namespace TEXT PRIMARY KEY REFERENCES namespaces(name) ON DELETE CASCADE
+);
+
+CREATE TABLE IF NOT EXISTS meta (
+	name  TEXT PRIMARY KEY,
+	value BLOB NOT NULL
+);
+`
@@ -0,0 +1,662 @@
+package sqlite
+
+import (
+	"MediaMTXAuth/internal"
+	"MediaMTXAuth/internal/storage"
+	"database/sql"
+	"database/sql/driver"
+	"errors"
+	"fmt"
+	"net/url"
+	"time"
+
+	_ "modernc.org/sqlite"
+)
+
+type sqliteStorage struct {
+	DB *sql.DB
+}
+
+// New opens the SQLite database at path, creating it if needed. Unlike bolt,
+// several processes can use the same file, and the data can be inspected with
+// the sqlite3 command line tool.
+func New(path string) (storage.Storage, error) {
+	params := url.Values{}
+	params.Add("_pragma", "foreign_keys(1)")
+	params.Add("_pragma", "busy_timeout(5000)")
+	params.Add("_pragma", "journal_mode(WAL)")
+	params.Add("_pragma", "synchronous(NORMAL)")
+	// Take the write lock when a transaction starts, so that concurrent
+	// updates wait for each other instead of failing to upgrade their lock.
+	params.Set("_txlock", "immediate")
+
+	db, err := sql.Open("sqlite", "file:"+path+"?"+params.Encode())
+	if err != nil {
+		return nil, err
+	}
+
+	// A single connection makes writers in this process queue up in Go
+	// rather than wait on the file lock. Other processes still rely on the
+	// busy timeout.
+	db.SetMaxOpenConns(1)
+
+	if err := db.Ping(); err != nil {
+		db.Close()
+		return nil, err
+	}
+
+	return &sqliteStorage{DB: db}, nil
+}
+
+func (s *sqliteStorage) Close() error {
+	return s.DB.Close()
+}
+
+func (s *sqliteStorage) Init() error {
+	_, err := s.DB.Exec(schema)
+	return err
+}
+
+func (s *sqliteStorage) SetUser(u internal.User) error {
+	return s.transaction(func(tx *sql.Tx) error {
+		if err := deleteUser(tx, u.Name); err != nil {
+			return err
+		}
+		return insertUser(tx, u)
+	})
+}
+
+func (s *sqliteStorage) GetUser(name string) (user *internal.User, err error) {
+	err = s.transaction(func(tx *sql.Tx) error {
+		user, err = getUser(tx, name)
+		return err
+	})
+	return
+}
+
+func (s *sqliteStorage) GetAllUsers() (users []internal.User, err error) {
+	users = []internal.User{}
+	err = s.transaction(func(tx *sql.Tx) error {
+		names, err := queryNames(tx, "SELECT name FROM users ORDER BY name")
+		if err != nil {
+			return err
+		}
+
+		for _, name := range names {
+			user, err := getUser(tx, name)
+			if err != nil {
+				return err
+			}
+			users = append(users, *user)
+		}
+
+		return nil
+	})
+	return
+}
+
+func (s *sqliteStorage) GetUsersByNamespace(namespace string) (users []internal.User, err error) {
+	users = []internal.User{}
+	err = s.transaction(func(tx *sql.Tx) error {
+		var names []string
+		err := queryList(tx, &names, `SELECT user FROM memberships WHERE namespace = ?1
+			UNION SELECT user FROM managed_namespaces WHERE namespace = ?1 ORDER BY user`, namespace, func(name *string) []any {
+			return []any{name}
+		})
+		if err != nil {
+			return err
+		}
+
+		for _, name := range names {
+			user, err := getUser(tx, name)
+			if err != nil {
+				return err
+			}
+			users = append(users, *user)
+		}
+
+		return nil
+	})
+	return
+}
+
+func (s *sqliteStorage) GetUserByStreamKey(key string) (user *internal.User, err error) {
+	if key == "" {
+		return nil, nil
+	}
+
+	err = s.transaction(func(tx *sql.Tx) error {
+		var name string
+		err := tx.QueryRow(`SELECT name FROM users WHERE stream_key = ?1 OR previous_stream_key = ?1`, key).Scan(&name)
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+
+		user, err = getUser(tx, name)
+		return err
+	})
+	return
+}
+
+func (s *sqliteStorage) HasAdmin() (found bool, err error) {
+	err = s.transaction(func(tx *sql.Tx) error {
+		return tx.QueryRow(`SELECT EXISTS (SELECT 1 FROM users WHERE is_admin)`).Scan(&found)
+	})
+	return
+}
+
+func (s *sqliteStorage) ListUsers(r storage.Range) (users []internal.User, err error) {
+	users = []internal.User{}
+	err = s.transaction(func(tx *sql.Tx) error {
+		names, err := queryRange(tx, "users", r)
+		if err != nil {
+			return err
+		}
+
+		for _, name := range names {
+			user, err := getUser(tx, name)
+			if err != nil {
+				return err
+			}
+			users = append(users, *user)
+		}
+
+		return nil
+	})
+	return
+}
+
+func (s *sqliteStorage) DeleteUser(name string) error {
+	return s.transaction(func(tx *sql.Tx) error {
+		return deleteUser(tx, name)
+	})
+}
+
+func (s *sqliteStorage) UpdateUser(name string, update func(*internal.User) error) error {
+	return s.transaction(func(tx *sql.Tx) error {
+		user, err := getUser(tx, name)
+		if err != nil {
+			return err
+		}
+		if user == nil {
+			return internal.ErrUserNotFound
+		}
+
+		if err := update(user); err != nil {
+			return err
+		}
+
+		if user.Name != name {
+			existing, err := getUser(tx, user.Name)
+			if err != nil {
+				return err
+			}
+			if existing != nil {
+				return internal.ErrUserAlreadyExists
+			}
+		}
+
+		if err := deleteUser(tx, name); err != nil {
+			return err
+		}
+		return insertUser(tx, *user)
+	})
+}
+
+func (s *sqliteStorage) GetMeta(name string) (value []byte, err error) {
+	err = s.transaction(func(tx *sql.Tx) error {
+		err := tx.QueryRow(`SELECT value FROM meta WHERE name = ?`, name).Scan(&value)
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil
+		}
+		return err
+	})
+	return
+}
+
+func (s *sqliteStorage) SetMeta(name string, value []byte) error {
+	return s.transaction(func(tx *sql.Tx) error {
+		if value == nil {
+			_, err := tx.Exec(`DELETE FROM meta WHERE name = ?`, name)
+			return err
+		}
+
+		_, err := tx.Exec(`INSERT OR REPLACE INTO meta VALUES (?, ?)`, name, value)
+		return err
+	})
+}
+
+func (s *sqliteStorage) SetNamespace(n internal.Namespace) error {
+	return s.transaction(func(tx *sql.Tx) error {
+		if err := deleteNamespace(tx, n.Name); err != nil {
+			return err
+		}
+		return insertNamespace(tx, n)
+	})
+}
+
+func (s *sqliteStorage) GetNamespace(name string) (namespace *internal.Namespace, err error) {
+	err = s.transaction(func(tx *sql.Tx) error {
+		namespace, err = getNamespace(tx, name)
+		return err
+	})
+	return
+}
+
+func (s *sqliteStorage) GetAllNamespaces() (namespaces []internal.Namespace, err error) {
+	namespaces = []internal.Namespace{}
+	err = s.transaction(func(tx *sql.Tx) error {
+		names, err := queryNames(tx, "SELECT name FROM namespaces ORDER BY name")
+		if err != nil {
+			return err
+		}
+
+		for _, name := range names {
+			namespace, err := getNamespace(tx, name)
+			if err != nil {
+				return err
+			}
+			namespaces = append(namespaces, *namespace)
+		}
+
+		return nil
+	})
+	return
+}
+
+func (s *sqliteStorage) ListNamespaces(r storage.Range) (namespaces []internal.Namespace, err error) {
+	namespaces = []internal.Namespace{}
+	err = s.transaction(func(tx *sql.Tx) error {
+		names, err := queryRange(tx, "namespaces", r)
+		if err != nil {
+			return err
+		}
+
+		for _, name := range names {
+			namespace, err := getNamespace(tx, name)
+			if err != nil {
+				return err
+			}
+			namespaces = append(namespaces, *namespace)
+		}
+
+		return nil
+	})
+	return
+}
+
+func (s *sqliteStorage) DeleteNamespace(name string) error {
+	return s.transaction(func(tx *sql.Tx) error {
+		return deleteNamespace(tx, name)
+	})
+}
+
+func (s *sqliteStorage) UpdateNamespace(name string, update func(*internal.Namespace) error) error {
+	return s.transaction(func(tx *sql.Tx) error {
+		namespace, err := getNamespace(tx, name)
+		if err != nil {
+			return err
+		}
+		if namespace == nil {
+			return internal.ErrNamespaceNotFound
+		}
+
+		if err := update(namespace); err != nil {
+			return err
+		}
+
+		if namespace.Name != name {
+			existing, err := getNamespace(tx, namespace.Name)
+			if err != nil {
+				return err
+			}
+			if existing != nil {
+				return internal.ErrNamespaceAlreadyExists
+			}
+		}
+
+		if err := deleteNamespace(tx, name); err != nil {
+			return err
+		}
+		return insertNamespace(tx, *namespace)
+	})
+}
+
+// transaction runs fn in a transaction, which is committed if fn succeeds and
+// rolled back otherwise.
+func (s *sqliteStorage) transaction(fn func(*sql.Tx) error) error {
+	tx, err := s.DB.Begin()
+	if err != nil {
+		return err
+	}
+
+	if err := fn(tx); err != nil {
+		tx.Rollback()
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// insertUser stores u, which must not exist. It fails with
+// internal.ErrStreamKeyTaken if another user has one of its stream keys.
+func insertUser(tx *sql.Tx, u internal.User) error {
+	for _, key := range storage.UserStreamKeys(u) {
+		var taken bool
+		err := tx.QueryRow(`SELECT EXISTS(SELECT 1 FROM users WHERE stream_key = ?1 OR previous_stream_key = ?1)`, key).Scan(&taken)
+		if err != nil {
+			return err
+		}
+		if taken {
+			return internal.ErrStreamKeyTaken
+		}
+	}
+
+	_, err := tx.Exec(`INSERT INTO users VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`,
+		u.Name, u.StreamKey, u.IsAdmin,
+		u.Password.Hash, u.Password.IsGenerated,
+		u.LoginAttempts.Failures, timestamp(u.LoginAttempts.LastFailure), timestamp(u.LoginAttempts.LockedUntil),
+		u.PasswordReset.TokenHash, u.PasswordReset.CreatedBy, timestamp(u.PasswordReset.Created), timestamp(u.PasswordReset.Expiration),
+		u.Suspension.Reason, u.Suspension.By, timestamp(u.Suspension.Since), timestamp(u.Suspension.Until),
+		timestamp(u.ExpiresAt),
+		u.PreviousStreamKey, timestamp(u.PreviousStreamKeyUntil),
+	)
+	if err != nil {
+		return err
+	}
+
+	if u.FromConfig {
+		if _, err := tx.Exec(`INSERT INTO config_users VALUES (?)`, u.Name); err != nil {
+			return err
+		}
+	}
+
+	for i, session := range u.Sessions {
+		_, err := tx.Exec(`INSERT INTO user_sessions VALUES (?, ?, ?, ?, ?, ?)`,
+			u.Name, i, int64(session.ID), timestamp(session.Created), timestamp(session.Expiration), session.ImpersonatedBy)
+		if err != nil {
+			return err
+		}
+	}
+
+	for i, membership := range u.Memberships {
+		_, err := tx.Exec(`INSERT INTO memberships VALUES (?, ?, ?, ?)`,
+			u.Name, i, membership.Namespace, membership.Permission)
+		if err != nil {
+			return err
+		}
+	}
+
+	for i, namespace := range u.Manages {
+		_, err := tx.Exec(`INSERT INTO managed_namespaces VALUES (?, ?, ?)`, u.Name, i, namespace)
+		if err != nil {
+			return err
+		}
+	}
+
+	for i, entry := range u.History {
+		_, err := tx.Exec(`INSERT INTO user_history VALUES (?, ?, ?, ?, ?)`,
+			u.Name, i, timestamp(entry.Time), entry.Action, entry.By)
+		if err != nil {
+			return err
+		}
+	}
+
+	for i, token := range u.APITokens {
+		_, err := tx.Exec(`INSERT INTO api_tokens VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?)`,
+			u.Name, i, token.ID, token.Name, token.TokenHash, token.Scope,
+			timestamp(token.Created), timestamp(token.Expiration), timestamp(token.LastUsed))
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func getUser(tx *sql.Tx, name string) (*internal.User, error) {
+	var u internal.User
+
+	err := tx.QueryRow(`SELECT * FROM users WHERE name = ?`, name).Scan(
+		&u.Name, &u.StreamKey, &u.IsAdmin,
+		&u.Password.Hash, &u.Password.IsGenerated,
+		&u.LoginAttempts.Failures, (*timestamp)(&u.LoginAttempts.LastFailure), (*timestamp)(&u.LoginAttempts.LockedUntil),
+		&u.PasswordReset.TokenHash, &u.PasswordReset.CreatedBy, (*timestamp)(&u.PasswordReset.Created), (*timestamp)(&u.PasswordReset.Expiration),
+		&u.Suspension.Reason, &u.Suspension.By, (*timestamp)(&u.Suspension.Since), (*timestamp)(&u.Suspension.Until),
+		(*timestamp)(&u.ExpiresAt),
+		&u.PreviousStreamKey, (*timestamp)(&u.PreviousStreamKeyUntil),
+	)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+
+	err = tx.QueryRow(`SELECT EXISTS (SELECT 1 FROM config_users WHERE user = ?)`, name).Scan(&u.FromConfig)
+	if err != nil {
+		return nil, err
+	}
+
+	err = queryList(tx, &u.Sessions, `SELECT id, created, expiration, impersonated_by FROM user_sessions WHERE user = ? ORDER BY position`, name,
+		func(s *internal.UserSession) []any {
+			return []any{(*sessionID)(&s.ID), (*timestamp)(&s.Created), (*timestamp)(&s.Expiration), &s.ImpersonatedBy}
+		})
+	if err != nil {
+		return nil, err
+	}
+
+	err = queryList(tx, &u.Memberships, `SELECT namespace, permission FROM memberships WHERE user = ? ORDER BY position`, name,
+		func(m *internal.Membership) []any {
+			return []any{&m.Namespace, &m.Permission}
+		})
+	if err != nil {
+		return nil, err
+	}
+
+	err = queryList(tx, &u.Manages, `SELECT namespace FROM managed_namespaces WHERE user = ? ORDER BY position`, name,
+		func(namespace *string) []any {
+			return []any{namespace}
+		})
+	if err != nil {
+		return nil, err
+	}
+
+	err = queryList(tx, &u.History, `SELECT time, action, by_user FROM user_history WHERE user = ? ORDER BY position`, name,
+		func(e *internal.HistoryEntry) []any {
+			return []any{(*timestamp)(&e.Time), &e.Action, &e.By}
+		})
+	if err != nil {
+		return nil, err
+	}
+
+	err = queryList(tx, &u.APITokens, `SELECT id, name, token_hash, scope, created, expiration, last_used FROM api_tokens WHERE user = ? ORDER BY position`, name,
+		func(t *internal.APIToken) []any {
+			return []any{&t.ID, &t.Name, &t.TokenHash, &t.Scope, (*timestamp)(&t.Created), (*timestamp)(&t.Expiration), (*timestamp)(&t.LastUsed)}
+		})
+	if err != nil {
+		return nil, err
+	}
+
+	return &u, nil
+}
+
+func deleteUser(tx *sql.Tx, name string) error {
+	_, err := tx.Exec(`DELETE FROM users WHERE name = ?`, name)
+	return err
+}
+
+func insertNamespace(tx *sql.Tx, n internal.Namespace) error {
+	if _, err := tx.Exec(`INSERT INTO namespaces VALUES (?)`, n.Name); err != nil {
+		return err
+	}
+
+	if n.FromConfig {
+		if _, err := tx.Exec(`INSERT INTO config_namespaces VALUES (?)`, n.Name); err != nil {
+			return err
+		}
+	}
+
+	for i, session := range n.Sessions {
+		_, err := tx.Exec(`INSERT INTO namespace_sessions VALUES (?, ?, ?, ?, ?, ?)`,
+			n.Name, i, session.Key, session.Name, session.User, timestamp(session.Created))
+		if err != nil {
+			return err
+		}
+	}
+
+	for i, invitation := range n.Invitations {
+		_, err := tx.Exec(`INSERT INTO namespace_invitations VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`,
+			n.Name, i, invitation.ID, invitation.TokenHash, invitation.Role, invitation.MaxUses, invitation.Uses,
+			invitation.CreatedBy, timestamp(invitation.Created), timestamp(invitation.Expiration))
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func getNamespace(tx *sql.Tx, name string) (*internal.Namespace, error) {
+	var n internal.Namespace
+
+	err := tx.QueryRow(`SELECT name FROM namespaces WHERE name = ?`, name).Scan(&n.Name)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+
+	err = tx.QueryRow(`SELECT EXISTS (SELECT 1 FROM config_namespaces WHERE namespace = ?)`, name).Scan(&n.FromConfig)
+	if err != nil {
+		return nil, err
+	}
+
+	err = queryList(tx, &n.Sessions, `SELECT session_key, name, user, created FROM namespace_sessions WHERE namespace = ? ORDER BY position`, name,
+		func(s *internal.NamespaceSession) []any {
+			return []any{&s.Key, &s.Name, &s.User, (*timestamp)(&s.Created)}
+		})
+	if err != nil {
+		return nil, err
+	}
+
+	err = queryList(tx, &n.Invitations, `SELECT id, token_hash, role, max_uses, uses, created_by, created, expiration FROM namespace_invitations WHERE namespace = ? ORDER BY position`, name,
+		func(i *internal.NamespaceInvitation) []any {
+			return []any{&i.ID, &i.TokenHash, &i.Role, &i.MaxUses, &i.Uses, &i.CreatedBy, (*timestamp)(&i.Created), (*timestamp)(&i.Expiration)}
+		})
+	if err != nil {
+		return nil, err
+	}
+
+	return &n, nil
+}
+
+func deleteNamespace(tx *sql.Tx, name string) error {
+	_, err := tx.Exec(`DELETE FROM namespaces WHERE name = ?`, name)
+	return err
+}
+
+func queryNames(tx *sql.Tx, query string) ([]string, error) {
+	var names []string
+	err := queryList(tx, &names, query, nil, func(name *string) []any {
+		return []any{name}
+	})
+	return names, err
+}
+
+// queryRange returns the names in table that are in r. The default BINARY
+// collation compares names byte by byte, like the other backends.
+func queryRange(tx *sql.Tx, table string, r storage.Range) ([]string, error) {
+	order, after := "ASC", ">"
+	if r.Desc {
+		order, after = "DESC", "<"
+	}
+
+	limit := -1
+	if r.Limit > 0 {
+		limit = r.Limit
+	}
+
+	rows, err := tx.Query(`SELECT name FROM `+table+` WHERE substr(name, 1, length(?1)) = ?1
+		AND instr(lower(name), lower(?2)) > 0
+		AND (?3 = '' OR name `+after+` ?3)
+		ORDER BY name `+order+` LIMIT ?4`, r.Prefix, r.Contains, r.After, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var names []string
+	for rows.Next() {
+		var name string
+		if err := rows.Scan(&name); err != nil {
+			return nil, err
+		}
+		names = append(names, name)
+	}
+
+	return names, rows.Err()
+}
+
+// queryList appends a value to list for every row returned by query. fields
+// returns the scan destinations for the columns of a row.
+func queryList[T any](tx *sql.Tx, list *[]T, query string, arg any, fields func(*T) []any) error {
+	var args []any
+	if arg != nil {
+		args = append(args, arg)
+	}
+
+	rows, err := tx.Query(query, args...)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var v T
+		if err := rows.Scan(fields(&v)...); err != nil {
+			return err
+		}
+		*list = append(*list, v)
+	}
+
+	return rows.Err()
+}
+
+// timestamp stores a time.Time as RFC 3339 text, which sorts correctly and is
+// readable with standard tools.
+type timestamp time.Time
+
+func (t timestamp) Value() (driver.Value, error) {
+	return time.Time(t).UTC().Format(time.RFC3339Nano), nil
+}
+
+func (t *timestamp) Scan(src any) error {
+	s, ok := src.(string)
+	if !ok {
+		return fmt.Errorf("cannot scan %T into timestamp", src)
+	}
+
+	parsed, err := time.Parse(time.RFC3339Nano, s)
+	if err != nil {
+		return err
+	}
+
+	*t = timestamp(parsed)
+	return nil
+}
+
+// sessionID stores a uint64 session ID in a signed INTEGER column.
+type sessionID uint64
+
+func (id *sessionID) Scan(src any) error {
+	n, ok := src.(int64)
+	if !ok {
+		return fmt.Errorf("cannot scan %T into session ID", src)
+	}
+
+	*id = sessionID(n)
+	return nil
+}
@@ -2,6 +2,9 @@ package storage
 
 import (
 	"MediaMTXAuth/internal"
+	"errors"
+	"fmt"
+	"sync"
 	"testing"
 	"time"
 
@@ -13,12 +16,22 @@ func XTestStorage(t *testing.T, s Storage) {
 		t.Fatal(err)
 	}
 
+	t.Run("empty", func(t *testing.T) {
+		if users, err := s.GetAllUsers(); err != nil || users == nil || len(users) != 0 {
+			t.Errorf("Expected an empty list of users, got %#v, %v", users, err)
+		}
+		if namespaces, err := s.GetAllNamespaces(); err != nil || namespaces == nil || len(namespaces) != 0 {
+			t.Errorf("Expected an empty list of namespaces, got %#v, %v", namespaces, err)
+		}
+	})
+
 	t.Run("users", func(t *testing.T) {
 		u := internal.User{
-			Name:      "test",
-			StreamKey: "test",
-			Password:  internal.UserPassword{"hash", true},
-			Session:   internal.UserSession{123, time.Unix(1234567890, 0)},
+			Name:       "test",
+			StreamKey:  "test",
+			Password:   internal.UserPassword{Hash: "hash", IsGenerated: true},
+			Sessions:   []internal.UserSession{{ID: 123, Expiration: time.Unix(1234567890, 0)}},
+			FromConfig: true,
 		}
 
 		t.Run("not found", func(t *testing.T) {
@@ -77,6 +90,99 @@ func XTestStorage(t *testing.T, s Storage) {
 			}
 		})
 
+		t.Run("update", func(t *testing.T) {
+			err := s.UpdateUser(u.Name, func(user *internal.User) error {
+				user.StreamKey = "updated"
+				return nil
+			})
+			if err != nil {
+				t.Errorf("Failed to update user: %v", err)
+				return
+			}
+
+			storedUser, _ := s.GetUser(u.Name)
+			if storedUser == nil || storedUser.StreamKey != "updated" {
+				t.Errorf("Expected updated stream key, got %v", storedUser)
+			}
+
+			failure := errors.New("failure")
+			err = s.UpdateUser(u.Name, func(user *internal.User) error {
+				user.StreamKey = "discarded"
+				return failure
+			})
+			if !errors.Is(err, failure) {
+				t.Errorf("Expected update error, got %v", err)
+			}
+
+			storedUser, _ = s.GetUser(u.Name)
+			if storedUser == nil || storedUser.StreamKey != "updated" {
+				t.Errorf("Failed update should not be stored, got %v", storedUser)
+			}
+
+			err = s.UpdateUser("missing", func(user *internal.User) error { return nil })
+			if !errors.Is(err, internal.ErrUserNotFound) {
+				t.Errorf("Expected ErrUserNotFound, got %v", err)
+			}
+		})
+
+		t.Run("rename", func(t *testing.T) {
+			if err := s.SetUser(internal.User{Name: "other"}); err != nil {
+				t.Errorf("Failed to set user: %v", err)
+				return
+			}
+			defer s.DeleteUser("other")
+
+			err := s.UpdateUser(u.Name, func(user *internal.User) error {
+				user.Name = "other"
+				return nil
+			})
+			if !errors.Is(err, internal.ErrUserAlreadyExists) {
+				t.Errorf("Expected ErrUserAlreadyExists, got %v", err)
+			}
+
+			err = s.UpdateUser(u.Name, func(user *internal.User) error {
+				user.Name = "renamed"
+				return nil
+			})
+			if err != nil {
+				t.Errorf("Failed to rename user: %v", err)
+				return
+			}
+
+			if storedUser, _ := s.GetUser(u.Name); storedUser != nil {
+				t.Errorf("Old name should be gone, got %v", storedUser)
+			}
+
+			if storedUser, _ := s.GetUser("renamed"); storedUser == nil {
+				t.Errorf("Renamed user not found")
+			}
+
+			_ = s.UpdateUser("renamed", func(user *internal.User) error {
+				user.Name = u.Name
+				return nil
+			})
+		})
+
+		t.Run("concurrent updates", func(t *testing.T) {
+			var wg sync.WaitGroup
+			for range 50 {
+				wg.Add(1)
+				go func() {
+					defer wg.Done()
+					_ = s.UpdateUser(u.Name, func(user *internal.User) error {
+						user.Manages = append(user.Manages, "concurrent")
+						return nil
+					})
+				}()
+			}
+			wg.Wait()
+
+			storedUser, _ := s.GetUser(u.Name)
+			if storedUser == nil || len(storedUser.Manages) != 50 {
+				t.Errorf("Expected 50 updates, got %v", storedUser)
+			}
+		})
+
 		t.Run("delete", func(t *testing.T) {
 			s.DeleteUser(u.Name)
 
@@ -94,7 +200,8 @@ func XTestStorage(t *testing.T, s Storage) {
 
 	t.Run("namespaces", func(t *testing.T) {
 		n := internal.Namespace{
-			Name: "test",
+			Name:       "test",
+			FromConfig: true,
 			Sessions: []internal.NamespaceSession{
 				{
 					Key:     "random key",
@@ -131,6 +238,31 @@ func XTestStorage(t *testing.T, s Storage) {
 			return
 		}
 
+		t.Run("update", func(t *testing.T) {
+			var wg sync.WaitGroup
+			for range 50 {
+				wg.Add(1)
+				go func() {
+					defer wg.Done()
+					_ = s.UpdateNamespace(n.Name, func(namespace *internal.Namespace) error {
+						namespace.Sessions = append(namespace.Sessions, internal.NamespaceSession{Name: "concurrent"})
+						return nil
+					})
+				}()
+			}
+			wg.Wait()
+
+			storedNamespace, _ := s.GetNamespace(n.Name)
+			if storedNamespace == nil || len(storedNamespace.Sessions) != 51 {
+				t.Errorf("Expected 51 sessions, got %v", storedNamespace)
+			}
+
+			err := s.UpdateNamespace("missing", func(namespace *internal.Namespace) error { return nil })
+			if !errors.Is(err, internal.ErrNamespaceNotFound) {
+				t.Errorf("Expected ErrNamespaceNotFound, got %v", err)
+			}
+		})
+
 		t.Run("delete", func(t *testing.T) {
 			s.DeleteNamespace(n.Name)
 
@@ -145,4 +277,292 @@ func XTestStorage(t *testing.T, s Storage) {
 			}
 		})
 	})
+
+	t.Run("parallel", func(t *testing.T) {
+		var wg sync.WaitGroup
+		for i := range 20 {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+
+				name := fmt.Sprintf("parallel%d", i)
+				for j := range 20 {
+					if err := s.SetUser(internal.User{Name: name}); err != nil {
+						t.Errorf("Failed to set user: %v", err)
+						return
+					}
+
+					if err := s.SetNamespace(internal.Namespace{Name: name}); err != nil {
+						t.Errorf("Failed to set namespace: %v", err)
+						return
+					}
+
+					err := s.UpdateUser(name, func(user *internal.User) error {
+						user.Manages = append(user.Manages, name)
+						return nil
+					})
+					if err != nil {
+						t.Errorf("Failed to update user: %v", err)
+						return
+					}
+
+					storedUser, err := s.GetUser(name)
+					if err != nil || storedUser == nil || len(storedUser.Manages) != 1 {
+						t.Errorf("Expected user with one update, got %v, %v", storedUser, err)
+						return
+					}
+
+					if _, err := s.GetAllUsers(); err != nil {
+						t.Errorf("Failed to get all users: %v", err)
+						return
+					}
+
+					if _, err := s.GetAllNamespaces(); err != nil {
+						t.Errorf("Failed to get all namespaces: %v", err)
+						return
+					}
+
+					if j%2 == 1 {
+						_ = s.DeleteUser(name)
+						_ = s.DeleteNamespace(name)
+					}
+				}
+			}()
+		}
+		wg.Wait()
+
+		users, _ := s.GetAllUsers()
+		namespaces, _ := s.GetAllNamespaces()
+		if len(users) != 0 || len(namespaces) != 0 {
+			t.Errorf("Expected everything to be deleted, got %d users and %d namespaces", len(users), len(namespaces))
+		}
+	})
+
+	t.Run("indexes", func(t *testing.T) {
+		names := func(users []internal.User) []string {
+			var names []string
+			for _, user := range users {
+				names = append(names, user.Name)
+			}
+			return names
+		}
+
+		for _, u := range []internal.User{
+			{Name: "carol", StreamKey: "carol-key", Memberships: []internal.Membership{{Namespace: "studio", Permission: internal.PermissionRead}}},
+			{Name: "alice", StreamKey: "alice-key", Manages: []string{"studio"}},
+			{Name: "bob", StreamKey: "bob-key", Memberships: []internal.Membership{{Namespace: "other", Permission: internal.PermissionRead}}},
+			{Name: "dave"},
+			{Name: "erin"},
+		} {
+			if err := s.SetUser(u); err != nil {
+				t.Fatalf("Failed to set user: %v", err)
+			}
+		}
+		defer func() {
+			for _, name := range []string{"alice", "bob", "carol", "dave", "erin", "renamed", "admin"} {
+				_ = s.DeleteUser(name)
+			}
+		}()
+
+		t.Run("by namespace", func(t *testing.T) {
+			users, err := s.GetUsersByNamespace("studio")
+			if err != nil {
+				t.Fatalf("Failed to get users by namespace: %v", err)
+			}
+			if diff := cmp.Diff([]string{"alice", "carol"}, names(users)); diff != "" {
+				t.Errorf("Unexpected users (-want +got):\n%s", diff)
+			}
+
+			err = s.UpdateUser("carol", func(user *internal.User) error {
+				user.Name = "renamed"
+				user.Memberships = append(user.Memberships, internal.Membership{Namespace: "other", Permission: internal.PermissionRead})
+				return nil
+			})
+			if err != nil {
+				t.Fatalf("Failed to update user: %v", err)
+			}
+
+			users, _ = s.GetUsersByNamespace("other")
+			if diff := cmp.Diff([]string{"bob", "renamed"}, names(users)); diff != "" {
+				t.Errorf("Unexpected users after rename (-want +got):\n%s", diff)
+			}
+
+			_ = s.DeleteUser("alice")
+			users, _ = s.GetUsersByNamespace("studio")
+			if diff := cmp.Diff([]string{"renamed"}, names(users)); diff != "" {
+				t.Errorf("Unexpected users after delete (-want +got):\n%s", diff)
+			}
+
+			users, err = s.GetUsersByNamespace("missing")
+			if err != nil || len(users) != 0 {
+				t.Errorf("Expected no users, got %v, %v", users, err)
+			}
+		})
+
+		t.Run("by stream key", func(t *testing.T) {
+			user, err := s.GetUserByStreamKey("bob-key")
+			if err != nil || user == nil || user.Name != "bob" {
+				t.Fatalf("Expected bob, got %v, %v", user, err)
+			}
+
+			err = s.UpdateUser("bob", func(user *internal.User) error {
+				user.PreviousStreamKey = user.StreamKey
+				user.StreamKey = "bob-new-key"
+				return nil
+			})
+			if err != nil {
+				t.Fatalf("Failed to update user: %v", err)
+			}
+			for _, key := range []string{"bob-key", "bob-new-key"} {
+				if user, _ := s.GetUserByStreamKey(key); user == nil || user.Name != "bob" {
+					t.Errorf("Expected bob for %s, got %v", key, user)
+				}
+			}
+
+			err = s.UpdateUser("dave", func(user *internal.User) error {
+				user.StreamKey = "bob-key"
+				return nil
+			})
+			if !errors.Is(err, internal.ErrStreamKeyTaken) {
+				t.Errorf("Expected ErrStreamKeyTaken, got %v", err)
+			}
+			if err := s.SetUser(internal.User{Name: "erin", StreamKey: "bob-new-key"}); !errors.Is(err, internal.ErrStreamKeyTaken) {
+				t.Errorf("Expected ErrStreamKeyTaken, got %v", err)
+			}
+			if dave, _ := s.GetUser("dave"); dave == nil || dave.StreamKey != "" {
+				t.Errorf("Refused update should not be stored, got %v", dave)
+			}
+
+			_ = s.DeleteUser("bob")
+			if user, _ := s.GetUserByStreamKey("bob-new-key"); user != nil {
+				t.Errorf("Expected no user after delete, got %v", user)
+			}
+			if err := s.SetUser(internal.User{Name: "dave", StreamKey: "bob-key"}); err != nil {
+				t.Errorf("Key of a deleted user should be free, got %v", err)
+			}
+
+			if user, _ := s.GetUserByStreamKey(""); user != nil {
+				t.Errorf("Empty keys should not be indexed, got %v", user)
+			}
+		})
+
+		t.Run("admins", func(t *testing.T) {
+			if found, err := s.HasAdmin(); err != nil || found {
+				t.Errorf("Expected no admin, got %v, %v", found, err)
+			}
+
+			if err := s.SetUser(internal.User{Name: "erin", IsAdmin: true}); err != nil {
+				t.Fatalf("Failed to set user: %v", err)
+			}
+			if found, err := s.HasAdmin(); err != nil || !found {
+				t.Errorf("Expected an admin, got %v, %v", found, err)
+			}
+
+			err := s.UpdateUser("erin", func(user *internal.User) error {
+				user.Name = "admin"
+				return nil
+			})
+			if err != nil {
+				t.Fatalf("Failed to update user: %v", err)
+			}
+			if found, _ := s.HasAdmin(); !found {
+				t.Errorf("Renamed admin should stay an admin")
+			}
+
+			err = s.UpdateUser("admin", func(user *internal.User) error {
+				user.IsAdmin = false
+				return nil
+			})
+			if err != nil {
+				t.Fatalf("Failed to update user: %v", err)
+			}
+			if found, _ := s.HasAdmin(); found {
+				t.Errorf("Expected no admin after demotion")
+			}
+
+			_ = s.SetUser(internal.User{Name: "erin", IsAdmin: true})
+			_ = s.DeleteUser("erin")
+			if found, _ := s.HasAdmin(); found {
+				t.Errorf("Expected no admin after delete")
+			}
+		})
+	})
+	t.Run("list", func(t *testing.T) {
+		for _, name := range []string{"beta", "alpha", "Alpine", "gamma", "alps"} {
+			if err := s.SetNamespace(internal.Namespace{Name: name}); err != nil {
+				t.Fatalf("Failed to set namespace: %v", err)
+			}
+			defer s.DeleteNamespace(name)
+		}
+		if err := s.SetUser(internal.User{Name: "user"}); err != nil {
+			t.Fatalf("Failed to set user: %v", err)
+		}
+		defer s.DeleteUser("user")
+
+		tests := map[string]struct {
+			r    Range
+			want []string
+		}{
+			"all":               {Range{}, []string{"Alpine", "alpha", "alps", "beta", "gamma"}},
+			"desc":              {Range{Desc: true}, []string{"gamma", "beta", "alps", "alpha", "Alpine"}},
+			"limit":             {Range{Limit: 2}, []string{"Alpine", "alpha"}},
+			"after":             {Range{After: "alpha", Limit: 2}, []string{"alps", "beta"}},
+			"after desc":        {Range{After: "beta", Desc: true}, []string{"alps", "alpha", "Alpine"}},
+			"after missing":     {Range{After: "b"}, []string{"beta", "gamma"}},
+			"prefix":            {Range{Prefix: "al"}, []string{"alpha", "alps"}},
+			"prefix desc":       {Range{Prefix: "al", Desc: true}, []string{"alps", "alpha"}},
+			"prefix after":      {Range{Prefix: "al", After: "alpha"}, []string{"alps"}},
+			"prefix before":     {Range{Prefix: "al", After: "Alpine"}, []string{"alpha", "alps"}},
+			"prefix desc after": {Range{Prefix: "al", After: "alps", Desc: true}, []string{"alpha"}},
+			"prefix none":       {Range{Prefix: "x"}, []string{}},
+			"contains":          {Range{Contains: "ALP"}, []string{"Alpine", "alpha", "alps"}},
+			"contains limit":    {Range{Contains: "a", After: "alps", Limit: 2}, []string{"beta", "gamma"}},
+		}
+
+		for name, test := range tests {
+			namespaces, err := s.ListNamespaces(test.r)
+			if err != nil {
+				t.Errorf("%s: failed to list namespaces: %v", name, err)
+				continue
+			}
+
+			got := []string{}
+			for _, namespace := range namespaces {
+				got = append(got, namespace.Name)
+			}
+			if diff := cmp.Diff(test.want, got); diff != "" {
+				t.Errorf("%s: unexpected namespaces (-want +got):\n%s", name, diff)
+			}
+		}
+
+		users, err := s.ListUsers(Range{Contains: "us"})
+		if err != nil || len(users) != 1 || users[0].Name != "user" {
+			t.Errorf("Expected user, got %v, %v", users, err)
+		}
+	})
+
+	if meta, ok := s.(MetaStore); ok {
+		t.Run("meta", func(t *testing.T) {
+			if value, err := meta.GetMeta("missing"); err != nil || value != nil {
+				t.Errorf("Expected no value, got %q, %v", value, err)
+			}
+
+			if err := meta.SetMeta("name", []byte("value")); err != nil {
+				t.Fatalf("Failed to set meta value: %v", err)
+			}
+			if err := meta.SetMeta("name", []byte("new value")); err != nil {
+				t.Fatalf("Failed to replace meta value: %v", err)
+			}
+			if value, err := meta.GetMeta("name"); err != nil || string(value) != "new value" {
+				t.Errorf("Expected new value, got %q, %v", value, err)
+			}
+
+			if err := meta.SetMeta("name", nil); err != nil {
+				t.Fatalf("Failed to remove meta value: %v", err)
+			}
+			if value, err := meta.GetMeta("name"); err != nil || value != nil {
+				t.Errorf("Expected removed value, got %q, %v", value, err)
+			}
+		})
+	}
 }
@@ -0,0 +1,173 @@
+package throttle
+
+import (
+	"MediaMTXAuth/internal"
+	"sync"
+	"time"
+)
+
+// Policy describes how failed login attempts are slowed down and when the
+// attempting party is locked out entirely.
+type Policy struct {
+	FreeAttempts int
+	BaseDelay    time.Duration
+	MaxDelay     time.Duration
+	LockAfter    int
+	LockFor      time.Duration
+}
+
+var DefaultAccountPolicy = Policy{
+	FreeAttempts: 3,
+	BaseDelay:    time.Second,
+	MaxDelay:     time.Minute,
+	LockAfter:    10,
+	LockFor:      15 * time.Minute,
+}
+
+var DefaultIPPolicy = Policy{
+	FreeAttempts: 5,
+	BaseDelay:    time.Second,
+	MaxDelay:     time.Minute,
+	LockAfter:    30,
+	LockFor:      15 * time.Minute,
+}
+
+// Delay returns how long to wait after the last failure before another attempt
+// is allowed. The delay doubles with every failure past FreeAttempts.
+func (p Policy) Delay(failures int) time.Duration {
+	if failures < p.FreeAttempts {
+		return 0
+	}
+
+	delay := p.BaseDelay
+	for i := p.FreeAttempts; i < failures && delay < p.MaxDelay; i++ {
+		delay *= 2
+	}
+
+	return min(delay, p.MaxDelay)
+}
+
+// Check returns the attempts with an expired lock or stale failures cleared,
+// and an error if another attempt is not allowed at the given time.
+func (p Policy) Check(a internal.LoginAttempts, now time.Time) (internal.LoginAttempts, error) {
+	if a.LockedUntil.After(now) {
+		return a, internal.ErrAccountLocked
+	}
+
+	if !a.LockedUntil.IsZero() || now.Sub(a.LastFailure) > p.LockFor {
+		a = internal.LoginAttempts{}
+	}
+
+	if a.Failures > 0 && a.LastFailure.Add(p.Delay(a.Failures)).After(now) {
+		return a, internal.ErrTooManyAttempts
+	}
+
+	return a, nil
+}
+
+// Fail records a failed attempt and locks once LockAfter failures are reached.
+func (p Policy) Fail(a internal.LoginAttempts, now time.Time) internal.LoginAttempts {
+	a.Failures++
+	a.LastFailure = now
+
+	if p.LockAfter > 0 && a.Failures >= p.LockAfter {
+		a.LockedUntil = now.Add(p.LockFor)
+	}
+
+	return a
+}
+
+// Attempt checks that another attempt is allowed at the given time and, if
+// so, records it as failed. Recording it before the credentials are verified
+// keeps concurrent attempts from all passing the check; a successful attempt
+// clears the failures afterwards.
+func (p Policy) Attempt(a internal.LoginAttempts, now time.Time) (internal.LoginAttempts, error) {
+	a, err := p.Check(a, now)
+	if err != nil {
+		return a, err
+	}
+
+	return p.Fail(a, now), nil
+}
+
+const maxEntries = 10000
+
+// Limiter keeps in-memory attempt counters keyed by an arbitrary string,
+// such as a client IP address.
+type Limiter struct {
+	Policy Policy
+
+	mu       sync.Mutex
+	attempts map[string]internal.LoginAttempts
+}
+
+func NewLimiter(policy Policy) *Limiter {
+	return &Limiter{
+		Policy:   policy,
+		attempts: make(map[string]internal.LoginAttempts),
+	}
+}
+
+func (l *Limiter) Check(key string) error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	a, ok := l.attempts[key]
+	if !ok {
+		return nil
+	}
+
+	a, err := l.Policy.Check(a, time.Now())
+	if a.Failures == 0 {
+		delete(l.attempts, key)
+	}
+
+	return err
+}
+
+// Attempt is like Policy.Attempt for the counter of key.
+func (l *Limiter) Attempt(key string) error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	now := time.Now()
+	if len(l.attempts) >= maxEntries {
+		l.prune(now)
+	}
+
+	a, err := l.Policy.Attempt(l.attempts[key], now)
+	if err != nil {
+		return err
+	}
+
+	l.attempts[key] = a
+	return nil
+}
+
+func (l *Limiter) Fail(key string) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	now := time.Now()
+	if len(l.attempts) >= maxEntries {
+		l.prune(now)
+	}
+
+	l.attempts[key] = l.Policy.Fail(l.attempts[key], now)
+}
+
+func (l *Limiter) Reset(key string) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	delete(l.attempts, key)
+}
+
+// prune forgets keys that are not locked and have not failed for LockFor.
+func (l *Limiter) prune(now time.Time) {
+	for key, a := range l.attempts {
+		if a.LockedUntil.Before(now) && a.LastFailure.Add(l.Policy.LockFor).Before(now) {
+			delete(l.attempts, key)
+		}
+	}
+}
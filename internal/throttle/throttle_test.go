@@ -0,0 +1,117 @@
+package throttle
+
+import (
+	"MediaMTXAuth/internal"
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestPolicy(t *testing.T) {
+	p := Policy{
+		FreeAttempts: 2,
+		BaseDelay:    time.Second,
+		MaxDelay:     4 * time.Second,
+		LockAfter:    6,
+		LockFor:      time.Minute,
+	}
+
+	t.Run("delay", func(t *testing.T) {
+		expected := []time.Duration{0, 0, time.Second, 2 * time.Second, 4 * time.Second, 4 * time.Second}
+
+		for failures, want := range expected {
+			if got := p.Delay(failures); got != want {
+				t.Errorf("Delay(%d) = %v, want %v", failures, got, want)
+			}
+		}
+	})
+
+	t.Run("throttled after free attempts", func(t *testing.T) {
+		now := time.Now()
+		a := internal.LoginAttempts{}
+
+		for range p.FreeAttempts {
+			a = p.Fail(a, now)
+		}
+
+		if _, err := p.Check(a, now); !errors.Is(err, internal.ErrTooManyAttempts) {
+			t.Errorf("Expected ErrTooManyAttempts, got %v", err)
+		}
+
+		if _, err := p.Check(a, now.Add(time.Second)); err != nil {
+			t.Errorf("Expected attempt to be allowed after delay, got %v", err)
+		}
+	})
+
+	t.Run("locked", func(t *testing.T) {
+		now := time.Now()
+		a := internal.LoginAttempts{}
+
+		for range p.LockAfter {
+			a = p.Fail(a, now)
+		}
+
+		if _, err := p.Check(a, now.Add(30*time.Second)); !errors.Is(err, internal.ErrAccountLocked) {
+			t.Errorf("Expected ErrAccountLocked, got %v", err)
+		}
+
+		a, err := p.Check(a, now.Add(2*time.Minute))
+		if err != nil {
+			t.Errorf("Expected lock to expire, got %v", err)
+		}
+
+		if a.Failures != 0 {
+			t.Errorf("Expected failures to be reset after lock expired, got %d", a.Failures)
+		}
+	})
+}
+
+func TestAttempt(t *testing.T) {
+	p := Policy{FreeAttempts: 2, BaseDelay: time.Second, MaxDelay: time.Second, LockFor: time.Minute}
+	now := time.Now()
+	a := internal.LoginAttempts{}
+
+	for i := range p.FreeAttempts {
+		var err error
+		if a, err = p.Attempt(a, now); err != nil {
+			t.Fatalf("Attempt %d should be allowed, got %v", i, err)
+		}
+	}
+
+	if _, err := p.Attempt(a, now); !errors.Is(err, internal.ErrTooManyAttempts) {
+		t.Errorf("Expected ErrTooManyAttempts, got %v", err)
+	}
+
+	l := NewLimiter(p)
+	for range p.FreeAttempts {
+		_ = l.Attempt("1.2.3.4")
+	}
+
+	if err := l.Attempt("1.2.3.4"); !errors.Is(err, internal.ErrTooManyAttempts) {
+		t.Errorf("Expected ErrTooManyAttempts from the limiter, got %v", err)
+	}
+}
+
+func TestLimiter(t *testing.T) {
+	l := NewLimiter(Policy{FreeAttempts: 1, BaseDelay: time.Hour, MaxDelay: time.Hour, LockFor: time.Hour})
+
+	if err := l.Check("1.2.3.4"); err != nil {
+		t.Errorf("Unexpected error: %v", err)
+	}
+
+	l.Fail("1.2.3.4")
+
+	if err := l.Check("1.2.3.4"); !errors.Is(err, internal.ErrTooManyAttempts) {
+		t.Errorf("Expected ErrTooManyAttempts, got %v", err)
+	}
+
+	if err := l.Check("5.6.7.8"); err != nil {
+		t.Errorf("Other keys should not be throttled, got %v", err)
+	}
+
+	l.Reset("1.2.3.4")
+
+	if err := l.Check("1.2.3.4"); err != nil {
+		t.Errorf("Expected reset key to be allowed, got %v", err)
+	}
+}
@@ -0,0 +1,51 @@
+package transfer
+
+import (
+	"encoding/json"
+	"errors"
+	"io"
+
+	"gopkg.in/yaml.v3"
+)
+
+// Format is the encoding of a document.
+type Format string
+
+const (
+	JSON Format = "json"
+	YAML Format = "yaml"
+)
+
+var ErrInvalidFormat = errors.New("format must be json or yaml")
+
+// Encode writes doc to w in format.
+func Encode(w io.Writer, doc *Document, format Format) error {
+	switch format {
+	case JSON:
+		encoder := json.NewEncoder(w)
+		encoder.SetIndent("", "  ")
+		return encoder.Encode(doc)
+	case YAML:
+		encoder := yaml.NewEncoder(w)
+		encoder.SetIndent(2)
+		if err := encoder.Encode(doc); err != nil {
+			return err
+		}
+		return encoder.Close()
+	default:
+		return ErrInvalidFormat
+	}
+}
+
+// Decode reads a document in either format, since JSON is valid YAML.
+// Unknown fields are rejected to catch typos.
+func Decode(r io.Reader) (*Document, error) {
+	decoder := yaml.NewDecoder(r)
+	decoder.KnownFields(true)
+
+	var doc Document
+	if err := decoder.Decode(&doc); err != nil {
+		return nil, err
+	}
+	return &doc, nil
+}
@@ -0,0 +1,439 @@
+// Package transfer exports users and namespaces to a JSON or YAML document and
+// imports them again, for moving a setup between installations or keeping it
+// in version control.
+package transfer
+
+import (
+	"MediaMTXAuth/internal"
+	"MediaMTXAuth/internal/passwords"
+	"MediaMTXAuth/internal/services"
+	"errors"
+	"fmt"
+	"maps"
+	"slices"
+	"strings"
+	"time"
+)
+
+// Version is the document format written by Export.
+const Version = 1
+
+// Mode decides what happens to users and namespaces missing from an imported
+// document.
+type Mode string
+
+const (
+	// Merge keeps them.
+	Merge Mode = "merge"
+	// Replace deletes them.
+	Replace Mode = "replace"
+)
+
+var (
+	ErrUnsupportedVersion = errors.New("unsupported document version")
+	ErrInvalidMode        = errors.New("mode must be merge or replace")
+	ErrDuplicateName      = errors.New("listed more than once")
+)
+
+// Document holds everything exported from an installation.
+type Document struct {
+	Version    int         `json:"version" yaml:"version"`
+	Namespaces []Namespace `json:"namespaces" yaml:"namespaces"`
+	Users      []User      `json:"users" yaml:"users"`
+}
+
+type Namespace struct {
+	Name string `json:"name" yaml:"name"`
+}
+
+type User struct {
+	Name    string `json:"name" yaml:"name"`
+	IsAdmin bool   `json:"isAdmin,omitempty" yaml:"isAdmin,omitempty"`
+	// PasswordHash is the argon2id hash of the password.
+	PasswordHash string `json:"passwordHash" yaml:"passwordHash"`
+	// MustChangePassword is set for generated passwords, which have to be
+	// changed at the next login.
+	MustChangePassword bool `json:"mustChangePassword,omitempty" yaml:"mustChangePassword,omitempty"`
+	// StreamKey is only exported on request. Without it, imported users keep
+	// their current stream key or get a new one.
+	StreamKey   string       `json:"streamKey,omitempty" yaml:"streamKey,omitempty"`
+	Memberships []Membership `json:"memberships,omitempty" yaml:"memberships,omitempty"`
+	Manages     []string     `json:"manages,omitempty" yaml:"manages,omitempty"`
+	ExpiresAt   time.Time    `json:"expiresAt,omitzero" yaml:"expiresAt,omitempty"`
+}
+
+type Membership struct {
+	Namespace  string              `json:"namespace" yaml:"namespace"`
+	Permission internal.Permission `json:"permission" yaml:"permission"`
+}
+
+// Change is one step of an import.
+type Change struct {
+	// Action is "create", "update" or "delete". The config file reconciler
+	// also plans "adopt" and "release".
+	Action string
+	// Kind is "namespace" or "user".
+	Kind string
+	Name string
+	// Fields lists the user fields that are set, such as "memberships".
+	Fields []string
+}
+
+func (c Change) String() string {
+	s := c.Action + " " + c.Kind + " " + c.Name
+	if len(c.Fields) > 0 {
+		s += " (" + strings.Join(c.Fields, ", ") + ")"
+	}
+	return s
+}
+
+// Transfer exports and imports through the services, so that imported data
+// follows the same rules as changes made on the admin page.
+type Transfer struct {
+	UserService      internal.UserService
+	NamespaceService internal.NamespaceService
+}
+
+func New(userService internal.UserService, namespaceService internal.NamespaceService) *Transfer {
+	return &Transfer{
+		UserService:      userService,
+		NamespaceService: namespaceService,
+	}
+}
+
+// Export returns all namespaces and users. Stream keys are only included if
+// withStreamKeys is set.
+func (t *Transfer) Export(withStreamKeys bool) (*Document, error) {
+	namespaces, err := t.NamespaceService.GetAllNamespaces()
+	if err != nil {
+		return nil, err
+	}
+
+	users, err := t.UserService.GetAllUsers()
+	if err != nil {
+		return nil, err
+	}
+
+	doc := &Document{
+		Version:    Version,
+		Namespaces: []Namespace{},
+		Users:      []User{},
+	}
+
+	for _, namespace := range namespaces {
+		doc.Namespaces = append(doc.Namespaces, Namespace{Name: namespace.Name})
+	}
+
+	for _, user := range users {
+		exported := toUser(user)
+		if !withStreamKeys {
+			exported.StreamKey = ""
+		}
+		doc.Users = append(doc.Users, exported)
+	}
+
+	return doc, nil
+}
+
+// Plan checks doc and returns the changes Import would make, without making
+// them.
+func (t *Transfer) Plan(doc *Document, mode Mode) ([]Change, error) {
+	namespaces, err := t.NamespaceService.GetAllNamespaces()
+	if err != nil {
+		return nil, err
+	}
+
+	users, err := t.UserService.GetAllUsers()
+	if err != nil {
+		return nil, err
+	}
+
+	existingNamespaces := map[string]bool{}
+	for _, namespace := range namespaces {
+		existingNamespaces[namespace.Name] = true
+	}
+
+	existingUsers := map[string]internal.User{}
+	for _, user := range users {
+		existingUsers[user.Name] = user
+	}
+
+	if err := validate(doc, mode, existingNamespaces, existingUsers); err != nil {
+		return nil, err
+	}
+
+	var changes []Change
+
+	for _, namespace := range doc.Namespaces {
+		if !existingNamespaces[namespace.Name] {
+			changes = append(changes, Change{Action: "create", Kind: "namespace", Name: namespace.Name})
+		}
+	}
+
+	for _, user := range doc.Users {
+		current, ok := existingUsers[user.Name]
+		if !ok {
+			// CreateFromHash sets these, everything else is applied after.
+			created := User{Name: user.Name, IsAdmin: user.IsAdmin, PasswordHash: user.PasswordHash, MustChangePassword: user.MustChangePassword}
+			changes = append(changes, Change{Action: "create", Kind: "user", Name: user.Name, Fields: diff(user, created)})
+			continue
+		}
+
+		if fields := diff(user, toUser(current)); len(fields) > 0 {
+			changes = append(changes, Change{Action: "update", Kind: "user", Name: user.Name, Fields: fields})
+		}
+	}
+
+	if mode == Replace {
+		for _, name := range slices.Sorted(maps.Keys(existingUsers)) {
+			if !slices.ContainsFunc(doc.Users, func(u User) bool { return u.Name == name }) {
+				changes = append(changes, Change{Action: "delete", Kind: "user", Name: name})
+			}
+		}
+
+		for _, name := range slices.Sorted(maps.Keys(existingNamespaces)) {
+			if !slices.ContainsFunc(doc.Namespaces, func(n Namespace) bool { return n.Name == name }) {
+				changes = append(changes, Change{Action: "delete", Kind: "namespace", Name: name})
+			}
+		}
+	}
+
+	return changes, nil
+}
+
+// Import applies doc and returns the changes made. Users in doc get exactly
+// the memberships and managed namespaces listed for them. The document is
+// checked before anything is changed, but a failure while applying it leaves
+// the changes made so far in place.
+func (t *Transfer) Import(doc *Document, mode Mode) ([]Change, error) {
+	changes, err := t.Plan(doc, mode)
+	if err != nil {
+		return nil, err
+	}
+
+	users := map[string]User{}
+	for _, user := range doc.Users {
+		users[user.Name] = user
+	}
+
+	for i, change := range changes {
+		if err := t.apply(change, users[change.Name]); err != nil {
+			return changes[:i], fmt.Errorf("%s: %w", change, err)
+		}
+	}
+
+	return changes, nil
+}
+
+func (t *Transfer) apply(change Change, user User) error {
+	switch {
+	case change.Kind == "namespace" && change.Action == "create":
+		_, err := t.NamespaceService.Create(change.Name)
+		return err
+	case change.Kind == "namespace" && change.Action == "delete":
+		return t.NamespaceService.Delete(change.Name)
+	case change.Kind == "user" && change.Action == "delete":
+		return t.UserService.Delete(change.Name)
+	case change.Kind == "user" && change.Action == "create":
+		if _, err := t.UserService.CreateFromHash(user.Name, user.PasswordHash, user.MustChangePassword, user.IsAdmin); err != nil {
+			return err
+		}
+	}
+
+	current, err := t.UserService.Get(user.Name)
+	if err != nil {
+		return err
+	}
+
+	for _, field := range change.Fields {
+		switch field {
+		case "isAdmin":
+			err = t.UserService.SetAdmin(user.Name, user.IsAdmin)
+		case "password":
+			err = t.UserService.SetPasswordHash(user.Name, user.PasswordHash, user.MustChangePassword)
+		case "streamKey":
+			err = t.UserService.SetStreamKey(user.Name, user.StreamKey)
+		case "memberships":
+			err = t.setMemberships(*current, user.Memberships)
+		case "manages":
+			err = t.UserService.SetManagedNamespaces(user.Name, user.Manages)
+		case "expiresAt":
+			err = t.UserService.SetExpiry(user.Name, user.ExpiresAt)
+		}
+
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (t *Transfer) setMemberships(user internal.User, memberships []Membership) error {
+	for _, m := range user.Memberships {
+		if !slices.ContainsFunc(memberships, func(wanted Membership) bool { return wanted.Namespace == m.Namespace }) {
+			if err := t.UserService.RemoveMembership(user.Name, m.Namespace); err != nil {
+				return err
+			}
+		}
+	}
+
+	for _, m := range memberships {
+		if err := t.UserService.SetMembership(user.Name, m.Namespace, m.Permission); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// validate checks doc against the rules of the services before anything is
+// changed. Namespaces referenced by users must be in doc or, when merging,
+// exist already. Stream keys must be unique in doc and not be the current or
+// previous key of another existing user, even one doc gives a new key, since
+// users are updated one at a time.
+func validate(doc *Document, mode Mode, existingNamespaces map[string]bool, existingUsers map[string]internal.User) error {
+	if mode != Merge && mode != Replace {
+		return ErrInvalidMode
+	}
+
+	if doc.Version != Version {
+		return fmt.Errorf("%w: %d", ErrUnsupportedVersion, doc.Version)
+	}
+
+	namespaces := map[string]bool{}
+	if mode == Merge {
+		maps.Copy(namespaces, existingNamespaces)
+	}
+
+	seen := map[string]bool{}
+	for _, namespace := range doc.Namespaces {
+		if namespace.Name == "" {
+			return errors.New("namespace without a name")
+		}
+		if seen[namespace.Name] {
+			return fmt.Errorf("namespace %q: %w", namespace.Name, ErrDuplicateName)
+		}
+		seen[namespace.Name] = true
+		namespaces[namespace.Name] = true
+	}
+
+	keys := map[string]string{}
+	for _, user := range existingUsers {
+		for _, key := range []string{user.StreamKey, user.PreviousStreamKey} {
+			if key != "" {
+				keys[key] = user.Name
+			}
+		}
+	}
+
+	seen = map[string]bool{}
+	for _, user := range doc.Users {
+		if err := validateUser(user, namespaces); err != nil {
+			return fmt.Errorf("user %q: %w", user.Name, err)
+		}
+		if seen[user.Name] {
+			return fmt.Errorf("user %q: %w", user.Name, ErrDuplicateName)
+		}
+		seen[user.Name] = true
+
+		if user.StreamKey != "" {
+			if owner, ok := keys[user.StreamKey]; ok && owner != user.Name {
+				return fmt.Errorf("user %q: %w", user.Name, internal.ErrStreamKeyTaken)
+			}
+			keys[user.StreamKey] = user.Name
+		}
+	}
+
+	return nil
+}
+
+func validateUser(user User, namespaces map[string]bool) error {
+	if err := services.ValidateUsername(user.Name); err != nil {
+		return err
+	}
+
+	if err := passwords.ValidateHash(user.PasswordHash); err != nil {
+		return err
+	}
+
+	if user.StreamKey != "" && len(user.StreamKey) < 16 {
+		return services.ErrShortStreamKey
+	}
+
+	seen := map[string]bool{}
+	for _, m := range user.Memberships {
+		if !m.Permission.IsValid() {
+			return fmt.Errorf("%w %q for namespace %q", internal.ErrInvalidPermission, m.Permission, m.Namespace)
+		}
+		if !namespaces[m.Namespace] {
+			return fmt.Errorf("%w: %q", internal.ErrNamespaceNotFound, m.Namespace)
+		}
+		if seen[m.Namespace] {
+			return fmt.Errorf("membership in %q: %w", m.Namespace, ErrDuplicateName)
+		}
+		seen[m.Namespace] = true
+	}
+
+	for _, namespace := range user.Manages {
+		if !namespaces[namespace] {
+			return fmt.Errorf("%w: %q", internal.ErrNamespaceNotFound, namespace)
+		}
+	}
+
+	return nil
+}
+
+// diff returns the fields that have to be set to turn current into user.
+func diff(user, current User) []string {
+	var fields []string
+
+	if user.IsAdmin != current.IsAdmin {
+		fields = append(fields, "isAdmin")
+	}
+
+	if user.PasswordHash != current.PasswordHash || user.MustChangePassword != current.MustChangePassword {
+		fields = append(fields, "password")
+	}
+
+	if user.StreamKey != "" && user.StreamKey != current.StreamKey {
+		fields = append(fields, "streamKey")
+	}
+
+	sortMemberships := func(m []Membership) []Membership {
+		return slices.SortedFunc(slices.Values(m), func(a, b Membership) int {
+			return strings.Compare(a.Namespace, b.Namespace)
+		})
+	}
+	if !slices.Equal(sortMemberships(user.Memberships), sortMemberships(current.Memberships)) {
+		fields = append(fields, "memberships")
+	}
+
+	if !slices.Equal(slices.Sorted(slices.Values(user.Manages)), slices.Sorted(slices.Values(current.Manages))) {
+		fields = append(fields, "manages")
+	}
+
+	if !user.ExpiresAt.Equal(current.ExpiresAt) {
+		fields = append(fields, "expiresAt")
+	}
+
+	return fields
+}
+
+func toUser(user internal.User) User {
+	exported := User{
+		Name:               user.Name,
+		IsAdmin:            user.IsAdmin,
+		PasswordHash:       user.Password.Hash,
+		MustChangePassword: user.Password.IsGenerated,
+		StreamKey:          user.StreamKey,
+		Manages:            user.Manages,
+		ExpiresAt:          user.ExpiresAt,
+	}
+
+	for _, m := range user.Memberships {
+		exported.Memberships = append(exported.Memberships, Membership{Namespace: m.Namespace, Permission: m.Permission})
+	}
+
+	return exported
+}
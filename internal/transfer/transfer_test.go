@@ -0,0 +1,244 @@
+package transfer
+
+import (
+	"MediaMTXAuth/internal"
+	"MediaMTXAuth/internal/passwords"
+	"MediaMTXAuth/internal/services"
+	"MediaMTXAuth/internal/storage/memory"
+	"bytes"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestTransfer(t *testing.T) {
+	storage := &memory.Storage{}
+	if err := storage.Init(); err != nil {
+		t.Fatal(err)
+	}
+
+	userService := services.NewUserService(storage)
+	namespaceService := services.NewNamespaceService(storage)
+	transfer := New(userService, namespaceService)
+
+	setup := func(t *testing.T) {
+		t.Cleanup(storage.Clear)
+
+		if _, err := namespaceService.Create("studio"); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := userService.Create("alice", "password123", true, ""); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := userService.Create("bob", "password123", false, ""); err != nil {
+			t.Fatal(err)
+		}
+		if err := userService.SetMembership("bob", "studio", internal.PermissionPublish); err != nil {
+			t.Fatal(err)
+		}
+		if err := userService.SetExpiry("bob", time.Date(2030, 1, 1, 0, 0, 0, 0, time.UTC)); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	t.Run("export", func(t *testing.T) {
+		setup(t)
+
+		doc, err := transfer.Export(false)
+		if err != nil {
+			t.Fatalf("Failed to export: %v", err)
+		}
+
+		if len(doc.Namespaces) != 1 || len(doc.Users) != 2 {
+			t.Fatalf("Expected 1 namespace and 2 users, got %+v", doc)
+		}
+
+		for _, user := range doc.Users {
+			if user.StreamKey != "" {
+				t.Errorf("Expected no stream keys, got %q for %s", user.StreamKey, user.Name)
+			}
+			if user.PasswordHash == "" {
+				t.Errorf("Expected password hash for %s", user.Name)
+			}
+		}
+
+		doc, _ = transfer.Export(true)
+		if doc.Users[0].StreamKey == "" {
+			t.Errorf("Expected stream keys when requested")
+		}
+	})
+
+	t.Run("round trip", func(t *testing.T) {
+		setup(t)
+
+		for _, format := range []Format{JSON, YAML} {
+			doc, _ := transfer.Export(true)
+
+			var buf bytes.Buffer
+			if err := Encode(&buf, doc, format); err != nil {
+				t.Fatalf("Failed to encode %s: %v", format, err)
+			}
+
+			decoded, err := Decode(&buf)
+			if err != nil {
+				t.Fatalf("Failed to decode %s: %v", format, err)
+			}
+
+			if diff := cmp.Diff(doc, decoded); diff != "" {
+				t.Errorf("Decoded %s document differs (-want +got):\n%s", format, diff)
+			}
+
+			changes, err := transfer.Import(decoded, Replace)
+			if err != nil {
+				t.Fatalf("Failed to import %s: %v", format, err)
+			}
+			if len(changes) != 0 {
+				t.Errorf("Expected no changes importing an unchanged export, got %v", changes)
+			}
+		}
+	})
+
+	t.Run("import into empty installation", func(t *testing.T) {
+		setup(t)
+		doc, _ := transfer.Export(true)
+		storage.Clear()
+
+		changes, err := transfer.Import(doc, Merge)
+		if err != nil {
+			t.Fatalf("Failed to import: %v", err)
+		}
+		if len(changes) != 3 {
+			t.Errorf("Expected 3 changes, got %v", changes)
+		}
+
+		bob, err := userService.Get("bob")
+		if err != nil || bob == nil {
+			t.Fatalf("Expected imported user, got %v", err)
+		}
+		if m, _ := bob.Membership("studio"); m.Permission != internal.PermissionPublish {
+			t.Errorf("Expected imported membership, got %v", bob.Memberships)
+		}
+		if bob.StreamKey != doc.Users[1].StreamKey {
+			t.Errorf("Expected imported stream key")
+		}
+		if _, err := userService.Login("bob", "password123"); err != nil {
+			t.Errorf("Expected imported password to work: %v", err)
+		}
+	})
+
+	t.Run("merge keeps unlisted users", func(t *testing.T) {
+		setup(t)
+
+		doc, _ := transfer.Export(false)
+		doc.Users = doc.Users[1:]
+		doc.Users[0].Memberships = nil
+
+		changes, err := transfer.Import(doc, Merge)
+		if err != nil {
+			t.Fatalf("Failed to import: %v", err)
+		}
+
+		want := []string{"update user bob (memberships)"}
+		if diff := cmp.Diff(want, describe(changes)); diff != "" {
+			t.Errorf("Unexpected changes (-want +got):\n%s", diff)
+		}
+
+		if alice, _ := userService.Get("alice"); alice == nil {
+			t.Errorf("Merge should keep unlisted users")
+		}
+		if bob, _ := userService.Get("bob"); len(bob.Memberships) != 0 {
+			t.Errorf("Expected memberships to be replaced, got %v", bob.Memberships)
+		}
+	})
+
+	t.Run("replace deletes unlisted", func(t *testing.T) {
+		setup(t)
+
+		doc, _ := transfer.Export(false)
+		doc.Namespaces = nil
+		doc.Users = doc.Users[:1]
+
+		changes, err := transfer.Plan(doc, Replace)
+		if err != nil {
+			t.Fatalf("Failed to plan: %v", err)
+		}
+
+		want := []string{"delete user bob", "delete namespace studio"}
+		if diff := cmp.Diff(want, describe(changes)); diff != "" {
+			t.Errorf("Unexpected changes (-want +got):\n%s", diff)
+		}
+
+		if bob, _ := userService.Get("bob"); bob == nil {
+			t.Errorf("Plan should not change anything")
+		}
+
+		if _, err := transfer.Import(doc, Replace); err != nil {
+			t.Fatalf("Failed to import: %v", err)
+		}
+		if bob, _ := userService.Get("bob"); bob != nil {
+			t.Errorf("Expected unlisted user to be deleted")
+		}
+		if namespace, _ := namespaceService.Get("studio"); namespace != nil {
+			t.Errorf("Expected unlisted namespace to be deleted")
+		}
+	})
+
+	t.Run("invalid documents", func(t *testing.T) {
+		setup(t)
+		bob, _ := userService.Get("bob")
+		const key = "0123456789abcdef"
+
+		tests := map[string]struct {
+			change func(doc *Document)
+			mode   Mode
+			want   error
+		}{
+			"version":            {func(doc *Document) { doc.Version = 2 }, Merge, ErrUnsupportedVersion},
+			"mode":               {func(doc *Document) {}, "overwrite", ErrInvalidMode},
+			"duplicate user":     {func(doc *Document) { doc.Users = append(doc.Users, doc.Users[0]) }, Merge, ErrDuplicateName},
+			"invalid name":       {func(doc *Document) { doc.Users[0].Name = "x" }, Merge, services.ErrShortUsername},
+			"invalid hash":       {func(doc *Document) { doc.Users[0].PasswordHash = "plaintext" }, Merge, passwords.ErrInvalidHash},
+			"short stream key":   {func(doc *Document) { doc.Users[0].StreamKey = "short" }, Merge, services.ErrShortStreamKey},
+			"repeated key":       {func(doc *Document) { doc.Users[0].StreamKey, doc.Users[1].StreamKey = key, key }, Merge, internal.ErrStreamKeyTaken},
+			"taken key":          {func(doc *Document) { doc.Users[0].StreamKey = bob.StreamKey }, Merge, internal.ErrStreamKeyTaken},
+			"invalid permission": {func(doc *Document) { doc.Users[1].Memberships[0].Permission = "own" }, Merge, internal.ErrInvalidPermission},
+			"unknown namespace":  {func(doc *Document) { doc.Users[1].Manages = []string{"missing"} }, Merge, internal.ErrNamespaceNotFound},
+			"removed namespace":  {func(doc *Document) { doc.Namespaces = nil }, Replace, internal.ErrNamespaceNotFound},
+		}
+
+		for name, test := range tests {
+			t.Run(name, func(t *testing.T) {
+				doc, _ := transfer.Export(false)
+				test.change(doc)
+
+				if _, err := transfer.Plan(doc, test.mode); !errors.Is(err, test.want) {
+					t.Errorf("Expected %v from the plan, got %v", test.want, err)
+				}
+				if _, err := transfer.Import(doc, test.mode); !errors.Is(err, test.want) {
+					t.Errorf("Expected %v, got %v", test.want, err)
+				}
+			})
+		}
+
+		if users, _ := userService.GetAllUsers(); len(users) != 2 {
+			t.Errorf("Invalid documents should not change anything, got %d users", len(users))
+		}
+	})
+
+	t.Run("unknown fields", func(t *testing.T) {
+		_, err := Decode(bytes.NewBufferString(`{"version": 1, "usres": []}`))
+		if err == nil {
+			t.Errorf("Expected error for unknown field")
+		}
+	})
+}
+
+func describe(changes []Change) []string {
+	var s []string
+	for _, change := range changes {
+		s = append(s, change.String())
+	}
+	return s
+}
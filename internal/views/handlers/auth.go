@@ -1,48 +1,247 @@
 package handlers
 
 import (
+	"MediaMTXAuth/internal"
 	"MediaMTXAuth/internal/views"
+	"errors"
 	"net/http"
+	"strconv"
+	"strings"
+	"time"
 )
 
-func RequireAuth(page *views.Page, w http.ResponseWriter, r *http.Request) (string, bool) {
+func SetSessionCookies(w http.ResponseWriter, r *http.Request, user *internal.User) {
+	maxAge := int(time.Until(user.Session.Expiration).Seconds())
+
+	setCookie(w, r, "session_id", strconv.FormatUint(user.Session.ID, 10), maxAge)
+	setCookie(w, r, "username", user.Name, maxAge)
+}
+
+// ClearSessionCookies removes the cookies set by SetSessionCookies.
+func ClearSessionCookies(w http.ResponseWriter, r *http.Request) {
+	setCookie(w, r, "session_id", "", -1)
+	setCookie(w, r, "username", "", -1)
+}
+
+// SetImpersonatorCookies remembers the session of the admin who starts an
+// impersonation, so that it can be restored when the impersonation ends.
+func SetImpersonatorCookies(w http.ResponseWriter, r *http.Request, admin *internal.User) {
+	maxAge := int(time.Until(admin.Session.Expiration).Seconds())
+
+	setCookie(w, r, "impersonator_session_id", strconv.FormatUint(admin.Session.ID, 10), maxAge)
+	setCookie(w, r, "impersonator", admin.Name, maxAge)
+}
+
+func ClearImpersonatorCookies(w http.ResponseWriter, r *http.Request) {
+	setCookie(w, r, "impersonator_session_id", "", -1)
+	setCookie(w, r, "impersonator", "", -1)
+}
+
+func setCookie(w http.ResponseWriter, r *http.Request, name, value string, maxAge int) {
+	http.SetCookie(w, &http.Cookie{
+		Name:     name,
+		Value:    value,
+		Path:     "/",
+		HttpOnly: true,
+		Secure:   r.TLS != nil,
+		SameSite: http.SameSiteStrictMode,
+		MaxAge:   maxAge,
+	})
+}
+
+// CurrentSession returns the session of user that the request was made with.
+func CurrentSession(r *http.Request, user *internal.User) (internal.UserSession, bool) {
+	cookie, err := r.Cookie("session_id")
+	if err != nil {
+		return internal.UserSession{}, false
+	}
+
+	return FindSession(user, cookie.Value)
+}
+
+// FindSession returns the session of user with the given ID.
+func FindSession(user *internal.User, sessionID string) (internal.UserSession, bool) {
+	for _, session := range user.Sessions {
+		if strconv.FormatUint(session.ID, 10) == sessionID {
+			return session, true
+		}
+	}
+
+	return internal.UserSession{}, false
+}
+
+func RedirectHome(w http.ResponseWriter, r *http.Request, user *internal.User) {
+	if user.IsAdmin || user.IsManager() {
+		http.Redirect(w, r, "/admin", http.StatusSeeOther)
+	} else {
+		http.Redirect(w, r, "/panel", http.StatusSeeOther)
+	}
+}
+
+var (
+	ErrNotLoggedIn   = errors.New("not logged in")
+	ErrReadOnlyToken = errors.New("API token is read-only")
+)
+
+// bearerToken returns the API token sent in the Authorization header.
+func bearerToken(r *http.Request) (string, bool) {
+	return strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+}
+
+// Authenticate checks the API token or the session cookies of a request and
+// returns the username. The token is nil for cookie sessions. Read-only tokens
+// only allow GET and HEAD.
+func Authenticate(userService internal.UserService, r *http.Request) (string, *internal.APIToken, error) {
+	if secret, ok := bearerToken(r); ok {
+		user, token, err := userService.AuthenticateToken(secret)
+		if err != nil {
+			return "", nil, err
+		}
+
+		if token.Scope == internal.ScopeRead && r.Method != http.MethodGet && r.Method != http.MethodHead {
+			return "", nil, ErrReadOnlyToken
+		}
+
+		return user.Name, token, nil
+	}
+
 	cookie, err := r.Cookie("session_id")
 	if err != nil || cookie.Value == "" {
-		http.Redirect(w, r, "/login", http.StatusFound)
-		return "", false
+		return "", nil, ErrNotLoggedIn
 	}
 
 	usernameCookie, err := r.Cookie("username")
 	if err != nil || usernameCookie.Value == "" {
-		http.Redirect(w, r, "/login", http.StatusFound)
-		return "", false
+		return "", nil, ErrNotLoggedIn
 	}
 
-	valid, err := page.UserService.VerifySession(usernameCookie.Value, cookie.Value)
+	valid, err := userService.VerifySession(usernameCookie.Value, cookie.Value)
 	if err != nil || !valid {
-		http.Redirect(w, r, "/login", http.StatusFound)
-		return "", false
+		return "", nil, ErrNotLoggedIn
 	}
 
-	return usernameCookie.Value, true
+	return usernameCookie.Value, nil, nil
+}
+
+// AuthenticateManager is like Authenticate but only lets through admins and
+// namespace managers and returns the user. Impersonation sessions fail with
+// internal.ErrForbidden. Read-only tokens of admins can read everything, but
+// admins using a namespace:write token are treated as managers of the
+// namespaces they manage, if any.
+func AuthenticateManager(userService internal.UserService, r *http.Request) (*internal.User, error) {
+	username, token, err := Authenticate(userService, r)
+	if err != nil {
+		return nil, err
+	}
+
+	user, err := userService.Get(username)
+	if err != nil || user == nil {
+		return nil, ErrNotLoggedIn
+	}
+
+	if token != nil {
+		user.IsAdmin = user.IsAdmin && token.Scope != internal.ScopeNamespaceWrite
+	} else if session, _ := CurrentSession(r, user); session.ImpersonatedBy != "" {
+		return nil, internal.ErrForbidden
+	}
+
+	if !user.IsAdmin && !user.IsManager() {
+		return nil, internal.ErrForbidden
+	}
+
+	return user, nil
+}
+
+// authenticate wraps Authenticate for HTML pages. Requests without a login
+// session are redirected to the login page, while requests with a bad API
+// token get a plain error instead.
+func authenticate(page *views.Page, w http.ResponseWriter, r *http.Request) (string, *internal.APIToken, bool) {
+	username, token, err := Authenticate(page.UserService, r)
+	if err != nil {
+		fail(w, r, err, "/login")
+		return "", nil, false
+	}
+
+	return username, token, true
+}
+
+// fail answers requests made with an API token with an error status and
+// redirects everything else.
+func fail(w http.ResponseWriter, r *http.Request, err error, redirect string) {
+	if _, ok := bearerToken(r); !ok {
+		http.Redirect(w, r, redirect, http.StatusFound)
+		return
+	}
+
+	if errors.Is(err, ErrReadOnlyToken) || errors.Is(err, internal.ErrForbidden) {
+		http.Error(w, err.Error(), http.StatusForbidden)
+		return
+	}
+
+	http.Error(w, err.Error(), http.StatusUnauthorized)
+}
+
+// RequireAuth accepts session cookies and API tokens of any scope.
+func RequireAuth(page *views.Page, w http.ResponseWriter, r *http.Request) (string, bool) {
+	username, _, authenticated := authenticate(page, w, r)
+	return username, authenticated
 }
 
 func RequireAdminAuth(page *views.Page, w http.ResponseWriter, r *http.Request) (string, bool) {
-	username, authenticated := RequireAuth(page, w, r)
+	user, err := AuthenticateManager(page.UserService, r)
+	if err == nil && !user.IsAdmin {
+		err = internal.ErrForbidden
+	}
+
+	if errors.Is(err, internal.ErrForbidden) {
+		fail(w, r, err, "/panel")
+		return "", false
+	} else if err != nil {
+		fail(w, r, err, "/login")
+		return "", false
+	}
+
+	return user.Name, true
+}
+
+// RequireSelfAuth is like RequireAuth but refuses impersonation sessions and
+// API tokens. It guards actions that only the owner of the account may take.
+func RequireSelfAuth(page *views.Page, w http.ResponseWriter, r *http.Request) (string, bool) {
+	username, token, authenticated := authenticate(page, w, r)
 	if !authenticated {
 		return "", false
 	}
 
+	if token != nil {
+		http.Error(w, "Not allowed with an API token", http.StatusForbidden)
+		return "", false
+	}
+
 	user, err := page.UserService.Get(username)
-	if err != nil {
+	if err != nil || user == nil {
 		http.Redirect(w, r, "/login", http.StatusFound)
 		return "", false
 	}
 
-	if !user.IsAdmin {
-		http.Redirect(w, r, "/panel", http.StatusFound)
+	if session, _ := CurrentSession(r, user); session.ImpersonatedBy != "" {
+		http.Error(w, "Not allowed while viewing as another user", http.StatusForbidden)
 		return "", false
 	}
 
 	return username, true
 }
+
+// RequireManagerAuth lets through admins and namespace managers and returns
+// the authenticated user. Impersonation sessions only have access to /panel.
+func RequireManagerAuth(page *views.Page, w http.ResponseWriter, r *http.Request) (*internal.User, bool) {
+	user, err := AuthenticateManager(page.UserService, r)
+	if errors.Is(err, internal.ErrForbidden) {
+		fail(w, r, err, "/panel")
+		return nil, false
+	} else if err != nil {
+		fail(w, r, err, "/login")
+		return nil, false
+	}
+
+	return user, true
+}
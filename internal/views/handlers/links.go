@@ -0,0 +1,26 @@
+package handlers
+
+import (
+	"net/http"
+	"net/url"
+)
+
+// BaseURL returns the scheme and host the request was sent to, taking a TLS
+// terminating proxy into account.
+func BaseURL(r *http.Request) string {
+	scheme := "http"
+	if r.TLS != nil || r.Header.Get("X-Forwarded-Proto") == "https" {
+		scheme = "https"
+	}
+	return scheme + "://" + r.Host
+}
+
+// ResetLink returns the link a user sets a new password with.
+func ResetLink(r *http.Request, username, token string) string {
+	return BaseURL(r) + "/reset?" + url.Values{"user": {username}, "token": {token}}.Encode()
+}
+
+// InviteLink returns the link that lets people join namespace.
+func InviteLink(r *http.Request, namespace, token string) string {
+	return BaseURL(r) + "/invite?" + url.Values{"namespace": {namespace}, "token": {token}}.Encode()
+}
@@ -13,20 +13,66 @@ type Page struct {
 type LoginData struct {
 	Error   string
 	Message string
+	// Setup shows the form for creating the first admin instead of the login.
+	Setup bool
 }
 
 type AdminData struct {
 	Error      string
 	Message    string
-	User       internal.User
-	Users      []internal.User
-	Namespaces []internal.Namespace
+	User internal.User
+	// Users and Namespaces are the pages shown in the tables, whose state
+	// is in UserList and NamespaceList. AllNamespaces are the namespaces
+	// the user can pick in forms.
+	Users         []internal.User
+	Namespaces    []internal.Namespace
+	AllNamespaces []internal.Namespace
+	UserList      ListView
+	NamespaceList ListView
+	// Resets are the users with a pending password reset link, whether
+	// they are on the page shown or not.
+	Resets []internal.User
 
 	TempPassword string
+	ResetLink    string
+	InviteLink   string
+
+	// ImportChanges lists what an import made or, with ImportDryRun, would
+	// make.
+	ImportChanges []string
+	ImportDryRun  bool
 }
 
 type PanelData struct {
 	Error   string
 	Message string
 	User    internal.User
+	// Session is the session the page is viewed with. It tells whether an
+	// admin is viewing the panel as the user.
+	Session internal.UserSession
+	// NewToken is a freshly created API token. It is shown only once.
+	NewToken string
+}
+
+type ResetData struct {
+	Error    string
+	Username string
+	Token    string
+}
+
+type InviteData struct {
+	Error     string
+	Namespace string
+	Token     string
+	Username  string
+}
+
+type UserData struct {
+	Error      string
+	Message    string
+	Actor      internal.User
+	User       internal.User
+	Namespaces []internal.Namespace
+
+	ResetLink string
 }
@@ -0,0 +1,115 @@
+package views
+
+import (
+	"MediaMTXAuth/internal"
+	"net/url"
+)
+
+// ListView is the state of a searchable, sortable and paged table on the
+// admin page. It is kept in the query string, with parameter names prefixed
+// by the table, such as users_q.
+type ListView struct {
+	Search string
+	Prefix bool
+	Sort   string
+	Desc   bool
+	Cursor string
+	// Next is the cursor of the following page, empty on the last page.
+	Next string
+}
+
+// ParseListView reads the state of table from query.
+func ParseListView(query url.Values, table string) ListView {
+	return ListView{
+		Search: query.Get(table + "_q"),
+		Prefix: query.Get(table+"_prefix") != "",
+		Sort:   query.Get(table + "_sort"),
+		Desc:   query.Get(table+"_desc") != "",
+		Cursor: query.Get(table + "_cursor"),
+	}
+}
+
+// Options returns the list options selecting the page shown.
+func (l ListView) Options() internal.ListOptions {
+	return internal.ListOptions{
+		Search: l.Search,
+		Prefix: l.Prefix,
+		Sort:   l.Sort,
+		Desc:   l.Desc,
+		Cursor: l.Cursor,
+	}
+}
+
+// SortMark returns an arrow if the table is sorted by column.
+func (l ListView) SortMark(column string) string {
+	if l.Sort != column && (l.Sort != "" || column != internal.SortByName) {
+		return ""
+	}
+	if l.Desc {
+		return "▼"
+	}
+	return "▲"
+}
+
+// encode adds the state of l for table to query, leaving out defaults.
+func (l ListView) encode(query url.Values, table string) {
+	for name, value := range map[string]string{"q": l.Search, "sort": l.Sort, "cursor": l.Cursor} {
+		if value != "" {
+			query.Set(table+"_"+name, value)
+		}
+	}
+	for name, value := range map[string]bool{"prefix": l.Prefix, "desc": l.Desc} {
+		if value {
+			query.Set(table+"_"+name, "1")
+		}
+	}
+}
+
+// list returns the state of table.
+func (d *AdminData) list(table string) *ListView {
+	if table == "namespaces" {
+		return &d.NamespaceList
+	}
+	return &d.UserList
+}
+
+// ListURL returns the admin page URL with one table changed and the other
+// kept as it is. action is "sort" to sort by the column value, which toggles
+// the order if the table is sorted by it already, "next" to show the next
+// page or "first" to show the first page.
+func (d AdminData) ListURL(table, action, value string) string {
+	l := d.list(table)
+
+	switch action {
+	case "sort":
+		l.Desc = l.SortMark(value) == "▲"
+		l.Sort = value
+		l.Cursor = ""
+	case "next":
+		l.Cursor = l.Next
+	case "first":
+		l.Cursor = ""
+	}
+
+	query := url.Values{}
+	d.UserList.encode(query, "users")
+	d.NamespaceList.encode(query, "namespaces")
+	return "/admin?" + query.Encode()
+}
+
+// ListFields returns the query parameters a search form for table has to
+// send along: the sorting of table and the state of the other table.
+func (d AdminData) ListFields(table string) map[string]string {
+	l := d.list(table)
+	l.Search, l.Prefix, l.Cursor = "", false, ""
+
+	query := url.Values{}
+	d.UserList.encode(query, "users")
+	d.NamespaceList.encode(query, "namespaces")
+
+	fields := make(map[string]string)
+	for name := range query {
+		fields[name] = query.Get(name)
+	}
+	return fields
+}
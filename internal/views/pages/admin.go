@@ -2,12 +2,17 @@ package pages
 
 import (
 	"MediaMTXAuth/internal"
+	"MediaMTXAuth/internal/services"
 	"MediaMTXAuth/internal/views"
 	"MediaMTXAuth/internal/views/handlers"
 	"crypto/rand"
 	_ "embed"
+	"errors"
+	"fmt"
 	"html/template"
 	"net/http"
+	"strconv"
+	"time"
 )
 
 //go:embed html/admin.html
@@ -16,10 +21,16 @@ var AdminPageHTML string
 type AdminPage struct {
 	*views.Page
 	NamespaceService internal.NamespaceService
+	// Kicker disconnects live streams of suspended users. It is optional.
+	Kicker internal.SessionKicker
+}
+
+var adminFuncs = template.FuncMap{
+	"countdown": countdown,
 }
 
 func NewAdmin(userService internal.UserService, namespaceService internal.NamespaceService) *AdminPage {
-	tmpl := template.Must(template.New("pages").Parse(AdminPageHTML))
+	tmpl := template.Must(template.New("pages").Funcs(adminFuncs).Parse(AdminPageHTML))
 	return &AdminPage{
 		Page: &views.Page{
 			UserService: userService,
@@ -39,103 +50,433 @@ func (v *AdminPage) ServeHTTP(rw http.ResponseWriter, r *http.Request) {
 }
 
 func (v *AdminPage) showAdminForm(rw http.ResponseWriter, r *http.Request) {
-	username, authenticated := handlers.RequireAdminAuth(v.Page, rw, r)
+	actor, authenticated := handlers.RequireManagerAuth(v.Page, rw, r)
 	if !authenticated {
 		return
 	}
 
-	currentUser, err := v.UserService.Get(username)
-	if err != nil {
-		http.Redirect(rw, r, "/login", http.StatusFound)
+	query := r.URL.Query()
+	v.render(rw, actor, views.AdminData{
+		UserList:      views.ParseListView(query, "users"),
+		NamespaceList: views.ParseListView(query, "namespaces"),
+	})
+}
+
+func (v *AdminPage) HandleAddUser(rw http.ResponseWriter, r *http.Request) {
+	actor, authenticated := handlers.RequireManagerAuth(v.Page, rw, r)
+	if !authenticated {
 		return
 	}
 
-	// Get all users from storage
-	users, err := v.UserService.GetAllUsers()
+	username := r.FormValue("username")
+	namespace := r.FormValue("namespace")
+	isAdmin := r.FormValue("isAdmin") == "true"
+	isManager := r.FormValue("isManager") == "true"
+	password := rand.Text()
+
+	expiresAt, err := parseDateTime(r.FormValue("expiresAt"))
 	if err != nil {
-		data := views.AdminData{Error: "Failed to load users", User: *currentUser}
-		v.renderTemplate(rw, data)
+		v.render(rw, actor, views.AdminData{Error: "Invalid expiry date"})
 		return
 	}
 
-	// Get all namespaces from storage
-	namespaces, err := v.NamespaceService.GetAllNamespaces()
+	switch {
+	case (isAdmin || isManager) && !actor.IsAdmin:
+		err = internal.ErrForbidden
+	case !actor.CanManage(namespace):
+		err = internal.ErrForbidden
+	case isManager && namespace == "":
+		err = services.ErrManagerWithoutNamespace
+	}
+
+	if err == nil {
+		_, err = v.UserService.Create(username, password, isAdmin, namespace)
+		if err == nil {
+			err = v.configureNewUser(username, namespace, isManager, expiresAt)
+		}
+	}
+
 	if err != nil {
-		data := views.AdminData{Error: "Failed to load namespaces", Users: users, User: *currentUser}
-		v.renderTemplate(rw, data)
+		v.render(rw, actor, views.AdminData{Error: err.Error()})
 		return
 	}
 
-	data := views.AdminData{Users: users, Namespaces: namespaces, User: *currentUser}
-	v.renderTemplate(rw, data)
+	v.render(rw, actor, views.AdminData{TempPassword: password})
 }
 
-func (v *AdminPage) HandleAddUser(rw http.ResponseWriter, r *http.Request) {
+// configureNewUser gives the user just created by HandleAddUser the rights
+// and expiry asked for. If that fails, the user is deleted again, so that no
+// user without them is left behind.
+func (v *AdminPage) configureNewUser(username, namespace string, isManager bool, expiresAt time.Time) error {
 	var err error
-	var currentUser *internal.User
-	var data views.AdminData
+	if isManager {
+		err = v.UserService.SetManagedNamespaces(username, []string{namespace})
+	}
+	if err == nil && !expiresAt.IsZero() {
+		err = v.UserService.SetExpiry(username, expiresAt)
+	}
+	if err != nil {
+		return errors.Join(err, v.UserService.Delete(username))
+	}
+	return nil
+}
 
-	usernameAuth, authenticated := handlers.RequireAdminAuth(v.Page, rw, r)
+func (v *AdminPage) HandleRemoveUser(rw http.ResponseWriter, r *http.Request) {
+	actor, authenticated := handlers.RequireManagerAuth(v.Page, rw, r)
 	if !authenticated {
 		return
 	}
 
 	username := r.FormValue("username")
-	namespace := r.FormValue("namespace")
-	isAdminStr := r.FormValue("isAdmin")
-	isAdmin := isAdminStr == "true"
-	password := rand.Text()
-	currentUser, err = v.UserService.Get(usernameAuth)
 
-	if currentUser != nil {
-		data.User = *currentUser
+	_, err := services.AuthorizeEdit(v.UserService, actor, username)
+	if err == nil {
+		err = v.UserService.Delete(username)
 	}
 
 	if err != nil {
-		goto end
+		v.render(rw, actor, views.AdminData{Error: err.Error()})
+		return
 	}
 
-	data.Namespaces, err = v.NamespaceService.GetAllNamespaces()
+	http.Redirect(rw, r, "/admin", http.StatusSeeOther)
+}
+
+func (v *AdminPage) HandleUnlockUser(rw http.ResponseWriter, r *http.Request) {
+	actor, authenticated := handlers.RequireManagerAuth(v.Page, rw, r)
+	if !authenticated {
+		return
+	}
+
+	username := r.FormValue("username")
+
+	_, err := services.AuthorizeUser(v.UserService, actor, username)
+	if err == nil {
+		err = v.UserService.Unlock(username)
+	}
 
 	if err != nil {
-		goto end
+		v.render(rw, actor, views.AdminData{Error: err.Error()})
+		return
 	}
 
-	_, err = v.UserService.Create(username, password, isAdmin, namespace)
+	redirectBack(rw, r)
+}
+
+func (v *AdminPage) HandleSuspendUser(rw http.ResponseWriter, r *http.Request) {
+	actor, authenticated := handlers.RequireManagerAuth(v.Page, rw, r)
+	if !authenticated {
+		return
+	}
+
+	username := r.FormValue("username")
+	reason := r.FormValue("reason")
+
+	var until time.Time
+	if value := r.FormValue("until"); value != "" {
+		var err error
+		until, err = time.ParseInLocation("2006-01-02", value, time.Local)
+		if err != nil {
+			v.render(rw, actor, views.AdminData{Error: "Invalid reactivation date"})
+			return
+		}
+	}
+
+	_, err := services.AuthorizeUser(v.UserService, actor, username)
+	if err == nil && username == actor.Name {
+		err = services.ErrSuspendSelf
+	}
+	if err == nil {
+		err = v.UserService.Suspend(username, reason, actor.Name, until)
+	}
 
 	if err != nil {
-		goto end
+		v.render(rw, actor, views.AdminData{Error: err.Error()})
+		return
 	}
 
-	data.Users, err = v.UserService.GetAllUsers()
+	services.KickStreams(v.UserService, v.Kicker, username)
+
+	redirectBack(rw, r)
+}
+
+func (v *AdminPage) HandleReactivateUser(rw http.ResponseWriter, r *http.Request) {
+	actor, authenticated := handlers.RequireManagerAuth(v.Page, rw, r)
+	if !authenticated {
+		return
+	}
+
+	username := r.FormValue("username")
 
-end:
+	_, err := services.AuthorizeUser(v.UserService, actor, username)
 	if err == nil {
-		data.TempPassword = password
-	} else {
-		data.Error = err.Error()
+		err = v.UserService.Reactivate(username)
 	}
 
-	v.renderTemplate(rw, data)
+	if err != nil {
+		v.render(rw, actor, views.AdminData{Error: err.Error()})
+		return
+	}
 
-	return
+	redirectBack(rw, r)
 }
 
-func (v *AdminPage) HandleRemoveUser(rw http.ResponseWriter, r *http.Request) {
-	usernameAuth, authenticated := handlers.RequireAdminAuth(v.Page, rw, r)
+// HandleLogoutUser ends every login session of a user.
+func (v *AdminPage) HandleLogoutUser(rw http.ResponseWriter, r *http.Request) {
+	actor, authenticated := handlers.RequireManagerAuth(v.Page, rw, r)
+	if !authenticated {
+		return
+	}
+
+	username := r.FormValue("username")
+
+	_, err := services.AuthorizeUser(v.UserService, actor, username)
+	if err == nil {
+		err = v.UserService.LogoutAll(username, actor.Name)
+	}
+
+	if err != nil {
+		v.render(rw, actor, views.AdminData{Error: err.Error()})
+		return
+	}
+
+	redirectBack(rw, r)
+}
+
+func (v *AdminPage) HandleSetExpiry(rw http.ResponseWriter, r *http.Request) {
+	actor, authenticated := handlers.RequireManagerAuth(v.Page, rw, r)
+	if !authenticated {
+		return
+	}
+
+	username := r.FormValue("username")
+
+	expiresAt, err := parseDateTime(r.FormValue("expiresAt"))
+	if err != nil {
+		v.render(rw, actor, views.AdminData{Error: "Invalid expiry date"})
+		return
+	}
+
+	_, err = services.AuthorizeEdit(v.UserService, actor, username)
+	if err == nil {
+		err = v.UserService.SetExpiry(username, expiresAt)
+	}
+
+	if err != nil {
+		v.render(rw, actor, views.AdminData{Error: err.Error()})
+		return
+	}
+
+	redirectBack(rw, r)
+}
+
+func (v *AdminPage) HandleResetStreamKey(rw http.ResponseWriter, r *http.Request) {
+	actor, authenticated := handlers.RequireManagerAuth(v.Page, rw, r)
+	if !authenticated {
+		return
+	}
+
+	username := r.FormValue("username")
+
+	_, err := services.AuthorizeEdit(v.UserService, actor, username)
+	if err == nil {
+		_, err = v.UserService.RotateStreamKey(username, actor.Name, 0)
+	}
+
+	if err != nil {
+		v.render(rw, actor, views.AdminData{Error: err.Error()})
+		return
+	}
+
+	v.render(rw, actor, views.AdminData{Message: "Stream key of " + username + " has been reset"})
+}
+
+func (v *AdminPage) HandleSetMembership(rw http.ResponseWriter, r *http.Request) {
+	actor, authenticated := handlers.RequireManagerAuth(v.Page, rw, r)
+	if !authenticated {
+		return
+	}
+
+	username := r.FormValue("username")
+	namespace := r.FormValue("namespace")
+	permission := internal.Permission(r.FormValue("permission"))
+
+	err := services.AuthorizeMembership(v.UserService, actor, username, namespace)
+	if err == nil {
+		err = v.UserService.SetMembership(username, namespace, permission)
+	}
+
+	if err != nil {
+		v.render(rw, actor, views.AdminData{Error: err.Error()})
+		return
+	}
+
+	redirectBack(rw, r)
+}
+
+func (v *AdminPage) HandleRemoveMembership(rw http.ResponseWriter, r *http.Request) {
+	actor, authenticated := handlers.RequireManagerAuth(v.Page, rw, r)
+	if !authenticated {
+		return
+	}
+
+	username := r.FormValue("username")
+	namespace := r.FormValue("namespace")
+
+	err := services.AuthorizeMembership(v.UserService, actor, username, namespace)
+	if err == nil {
+		err = v.UserService.RemoveMembership(username, namespace)
+	}
+
+	if err != nil {
+		v.render(rw, actor, views.AdminData{Error: err.Error()})
+		return
+	}
+
+	redirectBack(rw, r)
+}
+
+func (v *AdminPage) HandleCreateResetLink(rw http.ResponseWriter, r *http.Request) {
+	actor, authenticated := handlers.RequireManagerAuth(v.Page, rw, r)
 	if !authenticated {
 		return
 	}
 
 	username := r.FormValue("username")
 
-	err := v.UserService.Delete(username)
+	_, err := services.AuthorizeEdit(v.UserService, actor, username)
+	if err != nil {
+		v.render(rw, actor, views.AdminData{Error: err.Error()})
+		return
+	}
+
+	token, err := v.UserService.CreatePasswordReset(username, actor.Name)
+	if err != nil {
+		v.render(rw, actor, views.AdminData{Error: err.Error()})
+		return
+	}
+
+	link := handlers.ResetLink(r, username, token)
+	v.render(rw, actor, views.AdminData{ResetLink: link})
+}
+
+func (v *AdminPage) HandleCancelResetLink(rw http.ResponseWriter, r *http.Request) {
+	actor, authenticated := handlers.RequireManagerAuth(v.Page, rw, r)
+	if !authenticated {
+		return
+	}
+
+	username := r.FormValue("username")
+
+	_, err := services.AuthorizeUser(v.UserService, actor, username)
+	if err == nil {
+		err = v.UserService.CancelPasswordReset(username)
+	}
+
+	if err != nil {
+		v.render(rw, actor, views.AdminData{Error: err.Error()})
+		return
+	}
+
+	redirectBack(rw, r)
+}
+
+func (v *AdminPage) HandleAddInvitation(rw http.ResponseWriter, r *http.Request) {
+	actor, authenticated := handlers.RequireManagerAuth(v.Page, rw, r)
+	if !authenticated {
+		return
+	}
+
+	namespace := r.FormValue("namespace")
+	role := internal.Role(r.FormValue("role"))
+
+	if !actor.CanManage(namespace) || (role != internal.RoleUser && !actor.IsAdmin) {
+		v.render(rw, actor, views.AdminData{Error: internal.ErrForbidden.Error()})
+		return
+	}
+
+	maxUses, err := strconv.Atoi(r.FormValue("maxUses"))
+	if err != nil {
+		v.render(rw, actor, views.AdminData{Error: "Invalid max uses"})
+		return
+	}
+
+	expiresHours, err := strconv.Atoi(r.FormValue("expiresHours"))
+	if err != nil {
+		v.render(rw, actor, views.AdminData{Error: "Invalid expiration"})
+		return
+	}
+
+	_, token, err := v.NamespaceService.CreateInvitation(namespace, role, maxUses, time.Duration(expiresHours)*time.Hour, actor.Name)
+	if err != nil {
+		v.render(rw, actor, views.AdminData{Error: err.Error()})
+		return
+	}
+
+	link := handlers.InviteLink(r, namespace, token)
+	v.render(rw, actor, views.AdminData{InviteLink: link})
+}
+
+func (v *AdminPage) HandleRevokeInvitation(rw http.ResponseWriter, r *http.Request) {
+	actor, authenticated := handlers.RequireManagerAuth(v.Page, rw, r)
+	if !authenticated {
+		return
+	}
+
+	namespace := r.FormValue("namespace")
+	id := r.FormValue("id")
+
+	err := internal.ErrForbidden
+	if actor.CanManage(namespace) {
+		err = v.NamespaceService.RevokeInvitation(namespace, id)
+	}
+
+	if err != nil {
+		v.render(rw, actor, views.AdminData{Error: err.Error()})
+		return
+	}
+
+	http.Redirect(rw, r, "/admin", http.StatusSeeOther)
+}
+
+func (v *AdminPage) HandleAddSession(rw http.ResponseWriter, r *http.Request) {
+	actor, authenticated := handlers.RequireManagerAuth(v.Page, rw, r)
+	if !authenticated {
+		return
+	}
+
+	namespace := r.FormValue("namespace")
+	name := r.FormValue("name")
+
+	err := internal.ErrForbidden
+	if actor.CanManage(namespace) {
+		_, err = v.NamespaceService.AddSession(namespace, name, actor.Name)
+	}
+
 	if err != nil {
-		currentUser, _ := v.UserService.Get(usernameAuth)
-		users, _ := v.UserService.GetAllUsers()
-		namespaces, _ := v.NamespaceService.GetAllNamespaces()
-		data := views.AdminData{Error: err.Error(), Users: users, Namespaces: namespaces, User: *currentUser}
-		v.renderTemplate(rw, data)
+		v.render(rw, actor, views.AdminData{Error: err.Error()})
+		return
+	}
+
+	http.Redirect(rw, r, "/admin", http.StatusSeeOther)
+}
+
+func (v *AdminPage) HandleRemoveSession(rw http.ResponseWriter, r *http.Request) {
+	actor, authenticated := handlers.RequireManagerAuth(v.Page, rw, r)
+	if !authenticated {
+		return
+	}
+
+	namespace := r.FormValue("namespace")
+	key := r.FormValue("key")
+
+	err := internal.ErrForbidden
+	if actor.CanManage(namespace) {
+		err = v.NamespaceService.RemoveSession(namespace, key)
+	}
+
+	if err != nil {
+		v.render(rw, actor, views.AdminData{Error: err.Error()})
 		return
 	}
 
@@ -143,20 +484,20 @@ func (v *AdminPage) HandleRemoveUser(rw http.ResponseWriter, r *http.Request) {
 }
 
 func (v *AdminPage) HandleAddNamespace(rw http.ResponseWriter, r *http.Request) {
-	usernameAuth, authenticated := handlers.RequireAdminAuth(v.Page, rw, r)
+	actor, authenticated := handlers.RequireManagerAuth(v.Page, rw, r)
 	if !authenticated {
 		return
 	}
 
 	name := r.FormValue("name")
 
-	_, err := v.NamespaceService.Create(name)
+	err := internal.ErrForbidden
+	if actor.IsAdmin {
+		_, err = v.NamespaceService.Create(name)
+	}
+
 	if err != nil {
-		currentUser, _ := v.UserService.Get(usernameAuth)
-		users, _ := v.UserService.GetAllUsers()
-		namespaces, _ := v.NamespaceService.GetAllNamespaces()
-		data := views.AdminData{Error: err.Error(), Users: users, Namespaces: namespaces, User: *currentUser}
-		v.renderTemplate(rw, data)
+		v.render(rw, actor, views.AdminData{Error: err.Error()})
 		return
 	}
 
@@ -164,26 +505,126 @@ func (v *AdminPage) HandleAddNamespace(rw http.ResponseWriter, r *http.Request)
 }
 
 func (v *AdminPage) HandleRemoveNamespace(rw http.ResponseWriter, r *http.Request) {
-	usernameAuth, authenticated := handlers.RequireAdminAuth(v.Page, rw, r)
+	actor, authenticated := handlers.RequireManagerAuth(v.Page, rw, r)
 	if !authenticated {
 		return
 	}
 
 	name := r.FormValue("name")
 
-	err := v.NamespaceService.Delete(name)
+	err := internal.ErrForbidden
+	if actor.IsAdmin {
+		err = services.AuthorizeNamespaceRemoval(v.NamespaceService, name)
+	}
+
+	if err == nil {
+		err = v.NamespaceService.Delete(name)
+	}
+
 	if err != nil {
-		currentUser, _ := v.UserService.Get(usernameAuth)
-		users, _ := v.UserService.GetAllUsers()
-		namespaces, _ := v.NamespaceService.GetAllNamespaces()
-		data := views.AdminData{Error: err.Error(), Users: users, Namespaces: namespaces, User: *currentUser}
-		v.renderTemplate(rw, data)
+		v.render(rw, actor, views.AdminData{Error: err.Error()})
 		return
 	}
 
 	http.Redirect(rw, r, "/admin", http.StatusSeeOther)
 }
 
+// render fills in the current user and the users and namespaces visible to
+// them and renders the page.
+func (v *AdminPage) render(rw http.ResponseWriter, actor *internal.User, data views.AdminData) {
+	if current, _ := v.UserService.Get(actor.Name); current != nil {
+		actor = current
+	}
+	data.User = *actor
+
+	users, err := v.UserService.List(*actor, data.UserList.Options())
+	if err != nil && data.Error == "" {
+		data.Error = listError(err, "Failed to load users")
+	}
+	data.Users = users.Items
+	data.UserList.Next = users.Next
+
+	visible, err := v.UserService.GetVisibleTo(*actor)
+	if err != nil && data.Error == "" {
+		data.Error = "Failed to load password resets"
+	}
+	for _, user := range visible {
+		if user.PasswordReset.IsPending() {
+			data.Resets = append(data.Resets, user)
+		}
+	}
+
+	namespaces, err := v.NamespaceService.List(*actor, data.NamespaceList.Options())
+	if err != nil && data.Error == "" {
+		data.Error = listError(err, "Failed to load namespaces")
+	}
+	data.Namespaces = namespaces.Items
+	data.NamespaceList.Next = namespaces.Next
+
+	all, err := v.NamespaceService.GetAllNamespaces()
+	if err != nil && data.Error == "" {
+		data.Error = "Failed to load namespaces"
+	}
+
+	for _, namespace := range all {
+		if actor.CanManage(namespace.Name) {
+			data.AllNamespaces = append(data.AllNamespaces, namespace)
+		}
+	}
+
+	v.renderTemplate(rw, data)
+}
+
+// listError returns the message shown when a table cannot be loaded. Errors
+// caused by the query string, such as an outdated cursor, are shown as is.
+func listError(err error, message string) string {
+	if errors.Is(err, internal.ErrInvalidSort) || errors.Is(err, internal.ErrInvalidCursor) {
+		return err.Error()
+	}
+	return message
+}
+
+// redirectBack returns to the edit page of the user if the form was sent from
+// there and to the admin page otherwise.
+func redirectBack(rw http.ResponseWriter, r *http.Request) {
+	if r.FormValue("from") == "user" {
+		http.Redirect(rw, r, userURL(r.FormValue("username")), http.StatusSeeOther)
+		return
+	}
+
+	http.Redirect(rw, r, "/admin", http.StatusSeeOther)
+}
+
+// parseDateTime parses the value of a datetime-local input. An empty value
+// yields the zero time.
+func parseDateTime(value string) (time.Time, error) {
+	if value == "" {
+		return time.Time{}, nil
+	}
+	return time.ParseInLocation("2006-01-02T15:04", value, time.Local)
+}
+
+// countdown formats the time left until t, such as "2d 5h" or "40m".
+func countdown(t time.Time) string {
+	left := time.Until(t)
+	if left <= 0 {
+		return "expired"
+	}
+
+	days := int(left / (24 * time.Hour))
+	hours := int(left % (24 * time.Hour) / time.Hour)
+	minutes := int(left % time.Hour / time.Minute)
+
+	switch {
+	case days > 0:
+		return fmt.Sprintf("%dd %dh", days, hours)
+	case hours > 0:
+		return fmt.Sprintf("%dh %dm", hours, minutes)
+	default:
+		return fmt.Sprintf("%dm", max(minutes, 1))
+	}
+}
+
 func (v *AdminPage) renderTemplate(rw http.ResponseWriter, data views.AdminData) {
 	rw.Header().Set("Content-Type", "text/html; charset=utf-8")
 	if err := v.Template.Execute(rw, data); err != nil {
@@ -1,12 +1,17 @@
 package pages
 
 import (
+	"MediaMTXAuth/internal"
 	"MediaMTXAuth/internal/services"
 	"MediaMTXAuth/internal/storage/memory"
+	"bytes"
 	"fmt"
+	"html"
+	"mime/multipart"
 	"net/http"
 	"net/http/httptest"
 	"net/url"
+	"regexp"
 	"strings"
 	"testing"
 )
@@ -66,7 +71,7 @@ func TestAdminPage(t *testing.T) {
 	t.Run("GET admin page as admin", func(t *testing.T) {
 		t.Cleanup(storage.Clear)
 
-		adminPass, err := userService.CreateDefaultAdminUser()
+		adminPass, err := userService.CreateInitialAdmin(username, "")
 		if err != nil {
 			t.Fatalf("failed to create default admin: %v", err)
 		}
@@ -100,7 +105,7 @@ func TestAdminPage(t *testing.T) {
 	t.Run("POST add user as admin", func(t *testing.T) {
 		t.Cleanup(storage.Clear)
 
-		adminPass, _ := userService.CreateDefaultAdminUser()
+		adminPass, _ := userService.CreateInitialAdmin(username, "")
 		adminUser, _ := userService.Login(username, adminPass)
 
 		form := url.Values{}
@@ -135,9 +140,37 @@ func TestAdminPage(t *testing.T) {
 		}
 	})
 
+	t.Run("POST add user that cannot be configured", func(t *testing.T) {
+		t.Cleanup(storage.Clear)
+
+		adminPass, _ := userService.CreateInitialAdmin(username, "")
+		adminUser, _ := userService.Login(username, adminPass)
+
+		form := url.Values{}
+		form.Set("username", "newuser")
+		form.Set("namespace", "missing")
+		form.Set("isManager", "true")
+
+		req := httptest.NewRequest("POST", "/admin/add", strings.NewReader(form.Encode()))
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		req.AddCookie(&http.Cookie{Name: "session_id", Value: fmt.Sprintf("%d", adminUser.Session.ID)})
+		req.AddCookie(&http.Cookie{Name: "username", Value: adminUser.Name})
+		rec := httptest.NewRecorder()
+
+		page.HandleAddUser(rec, req)
+
+		if !strings.Contains(rec.Body.String(), internal.ErrNamespaceNotFound.Error()) {
+			t.Errorf("expected namespace error to be displayed")
+		}
+
+		if created, _ := userService.Get("newuser"); created != nil {
+			t.Errorf("expected no half configured user, got %+v", created)
+		}
+	})
+
 	t.Run("POST remove user as admin", func(t *testing.T) {
 		t.Cleanup(storage.Clear)
-		adminPass, _ := userService.CreateDefaultAdminUser()
+		adminPass, _ := userService.CreateInitialAdmin(username, "")
 		adminUser, _ := userService.Login(username, adminPass)
 
 		_, _ = userService.Create("toremove", "password", false, "")
@@ -168,4 +201,499 @@ func TestAdminPage(t *testing.T) {
 			t.Fatalf("expected toremove to be deleted")
 		}
 	})
+	t.Run("POST unlock user as admin", func(t *testing.T) {
+		t.Cleanup(storage.Clear)
+		adminPass, _ := userService.CreateInitialAdmin(username, "")
+		adminUser, _ := userService.Login(username, adminPass)
+
+		_, _ = userService.Create("locked", "password", false, "")
+		_, _ = userService.Login("locked", "wrongpassword")
+
+		form := url.Values{}
+		form.Set("username", "locked")
+
+		req := httptest.NewRequest("POST", "/admin/unlock", strings.NewReader(form.Encode()))
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		req.AddCookie(&http.Cookie{Name: "session_id", Value: fmt.Sprintf("%d", adminUser.Session.ID)})
+		req.AddCookie(&http.Cookie{Name: "username", Value: adminUser.Name})
+		rec := httptest.NewRecorder()
+
+		page.HandleUnlockUser(rec, req)
+		resp := rec.Result()
+
+		if resp.StatusCode != http.StatusSeeOther {
+			t.Fatalf("expected redirect after unlock, got %d", resp.StatusCode)
+		}
+
+		unlocked, _ := userService.Get("locked")
+		if unlocked.LoginAttempts.Failures != 0 {
+			t.Fatalf("expected failed attempts to be cleared")
+		}
+	})
+	t.Run("POST create reset link as admin", func(t *testing.T) {
+		t.Cleanup(storage.Clear)
+		adminPass, _ := userService.CreateInitialAdmin(username, "")
+		adminUser, _ := userService.Login(username, adminPass)
+
+		_, _ = userService.Create("forgetful", "password", false, "")
+
+		form := url.Values{}
+		form.Set("username", "forgetful")
+
+		req := httptest.NewRequest("POST", "/admin/reset_link", strings.NewReader(form.Encode()))
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		req.AddCookie(&http.Cookie{Name: "session_id", Value: fmt.Sprintf("%d", adminUser.Session.ID)})
+		req.AddCookie(&http.Cookie{Name: "username", Value: adminUser.Name})
+		rec := httptest.NewRecorder()
+
+		page.HandleCreateResetLink(rec, req)
+		resp := rec.Result()
+		body := rec.Body.String()
+
+		if resp.StatusCode != http.StatusOK {
+			t.Fatalf("expected 200 OK, got %d", resp.StatusCode)
+		}
+
+		if !strings.Contains(body, "http://example.com/reset?token=") {
+			t.Errorf("reset link was not displayed")
+		}
+
+		user, _ := userService.Get("forgetful")
+		if !user.PasswordReset.IsPending() || user.PasswordReset.CreatedBy != adminUser.Name {
+			t.Errorf("expected pending reset created by admin, got %+v", user.PasswordReset)
+		}
+	})
+	t.Run("search, sort and pages", func(t *testing.T) {
+		t.Cleanup(storage.Clear)
+		_, _ = userService.Create("root", "password", true, "")
+		_ = userService.ChangePassword("root", "password")
+		for i := range internal.DefaultPageSize + 10 {
+			_ = storage.SetUser(internal.User{Name: fmt.Sprintf("member%02d", i)})
+		}
+		root, _ := userService.Login("root", "password")
+
+		get := func(t *testing.T, target string) string {
+			req := httptest.NewRequest("GET", target, nil)
+			req.AddCookie(&http.Cookie{Name: "session_id", Value: fmt.Sprintf("%d", root.Session.ID)})
+			req.AddCookie(&http.Cookie{Name: "username", Value: root.Name})
+			rec := httptest.NewRecorder()
+
+			page.ServeHTTP(rec, req)
+			if rec.Code != http.StatusOK {
+				t.Fatalf("expected 200 OK, got %d", rec.Code)
+			}
+			return rec.Body.String()
+		}
+
+		next := regexp.MustCompile(`href="([^"]*)">Next page`)
+
+		body := get(t, "/admin")
+		if !strings.Contains(body, "member49") || strings.Contains(body, "member50") {
+			t.Errorf("expected the first page to end with member49")
+		}
+
+		match := next.FindStringSubmatch(body)
+		if match == nil {
+			t.Fatalf("expected a link to the next page")
+		}
+
+		body = get(t, html.UnescapeString(match[1]))
+		if !strings.Contains(body, "member59") || strings.Contains(body, "member49") || !strings.Contains(body, "First page") {
+			t.Errorf("expected the second page to start after member49")
+		}
+		if next.MatchString(body) {
+			t.Errorf("expected no link after the last page")
+		}
+
+		body = get(t, "/admin?users_q=member05")
+		if !strings.Contains(body, "member05") || strings.Contains(body, "member06") {
+			t.Errorf("expected only member05 to be found")
+		}
+
+		_, _ = userService.CreatePasswordReset("member55", root.Name)
+		if body := get(t, "/admin"); !strings.Contains(body, "member55") {
+			t.Errorf("expected reset links of users on other pages to be listed")
+		}
+
+		body = get(t, "/admin?users_sort=name&users_desc=1")
+		if strings.Index(body, "member59") > strings.Index(body, "member20") || strings.Contains(body, "member00") {
+			t.Errorf("expected users in descending order")
+		}
+
+		if body := get(t, "/admin?users_cursor=invalid"); !strings.Contains(body, internal.ErrInvalidCursor.Error()) {
+			t.Errorf("expected error for an invalid cursor")
+		}
+	})
+
+	t.Run("manager", func(t *testing.T) {
+		t.Cleanup(storage.Clear)
+		_, _ = namespaceService.Create("own")
+		_, _ = namespaceService.Create("other")
+		_, _ = userService.Create("manager", "password", false, "own")
+		_ = userService.SetManagedNamespaces("manager", []string{"own"})
+		_ = userService.ChangePassword("manager", "password")
+		_, _ = userService.Create("member", "password", false, "own")
+		_, _ = userService.Create("stranger", "password", false, "other")
+		manager, _ := userService.Login("manager", "password")
+
+		request := func(method, target string, form url.Values) *http.Request {
+			req := httptest.NewRequest(method, target, strings.NewReader(form.Encode()))
+			req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+			req.AddCookie(&http.Cookie{Name: "session_id", Value: fmt.Sprintf("%d", manager.Session.ID)})
+			req.AddCookie(&http.Cookie{Name: "username", Value: manager.Name})
+			return req
+		}
+
+		t.Run("sees only own namespace", func(t *testing.T) {
+			rec := httptest.NewRecorder()
+			page.ServeHTTP(rec, request("GET", "/admin", nil))
+
+			if rec.Code != http.StatusOK {
+				t.Fatalf("expected 200 OK for manager, got %d", rec.Code)
+			}
+
+			body := rec.Body.String()
+			if !strings.Contains(body, "member") {
+				t.Errorf("expected member of own namespace to be listed")
+			}
+			if strings.Contains(body, "stranger") || strings.Contains(body, "other") {
+				t.Errorf("users and namespaces of other namespaces should be hidden")
+			}
+		})
+
+		t.Run("cannot remove users of other namespaces", func(t *testing.T) {
+			rec := httptest.NewRecorder()
+			page.HandleRemoveUser(rec, request("POST", "/admin/remove", url.Values{"username": {"stranger"}}))
+
+			if !strings.Contains(rec.Body.String(), internal.ErrForbidden.Error()) {
+				t.Errorf("expected permission error")
+			}
+
+			if user, _ := userService.Get("stranger"); user == nil {
+				t.Errorf("stranger should not be removed")
+			}
+		})
+
+		t.Run("cannot add users of other namespaces", func(t *testing.T) {
+			rec := httptest.NewRecorder()
+			page.HandleSetMembership(rec, request("POST", "/admin/set_membership", url.Values{"username": {"stranger"}, "namespace": {"own"}, "permission": {string(internal.PermissionRead)}}))
+
+			if !strings.Contains(rec.Body.String(), internal.ErrForbidden.Error()) {
+				t.Errorf("expected permission error")
+			}
+			if user, _ := userService.Get("stranger"); len(user.Memberships) != 1 {
+				t.Errorf("stranger should not be added to own namespace, got %v", user.Memberships)
+			}
+		})
+
+		t.Run("cannot administer managers of other namespaces", func(t *testing.T) {
+			_, _ = userService.Create("rival", "password", false, "own")
+			_ = userService.SetManagedNamespaces("rival", []string{"other"})
+
+			rec := httptest.NewRecorder()
+			page.HandleCreateResetLink(rec, request("POST", "/admin/reset_link", url.Values{"username": {"rival"}}))
+
+			if !strings.Contains(rec.Body.String(), internal.ErrForbidden.Error()) {
+				t.Errorf("expected permission error")
+			}
+			if user, _ := userService.Get("rival"); user.PasswordReset.IsPending() {
+				t.Errorf("manager should not create reset links for managers of other namespaces")
+			}
+
+			rec = httptest.NewRecorder()
+			page.HandleRemoveUser(rec, request("POST", "/admin/remove", url.Values{"username": {"rival"}}))
+
+			if user, _ := userService.Get("rival"); user == nil {
+				t.Errorf("rival should not be removed")
+			}
+		})
+
+		t.Run("cannot create admins", func(t *testing.T) {
+			rec := httptest.NewRecorder()
+			page.HandleAddUser(rec, request("POST", "/admin/add", url.Values{"username": {"sneaky"}, "namespace": {"own"}, "isAdmin": {"true"}}))
+
+			if user, _ := userService.Get("sneaky"); user != nil {
+				t.Errorf("manager should not be able to create admins")
+			}
+		})
+
+		t.Run("cannot add namespaces", func(t *testing.T) {
+			rec := httptest.NewRecorder()
+			page.HandleAddNamespace(rec, request("POST", "/admin/add_namespace", url.Values{"name": {"new"}}))
+
+			if ns, _ := namespaceService.Get("new"); ns != nil {
+				t.Errorf("manager should not be able to create namespaces")
+			}
+		})
+
+		t.Run("creates users in own namespace", func(t *testing.T) {
+			rec := httptest.NewRecorder()
+			page.HandleAddUser(rec, request("POST", "/admin/add", url.Values{"username": {"newbie"}, "namespace": {"own"}}))
+
+			if user, _ := userService.Get("newbie"); user == nil || len(user.Memberships) != 1 || user.Memberships[0].Namespace != "own" {
+				t.Errorf("expected newbie to be created in own namespace, got %+v", user)
+			}
+		})
+
+		t.Run("resets stream keys in own namespace", func(t *testing.T) {
+			before, _ := userService.Get("member")
+
+			rec := httptest.NewRecorder()
+			page.HandleResetStreamKey(rec, request("POST", "/admin/reset_key", url.Values{"username": {"member"}}))
+
+			after, _ := userService.Get("member")
+			if before.StreamKey == after.StreamKey {
+				t.Errorf("expected stream key to be reset")
+			}
+		})
+
+		t.Run("manages guest sessions in own namespace", func(t *testing.T) {
+			rec := httptest.NewRecorder()
+			page.HandleAddSession(rec, request("POST", "/admin/add_session", url.Values{"namespace": {"own"}, "name": {"guest"}}))
+
+			rec = httptest.NewRecorder()
+			page.HandleAddSession(rec, request("POST", "/admin/add_session", url.Values{"namespace": {"other"}, "name": {"guest"}}))
+
+			own, _ := namespaceService.Get("own")
+			other, _ := namespaceService.Get("other")
+			if len(own.Sessions) != 1 || len(other.Sessions) != 0 {
+				t.Errorf("expected one session in own namespace only, got %d and %d", len(own.Sessions), len(other.Sessions))
+			}
+		})
+	})
+	t.Run("POST suspend user as admin", func(t *testing.T) {
+		t.Cleanup(storage.Clear)
+		t.Cleanup(func() { page.Kicker = nil })
+		adminPass, _ := userService.CreateInitialAdmin(username, "")
+		adminUser, _ := userService.Login(username, adminPass)
+
+		_ = storage.SetNamespace(internal.Namespace{Name: "ns"})
+		_, _ = userService.Create("streamer", "password", false, "ns")
+
+		kicker := &fakeKicker{}
+		page.Kicker = kicker
+
+		form := url.Values{}
+		form.Set("username", "streamer")
+		form.Set("reason", "rules")
+		form.Set("until", "2999-01-01")
+
+		req := httptest.NewRequest("POST", "/admin/suspend", strings.NewReader(form.Encode()))
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		req.AddCookie(&http.Cookie{Name: "session_id", Value: fmt.Sprintf("%d", adminUser.Session.ID)})
+		req.AddCookie(&http.Cookie{Name: "username", Value: adminUser.Name})
+		rec := httptest.NewRecorder()
+
+		page.HandleSuspendUser(rec, req)
+
+		if rec.Code != http.StatusSeeOther {
+			t.Fatalf("expected redirect after suspend, got %d: %s", rec.Code, rec.Body.String())
+		}
+
+		user, _ := userService.Get("streamer")
+		if !user.Suspension.IsActive() || user.Suspension.Reason != "rules" || user.Suspension.Until.Year() != 2999 {
+			t.Errorf("unexpected suspension: %+v", user.Suspension)
+		}
+
+		if len(kicker.paths) != 1 || kicker.paths[0] != "ns/streamer" {
+			t.Errorf("expected ns/streamer to be kicked, got %v", kicker.paths)
+		}
+	})
+
+	t.Run("POST force logout as admin", func(t *testing.T) {
+		t.Cleanup(storage.Clear)
+		adminPass, _ := userService.CreateInitialAdmin(username, "")
+		adminUser, _ := userService.Login(username, adminPass)
+
+		_, _ = userService.Create("streamer", "password", false, "")
+		streamer, _ := userService.Login("streamer", "password")
+
+		form := url.Values{}
+		form.Set("username", "streamer")
+
+		req := httptest.NewRequest("POST", "/admin/logout_user", strings.NewReader(form.Encode()))
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		req.AddCookie(&http.Cookie{Name: "session_id", Value: fmt.Sprintf("%d", adminUser.Session.ID)})
+		req.AddCookie(&http.Cookie{Name: "username", Value: adminUser.Name})
+		rec := httptest.NewRecorder()
+
+		page.HandleLogoutUser(rec, req)
+
+		if rec.Code != http.StatusSeeOther {
+			t.Fatalf("expected redirect after logout, got %d: %s", rec.Code, rec.Body.String())
+		}
+
+		if valid, _ := userService.VerifySession("streamer", fmt.Sprintf("%d", streamer.Session.ID)); valid {
+			t.Errorf("streamer should be logged out")
+		}
+	})
+
+	t.Run("POST set expiry as admin", func(t *testing.T) {
+		t.Cleanup(storage.Clear)
+		adminPass, _ := userService.CreateInitialAdmin(username, "")
+		adminUser, _ := userService.Login(username, adminPass)
+
+		_, _ = userService.Create("contributor", "password", false, "")
+
+		form := url.Values{}
+		form.Set("username", "contributor")
+		form.Set("expiresAt", "2999-01-02T15:04")
+
+		req := httptest.NewRequest("POST", "/admin/set_expiry", strings.NewReader(form.Encode()))
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		req.AddCookie(&http.Cookie{Name: "session_id", Value: fmt.Sprintf("%d", adminUser.Session.ID)})
+		req.AddCookie(&http.Cookie{Name: "username", Value: adminUser.Name})
+		rec := httptest.NewRecorder()
+
+		page.HandleSetExpiry(rec, req)
+
+		if rec.Code != http.StatusSeeOther {
+			t.Fatalf("expected redirect after setting expiry, got %d: %s", rec.Code, rec.Body.String())
+		}
+
+		user, _ := userService.Get("contributor")
+		if user.ExpiresAt.Year() != 2999 || user.ExpiresAt.Hour() != 15 {
+			t.Errorf("unexpected expiry: %v", user.ExpiresAt)
+		}
+
+		req = httptest.NewRequest("GET", "/admin", nil)
+		req.AddCookie(&http.Cookie{Name: "session_id", Value: fmt.Sprintf("%d", adminUser.Session.ID)})
+		req.AddCookie(&http.Cookie{Name: "username", Value: adminUser.Name})
+		rec = httptest.NewRecorder()
+
+		page.ServeHTTP(rec, req)
+
+		if !strings.Contains(rec.Body.String(), "Expires in") {
+			t.Errorf("expected expiry countdown on admin page")
+		}
+	})
+	t.Run("export and import as admin", func(t *testing.T) {
+		t.Cleanup(storage.Clear)
+		_, _ = userService.CreateInitialAdmin(username, "")
+		_ = userService.ChangePassword(username, "password")
+		adminUser, _ := userService.Login(username, "password")
+		_, _ = userService.Create("exported", "password", false, "")
+
+		withSession := func(req *http.Request) *http.Request {
+			req.AddCookie(&http.Cookie{Name: "session_id", Value: fmt.Sprintf("%d", adminUser.Session.ID)})
+			req.AddCookie(&http.Cookie{Name: "username", Value: adminUser.Name})
+			return req
+		}
+
+		rec := httptest.NewRecorder()
+		page.HandleExport(rec, withSession(httptest.NewRequest("GET", "/admin/export?format=json", nil)))
+
+		if rec.Code != http.StatusOK || !strings.Contains(rec.Header().Get("Content-Disposition"), ".json") {
+			t.Fatalf("expected JSON download, got %d %v", rec.Code, rec.Header())
+		}
+		export := rec.Body.String()
+		if !strings.Contains(export, `"exported"`) || strings.Contains(export, "streamKey") {
+			t.Errorf("expected users without stream keys, got %s", export)
+		}
+
+		upload := func(file string, form map[string]string) *http.Request {
+			var body bytes.Buffer
+			writer := multipart.NewWriter(&body)
+			part, _ := writer.CreateFormFile("file", "export.json")
+			_, _ = part.Write([]byte(file))
+			for key, value := range form {
+				_ = writer.WriteField(key, value)
+			}
+			_ = writer.Close()
+
+			req := httptest.NewRequest("POST", "/admin/import", &body)
+			req.Header.Set("Content-Type", writer.FormDataContentType())
+			return withSession(req)
+		}
+
+		_ = userService.Delete("exported")
+
+		rec = httptest.NewRecorder()
+		page.HandleImport(rec, upload(export, map[string]string{"mode": "merge", "dryRun": "true"}))
+
+		if !strings.Contains(rec.Body.String(), "create user exported") {
+			t.Errorf("expected planned changes, got %s", rec.Body.String())
+		}
+		if user, _ := userService.Get("exported"); user != nil {
+			t.Errorf("dry run should not change anything")
+		}
+
+		rec = httptest.NewRecorder()
+		page.HandleImport(rec, upload(export, map[string]string{"mode": "merge"}))
+
+		if user, _ := userService.Get("exported"); user == nil {
+			t.Errorf("expected user to be imported, got %s", rec.Body.String())
+		}
+
+		rec = httptest.NewRecorder()
+		page.HandleImport(rec, upload(`{"version": 1, "namespaces": [], "users": []}`, map[string]string{"mode": "replace"}))
+
+		if !strings.Contains(rec.Body.String(), ErrDeleteSelf.Error()) {
+			t.Errorf("expected import deleting the admin to be refused")
+		}
+		if user, _ := userService.Get("exported"); user == nil {
+			t.Errorf("refused import should not change anything")
+		}
+	})
+	t.Run("users from the config file are read-only", func(t *testing.T) {
+		t.Cleanup(storage.Clear)
+		_, _ = userService.CreateInitialAdmin(username, "")
+		_ = userService.ChangePassword(username, "password")
+		adminUser, _ := userService.Login(username, "password")
+		_, _ = namespaceService.Create("declared")
+		_ = namespaceService.SetFromConfig("declared", true)
+		_, _ = userService.Create("declared", "password", false, "declared")
+		_ = userService.SetFromConfig("declared", true)
+
+		request := func(method, target string, form url.Values) *http.Request {
+			req := httptest.NewRequest(method, target, strings.NewReader(form.Encode()))
+			req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+			req.AddCookie(&http.Cookie{Name: "session_id", Value: fmt.Sprintf("%d", adminUser.Session.ID)})
+			req.AddCookie(&http.Cookie{Name: "username", Value: adminUser.Name})
+			return req
+		}
+
+		rec := httptest.NewRecorder()
+		page.ServeHTTP(rec, request("GET", "/admin", nil))
+		if body := rec.Body.String(); !strings.Contains(body, ">config</span>") || strings.Contains(body, "removeUser('declared')") {
+			t.Errorf("expected user marked as declared in the config file without a remove button")
+		}
+
+		rec = httptest.NewRecorder()
+		page.HandleRemoveUser(rec, request("POST", "/admin/remove", url.Values{"username": {"declared"}}))
+		if !strings.Contains(rec.Body.String(), internal.ErrFromConfig.Error()) {
+			t.Errorf("expected removal to be refused")
+		}
+
+		rec = httptest.NewRecorder()
+		page.HandleRemoveMembership(rec, request("POST", "/admin/remove_membership", url.Values{"username": {"declared"}, "namespace": {"declared"}}))
+		if !strings.Contains(rec.Body.String(), internal.ErrFromConfig.Error()) {
+			t.Errorf("expected membership change to be refused")
+		}
+
+		rec = httptest.NewRecorder()
+		page.HandleRemoveNamespace(rec, request("POST", "/admin/remove_namespace", url.Values{"name": {"declared"}}))
+		if !strings.Contains(rec.Body.String(), internal.ErrFromConfig.Error()) {
+			t.Errorf("expected namespace removal to be refused")
+		}
+
+		if user, _ := userService.Get("declared"); user == nil || len(user.Memberships) != 1 {
+			t.Errorf("user should be unchanged, got %+v", user)
+		}
+
+		rec = httptest.NewRecorder()
+		page.HandleLogoutUser(rec, request("POST", "/admin/logout_user", url.Values{"username": {"declared"}}))
+		if rec.Code != http.StatusSeeOther {
+			t.Errorf("expected logging out to stay possible, got %d", rec.Code)
+		}
+	})
+}
+
+type fakeKicker struct {
+	paths []string
+}
+
+func (k *fakeKicker) KickPath(path string) error {
+	k.paths = append(k.paths, path)
+	return nil
 }
@@ -0,0 +1,118 @@
+package pages
+
+import (
+	"MediaMTXAuth/internal"
+	"MediaMTXAuth/internal/views"
+	"MediaMTXAuth/internal/views/handlers"
+	"errors"
+	"log"
+	"net/http"
+)
+
+var ErrImpersonateAdmin = errors.New("admins cannot be viewed as another user")
+
+// HandleImpersonate lets an admin open /panel as the user sees it, without
+// knowing their password. The admin's own session is kept in separate cookies
+// and restored by HandleStopImpersonation.
+func (v *UserPage) HandleImpersonate(rw http.ResponseWriter, r *http.Request) {
+	actor, authenticated := handlers.RequireManagerAuth(v.Page, rw, r)
+	if !authenticated {
+		return
+	}
+
+	username := r.FormValue("username")
+
+	if !actor.IsAdmin {
+		v.AdminPage.render(rw, actor, views.AdminData{Error: internal.ErrForbidden.Error()})
+		return
+	}
+
+	user, err := v.UserService.Get(username)
+	switch {
+	case err != nil:
+	case user == nil:
+		err = internal.ErrUserNotFound
+	case user.IsAdmin:
+		err = ErrImpersonateAdmin
+	}
+
+	if err == nil {
+		user, err = v.UserService.Impersonate(username, actor.Name)
+	}
+
+	if err != nil {
+		v.render(rw, actor, username, views.UserData{Error: err.Error()})
+		return
+	}
+
+	if session, ok := handlers.CurrentSession(r, actor); ok {
+		actor.Session = session
+		handlers.SetImpersonatorCookies(rw, r, actor)
+	}
+
+	log.Printf("audit: %s started viewing as %s until %s", actor.Name, user.Name, user.Session.Expiration.Format("2006-01-02 15:04:05"))
+
+	handlers.SetSessionCookies(rw, r, user)
+	http.Redirect(rw, r, "/panel", http.StatusSeeOther)
+}
+
+// HandleStopImpersonation ends the impersonation session and returns the
+// admin to their own session if it is still valid.
+func (v *LogoutPage) HandleStopImpersonation(rw http.ResponseWriter, r *http.Request) {
+	sessionCookie, err := r.Cookie("session_id")
+	usernameCookie, err2 := r.Cookie("username")
+	if err == nil && err2 == nil {
+		if err := v.UserService.Logout(usernameCookie.Value, sessionCookie.Value); err == nil {
+			log.Printf("audit: stopped viewing as %s", usernameCookie.Value)
+		}
+	}
+
+	handlers.ClearSessionCookies(rw, r)
+	handlers.ClearImpersonatorCookies(rw, r)
+
+	admin := v.impersonator(r)
+	if admin == nil {
+		http.Redirect(rw, r, "/login", http.StatusSeeOther)
+		return
+	}
+
+	handlers.SetSessionCookies(rw, r, admin)
+
+	if err2 == nil {
+		http.Redirect(rw, r, userURL(usernameCookie.Value), http.StatusSeeOther)
+		return
+	}
+
+	http.Redirect(rw, r, "/admin", http.StatusSeeOther)
+}
+
+// impersonator returns the admin remembered by SetImpersonatorCookies with
+// their session filled in, or nil if that session is no longer valid.
+func (v *LogoutPage) impersonator(r *http.Request) *internal.User {
+	sessionCookie, err := r.Cookie("impersonator_session_id")
+	if err != nil {
+		return nil
+	}
+
+	usernameCookie, err := r.Cookie("impersonator")
+	if err != nil {
+		return nil
+	}
+
+	if valid, _ := v.UserService.VerifySession(usernameCookie.Value, sessionCookie.Value); !valid {
+		return nil
+	}
+
+	admin, _ := v.UserService.Get(usernameCookie.Value)
+	if admin == nil {
+		return nil
+	}
+
+	session, ok := handlers.FindSession(admin, sessionCookie.Value)
+	if !ok {
+		return nil
+	}
+	admin.Session = session
+
+	return admin
+}
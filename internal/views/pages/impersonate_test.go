@@ -0,0 +1,134 @@
+package pages
+
+import (
+	"MediaMTXAuth/internal/services"
+	"MediaMTXAuth/internal/storage/memory"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+)
+
+func TestImpersonation(t *testing.T) {
+	storage := &memory.Storage{}
+	_ = storage.Init()
+	userService := services.NewUserService(storage)
+	namespaceService := services.NewNamespaceService(storage)
+	admin := NewAdmin(userService, namespaceService)
+	userPage := NewUser(admin)
+	panel := NewPanel(userService)
+	logout := NewLogout(userService)
+
+	_, _ = userService.Create("admin", "password", true, "")
+	_ = userService.ChangePassword("admin", "password")
+	_, _ = userService.Create("other_admin", "password", true, "")
+	_, _ = userService.Create("streamer", "password", false, "")
+	_ = userService.ChangePassword("streamer", "password")
+	adminUser, _ := userService.Login("admin", "password")
+
+	withCookies := func(req *http.Request, cookies []*http.Cookie) *http.Request {
+		for _, cookie := range cookies {
+			if cookie.MaxAge >= 0 {
+				req.AddCookie(cookie)
+			}
+		}
+		return req
+	}
+
+	adminRequest := func(target string, form url.Values) *http.Request {
+		req := httptest.NewRequest("POST", target, strings.NewReader(form.Encode()))
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		req.AddCookie(&http.Cookie{Name: "session_id", Value: fmt.Sprintf("%d", adminUser.Session.ID)})
+		req.AddCookie(&http.Cookie{Name: "username", Value: adminUser.Name})
+		return req
+	}
+
+	rec := httptest.NewRecorder()
+	userPage.HandleImpersonate(rec, adminRequest("/admin/user/impersonate", url.Values{"username": {"streamer"}}))
+
+	if rec.Code != http.StatusSeeOther || rec.Header().Get("Location") != "/panel" {
+		t.Fatalf("expected redirect to /panel, got %d: %s", rec.Code, rec.Body.String())
+	}
+	cookies := rec.Result().Cookies()
+
+	t.Run("panel is labeled", func(t *testing.T) {
+		rec := httptest.NewRecorder()
+		panel.ServeHTTP(rec, withCookies(httptest.NewRequest("GET", "/panel", nil), cookies))
+
+		body := rec.Body.String()
+		if !strings.Contains(body, "Viewing as streamer") || !strings.Contains(body, "admin is viewing this panel") {
+			t.Errorf("expected impersonation banner, got %s", body)
+		}
+	})
+
+	t.Run("is recorded in history", func(t *testing.T) {
+		user, _ := userService.Get("streamer")
+		if len(user.History) == 0 || user.History[len(user.History)-1].By != "admin" {
+			t.Errorf("expected impersonation in history, got %+v", user.History)
+		}
+	})
+
+	t.Run("password change is blocked", func(t *testing.T) {
+		form := url.Values{"password": {"hijacked1"}}
+		req := httptest.NewRequest("POST", "/panel/change_password", strings.NewReader(form.Encode()))
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+
+		rec := httptest.NewRecorder()
+		panel.HandleChangePassword(rec, withCookies(req, cookies))
+
+		if rec.Code != http.StatusForbidden {
+			t.Errorf("expected 403, got %d", rec.Code)
+		}
+
+		if _, err := userService.Login("streamer", "password"); err != nil {
+			t.Errorf("password should be unchanged: %v", err)
+		}
+	})
+
+	t.Run("admin pages are not reachable", func(t *testing.T) {
+		rec := httptest.NewRecorder()
+		admin.ServeHTTP(rec, withCookies(httptest.NewRequest("GET", "/admin", nil), cookies))
+
+		if rec.Code != http.StatusFound || rec.Header().Get("Location") != "/panel" {
+			t.Errorf("expected redirect to /panel, got %d", rec.Code)
+		}
+	})
+
+	t.Run("stop restores admin session", func(t *testing.T) {
+		rec := httptest.NewRecorder()
+		logout.HandleStopImpersonation(rec, withCookies(httptest.NewRequest("POST", "/impersonate/stop", nil), cookies))
+
+		if location := rec.Header().Get("Location"); location != "/admin/user?name=streamer" {
+			t.Fatalf("expected redirect to user page, got %q", location)
+		}
+
+		var restored bool
+		for _, cookie := range rec.Result().Cookies() {
+			if cookie.Name == "username" && cookie.Value == "admin" && cookie.MaxAge > 0 {
+				restored = true
+			}
+		}
+		if !restored {
+			t.Errorf("expected admin cookies to be restored")
+		}
+
+		for _, cookie := range cookies {
+			if cookie.Name == "session_id" {
+				if valid, _ := userService.VerifySession("streamer", cookie.Value); valid {
+					t.Errorf("impersonation session should be ended")
+				}
+			}
+		}
+	})
+
+	t.Run("admins cannot be impersonated", func(t *testing.T) {
+		rec := httptest.NewRecorder()
+		userPage.HandleImpersonate(rec, adminRequest("/admin/user/impersonate", url.Values{"username": {"other_admin"}}))
+
+		if !strings.Contains(rec.Body.String(), ErrImpersonateAdmin.Error()) {
+			t.Errorf("expected error, got %d", rec.Code)
+		}
+	})
+}
@@ -0,0 +1,109 @@
+package pages
+
+import (
+	"MediaMTXAuth/internal"
+	"MediaMTXAuth/internal/views"
+	"MediaMTXAuth/internal/views/handlers"
+	_ "embed"
+	"html/template"
+	"net/http"
+)
+
+//go:embed html/invite.html
+var InvitePageHTML string
+
+type InvitePage struct {
+	*views.Page
+	NamespaceService internal.NamespaceService
+}
+
+func NewInvite(userService internal.UserService, namespaceService internal.NamespaceService) *InvitePage {
+	tmpl := template.Must(template.New("pages").Parse(InvitePageHTML))
+	return &InvitePage{
+		Page: &views.Page{
+			UserService: userService,
+			Template:    tmpl,
+		},
+		NamespaceService: namespaceService,
+	}
+}
+
+func (v *InvitePage) ServeHTTP(rw http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet:
+		v.showInviteForm(rw, r)
+	case http.MethodPost:
+		v.handleInvite(rw, r)
+	default:
+		http.Error(rw, "Method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+func (v *InvitePage) showInviteForm(rw http.ResponseWriter, r *http.Request) {
+	data := views.InviteData{
+		Namespace: r.FormValue("namespace"),
+		Token:     r.FormValue("token"),
+	}
+
+	if _, err := v.NamespaceService.CheckInvitation(data.Namespace, data.Token); err != nil {
+		v.renderTemplate(rw, http.StatusNotFound, views.InviteData{Error: err.Error(), Namespace: data.Namespace})
+		return
+	}
+
+	v.renderTemplate(rw, http.StatusOK, data)
+}
+
+func (v *InvitePage) handleInvite(rw http.ResponseWriter, r *http.Request) {
+	data := views.InviteData{
+		Namespace: r.FormValue("namespace"),
+		Token:     r.FormValue("token"),
+		Username:  r.FormValue("username"),
+	}
+	password := r.FormValue("password")
+
+	invitation, err := v.NamespaceService.CheckInvitation(data.Namespace, data.Token)
+	if err != nil {
+		v.renderTemplate(rw, http.StatusNotFound, views.InviteData{Error: err.Error(), Namespace: data.Namespace})
+		return
+	}
+
+	if password != r.FormValue("confirm") {
+		data.Error = "Passwords do not match"
+		v.renderTemplate(rw, http.StatusBadRequest, data)
+		return
+	}
+
+	user, err := v.UserService.Register(data.Username, password, invitation.Role == internal.RoleAdmin, data.Namespace)
+	if err != nil {
+		data.Error = err.Error()
+		v.renderTemplate(rw, http.StatusBadRequest, data)
+		return
+	}
+
+	// The invitation may have been used up while the password was hashed.
+	if _, err := v.NamespaceService.UseInvitation(data.Namespace, data.Token); err != nil {
+		_ = v.UserService.Delete(user.Name)
+		v.renderTemplate(rw, http.StatusNotFound, views.InviteData{Error: err.Error(), Namespace: data.Namespace})
+		return
+	}
+
+	if invitation.Role == internal.RoleManager {
+		if err := v.UserService.SetManagedNamespaces(user.Name, []string{data.Namespace}); err != nil {
+			data.Error = err.Error()
+			v.renderTemplate(rw, http.StatusInternalServerError, data)
+			return
+		}
+		user.Manages = []string{data.Namespace}
+	}
+
+	handlers.SetSessionCookies(rw, r, user)
+	handlers.RedirectHome(rw, r, user)
+}
+
+func (v *InvitePage) renderTemplate(rw http.ResponseWriter, status int, data views.InviteData) {
+	rw.Header().Set("Content-Type", "text/html; charset=utf-8")
+	rw.WriteHeader(status)
+	if err := v.Template.Execute(rw, data); err != nil {
+		http.Error(rw, "Internal server error", http.StatusInternalServerError)
+	}
+}
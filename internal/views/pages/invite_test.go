@@ -0,0 +1,117 @@
+package pages
+
+import (
+	"MediaMTXAuth/internal"
+	"MediaMTXAuth/internal/services"
+	"MediaMTXAuth/internal/storage/memory"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestInvitePage(t *testing.T) {
+	storage := &memory.Storage{}
+	_ = storage.Init()
+	userService := services.NewUserService(storage)
+	namespaceService := services.NewNamespaceService(storage)
+	page := NewInvite(userService, namespaceService)
+
+	post := func(form url.Values) *httptest.ResponseRecorder {
+		req := httptest.NewRequest("POST", "/invite", strings.NewReader(form.Encode()))
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		rec := httptest.NewRecorder()
+
+		page.ServeHTTP(rec, req)
+		return rec
+	}
+
+	t.Run("GET invalid invitation", func(t *testing.T) {
+		t.Cleanup(storage.Clear)
+		_, _ = namespaceService.Create("ns")
+
+		req := httptest.NewRequest("GET", "/invite?namespace=ns&token=wrong", nil)
+		rec := httptest.NewRecorder()
+
+		page.ServeHTTP(rec, req)
+
+		if rec.Code != http.StatusNotFound {
+			t.Fatalf("expected 404, got %d", rec.Code)
+		}
+	})
+
+	t.Run("POST accept invitation", func(t *testing.T) {
+		t.Cleanup(storage.Clear)
+		_, _ = namespaceService.Create("ns")
+		_, token, _ := namespaceService.CreateInvitation("ns", internal.RoleUser, 1, time.Hour, "admin")
+
+		rec := post(url.Values{
+			"namespace": {"ns"},
+			"token":     {token},
+			"username":  {"streamer"},
+			"password":  {"password"},
+			"confirm":   {"password"},
+		})
+		resp := rec.Result()
+
+		if resp.StatusCode != http.StatusSeeOther {
+			t.Fatalf("expected redirect, got %d: %s", resp.StatusCode, rec.Body.String())
+		}
+
+		if loc := resp.Header.Get("Location"); loc != "/panel" {
+			t.Errorf("expected redirect to /panel, got %s", loc)
+		}
+
+		user, _ := userService.Get("streamer")
+		if user == nil {
+			t.Fatalf("expected streamer to be created")
+		}
+
+		if _, ok := user.Membership("ns"); !ok || user.IsAdmin {
+			t.Fatalf("expected streamer in namespace ns, got %+v", user)
+		}
+
+		t.Run("single use", func(t *testing.T) {
+			rec := post(url.Values{
+				"namespace": {"ns"},
+				"token":     {token},
+				"username":  {"another"},
+				"password":  {"password"},
+				"confirm":   {"password"},
+			})
+
+			if rec.Code != http.StatusNotFound {
+				t.Errorf("expected 404, got %d", rec.Code)
+			}
+
+			if user, _ := userService.Get("another"); user != nil {
+				t.Errorf("user should not be created with a used invitation")
+			}
+		})
+	})
+
+	t.Run("POST taken username", func(t *testing.T) {
+		t.Cleanup(storage.Clear)
+		_, _ = namespaceService.Create("ns")
+		_, _ = userService.Create("streamer", "password", false, "")
+		_, token, _ := namespaceService.CreateInvitation("ns", internal.RoleUser, 1, time.Hour, "admin")
+
+		rec := post(url.Values{
+			"namespace": {"ns"},
+			"token":     {token},
+			"username":  {"streamer"},
+			"password":  {"password"},
+			"confirm":   {"password"},
+		})
+
+		if rec.Code != http.StatusBadRequest {
+			t.Fatalf("expected 400, got %d", rec.Code)
+		}
+
+		if _, err := namespaceService.CheckInvitation("ns", token); err != nil {
+			t.Errorf("invitation should not be used up by a failed registration: %v", err)
+		}
+	})
+}
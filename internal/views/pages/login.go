@@ -2,12 +2,19 @@ package pages
 
 import (
 	"MediaMTXAuth/internal"
+	"MediaMTXAuth/internal/passwords"
+	"MediaMTXAuth/internal/services"
+	"MediaMTXAuth/internal/throttle"
 	"MediaMTXAuth/internal/views"
+	"MediaMTXAuth/internal/views/handlers"
+	"crypto/rand"
 	_ "embed"
+	"errors"
 	"html/template"
+	"log"
+	"net"
 	"net/http"
-	"strconv"
-	"time"
+	"sync"
 )
 
 //go:embed html/login.html
@@ -15,6 +22,10 @@ var LoginPageHTML string
 
 type LoginPage struct {
 	*views.Page
+	Limiter *throttle.Limiter
+
+	mu             sync.Mutex
+	setupTokenHash string
 }
 
 func NewLogin(userService internal.UserService) *LoginPage {
@@ -24,6 +35,7 @@ func NewLogin(userService internal.UserService) *LoginPage {
 			UserService: userService,
 			Template:    tmpl,
 		},
+		Limiter: throttle.NewLimiter(throttle.DefaultIPPolicy),
 	}
 }
 
@@ -38,8 +50,33 @@ func (v *LoginPage) ServeHTTP(rw http.ResponseWriter, r *http.Request) {
 	}
 }
 
+// NewSetupToken lets the first admin be created from /login with the returned
+// token. The token can be used once and only while no admin exists.
+func (v *LoginPage) NewSetupToken() string {
+	token := rand.Text()
+
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	v.setupTokenHash = passwords.HashToken(token)
+
+	return token
+}
+
+func (v *LoginPage) setupPending() bool {
+	v.mu.Lock()
+	pending := v.setupTokenHash != ""
+	v.mu.Unlock()
+
+	if !pending {
+		return false
+	}
+
+	hasAdmin, err := v.UserService.HasAdmin()
+	return err == nil && !hasAdmin
+}
+
 func (v *LoginPage) showLoginForm(rw http.ResponseWriter, r *http.Request) {
-	data := views.LoginData{}
+	data := views.LoginData{Setup: v.setupPending()}
 
 	rw.Header().Set("Content-Type", "text/html; charset=utf-8")
 	if err := v.Template.Execute(rw, data); err != nil {
@@ -49,9 +86,16 @@ func (v *LoginPage) showLoginForm(rw http.ResponseWriter, r *http.Request) {
 }
 
 func (v *LoginPage) renderWithError(rw http.ResponseWriter, r *http.Request, errorMsg string) {
-	data := views.LoginData{Error: errorMsg}
+	v.renderWithStatus(rw, r, http.StatusUnauthorized, errorMsg)
+}
+
+func (v *LoginPage) renderWithStatus(rw http.ResponseWriter, r *http.Request, status int, errorMsg string) {
+	v.render(rw, status, views.LoginData{Error: errorMsg})
+}
+
+func (v *LoginPage) render(rw http.ResponseWriter, status int, data views.LoginData) {
 	rw.Header().Set("Content-Type", "text/html; charset=utf-8")
-	rw.WriteHeader(http.StatusUnauthorized)
+	rw.WriteHeader(status)
 	if err := v.Template.Execute(rw, data); err != nil {
 		http.Error(rw, "Internal server error", http.StatusInternalServerError)
 	}
@@ -67,37 +111,86 @@ func (v *LoginPage) handleLogin(rw http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	// The attempt counts as failed until the login succeeds, so that
+	// concurrent requests cannot all get past the limit.
+	ip := clientIP(r)
+	if err := v.Limiter.Attempt(ip); err != nil {
+		v.renderWithStatus(rw, r, http.StatusTooManyRequests, internal.ErrTooManyAttempts.Error())
+		return
+	}
+
 	user, err := v.UserService.Login(username, password)
-	if err != nil {
+	if errors.Is(err, internal.ErrAccountLocked) || errors.Is(err, internal.ErrTooManyAttempts) {
+		v.renderWithStatus(rw, r, http.StatusTooManyRequests, err.Error())
+		return
+	} else if err != nil {
 		v.renderWithError(rw, r, "Invalid credentials")
 		return
 	}
 
-	sessionCookie := &http.Cookie{
-		Name:     "session_id",
-		Value:    strconv.FormatUint(user.Session.ID, 10),
-		Path:     "/",
-		HttpOnly: true,
-		Secure:   r.TLS != nil,
-		SameSite: http.SameSiteStrictMode,
-		MaxAge:   int(15 * time.Minute.Seconds()),
+	v.Limiter.Reset(ip)
+
+	handlers.SetSessionCookies(rw, r, user)
+	handlers.RedirectHome(rw, r, user)
+}
+
+// HandleSetup creates the first admin with the setup token printed at startup
+// and logs them in.
+func (v *LoginPage) HandleSetup(rw http.ResponseWriter, r *http.Request) {
+	token := r.FormValue("token")
+	username := r.FormValue("username")
+	password := r.FormValue("password")
+
+	ip := clientIP(r)
+	if err := v.Limiter.Check(ip); err != nil {
+		v.render(rw, http.StatusTooManyRequests, views.LoginData{Setup: true, Error: internal.ErrTooManyAttempts.Error()})
+		return
+	}
+
+	v.mu.Lock()
+	defer v.mu.Unlock()
+
+	if v.setupTokenHash == "" {
+		http.Redirect(rw, r, "/login", http.StatusSeeOther)
+		return
 	}
-	http.SetCookie(rw, sessionCookie)
-
-	usernameCookie := &http.Cookie{
-		Name:     "username",
-		Value:    user.Name,
-		Path:     "/",
-		HttpOnly: true,
-		Secure:   r.TLS != nil,
-		SameSite: http.SameSiteStrictMode,
-		MaxAge:   int(15 * time.Minute.Seconds()),
+
+	if !passwords.VerifyToken(token, v.setupTokenHash) {
+		v.Limiter.Fail(ip)
+		v.render(rw, http.StatusUnauthorized, views.LoginData{Setup: true, Error: internal.ErrInvalidToken.Error()})
+		return
+	}
+
+	// CreateInitialAdmin would fill in a blank username or password, but
+	// the form has to log the admin in with what was submitted.
+	if err := errors.Join(services.ValidateUsername(username), services.ValidatePassword(password)); err != nil {
+		v.render(rw, http.StatusBadRequest, views.LoginData{Setup: true, Error: err.Error()})
+		return
 	}
-	http.SetCookie(rw, usernameCookie)
 
-	if user.IsAdmin {
-		http.Redirect(rw, r, "/admin", http.StatusSeeOther)
-	} else {
-		http.Redirect(rw, r, "/panel", http.StatusSeeOther)
+	if _, err := v.UserService.CreateInitialAdmin(username, password); err != nil {
+		v.render(rw, http.StatusBadRequest, views.LoginData{Setup: true, Error: err.Error()})
+		return
+	}
+
+	v.setupTokenHash = ""
+	v.Limiter.Reset(ip)
+	log.Printf("initial admin %s created with the setup token", username)
+
+	user, err := v.UserService.Login(username, password)
+	if err != nil {
+		http.Redirect(rw, r, "/login", http.StatusSeeOther)
+		return
+	}
+
+	handlers.SetSessionCookies(rw, r, user)
+	handlers.RedirectHome(rw, r, user)
+}
+
+func clientIP(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
 	}
+	return host
 }
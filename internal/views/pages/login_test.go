@@ -4,15 +4,17 @@ import (
 	"MediaMTXAuth/internal/services"
 	"MediaMTXAuth/internal/storage/memory"
 	"bytes"
+	"fmt"
 	"mime/multipart"
 	"net/http"
 	"net/http/httptest"
+	"net/url"
 	"strings"
 	"testing"
 )
 
 const username = "admin"
-const password = "admin"
+const password = "adminpassword"
 
 func TestLoginView(t *testing.T) {
 	storage := &memory.Storage{}
@@ -40,7 +42,7 @@ func TestLoginView(t *testing.T) {
 
 	t.Run("POST invalid credentials", func(t *testing.T) {
 		t.Cleanup(storage.Clear)
-		_, _ = userService.CreateDefaultAdminUser()
+		_, _ = userService.CreateInitialAdmin(username, password)
 
 		var body bytes.Buffer
 		writer := multipart.NewWriter(&body)
@@ -66,7 +68,7 @@ func TestLoginView(t *testing.T) {
 
 	t.Run("POST valid credentials", func(t *testing.T) {
 		t.Cleanup(storage.Clear)
-		_, _ = userService.CreateDefaultAdminUser()
+		_, _ = userService.CreateInitialAdmin(username, password)
 
 		var body bytes.Buffer
 		writer := multipart.NewWriter(&body)
@@ -92,7 +94,7 @@ func TestLoginView(t *testing.T) {
 
 	t.Run("POST non-existent user", func(t *testing.T) {
 		t.Cleanup(storage.Clear)
-		_, _ = userService.CreateDefaultAdminUser()
+		_, _ = userService.CreateInitialAdmin(username, password)
 
 		var body bytes.Buffer
 		writer := multipart.NewWriter(&body)
@@ -114,4 +116,92 @@ func TestLoginView(t *testing.T) {
 			t.Errorf("Expected error message for non-existent user")
 		}
 	})
+	t.Run("POST throttled by IP", func(t *testing.T) {
+		t.Cleanup(storage.Clear)
+		view.Limiter.Reset("192.0.2.1")
+		_, _ = userService.CreateInitialAdmin(username, password)
+
+		post := func(user string) *http.Response {
+			var body bytes.Buffer
+			writer := multipart.NewWriter(&body)
+			_ = writer.WriteField("username", user)
+			_ = writer.WriteField("password", "wrongpass")
+			writer.Close()
+
+			req := httptest.NewRequest("POST", "/login", &body)
+			req.Header.Set("Content-Type", writer.FormDataContentType())
+			rec := httptest.NewRecorder()
+
+			view.ServeHTTP(rec, req)
+			return rec.Result()
+		}
+
+		for i := range view.Limiter.Policy.FreeAttempts {
+			if resp := post(fmt.Sprintf("nouser%d", i)); resp.StatusCode != http.StatusUnauthorized {
+				t.Fatalf("Expected status 401, got %d", resp.StatusCode)
+			}
+		}
+
+		if resp := post(username); resp.StatusCode != http.StatusTooManyRequests {
+			t.Errorf("Expected status 429, got %d", resp.StatusCode)
+		}
+	})
+
+	t.Run("setup token", func(t *testing.T) {
+		t.Cleanup(storage.Clear)
+		view.Limiter.Reset("192.0.2.1")
+		token := view.NewSetupToken()
+
+		setup := func(token, user, password string) *httptest.ResponseRecorder {
+			form := url.Values{"token": {token}, "username": {user}, "password": {password}}
+			req := httptest.NewRequest("POST", "/login/setup", strings.NewReader(form.Encode()))
+			req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+			rec := httptest.NewRecorder()
+
+			view.HandleSetup(rec, req)
+			return rec
+		}
+
+		rec := httptest.NewRecorder()
+		view.ServeHTTP(rec, httptest.NewRequest("GET", "/login", nil))
+		if !strings.Contains(rec.Body.String(), "Setup Token") {
+			t.Fatalf("Expected setup form while no admin exists")
+		}
+
+		if rec := setup("wrong", username, password); rec.Code != http.StatusUnauthorized {
+			t.Errorf("Expected status 401 for wrong token, got %d", rec.Code)
+		}
+
+		for _, form := range [][2]string{{username, ""}, {"", password}} {
+			if rec := setup(token, form[0], form[1]); rec.Code != http.StatusBadRequest {
+				t.Errorf("Expected status 400 for %q, got %d", form, rec.Code)
+			}
+		}
+		if hasAdmin, _ := userService.HasAdmin(); hasAdmin {
+			t.Errorf("Admin should not be created without a username and password")
+		}
+
+		rec = setup(token, username, password)
+		if rec.Code != http.StatusSeeOther || rec.Header().Get("Location") != "/admin" {
+			t.Fatalf("Expected redirect to /admin, got %d: %s", rec.Code, rec.Body.String())
+		}
+
+		if admin, _ := userService.Get(username); admin == nil || !admin.IsAdmin {
+			t.Errorf("Expected admin to be created, got %+v", admin)
+		}
+
+		if rec := setup(token, "second", password); rec.Code != http.StatusSeeOther || rec.Header().Get("Location") != "/login" {
+			t.Errorf("Expected setup token to work only once, got %d", rec.Code)
+		}
+
+		if user, _ := userService.Get("second"); user != nil {
+			t.Errorf("Second admin should not be created")
+		}
+
+		rec = httptest.NewRecorder()
+		view.ServeHTTP(rec, httptest.NewRequest("GET", "/login", nil))
+		if strings.Contains(rec.Body.String(), "Setup Token") {
+			t.Errorf("Expected login form once an admin exists")
+		}
+	})
 }
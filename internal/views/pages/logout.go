@@ -0,0 +1,61 @@
+package pages
+
+import (
+	"MediaMTXAuth/internal"
+	"MediaMTXAuth/internal/views"
+	"MediaMTXAuth/internal/views/handlers"
+	"net/http"
+)
+
+// LogoutPage ends login sessions. It has no template of its own and always
+// redirects to the login page.
+type LogoutPage struct {
+	*views.Page
+}
+
+func NewLogout(userService internal.UserService) *LogoutPage {
+	return &LogoutPage{
+		Page: &views.Page{
+			UserService: userService,
+		},
+	}
+}
+
+func (v *LogoutPage) ServeHTTP(rw http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodPost:
+		v.handleLogout(rw, r)
+	default:
+		http.Error(rw, "Method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+// handleLogout ends the session of the request. Unknown or expired sessions
+// are not an error, the cookies are cleared either way.
+func (v *LogoutPage) handleLogout(rw http.ResponseWriter, r *http.Request) {
+	sessionCookie, err := r.Cookie("session_id")
+	usernameCookie, err2 := r.Cookie("username")
+	if err == nil && err2 == nil {
+		_ = v.UserService.Logout(usernameCookie.Value, sessionCookie.Value)
+	}
+
+	handlers.ClearSessionCookies(rw, r)
+	http.Redirect(rw, r, "/login", http.StatusSeeOther)
+}
+
+// HandleLogoutAll ends every session of the logged in user, including those
+// on other devices.
+func (v *LogoutPage) HandleLogoutAll(rw http.ResponseWriter, r *http.Request) {
+	username, authenticated := handlers.RequireSelfAuth(v.Page, rw, r)
+	if !authenticated {
+		return
+	}
+
+	if err := v.UserService.LogoutAll(username, username); err != nil {
+		http.Error(rw, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+
+	handlers.ClearSessionCookies(rw, r)
+	http.Redirect(rw, r, "/login", http.StatusSeeOther)
+}
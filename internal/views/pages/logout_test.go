@@ -0,0 +1,89 @@
+package pages
+
+import (
+	"MediaMTXAuth/internal/services"
+	"MediaMTXAuth/internal/storage/memory"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestLogoutPage(t *testing.T) {
+	storage := &memory.Storage{}
+	_ = storage.Init()
+	userService := services.NewUserService(storage)
+	page := NewLogout(userService)
+
+	t.Run("GET logout not allowed", func(t *testing.T) {
+		rec := httptest.NewRecorder()
+		page.ServeHTTP(rec, httptest.NewRequest("GET", "/logout", nil))
+
+		if rec.Code != http.StatusMethodNotAllowed {
+			t.Fatalf("expected 405, got %d", rec.Code)
+		}
+	})
+
+	t.Run("POST logout", func(t *testing.T) {
+		t.Cleanup(storage.Clear)
+		_, _ = userService.Create("user1", "password", false, "")
+		first, _ := userService.Login("user1", "password")
+		second, _ := userService.Login("user1", "password")
+
+		req := httptest.NewRequest("POST", "/logout", nil)
+		req.AddCookie(&http.Cookie{Name: "session_id", Value: fmt.Sprintf("%d", first.Session.ID)})
+		req.AddCookie(&http.Cookie{Name: "username", Value: first.Name})
+		rec := httptest.NewRecorder()
+
+		page.ServeHTTP(rec, req)
+
+		if rec.Code != http.StatusSeeOther || rec.Header().Get("Location") != "/login" {
+			t.Fatalf("expected redirect to /login, got %d %s", rec.Code, rec.Header().Get("Location"))
+		}
+
+		for _, cookie := range rec.Result().Cookies() {
+			if cookie.MaxAge >= 0 {
+				t.Errorf("expected cookie %s to be cleared", cookie.Name)
+			}
+		}
+
+		if valid, _ := userService.VerifySession("user1", fmt.Sprintf("%d", first.Session.ID)); valid {
+			t.Errorf("session should be ended")
+		}
+
+		if valid, _ := userService.VerifySession("user1", fmt.Sprintf("%d", second.Session.ID)); !valid {
+			t.Errorf("other sessions should stay valid")
+		}
+	})
+
+	t.Run("POST logout without session", func(t *testing.T) {
+		rec := httptest.NewRecorder()
+		page.ServeHTTP(rec, httptest.NewRequest("POST", "/logout", nil))
+
+		if rec.Code != http.StatusSeeOther {
+			t.Fatalf("expected redirect, got %d", rec.Code)
+		}
+	})
+
+	t.Run("POST logout all", func(t *testing.T) {
+		t.Cleanup(storage.Clear)
+		_, _ = userService.Create("user1", "password", false, "")
+		first, _ := userService.Login("user1", "password")
+		second, _ := userService.Login("user1", "password")
+
+		req := httptest.NewRequest("POST", "/logout/all", nil)
+		req.AddCookie(&http.Cookie{Name: "session_id", Value: fmt.Sprintf("%d", first.Session.ID)})
+		req.AddCookie(&http.Cookie{Name: "username", Value: first.Name})
+		rec := httptest.NewRecorder()
+
+		page.HandleLogoutAll(rec, req)
+
+		if rec.Code != http.StatusSeeOther {
+			t.Fatalf("expected redirect, got %d", rec.Code)
+		}
+
+		if valid, _ := userService.VerifySession("user1", fmt.Sprintf("%d", second.Session.ID)); valid {
+			t.Errorf("all sessions should be ended")
+		}
+	})
+}
@@ -7,7 +7,9 @@ import (
 	_ "embed"
 	"html/template"
 	"net/http"
+	"strconv"
 	"strings"
+	"time"
 )
 
 //go:embed html/panel.html
@@ -49,12 +51,13 @@ func (v *PanelPage) showPanelForm(rw http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	data := views.PanelData{User: *user}
+	session, _ := handlers.CurrentSession(r, user)
+	data := views.PanelData{User: *user, Session: session}
 	v.renderTemplate(rw, data)
 }
 
 func (v *PanelPage) HandleChangePassword(rw http.ResponseWriter, r *http.Request) {
-	username, authenticated := handlers.RequireAuth(v.Page, rw, r)
+	username, authenticated := handlers.RequireSelfAuth(v.Page, rw, r)
 	if !authenticated {
 		return
 	}
@@ -84,6 +87,85 @@ func (v *PanelPage) HandleChangePassword(rw http.ResponseWriter, r *http.Request
 	http.Redirect(rw, r, "/panel", http.StatusSeeOther)
 }
 
+// HandleRotateStreamKey lets users replace a leaked stream key themselves. The
+// old key can be kept working for a short grace period.
+func (v *PanelPage) HandleRotateStreamKey(rw http.ResponseWriter, r *http.Request) {
+	username, authenticated := handlers.RequireSelfAuth(v.Page, rw, r)
+	if !authenticated {
+		return
+	}
+
+	grace, err := time.ParseDuration(r.FormValue("grace"))
+	if err == nil {
+		_, err = v.UserService.RotateStreamKey(username, username, grace)
+	}
+
+	user, _ := v.UserService.Get(username)
+	if user == nil {
+		http.Redirect(rw, r, "/login", http.StatusFound)
+		return
+	}
+
+	if err != nil {
+		v.renderTemplate(rw, views.PanelData{Error: err.Error(), User: *user})
+		return
+	}
+
+	message := "Your stream key has been regenerated. Update your encoder with the new key."
+	if grace > 0 {
+		message += " The old key keeps working until " + user.PreviousStreamKeyUntil.Format("2006-01-02 15:04") + "."
+	}
+
+	v.renderTemplate(rw, views.PanelData{Message: message, User: *user})
+}
+
+// HandleCreateToken creates a personal API token and shows it once.
+func (v *PanelPage) HandleCreateToken(rw http.ResponseWriter, r *http.Request) {
+	username, authenticated := handlers.RequireSelfAuth(v.Page, rw, r)
+	if !authenticated {
+		return
+	}
+
+	name := strings.TrimSpace(r.FormValue("name"))
+	scope := internal.TokenScope(r.FormValue("scope"))
+
+	// An unparsable value leaves days at zero, which the service rejects.
+	days, _ := strconv.Atoi(r.FormValue("expiresDays"))
+	_, secret, err := v.UserService.CreateAPIToken(username, name, scope, time.Duration(days)*24*time.Hour)
+
+	user, _ := v.UserService.Get(username)
+	if user == nil {
+		http.Redirect(rw, r, "/login", http.StatusFound)
+		return
+	}
+
+	if err != nil {
+		v.renderTemplate(rw, views.PanelData{Error: err.Error(), User: *user})
+		return
+	}
+
+	v.renderTemplate(rw, views.PanelData{NewToken: secret, User: *user})
+}
+
+func (v *PanelPage) HandleRevokeToken(rw http.ResponseWriter, r *http.Request) {
+	username, authenticated := handlers.RequireSelfAuth(v.Page, rw, r)
+	if !authenticated {
+		return
+	}
+
+	if err := v.UserService.RevokeAPIToken(username, r.FormValue("id")); err != nil {
+		user, _ := v.UserService.Get(username)
+		if user == nil {
+			http.Redirect(rw, r, "/login", http.StatusFound)
+			return
+		}
+		v.renderTemplate(rw, views.PanelData{Error: err.Error(), User: *user})
+		return
+	}
+
+	http.Redirect(rw, r, "/panel", http.StatusSeeOther)
+}
+
 func (v *PanelPage) renderTemplate(rw http.ResponseWriter, data views.PanelData) {
 	rw.Header().Set("Content-Type", "text/html; charset=utf-8")
 	if err := v.Template.Execute(rw, data); err != nil {
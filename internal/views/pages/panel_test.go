@@ -1,6 +1,7 @@
 package pages
 
 import (
+	"MediaMTXAuth/internal"
 	"MediaMTXAuth/internal/services"
 	"MediaMTXAuth/internal/storage/memory"
 	"fmt"
@@ -9,6 +10,7 @@ import (
 	"net/url"
 	"strings"
 	"testing"
+	"time"
 )
 
 func TestPanelPage(t *testing.T) {
@@ -122,4 +124,171 @@ func TestPanelPage(t *testing.T) {
 			t.Fatalf("password should not be marked as generated anymore")
 		}
 	})
+
+	t.Run("POST rotate stream key with grace", func(t *testing.T) {
+		t.Cleanup(storage.Clear)
+
+		created, _ := userService.Create("user1", "password", false, "")
+		_ = userService.ChangePassword("user1", "password")
+		loggedInUser, _ := userService.Login("user1", "password")
+
+		form := url.Values{}
+		form.Set("grace", "1h")
+		req := httptest.NewRequest("POST", "/panel/rotate_key", strings.NewReader(form.Encode()))
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		req.AddCookie(&http.Cookie{Name: "session_id", Value: fmt.Sprintf("%d", loggedInUser.Session.ID)})
+		req.AddCookie(&http.Cookie{Name: "username", Value: loggedInUser.Name})
+		rec := httptest.NewRecorder()
+
+		page.HandleRotateStreamKey(rec, req)
+
+		if !strings.Contains(rec.Body.String(), "old key keeps working") {
+			t.Errorf("expected grace period to be mentioned, got %s", rec.Body.String())
+		}
+
+		updatedUser, _ := userService.Get("user1")
+		if updatedUser.StreamKey == created.StreamKey {
+			t.Fatalf("stream key should have changed")
+		}
+
+		if !updatedUser.HasStreamKey(created.StreamKey) || !updatedUser.HasStreamKey(updatedUser.StreamKey) {
+			t.Errorf("old and new key should both work during the grace period")
+		}
+
+		if len(updatedUser.History) != 1 || updatedUser.History[0].By != "user1" {
+			t.Errorf("expected rotation to be recorded in history, got %+v", updatedUser.History)
+		}
+	})
+
+	t.Run("POST rotate stream key with invalid grace", func(t *testing.T) {
+		t.Cleanup(storage.Clear)
+
+		created, _ := userService.Create("user1", "password", false, "")
+		loggedInUser, _ := userService.Login("user1", "password")
+
+		form := url.Values{}
+		form.Set("grace", "720h")
+		req := httptest.NewRequest("POST", "/panel/rotate_key", strings.NewReader(form.Encode()))
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		req.AddCookie(&http.Cookie{Name: "session_id", Value: fmt.Sprintf("%d", loggedInUser.Session.ID)})
+		req.AddCookie(&http.Cookie{Name: "username", Value: loggedInUser.Name})
+		rec := httptest.NewRecorder()
+
+		page.HandleRotateStreamKey(rec, req)
+
+		if !strings.Contains(rec.Body.String(), services.ErrInvalidGrace.Error()) {
+			t.Errorf("expected invalid grace error")
+		}
+
+		if updatedUser, _ := userService.Get("user1"); updatedUser.StreamKey != created.StreamKey {
+			t.Errorf("stream key should not have changed")
+		}
+	})
+
+	t.Run("POST create and revoke API token", func(t *testing.T) {
+		t.Cleanup(storage.Clear)
+
+		_, _ = userService.Create("user1", "password", false, "")
+		_ = userService.ChangePassword("user1", "password")
+		loggedInUser, _ := userService.Login("user1", "password")
+
+		form := url.Values{}
+		form.Set("name", "obs script")
+		form.Set("scope", "read")
+		form.Set("expiresDays", "30")
+		req := httptest.NewRequest("POST", "/panel/create_token", strings.NewReader(form.Encode()))
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		req.AddCookie(&http.Cookie{Name: "session_id", Value: fmt.Sprintf("%d", loggedInUser.Session.ID)})
+		req.AddCookie(&http.Cookie{Name: "username", Value: loggedInUser.Name})
+		rec := httptest.NewRecorder()
+
+		page.HandleCreateToken(rec, req)
+
+		updatedUser, _ := userService.Get("user1")
+		if len(updatedUser.APITokens) != 1 {
+			t.Fatalf("expected one token, got %+v", updatedUser.APITokens)
+		}
+
+		if !strings.Contains(rec.Body.String(), "API token created") {
+			t.Errorf("expected new token to be shown, got %s", rec.Body.String())
+		}
+
+		form = url.Values{}
+		form.Set("id", updatedUser.APITokens[0].ID)
+		req = httptest.NewRequest("POST", "/panel/revoke_token", strings.NewReader(form.Encode()))
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		req.AddCookie(&http.Cookie{Name: "session_id", Value: fmt.Sprintf("%d", loggedInUser.Session.ID)})
+		req.AddCookie(&http.Cookie{Name: "username", Value: loggedInUser.Name})
+		rec = httptest.NewRecorder()
+
+		page.HandleRevokeToken(rec, req)
+
+		if rec.Code != http.StatusSeeOther {
+			t.Errorf("expected redirect, got %d", rec.Code)
+		}
+
+		if updatedUser, _ := userService.Get("user1"); len(updatedUser.APITokens) != 0 {
+			t.Errorf("token should have been revoked")
+		}
+	})
+
+	t.Run("POST create API token with a scope above the user", func(t *testing.T) {
+		t.Cleanup(storage.Clear)
+
+		_, _ = userService.Create("user1", "password", false, "")
+		loggedInUser, _ := userService.Login("user1", "password")
+
+		form := url.Values{}
+		form.Set("name", "script")
+		form.Set("scope", "admin")
+		form.Set("expiresDays", "30")
+		req := httptest.NewRequest("POST", "/panel/create_token", strings.NewReader(form.Encode()))
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		req.AddCookie(&http.Cookie{Name: "session_id", Value: fmt.Sprintf("%d", loggedInUser.Session.ID)})
+		req.AddCookie(&http.Cookie{Name: "username", Value: loggedInUser.Name})
+		rec := httptest.NewRecorder()
+
+		page.HandleCreateToken(rec, req)
+
+		if updatedUser, _ := userService.Get("user1"); len(updatedUser.APITokens) != 0 {
+			t.Errorf("token should not have been created")
+		}
+	})
+
+	t.Run("bearer token", func(t *testing.T) {
+		t.Cleanup(storage.Clear)
+
+		_, _ = userService.Create("user1", "password", false, "")
+		_, secret, _ := userService.CreateAPIToken("user1", "script", internal.ScopeRead, time.Hour)
+
+		req := httptest.NewRequest("GET", "/panel", nil)
+		req.Header.Set("Authorization", "Bearer "+secret)
+		rec := httptest.NewRecorder()
+
+		page.ServeHTTP(rec, req)
+
+		if rec.Code != http.StatusOK {
+			t.Errorf("expected 200 OK with a valid token, got %d", rec.Code)
+		}
+
+		req = httptest.NewRequest("GET", "/panel", nil)
+		req.Header.Set("Authorization", "Bearer invalid")
+		rec = httptest.NewRecorder()
+
+		page.ServeHTTP(rec, req)
+
+		if rec.Code != http.StatusUnauthorized {
+			t.Errorf("expected 401 with an invalid token, got %d", rec.Code)
+		}
+
+		req = httptest.NewRequest("POST", "/panel/create_token", nil)
+		req.Header.Set("Authorization", "Bearer "+secret)
+		rec = httptest.NewRecorder()
+
+		page.HandleCreateToken(rec, req)
+
+		if rec.Code != http.StatusForbidden {
+			t.Errorf("expected 403 for a read-only token, got %d", rec.Code)
+		}
+	})
 }
@@ -0,0 +1,87 @@
+package pages
+
+import (
+	"MediaMTXAuth/internal"
+	"MediaMTXAuth/internal/views"
+	"MediaMTXAuth/internal/views/handlers"
+	_ "embed"
+	"html/template"
+	"net/http"
+)
+
+//go:embed html/reset.html
+var ResetPageHTML string
+
+type ResetPage struct {
+	*views.Page
+}
+
+func NewReset(userService internal.UserService) *ResetPage {
+	tmpl := template.Must(template.New("pages").Parse(ResetPageHTML))
+	return &ResetPage{
+		Page: &views.Page{
+			UserService: userService,
+			Template:    tmpl,
+		},
+	}
+}
+
+func (v *ResetPage) ServeHTTP(rw http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet:
+		v.showResetForm(rw, r)
+	case http.MethodPost:
+		v.handleReset(rw, r)
+	default:
+		http.Error(rw, "Method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+func (v *ResetPage) showResetForm(rw http.ResponseWriter, r *http.Request) {
+	data := views.ResetData{
+		Username: r.FormValue("user"),
+		Token:    r.FormValue("token"),
+	}
+
+	if err := v.UserService.CheckPasswordReset(data.Username, data.Token); err != nil {
+		v.renderTemplate(rw, http.StatusNotFound, views.ResetData{Error: err.Error()})
+		return
+	}
+
+	v.renderTemplate(rw, http.StatusOK, data)
+}
+
+func (v *ResetPage) handleReset(rw http.ResponseWriter, r *http.Request) {
+	data := views.ResetData{
+		Username: r.FormValue("user"),
+		Token:    r.FormValue("token"),
+	}
+	password := r.FormValue("password")
+
+	if password != r.FormValue("confirm") {
+		data.Error = "Passwords do not match"
+		v.renderTemplate(rw, http.StatusBadRequest, data)
+		return
+	}
+
+	user, err := v.UserService.CompletePasswordReset(data.Username, data.Token, password)
+	if err == internal.ErrInvalidToken {
+		v.renderTemplate(rw, http.StatusNotFound, views.ResetData{Error: err.Error()})
+		return
+	} else if err != nil {
+		data.Error = err.Error()
+		v.renderTemplate(rw, http.StatusBadRequest, data)
+		return
+	}
+
+	handlers.SetSessionCookies(rw, r, user)
+	handlers.RedirectHome(rw, r, user)
+}
+
+func (v *ResetPage) renderTemplate(rw http.ResponseWriter, status int, data views.ResetData) {
+	rw.Header().Set("Content-Type", "text/html; charset=utf-8")
+	rw.WriteHeader(status)
+	if err := v.Template.Execute(rw, data); err != nil {
+		http.Error(rw, "Internal server error", http.StatusInternalServerError)
+	}
+}
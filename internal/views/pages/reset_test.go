@@ -0,0 +1,118 @@
+package pages
+
+import (
+	"MediaMTXAuth/internal/services"
+	"MediaMTXAuth/internal/storage/memory"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+)
+
+func TestResetPage(t *testing.T) {
+	storage := &memory.Storage{}
+	_ = storage.Init()
+	userService := services.NewUserService(storage)
+	page := NewReset(userService)
+
+	t.Run("GET invalid token", func(t *testing.T) {
+		t.Cleanup(storage.Clear)
+		_, _ = userService.Create("user1", "password", false, "")
+
+		req := httptest.NewRequest("GET", "/reset?user=user1&token=wrong", nil)
+		rec := httptest.NewRecorder()
+
+		page.ServeHTTP(rec, req)
+
+		if rec.Code != http.StatusNotFound {
+			t.Fatalf("expected 404, got %d", rec.Code)
+		}
+
+		if strings.Contains(rec.Body.String(), "<form") {
+			t.Errorf("form should not be shown for invalid token")
+		}
+	})
+
+	t.Run("GET valid token", func(t *testing.T) {
+		t.Cleanup(storage.Clear)
+		_, _ = userService.Create("user1", "password", false, "")
+		token, _ := userService.CreatePasswordReset("user1", "admin")
+
+		req := httptest.NewRequest("GET", "/reset?"+url.Values{"user": {"user1"}, "token": {token}}.Encode(), nil)
+		rec := httptest.NewRecorder()
+
+		page.ServeHTTP(rec, req)
+
+		if rec.Code != http.StatusOK {
+			t.Fatalf("expected 200 OK, got %d", rec.Code)
+		}
+
+		if !strings.Contains(rec.Body.String(), "<form") {
+			t.Errorf("expected reset form")
+		}
+	})
+
+	t.Run("POST new password", func(t *testing.T) {
+		t.Cleanup(storage.Clear)
+		_, _ = userService.Create("user1", "password", false, "")
+		token, _ := userService.CreatePasswordReset("user1", "admin")
+
+		form := url.Values{}
+		form.Set("user", "user1")
+		form.Set("token", token)
+		form.Set("password", "newpassword")
+		form.Set("confirm", "newpassword")
+
+		req := httptest.NewRequest("POST", "/reset", strings.NewReader(form.Encode()))
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		rec := httptest.NewRecorder()
+
+		page.ServeHTTP(rec, req)
+		resp := rec.Result()
+
+		if resp.StatusCode != http.StatusSeeOther {
+			t.Fatalf("expected redirect, got %d", resp.StatusCode)
+		}
+
+		if loc := resp.Header.Get("Location"); loc != "/panel" {
+			t.Errorf("expected redirect to /panel, got %s", loc)
+		}
+
+		if len(resp.Cookies()) != 2 {
+			t.Errorf("expected session cookies to be set, got %v", resp.Cookies())
+		}
+
+		user, _ := userService.Get("user1")
+		if user.Password.IsGenerated || user.PasswordReset.IsPending() {
+			t.Errorf("expected password to be set and reset link consumed")
+		}
+	})
+
+	t.Run("POST mismatched passwords", func(t *testing.T) {
+		t.Cleanup(storage.Clear)
+		_, _ = userService.Create("user1", "password", false, "")
+		token, _ := userService.CreatePasswordReset("user1", "admin")
+
+		form := url.Values{}
+		form.Set("user", "user1")
+		form.Set("token", token)
+		form.Set("password", "newpassword")
+		form.Set("confirm", "otherpassword")
+
+		req := httptest.NewRequest("POST", "/reset", strings.NewReader(form.Encode()))
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		rec := httptest.NewRecorder()
+
+		page.ServeHTTP(rec, req)
+
+		if rec.Code != http.StatusBadRequest {
+			t.Fatalf("expected 400, got %d", rec.Code)
+		}
+
+		user, _ := userService.Get("user1")
+		if !user.PasswordReset.IsPending() {
+			t.Errorf("reset link should still be pending")
+		}
+	})
+}
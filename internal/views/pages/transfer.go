@@ -0,0 +1,109 @@
+package pages
+
+import (
+	"MediaMTXAuth/internal"
+	"MediaMTXAuth/internal/transfer"
+	"MediaMTXAuth/internal/views"
+	"MediaMTXAuth/internal/views/handlers"
+	"errors"
+	"fmt"
+	"net/http"
+	"slices"
+	"time"
+)
+
+var ErrDeleteSelf = errors.New("the import would delete your own account")
+
+// HandleExport downloads all users and namespaces as JSON or YAML.
+func (v *AdminPage) HandleExport(rw http.ResponseWriter, r *http.Request) {
+	actor, authenticated := handlers.RequireManagerAuth(v.Page, rw, r)
+	if !authenticated {
+		return
+	}
+
+	if !actor.IsAdmin {
+		v.render(rw, actor, views.AdminData{Error: internal.ErrForbidden.Error()})
+		return
+	}
+
+	format := transfer.Format(r.FormValue("format"))
+	if format == "" {
+		format = transfer.YAML
+	}
+
+	if format != transfer.JSON && format != transfer.YAML {
+		v.render(rw, actor, views.AdminData{Error: transfer.ErrInvalidFormat.Error()})
+		return
+	}
+
+	doc, err := transfer.New(v.UserService, v.NamespaceService).Export(r.FormValue("streamKeys") == "true")
+	if err != nil {
+		v.render(rw, actor, views.AdminData{Error: err.Error()})
+		return
+	}
+
+	filename := fmt.Sprintf("mediamtx-auth-%s.%s", time.Now().Format("20060102-150405"), format)
+	rw.Header().Set("Content-Type", "application/"+string(format))
+	rw.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=%q", filename))
+	_ = transfer.Encode(rw, doc, format)
+}
+
+// HandleImport applies an uploaded export, or with dryRun only lists the
+// changes it would make.
+func (v *AdminPage) HandleImport(rw http.ResponseWriter, r *http.Request) {
+	actor, authenticated := handlers.RequireManagerAuth(v.Page, rw, r)
+	if !authenticated {
+		return
+	}
+
+	if !actor.IsAdmin {
+		v.render(rw, actor, views.AdminData{Error: internal.ErrForbidden.Error()})
+		return
+	}
+
+	file, _, err := r.FormFile("file")
+	if err != nil {
+		v.render(rw, actor, views.AdminData{Error: "No file uploaded"})
+		return
+	}
+	defer file.Close()
+
+	doc, err := transfer.Decode(file)
+	if err != nil {
+		v.render(rw, actor, views.AdminData{Error: "Invalid file: " + err.Error()})
+		return
+	}
+
+	t := transfer.New(v.UserService, v.NamespaceService)
+	mode := transfer.Mode(r.FormValue("mode"))
+	dryRun := r.FormValue("dryRun") == "true"
+
+	changes, err := t.Plan(doc, mode)
+	if err == nil && slices.ContainsFunc(changes, func(c transfer.Change) bool {
+		return c.Action == "delete" && c.Kind == "user" && c.Name == actor.Name
+	}) {
+		err = ErrDeleteSelf
+	}
+
+	if err == nil && !dryRun {
+		changes, err = t.Import(doc, mode)
+	}
+
+	data := views.AdminData{ImportDryRun: dryRun}
+	for _, change := range changes {
+		data.ImportChanges = append(data.ImportChanges, change.String())
+	}
+
+	switch {
+	case err != nil:
+		data.Error = err.Error()
+	case len(changes) == 0:
+		data.Message = "Nothing to change"
+	case dryRun:
+		data.Message = fmt.Sprintf("The import would make %d changes", len(changes))
+	default:
+		data.Message = fmt.Sprintf("Imported with %d changes", len(changes))
+	}
+
+	v.render(rw, actor, data)
+}
@@ -0,0 +1,197 @@
+package pages
+
+import (
+	"MediaMTXAuth/internal"
+	"MediaMTXAuth/internal/services"
+	"MediaMTXAuth/internal/views"
+	"MediaMTXAuth/internal/views/handlers"
+	_ "embed"
+	"html/template"
+	"net/http"
+	"net/url"
+)
+
+//go:embed html/user.html
+var UserPageHTML string
+
+// UserPage shows the details of a single user and lets admins and managers
+// edit them.
+type UserPage struct {
+	*AdminPage
+	Template *template.Template
+}
+
+func NewUser(admin *AdminPage) *UserPage {
+	tmpl := template.Must(template.New("pages").Funcs(adminFuncs).Parse(UserPageHTML))
+	return &UserPage{
+		AdminPage: admin,
+		Template:  tmpl,
+	}
+}
+
+func (v *UserPage) ServeHTTP(rw http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet:
+		v.showUserForm(rw, r)
+	default:
+		http.Error(rw, "Method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+func (v *UserPage) showUserForm(rw http.ResponseWriter, r *http.Request) {
+	actor, authenticated := handlers.RequireManagerAuth(v.Page, rw, r)
+	if !authenticated {
+		return
+	}
+
+	username := r.FormValue("name")
+
+	if _, err := services.AuthorizeUser(v.UserService, actor, username); err != nil {
+		v.AdminPage.render(rw, actor, views.AdminData{Error: err.Error()})
+		return
+	}
+
+	v.render(rw, actor, username, views.UserData{})
+}
+
+// HandleUpdateUser renames the user and changes their admin rights.
+func (v *UserPage) HandleUpdateUser(rw http.ResponseWriter, r *http.Request) {
+	actor, authenticated := handlers.RequireManagerAuth(v.Page, rw, r)
+	if !authenticated {
+		return
+	}
+
+	username := r.FormValue("username")
+	newName := r.FormValue("newName")
+	isAdmin := r.FormValue("isAdmin") == "true"
+
+	if _, err := services.AuthorizeEdit(v.UserService, actor, username); err != nil {
+		v.AdminPage.render(rw, actor, views.AdminData{Error: err.Error()})
+		return
+	}
+
+	user, err := v.UserService.Get(username)
+	if err == nil && user == nil {
+		err = internal.ErrUserNotFound
+	}
+
+	if err == nil && user.IsAdmin != isAdmin {
+		switch {
+		case !actor.IsAdmin:
+			err = internal.ErrForbidden
+		case username == actor.Name:
+			err = services.ErrDemoteSelf
+		default:
+			err = v.UserService.SetAdmin(username, isAdmin)
+		}
+	}
+
+	if err == nil && newName != "" && newName != username {
+		user, err = v.UserService.Rename(username, newName)
+		if err == nil {
+			// Streams under the old path no longer match the user.
+			services.KickRenamedStreams(v.Kicker, *user, username)
+
+			if session, ok := handlers.CurrentSession(r, user); ok && actor.Name == username {
+				user.Session = session
+				handlers.SetSessionCookies(rw, r, user)
+			}
+			username = newName
+		}
+	}
+
+	if err != nil {
+		v.render(rw, actor, username, views.UserData{Error: err.Error()})
+		return
+	}
+
+	http.Redirect(rw, r, userURL(username), http.StatusSeeOther)
+}
+
+// HandleCreateResetLink shows a single-use link the user can set a new
+// password with.
+func (v *UserPage) HandleCreateResetLink(rw http.ResponseWriter, r *http.Request) {
+	actor, authenticated := handlers.RequireManagerAuth(v.Page, rw, r)
+	if !authenticated {
+		return
+	}
+
+	username := r.FormValue("username")
+
+	if _, err := services.AuthorizeEdit(v.UserService, actor, username); err != nil {
+		v.AdminPage.render(rw, actor, views.AdminData{Error: err.Error()})
+		return
+	}
+
+	token, err := v.UserService.CreatePasswordReset(username, actor.Name)
+	if err != nil {
+		v.render(rw, actor, username, views.UserData{Error: err.Error()})
+		return
+	}
+
+	link := handlers.ResetLink(r, username, token)
+	v.render(rw, actor, username, views.UserData{ResetLink: link})
+}
+
+func (v *UserPage) HandleResetStreamKey(rw http.ResponseWriter, r *http.Request) {
+	actor, authenticated := handlers.RequireManagerAuth(v.Page, rw, r)
+	if !authenticated {
+		return
+	}
+
+	username := r.FormValue("username")
+
+	if _, err := services.AuthorizeEdit(v.UserService, actor, username); err != nil {
+		v.AdminPage.render(rw, actor, views.AdminData{Error: err.Error()})
+		return
+	}
+
+	if _, err := v.UserService.RotateStreamKey(username, actor.Name, 0); err != nil {
+		v.render(rw, actor, username, views.UserData{Error: err.Error()})
+		return
+	}
+
+	services.KickStreams(v.UserService, v.Kicker, username)
+
+	v.render(rw, actor, username, views.UserData{Message: "Stream key has been reset"})
+}
+
+// render fills in the actor, the user called username and the namespaces the
+// actor manages and renders the page.
+func (v *UserPage) render(rw http.ResponseWriter, actor *internal.User, username string, data views.UserData) {
+	if current, _ := v.UserService.Get(actor.Name); current != nil {
+		actor = current
+	}
+	data.Actor = *actor
+
+	user, err := v.UserService.Get(username)
+	if user == nil && data.Error == "" {
+		data.Error = internal.ErrUserNotFound.Error()
+	}
+	if err != nil && data.Error == "" {
+		data.Error = "Failed to load user"
+	}
+	if user != nil {
+		data.User = *user
+	}
+
+	namespaces, err := v.NamespaceService.GetAllNamespaces()
+	if err != nil && data.Error == "" {
+		data.Error = "Failed to load namespaces"
+	}
+
+	for _, namespace := range namespaces {
+		if actor.CanManage(namespace.Name) {
+			data.Namespaces = append(data.Namespaces, namespace)
+		}
+	}
+
+	rw.Header().Set("Content-Type", "text/html; charset=utf-8")
+	if err := v.Template.Execute(rw, data); err != nil {
+		http.Error(rw, "Internal server error", http.StatusInternalServerError)
+	}
+}
+
+func userURL(username string) string {
+	return "/admin/user?" + url.Values{"name": {username}}.Encode()
+}
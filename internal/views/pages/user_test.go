@@ -0,0 +1,219 @@
+package pages
+
+import (
+	"MediaMTXAuth/internal"
+	"MediaMTXAuth/internal/services"
+	"MediaMTXAuth/internal/storage/memory"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+)
+
+func TestUserPage(t *testing.T) {
+	storage := &memory.Storage{}
+	_ = storage.Init()
+	userService := services.NewUserService(storage)
+	namespaceService := services.NewNamespaceService(storage)
+	page := NewUser(NewAdmin(userService, namespaceService))
+
+	login := func(t *testing.T, name string) func(method, target string, form url.Values) *http.Request {
+		_ = userService.ChangePassword(name, "password")
+		user, err := userService.Login(name, "password")
+		if err != nil {
+			t.Fatalf("Failed to login: %v", err)
+		}
+
+		return func(method, target string, form url.Values) *http.Request {
+			req := httptest.NewRequest(method, target, strings.NewReader(form.Encode()))
+			req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+			req.AddCookie(&http.Cookie{Name: "session_id", Value: fmt.Sprintf("%d", user.Session.ID)})
+			req.AddCookie(&http.Cookie{Name: "username", Value: user.Name})
+			return req
+		}
+	}
+
+	t.Run("GET user page as admin", func(t *testing.T) {
+		t.Cleanup(storage.Clear)
+		_, _ = userService.Create("admin", "password", true, "")
+		_, _ = userService.Create("streamer", "password", false, "")
+		request := login(t, "admin")
+
+		rec := httptest.NewRecorder()
+		page.ServeHTTP(rec, request("GET", "/admin/user?name=streamer", nil))
+
+		if rec.Code != http.StatusOK {
+			t.Fatalf("expected 200 OK, got %d", rec.Code)
+		}
+
+		if !strings.Contains(rec.Body.String(), `value="streamer"`) {
+			t.Errorf("expected user details to be shown")
+		}
+	})
+
+	t.Run("rename keeps stream key and guest sessions", func(t *testing.T) {
+		t.Cleanup(storage.Clear)
+		_, _ = namespaceService.Create("ns")
+		_, _ = userService.Create("admin", "password", true, "")
+		streamer, _ := userService.Create("streamer", "password", false, "ns")
+		_, _ = namespaceService.AddSession("ns", "guest", "streamer")
+		request := login(t, "admin")
+
+		rec := httptest.NewRecorder()
+		page.HandleUpdateUser(rec, request("POST", "/admin/user/update", url.Values{"username": {"streamer"}, "newName": {"renamed"}}))
+
+		if rec.Code != http.StatusSeeOther || rec.Header().Get("Location") != "/admin/user?name=renamed" {
+			t.Fatalf("expected redirect to renamed user, got %d %s: %s", rec.Code, rec.Header().Get("Location"), rec.Body.String())
+		}
+
+		renamed, _ := userService.Get("renamed")
+		if renamed == nil || renamed.StreamKey != streamer.StreamKey {
+			t.Fatalf("expected renamed user with the same stream key, got %+v", renamed)
+		}
+
+		if old, _ := userService.Get("streamer"); old != nil {
+			t.Errorf("old user should be gone")
+		}
+
+		ns, _ := namespaceService.Get("ns")
+		if ns.Sessions[0].User != "renamed" {
+			t.Errorf("expected guest session to follow the rename, got %s", ns.Sessions[0].User)
+		}
+	})
+
+	t.Run("rename kicks streams of the old name", func(t *testing.T) {
+		t.Cleanup(storage.Clear)
+		kicker := &fakeKicker{}
+		page.Kicker = kicker
+		t.Cleanup(func() { page.Kicker = nil })
+
+		_, _ = namespaceService.Create("ns")
+		_, _ = userService.Create("admin", "password", true, "")
+		_, _ = userService.Create("streamer", "password", false, "ns")
+		_, _ = userService.Create("taken", "password", false, "ns")
+		request := login(t, "admin")
+
+		rec := httptest.NewRecorder()
+		page.HandleUpdateUser(rec, request("POST", "/admin/user/update", url.Values{"username": {"streamer"}, "newName": {"taken"}}))
+		if len(kicker.paths) != 0 {
+			t.Errorf("expected no streams kicked for a failed rename, got %v", kicker.paths)
+		}
+
+		rec = httptest.NewRecorder()
+		page.HandleUpdateUser(rec, request("POST", "/admin/user/update", url.Values{"username": {"streamer"}, "newName": {"renamed"}}))
+		if rec.Code != http.StatusSeeOther {
+			t.Fatalf("expected redirect after rename, got %d: %s", rec.Code, rec.Body.String())
+		}
+		if len(kicker.paths) != 1 || kicker.paths[0] != "ns/streamer" {
+			t.Errorf("expected the old path to be kicked, got %v", kicker.paths)
+		}
+	})
+
+	t.Run("renaming yourself updates the cookies", func(t *testing.T) {
+		t.Cleanup(storage.Clear)
+		_, _ = userService.Create("admin", "password", true, "")
+		request := login(t, "admin")
+
+		rec := httptest.NewRecorder()
+		page.HandleUpdateUser(rec, request("POST", "/admin/user/update", url.Values{"username": {"admin"}, "newName": {"root"}, "isAdmin": {"true"}}))
+
+		var found bool
+		for _, cookie := range rec.Result().Cookies() {
+			if cookie.Name == "username" && cookie.Value == "root" {
+				found = true
+			}
+		}
+
+		if !found {
+			t.Errorf("expected username cookie to be updated")
+		}
+	})
+
+	t.Run("toggle admin", func(t *testing.T) {
+		t.Cleanup(storage.Clear)
+		_, _ = userService.Create("admin", "password", true, "")
+		_, _ = userService.Create("streamer", "password", false, "")
+		request := login(t, "admin")
+
+		rec := httptest.NewRecorder()
+		page.HandleUpdateUser(rec, request("POST", "/admin/user/update", url.Values{"username": {"streamer"}, "isAdmin": {"true"}}))
+
+		if user, _ := userService.Get("streamer"); !user.IsAdmin {
+			t.Errorf("expected streamer to become admin")
+		}
+
+		rec = httptest.NewRecorder()
+		page.HandleUpdateUser(rec, request("POST", "/admin/user/update", url.Values{"username": {"admin"}}))
+
+		if !strings.Contains(rec.Body.String(), services.ErrDemoteSelf.Error()) {
+			t.Errorf("expected error when removing own admin rights")
+		}
+
+		if user, _ := userService.Get("admin"); !user.IsAdmin {
+			t.Errorf("admin should keep their rights")
+		}
+	})
+
+	t.Run("reset link", func(t *testing.T) {
+		t.Cleanup(storage.Clear)
+		_, _ = userService.Create("admin", "password", true, "")
+		_, _ = userService.Create("streamer", "password", false, "")
+		_ = userService.ChangePassword("streamer", "password")
+		request := login(t, "admin")
+
+		rec := httptest.NewRecorder()
+		page.HandleCreateResetLink(rec, request("POST", "/admin/user/reset_link", url.Values{"username": {"streamer"}}))
+
+		if !strings.Contains(rec.Body.String(), "/reset?token=") {
+			t.Errorf("expected reset link to be shown")
+		}
+
+		user, _ := userService.Get("streamer")
+		if !user.PasswordReset.IsPending() || user.PasswordReset.CreatedBy != "admin" {
+			t.Errorf("expected pending reset created by admin, got %+v", user.PasswordReset)
+		}
+		if user.Password.IsGenerated {
+			t.Errorf("password should be kept until the link is used")
+		}
+	})
+
+	t.Run("manager", func(t *testing.T) {
+		t.Cleanup(storage.Clear)
+		_, _ = namespaceService.Create("own")
+		_, _ = namespaceService.Create("other")
+		_, _ = userService.Create("manager", "password", false, "own")
+		_ = userService.SetManagedNamespaces("manager", []string{"own"})
+		_, _ = userService.Create("member", "password", false, "own")
+		_, _ = userService.Create("stranger", "password", false, "other")
+		request := login(t, "manager")
+
+		t.Run("cannot open users of other namespaces", func(t *testing.T) {
+			rec := httptest.NewRecorder()
+			page.ServeHTTP(rec, request("GET", "/admin/user?name=stranger", nil))
+
+			if !strings.Contains(rec.Body.String(), internal.ErrForbidden.Error()) {
+				t.Errorf("expected permission error")
+			}
+		})
+
+		t.Run("cannot grant admin rights", func(t *testing.T) {
+			rec := httptest.NewRecorder()
+			page.HandleUpdateUser(rec, request("POST", "/admin/user/update", url.Values{"username": {"member"}, "isAdmin": {"true"}}))
+
+			if user, _ := userService.Get("member"); user.IsAdmin {
+				t.Errorf("manager should not be able to grant admin rights")
+			}
+		})
+
+		t.Run("membership changes return to the user page", func(t *testing.T) {
+			rec := httptest.NewRecorder()
+			page.HandleSetMembership(rec, request("POST", "/admin/set_membership", url.Values{"username": {"member"}, "namespace": {"own"}, "permission": {"read"}, "from": {"user"}}))
+
+			if location := rec.Header().Get("Location"); location != "/admin/user?name=member" {
+				t.Errorf("expected redirect to user page, got %q", location)
+			}
+		})
+	})
+}
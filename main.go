@@ -1,83 +1,229 @@
 package main
 
 import (
-	"MediaMTXAuth/internal/auth"
+	"MediaMTXAuth/internal/backup"
+	"MediaMTXAuth/internal/config"
+	"MediaMTXAuth/internal/mediamtx"
+	"MediaMTXAuth/internal/passwords"
+	"MediaMTXAuth/internal/server"
 	"MediaMTXAuth/internal/services"
+	"MediaMTXAuth/internal/storage"
 	"MediaMTXAuth/internal/storage/bolt"
-	"MediaMTXAuth/internal/views/pages"
+	"MediaMTXAuth/internal/storage/encrypted"
+	"MediaMTXAuth/internal/storage/memory"
+	"MediaMTXAuth/internal/storage/sqlite"
 	"flag"
+	"fmt"
 	"log"
 	"net/http"
+	"os"
+	"os/signal"
+	"strings"
+	"syscall"
+	"time"
 )
 
 var dbPath string
+var maxHashes int
+var mediamtxAPI string
+var expiredGrace time.Duration
+var setupToken bool
+var migrateDryRun bool
+var backupDir string
+var backupInterval time.Duration
+var backupKeep int
+var configPath string
 
 func init() {
-	flag.StringVar(&dbPath, "db", "auth.db", "path to database file")
+	flag.StringVar(&dbPath, "db", "auth.db", "database to use: a bolt file path, bolt://path, sqlite://path, or :memory: to keep everything in memory")
+	flag.IntVar(&maxHashes, "max-hashes", passwords.DefaultMaxConcurrent, "maximum number of concurrent password hash computations")
+	flag.DurationVar(&expiredGrace, "expired-grace", 7*24*time.Hour, "how long expired accounts are kept before they are deleted")
+	flag.BoolVar(&setupToken, "setup-token", false, "let the first admin be created on /login with a one-time token instead of generating a password")
+	flag.BoolVar(&migrateDryRun, "migrate-dry-run", false, "list the database migrations that would run at startup, check that they succeed, and exit without changing anything")
+	flag.StringVar(&backupDir, "backup-dir", "", "directory for scheduled database backups, disabled if empty")
+	flag.DurationVar(&backupInterval, "backup-interval", 24*time.Hour, "how often a scheduled backup is taken")
+	flag.IntVar(&backupKeep, "backup-keep", 7, "how many scheduled backups are kept")
+	flag.StringVar(&configPath, "config", "", "YAML file declaring namespaces and users, applied at startup and on SIGHUP")
+	flag.StringVar(&mediamtxAPI, "mediamtx-api", "", "MediaMTX API address used to kick streams of suspended users, e.g. http://mediamtx:9997")
 }
 
 func main() {
 	flag.Parse()
+	passwords.SetMaxConcurrent(maxHashes)
 
-	store, err := bolt.New(dbPath)
+	if backupDir != "" && backupKeep < 1 {
+		log.Fatalf("--backup-keep must be at least 1")
+	}
+	if backupDir != "" && backupInterval <= 0 {
+		log.Fatalf("--backup-interval must be positive")
+	}
+
+	store, err := openStorage(dbPath)
 
 	if err != nil {
 		log.Fatalf("failed to open DB: %v", err)
 	}
 
-	err = store.Init()
+	if migrateDryRun {
+		planMigrations(store)
+		return
+	}
+
+	records, err := initStorage(store)
 
 	if err != nil {
 		log.Fatalf("failed to init DB: %v", err)
 	}
 
-	userService := services.NewUserService(store)
-	namespaceService := services.NewNamespaceService(store)
+	userService := services.NewUserService(records)
+	namespaceService := services.NewNamespaceService(records)
 
-	adminPassword, err := userService.CreateDefaultAdminUser()
+	defer store.Close()
 
-	if err != nil {
-		log.Fatalf("failed to create default admin user: %v", err)
+	if ok, err := runCommand(flag.Args(), userService, namespaceService); ok {
+		if err != nil {
+			log.Fatalf("%s failed: %v", flag.Arg(0), err)
+		}
+		return
 	}
 
-	if adminPassword != "" {
-		log.Println("admin password:", adminPassword)
+	if configPath != "" {
+		reconciler := config.New(userService, namespaceService, configPath)
+		if err := reconcile(reconciler); err != nil {
+			log.Fatalf("failed to apply config file: %v", err)
+		}
+		go reconcileOnHangup(reconciler)
 	}
 
-	defer store.Close()
-	requirePost := func(handler func(http.ResponseWriter, *http.Request)) http.HandlerFunc {
-		return func(w http.ResponseWriter, r *http.Request) {
-			if r.Method != http.MethodPost {
-				http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
-				return
+	go func() {
+		for ; ; time.Sleep(time.Hour) {
+			deleted, err := userService.DeleteExpired(expiredGrace)
+			if err != nil {
+				log.Printf("failed to delete expired users: %v", err)
+			}
+			for _, name := range deleted {
+				log.Printf("deleted expired user %s", name)
 			}
-			handler(w, r)
 		}
+	}()
+
+	srv := server.New(userService, namespaceService)
+	if mediamtxAPI != "" {
+		srv.SetKicker(mediamtx.New(mediamtxAPI))
 	}
 
-	loginView := pages.NewLogin(userService)
-	adminView := pages.NewAdmin(userService, namespaceService)
-	panelView := pages.NewPanel(userService)
-	api := auth.New(userService, namespaceService)
+	if _, ok := store.(storage.Backuper); ok {
+		// Restoring through records loads the keyring of the snapshot when
+		// encryption is enabled. Without it, encrypted snapshots are refused.
+		backuper := records.(storage.Backuper)
+		if records == store {
+			backuper = encrypted.Unencrypted(backuper)
+		}
+		srv.SetBackuper(backuper)
 
-	mux := http.NewServeMux()
-	mux.Handle("/static/", http.StripPrefix("/static/", http.FileServer(http.Dir("internal/views/pages/html/static"))))
+		if backupDir != "" {
+			go scheduleBackups(backuper)
+		}
+	} else if backupDir != "" {
+		log.Fatalf("scheduled backups are not supported by this database")
+	}
+
+	if err := bootstrapAdmin(userService, srv.Login, setupToken); err != nil {
+		log.Fatalf("failed to create initial admin: %v", err)
+	}
+
+	log.Println("Server starting on :8080")
+	log.Fatal(http.ListenAndServe(":8080", srv))
+}
 
-	// API
-	mux.Handle("/api/auth", api)
+// openStorage opens the database described by db. A plain path or bolt://path
+// opens a bolt file and sqlite://path a SQLite file. The special value :memory:
+// keeps everything in memory, which is useful for demos and integration tests.
+func openStorage(db string) (storage.Storage, error) {
+	if db == ":memory:" {
+		log.Println("Using in-memory storage, nothing will be persisted")
+		return &memory.Storage{}, nil
+	}
 
-	// Views
-	mux.Handle("/login", loginView)
-	mux.Handle("/admin", adminView)
-	mux.Handle("/panel", panelView)
+	scheme, path, ok := strings.Cut(db, "://")
+	if !ok {
+		return bolt.New(db)
+	}
 
-	// POST
-	mux.HandleFunc("/admin/add", requirePost(adminView.HandleAddUser))
-	mux.HandleFunc("/admin/remove", requirePost(adminView.HandleRemoveUser))
-	mux.HandleFunc("/admin/add_namespace", requirePost(adminView.HandleAddNamespace))
-	mux.HandleFunc("/admin/remove_namespace", requirePost(adminView.HandleRemoveNamespace))
-	mux.HandleFunc("/panel/change_password", requirePost(panelView.HandleChangePassword))
+	switch scheme {
+	case "bolt":
+		return bolt.New(path)
+	case "sqlite":
+		return sqlite.New(path)
+	default:
+		return nil, fmt.Errorf("unknown database scheme %q", scheme)
+	}
+}
 
-	log.Println("Server starting on :8080")
-	log.Fatal(http.ListenAndServe(":8080", mux))
+// scheduleBackups saves a backup to backupDir every backupInterval and
+// deletes the oldest ones beyond backupKeep.
+func scheduleBackups(backuper storage.Backuper) {
+	for {
+		time.Sleep(backupInterval)
+
+		path, err := backup.Save(backuper, backupDir)
+		if err != nil {
+			log.Printf("failed to back up database: %v", err)
+			continue
+		}
+		log.Printf("saved backup %s", path)
+
+		deleted, err := backup.Prune(backupDir, backupKeep)
+		if err != nil {
+			log.Printf("failed to delete old backups: %v", err)
+		}
+		for _, path := range deleted {
+			log.Printf("deleted old backup %s", path)
+		}
+	}
+}
+
+// reconcile applies the config file and logs the changes made.
+func reconcile(reconciler *config.Reconciler) error {
+	changes, err := reconciler.Reconcile()
+
+	log.Printf("Applied config file %s with %d changes", reconciler.Path, len(changes))
+	for _, change := range changes {
+		log.Printf("  %s", change)
+	}
+	return err
+}
+
+// reconcileOnHangup applies the config file again whenever the process gets
+// SIGHUP. Errors are logged and leave the server running.
+func reconcileOnHangup(reconciler *config.Reconciler) {
+	hangup := make(chan os.Signal, 1)
+	signal.Notify(hangup, syscall.SIGHUP)
+
+	for range hangup {
+		if err := reconcile(reconciler); err != nil {
+			log.Printf("failed to apply config file: %v", err)
+		}
+	}
+}
+
+// planMigrations logs the migrations Init would apply to store.
+func planMigrations(store storage.Storage) {
+	defer store.Close()
+
+	migrator, ok := store.(storage.Migrator)
+	if !ok {
+		log.Println("This database has no versioned records, nothing to migrate")
+		return
+	}
+
+	version, pending, err := migrator.PlanMigrations()
+	if err != nil {
+		log.Fatalf("migrations would fail: %v", err)
+	}
+
+	log.Printf("Database is at schema version %d, %d migrations pending", version, len(pending))
+	for _, description := range pending {
+		log.Printf("  %s", description)
+	}
 }
@@ -0,0 +1,100 @@
+package main
+
+import (
+	"MediaMTXAuth/internal"
+	"MediaMTXAuth/internal/transfer"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"os"
+)
+
+// runCommand runs the export or import command named by args[0] against the
+// database and reports whether there was one.
+func runCommand(args []string, userService internal.UserService, namespaceService internal.NamespaceService) (bool, error) {
+	if len(args) == 0 {
+		return false, nil
+	}
+
+	t := transfer.New(userService, namespaceService)
+
+	switch args[0] {
+	case "export":
+		return true, exportCommand(t, args[1:])
+	case "import":
+		return true, importCommand(t, args[1:])
+	default:
+		return true, fmt.Errorf("unknown command %q, expected export or import", args[0])
+	}
+}
+
+// exportCommand writes all users and namespaces to a file or stdout.
+func exportCommand(t *transfer.Transfer, args []string) error {
+	flags := flag.NewFlagSet("export", flag.ExitOnError)
+	format := flags.String("format", "yaml", "output format, json or yaml")
+	streamKeys := flags.Bool("stream-keys", false, "include stream keys, which lets anyone with the file publish as any user")
+	output := flags.String("o", "", "file to write to instead of stdout")
+	_ = flags.Parse(args)
+
+	doc, err := t.Export(*streamKeys)
+	if err != nil {
+		return err
+	}
+
+	var w io.Writer = os.Stdout
+	if *output != "" {
+		file, err := os.OpenFile(*output, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0600)
+		if err != nil {
+			return err
+		}
+		defer file.Close()
+		w = file
+	}
+
+	return transfer.Encode(w, doc, transfer.Format(*format))
+}
+
+// importCommand applies a document written by export and logs the changes.
+func importCommand(t *transfer.Transfer, args []string) error {
+	flags := flag.NewFlagSet("import", flag.ExitOnError)
+	mode := flags.String("mode", "merge", "merge keeps users and namespaces missing from the file, replace deletes them")
+	dryRun := flags.Bool("dry-run", false, "list the changes without making them")
+	_ = flags.Parse(args)
+
+	if flags.NArg() != 1 {
+		return fmt.Errorf("usage: import [-mode merge|replace] [-dry-run] file")
+	}
+
+	file, err := os.Open(flags.Arg(0))
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	doc, err := transfer.Decode(file)
+	if err != nil {
+		return fmt.Errorf("failed to read %s: %w", flags.Arg(0), err)
+	}
+
+	var changes []transfer.Change
+	if *dryRun {
+		changes, err = t.Plan(doc, transfer.Mode(*mode))
+	} else {
+		changes, err = t.Import(doc, transfer.Mode(*mode))
+	}
+
+	for _, change := range changes {
+		log.Printf("  %s", change)
+	}
+	if err != nil {
+		return err
+	}
+
+	if *dryRun {
+		log.Printf("%d changes would be made", len(changes))
+	} else {
+		log.Printf("%d changes made", len(changes))
+	}
+	return nil
+}